@@ -2,16 +2,25 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	osuser "os/user"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 	
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"go.uber.org/zap"
+	"go_market_email/internal/models"
 	"go_market_email/internal/services"
 	"go_market_email/internal/utils"
+	"gorm.io/gorm"
 )
 
 var configPath string
@@ -28,9 +37,230 @@ var workerCmd = &cobra.Command{
 	Run:   runWorker,
 }
 
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "用户管理",
+}
+
+var userCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "创建用户",
+	Run:   runUserCreate,
+}
+
+var apiKeyCmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "API密钥管理",
+}
+
+var apiKeyCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "为用户创建API密钥",
+	Run:   runAPIKeyCreate,
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "审计日志",
+}
+
+var auditExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "导出审计日志",
+	Run:   runAuditExport,
+}
+
+var secretCmd = &cobra.Command{
+	Use:   "secret",
+	Short: "配置密钥管理",
+}
+
+var secretEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "使用主密钥加密标准输入中的敏感值，输出可写入配置文件的 enc: 值",
+	Run:   runSecretEncrypt,
+}
+
+var (
+	auditFrom     string
+	auditTo       string
+	auditActorID  uint
+	auditAction   string
+	auditResource string
+	auditFormat   string
+	auditOutput   string
+)
+
+var (
+	username     string
+	userEmail    string
+	userPassword string
+	keyName      string
+	keyDays      int
+)
+
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "./configs/config.yaml", "配置文件路径")
 	rootCmd.AddCommand(workerCmd)
+	
+	userCreateCmd.Flags().StringVar(&username, "username", "", "用户名")
+	userCreateCmd.Flags().StringVar(&userEmail, "email", "", "邮箱")
+	userCreateCmd.Flags().StringVar(&userPassword, "password", "", "密码（至少8位）")
+	userCreateCmd.MarkFlagRequired("username")
+	userCreateCmd.MarkFlagRequired("email")
+	userCreateCmd.MarkFlagRequired("password")
+	userCmd.AddCommand(userCreateCmd)
+	rootCmd.AddCommand(userCmd)
+	
+	apiKeyCreateCmd.Flags().StringVar(&username, "username", "", "用户名")
+	apiKeyCreateCmd.Flags().StringVar(&keyName, "name", "cli", "密钥名称")
+	apiKeyCreateCmd.Flags().IntVar(&keyDays, "expires-in-days", 0, "有效天数，0表示永不过期")
+	apiKeyCreateCmd.MarkFlagRequired("username")
+	apiKeyCmd.AddCommand(apiKeyCreateCmd)
+	rootCmd.AddCommand(apiKeyCmd)
+	
+	auditExportCmd.Flags().StringVar(&auditFrom, "from", "", "开始时间（RFC3339或2006-01-02）")
+	auditExportCmd.Flags().StringVar(&auditTo, "to", "", "结束时间（RFC3339或2006-01-02）")
+	auditExportCmd.Flags().UintVar(&auditActorID, "actor-id", 0, "操作者用户ID")
+	auditExportCmd.Flags().StringVar(&auditAction, "action", "", "动作，如 template.delete")
+	auditExportCmd.Flags().StringVar(&auditResource, "resource", "", "资源，格式为 类型 或 类型:ID")
+	auditExportCmd.Flags().StringVar(&auditFormat, "format", "csv", "导出格式 csv 或 json")
+	auditExportCmd.Flags().StringVarP(&auditOutput, "output", "o", "", "输出文件，默认输出到标准输出")
+	auditCmd.AddCommand(auditExportCmd)
+	rootCmd.AddCommand(auditCmd)
+	
+	secretCmd.AddCommand(secretEncryptCmd)
+	rootCmd.AddCommand(secretCmd)
+}
+
+// openDatabase 加载配置并连接数据库，供管理命令使用
+func openDatabase() (*utils.Config, *gorm.DB) {
+	config, err := utils.LoadConfig(configPath)
+	if err != nil {
+		log.Fatal("加载配置失败:", err)
+	}
+	
+	db, err := utils.InitDatabase(config.Database)
+	if err != nil {
+		log.Fatal("初始化数据库失败:", err)
+	}
+	
+	return config, db
+}
+
+// newAuthService 创建认证服务，供用户和密钥管理命令使用
+func newAuthService() (*services.AuthService, *utils.Config, *gorm.DB) {
+	config, db := openDatabase()
+	return services.NewAuthService(db, config.Auth), config, db
+}
+
+// recordCLI 为CLI命令写入审计日志，操作者为本机系统用户，命令参数中的密码会被脱敏
+func recordCLI(cmd *cobra.Command, config *utils.Config, db *gorm.DB, action, resourceType string, resourceID uint, after interface{}) {
+	logger, err := utils.InitLogger(config.Log)
+	if err != nil {
+		logger = zap.NewNop()
+	}
+	
+	entry := &models.AuditLog{
+		ActorName:    cliActor(),
+		Source:       services.AuditSourceCLI,
+		Action:       action,
+		ResourceType: resourceType,
+		Path:         cmd.CommandPath(),
+	}
+	if resourceID != 0 {
+		entry.ResourceID = strconv.FormatUint(uint64(resourceID), 10)
+	}
+	
+	flags := map[string]string{}
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		flags[flag.Name] = flag.Value.String()
+	})
+	if data, err := json.Marshal(flags); err == nil {
+		entry.Request = services.RedactAuditJSON(data)
+	}
+	if after != nil {
+		if data, err := json.Marshal(after); err == nil {
+			entry.After = string(data)
+		}
+	}
+	
+	services.NewAuditService(db, logger).Record(entry)
+}
+
+// cliActor 返回执行命令的系统用户和主机名
+func cliActor() string {
+	name := "unknown"
+	if u, err := osuser.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
+
+// runSecretEncrypt 从标准输入读取明文，避免敏感值出现在命令行历史中
+func runSecretEncrypt(cmd *cobra.Command, args []string) {
+	config, err := utils.LoadConfig(configPath)
+	if err != nil {
+		log.Fatal("加载配置失败:", err)
+	}
+	
+	masterKey, err := utils.LoadMasterKey(config.Secrets)
+	if err != nil {
+		log.Fatal("读取主密钥失败:", err)
+	}
+	if masterKey == "" {
+		log.Fatal("未设置主密钥，请配置 GME_MASTER_KEY 或 secrets.master_key_file")
+	}
+	
+	plain, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal("读取输入失败:", err)
+	}
+	
+	value, err := utils.NewEncryptedSecretProvider(masterKey).Encrypt(strings.TrimRight(string(plain), "\r\n"))
+	if err != nil {
+		log.Fatal("加密失败:", err)
+	}
+	fmt.Println(value)
+}
+
+func runUserCreate(cmd *cobra.Command, args []string) {
+	authService, config, db := newAuthService()
+	
+	user, err := authService.CreateUser(username, userEmail, userPassword)
+	if err != nil {
+		log.Fatal("创建用户失败:", err)
+	}
+	recordCLI(cmd, config, db, "user.create", "user", user.ID, user)
+	
+	fmt.Printf("用户已创建: id=%d username=%s\n", user.ID, user.Username)
+}
+
+func runAPIKeyCreate(cmd *cobra.Command, args []string) {
+	authService, config, db := newAuthService()
+	
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		log.Fatal("用户不存在:", username)
+	}
+	
+	var expiresAt *time.Time
+	if keyDays > 0 {
+		t := time.Now().AddDate(0, 0, keyDays)
+		expiresAt = &t
+	}
+	
+	plain, apiKey, err := authService.CreateAPIKey(user.ID, keyName, expiresAt)
+	if err != nil {
+		log.Fatal("创建API密钥失败:", err)
+	}
+	recordCLI(cmd, config, db, "api_key.create", "api_key", apiKey.ID, apiKey)
+	
+	// 明文密钥只显示一次
+	fmt.Println(plain)
 }
 
 func runWorker(cmd *cobra.Command, args []string) {
@@ -65,8 +295,32 @@ func runWorker(cmd *cobra.Command, args []string) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	
+	// 启动Webhook异步投递
+	emailService.GetWebhookService().StartDispatcher(ctx)
+	
+	// 定期删除过期的数据集
+	datasetService := services.NewDatasetService(db, services.NewDataService(db, rdb, emailService.GetDataSourceService()), config.Upload)
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if count, err := datasetService.PurgeExpired(); err != nil {
+					logger.Error("清理过期数据集失败", zap.Error(err))
+				} else if count > 0 {
+					logger.Info("已清理过期数据集", zap.Int("count", count))
+				}
+			}
+		}
+	}()
+	
 	// 启动工作进程
 	logger.Info("邮件发送工作进程启动")
+	recordCLI(cmd, config, db, "worker.start", "worker", 0, nil)
 	
 	// 启动多个工作协程
 	for i := 0; i < config.Scheduler.MaxWorkers; i++ {
@@ -98,9 +352,46 @@ func runWorker(cmd *cobra.Command, args []string) {
 	
 	// 等待一段时间让工作进程完成
 	time.Sleep(5 * time.Second)
+	recordCLI(cmd, config, db, "worker.stop", "worker", 0, nil)
 	logger.Info("工作进程已停止")
 }
 
+func runAuditExport(cmd *cobra.Command, args []string) {
+	config, db := openDatabase()
+	
+	var filter services.AuditFilter
+	var err error
+	if filter.From, err = services.ParseAuditTime(auditFrom, false); err != nil {
+		log.Fatal(err)
+	}
+	if filter.To, err = services.ParseAuditTime(auditTo, true); err != nil {
+		log.Fatal(err)
+	}
+	filter.ActorID = auditActorID
+	filter.Action = auditAction
+	if auditResource != "" {
+		filter.ResourceType, filter.ResourceID, _ = strings.Cut(auditResource, ":")
+	}
+	
+	out := os.Stdout
+	if auditOutput != "" {
+		file, err := os.Create(auditOutput)
+		if err != nil {
+			log.Fatal("创建输出文件失败:", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	
+	logger, err := utils.InitLogger(config.Log)
+	if err != nil {
+		log.Fatal("初始化日志失败:", err)
+	}
+	if err := services.NewAuditService(db, logger).Export(out, filter, auditFormat); err != nil {
+		log.Fatal("导出审计日志失败:", err)
+	}
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
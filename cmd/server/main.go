@@ -46,71 +46,215 @@ func main() {
 	
 	// 创建服务
 	templateService := services.NewTemplateService(db)
-	dataService := services.NewDataService(db, rdb)
-	aiService := services.NewAIService(config.AI)
 	emailService := services.NewEmailService(db, rdb, *config, logger)
+	dataService := services.NewDataService(db, rdb, emailService.GetDataSourceService())
+	datasetService := services.NewDatasetService(db, dataService, config.Upload)
+	aiService := services.NewAIService(config.AI)
+	authService := services.NewAuthService(db, config.Auth)
+	rbacService := services.NewRBACService(db)
+	projectService := services.NewProjectService(db)
+	auditService := services.NewAuditService(db, logger)
 	
 	// 创建处理器
-	templateHandler := handlers.NewTemplateHandler(templateService)
-	emailHandler := handlers.NewEmailHandler(emailService, templateService, dataService, aiService)
+	templateHandler := handlers.NewTemplateHandler(templateService, emailService.GetAttachmentService(), emailService.GetAssetService(), emailService.GetPDFService(),
+		services.NewLinkChecker(nil, rdb, config.LinkCheck, logger))
+	assetHandler := handlers.NewAssetHandler(emailService.GetAssetService())
+	emailHandler := handlers.NewEmailHandler(emailService, templateService, dataService, datasetService, aiService)
 	
 	// 创建路由
-	r := gin.Default()
+	r := gin.New()
 	
 	// 中间件
+	r.Use(middleware.AccessLogger(), gin.Recovery())
 	r.Use(middleware.CORSMiddleware())
 	
+	authHandler := handlers.NewAuthHandler(authService)
+	authMiddleware := middleware.AuthMiddleware(authService, logger)
+	
 	// 公开路由
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
+	r.POST("/api/v1/auth/login", authHandler.Login)
+	
+	// 托管的模板图片，邮件客户端无需认证即可加载
+	r.GET("/assets/:token/:name", assetHandler.ServeAsset)
 	
 	// 需要认证的路由
 	api := r.Group("/api/v1")
-	api.Use(middleware.AuthMiddleware(config.Auth, logger))
+	api.Use(authMiddleware, middleware.AuditMiddleware(auditService))
+	
+	// 认证路由
+	auth := api.Group("/auth")
+	{
+		auth.GET("/me", authHandler.Me)
+		auth.POST("/refresh", authHandler.RefreshSession)
+		auth.POST("/api-keys", authHandler.CreateAPIKey)
+		auth.GET("/api-keys", authHandler.ListAPIKeys)
+		auth.DELETE("/api-keys/:id", authHandler.RevokeAPIKey)
+	}
 	
 	// 创建处理器
-	statsHandler := handlers.NewStatsHandler(db, rdb, logger, emailService)
-	dataHandler := handlers.NewDataHandler(dataService)
+	statsHandler := handlers.NewStatsHandler(db, rdb, logger, emailService, rbacService, config.Server.AllowedOrigins)
+	dataHandler := handlers.NewDataHandler(dataService, datasetService, rbacService)
+	datasetHandler := handlers.NewDatasetHandler(datasetService, dataService, rbacService)
+	attachmentHandler := handlers.NewAttachmentHandler(emailService.GetAttachmentService())
+	importMappingHandler := handlers.NewImportMappingHandler(dataService.GetImportMappingService())
 	aiHandler := handlers.NewAIHandler(aiService)
+	webhookHandler := handlers.NewWebhookHandler(emailService.GetWebhookService())
+	projectHandler := handlers.NewProjectHandler(projectService, rbacService)
+	auditHandler := handlers.NewAuditHandler(auditService)
+	segmentHandler := handlers.NewSegmentHandler(emailService.GetSegmentService())
+	contactHandler := handlers.NewContactHandler(services.NewContactService(db, emailService.GetDataSourceService()), dataService.GetImportMappingService())
+	
+	// 项目权限校验
+	perm := func(permission string, scope middleware.ProjectScope) gin.HandlerFunc {
+		return middleware.RequirePermission(rbacService, permission, scope)
+	}
 	
 	// 模板路由
 	templates := api.Group("/templates")
 	{
-		templates.POST("", templateHandler.CreateTemplate)
-		templates.GET("/:id", templateHandler.GetTemplate)
-		templates.GET("", templateHandler.ListTemplates)
-		templates.PUT("/:id", templateHandler.UpdateTemplate)
-		templates.DELETE("/:id", templateHandler.DeleteTemplate)
+		templates.POST("", perm(services.PermTemplateWrite, middleware.ScopeFromRequest), templateHandler.CreateTemplate)
+		templates.GET("/:id", perm(services.PermTemplateRead, middleware.ScopeTemplateParam), templateHandler.GetTemplate)
+		templates.GET("", perm(services.PermTemplateRead, middleware.ScopeFromRequest), templateHandler.ListTemplates)
+		templates.PUT("/:id", perm(services.PermTemplateWrite, middleware.ScopeTemplateParam), templateHandler.UpdateTemplate)
+		templates.DELETE("/:id", perm(services.PermTemplateWrite, middleware.ScopeTemplateParam), templateHandler.DeleteTemplate)
 		templates.POST("/extract-variables", templateHandler.ExtractVariables)
-		templates.POST("/preview", templateHandler.PreviewTemplate)
+		templates.POST("/preview", perm(services.PermTemplateRead, middleware.ScopeTemplateFromRequest), templateHandler.PreviewTemplate)
+		templates.POST("/:id/pdf-preview", perm(services.PermTemplateRead, middleware.ScopeTemplateParam), templateHandler.PreviewPDF)
+		templates.GET("/:id/lint", perm(services.PermTemplateRead, middleware.ScopeTemplateParam), templateHandler.LintTemplate)
+		templates.POST("/:id/link-check", perm(services.PermTemplateRead, middleware.ScopeTemplateParam), templateHandler.StartLinkCheck)
+		templates.GET("/:id/link-check", perm(services.PermTemplateRead, middleware.ScopeTemplateParam), templateHandler.GetLinkCheck)
 	}
 	
 	// 邮件路由
 	emails := api.Group("/emails")
 	{
-		emails.POST("/test", emailHandler.SendTestEmail)
+		emails.POST("/test", perm(services.PermTaskSend, middleware.ScopeTemplateFromRequest), emailHandler.SendTestEmail)
 	}
 	
 	// 任务路由
 	tasks := api.Group("/tasks")
 	{
-		tasks.POST("", emailHandler.CreateEmailTask)
-		tasks.GET("", emailHandler.ListTasks)
-		tasks.GET("/running", statsHandler.GetRunningTasks)
-		tasks.GET("/:id/logs", emailHandler.GetTaskLogs)
-		tasks.POST("/:id/start", emailHandler.StartTask)
-		tasks.POST("/:id/pause", statsHandler.PauseTask)
-		tasks.POST("/:id/resume", statsHandler.ResumeTask)
-		tasks.DELETE("/:id", emailHandler.DeleteTask)
+		tasks.POST("", perm(services.PermTaskWrite, middleware.ScopeFromRequest), emailHandler.CreateEmailTask)
+		tasks.GET("", perm(services.PermTaskRead, middleware.ScopeFromRequest), emailHandler.ListTasks)
+		tasks.GET("/logs", perm(services.PermTaskRead, middleware.ScopeFromRequest), emailHandler.ListLogs)
+		tasks.GET("/running", perm(services.PermTaskRead, middleware.ScopeFromRequest), statsHandler.GetRunningTasks)
+		tasks.GET("/:id/logs", perm(services.PermTaskRead, middleware.ScopeTaskParam), emailHandler.GetTaskLogs)
+		tasks.POST("/:id/start", perm(services.PermTaskSend, middleware.ScopeTaskParam), emailHandler.StartTask)
+		tasks.POST("/:id/pause", perm(services.PermTaskSend, middleware.ScopeTaskParam), statsHandler.PauseTask)
+		tasks.POST("/:id/resume", perm(services.PermTaskSend, middleware.ScopeTaskParam), statsHandler.ResumeTask)
+		tasks.DELETE("/:id", perm(services.PermTaskWrite, middleware.ScopeTaskParam), emailHandler.DeleteTask)
+		tasks.GET("/:id/data", perm(services.PermDataRead, middleware.ScopeTaskParam), dataHandler.GetTaskData)
+		tasks.GET("/:id/data/stats", perm(services.PermDataRead, middleware.ScopeTaskParam), dataHandler.GetTaskDataStats)
+		tasks.PUT("/:id/data/:row", perm(services.PermDataWrite, middleware.ScopeTaskParam), dataHandler.UpdateTaskRow)
+		tasks.DELETE("/:id/data/:row", perm(services.PermDataWrite, middleware.ScopeTaskParam), dataHandler.DeleteTaskRow)
 	}
 	
 	// 数据路由
 	data := api.Group("/data")
 	{
-		data.POST("/upload", dataHandler.UploadFile)
-		data.POST("/sql", dataHandler.ExecuteSQL)
-		data.POST("/save", dataHandler.SaveManualData)
+		data.POST("/upload", perm(services.PermDataWrite, middleware.ScopeTaskFromRequest), dataHandler.UploadFile)
+		data.POST("/sheets", perm(services.PermDataRead, middleware.ScopeFromRequest), dataHandler.ListSheets)
+		data.POST("/sql", perm(services.PermDataWrite, middleware.ScopeTaskFromRequest), dataHandler.ExecuteSQL)
+		data.POST("/save", perm(services.PermDataWrite, middleware.ScopeTaskFromRequest), dataHandler.SaveManualData)
+		data.GET("/sources", perm(services.PermDataRead, middleware.ScopeFromRequest), dataHandler.ListDataSources)
+		data.GET("/progress", perm(services.PermDataRead, middleware.ScopeTaskFromRequest), dataHandler.GetImportProgress)
+		data.POST("/validate", perm(services.PermDataWrite, middleware.ScopeTaskFromRequest), dataHandler.ValidateTaskData)
+		data.GET("/validation", perm(services.PermDataRead, middleware.ScopeTaskFromRequest), dataHandler.GetValidationReport)
+	}
+	
+	// 数据集路由
+	datasets := api.Group("/datasets")
+	{
+		datasets.POST("", perm(services.PermDataWrite, middleware.ScopeFromRequest), datasetHandler.UploadDataset)
+		datasets.GET("", perm(services.PermDataRead, middleware.ScopeFromRequest), datasetHandler.ListDatasets)
+		datasets.GET("/:id", perm(services.PermDataRead, middleware.ScopeDatasetParam), datasetHandler.GetDataset)
+		datasets.DELETE("/:id", perm(services.PermDataWrite, middleware.ScopeDatasetParam), datasetHandler.DeleteDataset)
+		datasets.GET("/:id/progress", perm(services.PermDataRead, middleware.ScopeDatasetParam), datasetHandler.GetImportProgress)
+		datasets.GET("/:id/rows", perm(services.PermDataRead, middleware.ScopeDatasetParam), datasetHandler.GetDatasetRows)
+		datasets.GET("/:id/stats", perm(services.PermDataRead, middleware.ScopeDatasetParam), datasetHandler.GetDatasetStats)
+		datasets.POST("/:id/attach", perm(services.PermDataWrite, middleware.ScopeDatasetParam), datasetHandler.AttachDataset)
+	}
+	
+	// 附件路由
+	attachments := api.Group("/attachments")
+	{
+		attachments.POST("", perm(services.PermTemplateWrite, middleware.ScopeFromRequest), attachmentHandler.UploadAttachment)
+		attachments.GET("", perm(services.PermTemplateRead, middleware.ScopeFromRequest), attachmentHandler.ListAttachments)
+		attachments.GET("/:id", perm(services.PermTemplateRead, middleware.ScopeAttachmentParam), attachmentHandler.GetAttachment)
+		attachments.GET("/:id/download", perm(services.PermTemplateRead, middleware.ScopeAttachmentParam), attachmentHandler.DownloadAttachment)
+		attachments.DELETE("/:id", perm(services.PermTemplateWrite, middleware.ScopeAttachmentParam), attachmentHandler.DeleteAttachment)
+	}
+	
+	// 图片资源路由
+	assets := api.Group("/assets")
+	{
+		assets.POST("", perm(services.PermTemplateWrite, middleware.ScopeFromRequest), assetHandler.UploadAsset)
+		assets.GET("", perm(services.PermTemplateRead, middleware.ScopeFromRequest), assetHandler.ListAssets)
+		assets.GET("/:id", perm(services.PermTemplateRead, middleware.ScopeAssetParam), assetHandler.GetAsset)
+		assets.DELETE("/:id", perm(services.PermTemplateWrite, middleware.ScopeAssetParam), assetHandler.DeleteAsset)
+	}
+	
+	// 保存的查询路由
+	queries := api.Group("/queries")
+	{
+		queries.POST("", perm(services.PermDataWrite, middleware.ScopeFromRequest), dataHandler.CreateSavedQuery)
+		queries.GET("", perm(services.PermDataRead, middleware.ScopeFromRequest), dataHandler.ListSavedQueries)
+		queries.GET("/:id", perm(services.PermDataRead, middleware.ScopeSavedQueryParam), dataHandler.GetSavedQuery)
+		queries.PUT("/:id", perm(services.PermDataWrite, middleware.ScopeSavedQueryParam), dataHandler.UpdateSavedQuery)
+		queries.DELETE("/:id", perm(services.PermDataWrite, middleware.ScopeSavedQueryParam), dataHandler.DeleteSavedQuery)
+		queries.POST("/:id/run", perm(services.PermDataWrite, middleware.ScopeSavedQueryParam), dataHandler.RunSavedQuery)
+	}
+	
+	// 导入列映射预设路由
+	importMappings := api.Group("/import-mappings")
+	{
+		importMappings.POST("", perm(services.PermDataWrite, middleware.ScopeFromRequest), importMappingHandler.CreateMapping)
+		importMappings.GET("", perm(services.PermDataRead, middleware.ScopeFromRequest), importMappingHandler.ListMappings)
+		importMappings.GET("/:id", perm(services.PermDataRead, middleware.ScopeImportMappingParam), importMappingHandler.GetMapping)
+		importMappings.PUT("/:id", perm(services.PermDataWrite, middleware.ScopeImportMappingParam), importMappingHandler.UpdateMapping)
+		importMappings.DELETE("/:id", perm(services.PermDataWrite, middleware.ScopeImportMappingParam), importMappingHandler.DeleteMapping)
+	}
+	
+	// 分群路由
+	segments := api.Group("/segments")
+	{
+		segments.POST("", perm(services.PermDataWrite, middleware.ScopeFromRequest), segmentHandler.CreateSegment)
+		segments.GET("", perm(services.PermDataRead, middleware.ScopeFromRequest), segmentHandler.ListSegments)
+		segments.POST("/preview", perm(services.PermDataRead, middleware.ScopeFromRequest), segmentHandler.PreviewDefinition)
+		segments.GET("/:id", perm(services.PermDataRead, middleware.ScopeSegmentParam), segmentHandler.GetSegment)
+		segments.PUT("/:id", perm(services.PermDataWrite, middleware.ScopeSegmentParam), segmentHandler.UpdateSegment)
+		segments.DELETE("/:id", perm(services.PermDataWrite, middleware.ScopeSegmentParam), segmentHandler.DeleteSegment)
+		segments.GET("/:id/versions", perm(services.PermDataRead, middleware.ScopeSegmentParam), segmentHandler.ListVersions)
+		segments.POST("/:id/preview", perm(services.PermDataRead, middleware.ScopeSegmentParam), segmentHandler.PreviewSegment)
+	}
+	
+	// 联系人路由
+	contacts := api.Group("/contacts")
+	{
+		contacts.POST("", perm(services.PermDataWrite, middleware.ScopeFromRequest), contactHandler.CreateContact)
+		contacts.GET("", perm(services.PermDataRead, middleware.ScopeFromRequest), contactHandler.SearchContacts)
+		contacts.GET("/export", perm(services.PermDataRead, middleware.ScopeFromRequest), contactHandler.ExportContacts)
+		contacts.POST("/import", perm(services.PermDataWrite, middleware.ScopeFromRequest), contactHandler.ImportContacts)
+		contacts.POST("/import/sql", perm(services.PermDataWrite, middleware.ScopeFromRequest), contactHandler.ImportContactsSQL)
+		contacts.POST("/dedupe", perm(services.PermDataWrite, middleware.ScopeFromRequest), contactHandler.DedupeContacts)
+		contacts.GET("/:id", perm(services.PermDataRead, middleware.ScopeContactParam), contactHandler.GetContact)
+		contacts.PUT("/:id", perm(services.PermDataWrite, middleware.ScopeContactParam), contactHandler.UpdateContact)
+		contacts.DELETE("/:id", perm(services.PermDataWrite, middleware.ScopeContactParam), contactHandler.DeleteContact)
+	}
+	
+	// 联系人列表路由
+	contactLists := api.Group("/contact-lists")
+	{
+		contactLists.POST("", perm(services.PermDataWrite, middleware.ScopeFromRequest), contactHandler.CreateList)
+		contactLists.GET("", perm(services.PermDataRead, middleware.ScopeFromRequest), contactHandler.ListLists)
+		contactLists.GET("/:id", perm(services.PermDataRead, middleware.ScopeContactListParam), contactHandler.GetList)
+		contactLists.PUT("/:id", perm(services.PermDataWrite, middleware.ScopeContactListParam), contactHandler.UpdateList)
+		contactLists.DELETE("/:id", perm(services.PermDataWrite, middleware.ScopeContactListParam), contactHandler.DeleteList)
+		contactLists.POST("/:id/members", perm(services.PermDataWrite, middleware.ScopeContactListParam), contactHandler.AddListMembers)
+		contactLists.DELETE("/:id/members", perm(services.PermDataWrite, middleware.ScopeContactListParam), contactHandler.RemoveListMembers)
 	}
 	
 	// AI路由
@@ -123,11 +267,51 @@ func main() {
 	// 统计路由
 	stats := api.Group("/stats")
 	{
-		stats.GET("", statsHandler.GetStats)
+		stats.GET("", perm(services.PermStatsRead, middleware.ScopeFromRequest), statsHandler.GetStats)
+		stats.GET("/events", perm(services.PermStatsRead, middleware.ScopeFromRequest), statsHandler.StreamEvents)
+	}
+	
+	// 项目路由
+	projects := api.Group("/projects")
+	{
+		projects.POST("", projectHandler.CreateProject)
+		projects.GET("", projectHandler.ListProjects)
+		projects.POST("/switch", projectHandler.SwitchProject)
+		projects.GET("/:id", perm(services.PermProjectRead, middleware.ScopeProjectParam), projectHandler.GetProject)
+		projects.PUT("/:id", perm(services.PermProjectManage, middleware.ScopeProjectParam), projectHandler.UpdateProject)
+		projects.DELETE("/:id", perm(services.PermProjectManage, middleware.ScopeProjectParam), projectHandler.DeleteProject)
+	}
+	
+	// 项目成员路由
+	members := api.Group("/projects/:id/members")
+	{
+		members.GET("", perm(services.PermProjectRead, middleware.ScopeProjectParam), projectHandler.ListMembers)
+		members.POST("", perm(services.PermMemberManage, middleware.ScopeProjectParam), projectHandler.AddMember)
+		members.PUT("/:userId", perm(services.PermMemberManage, middleware.ScopeProjectParam), projectHandler.UpdateMember)
+		members.DELETE("/:userId", perm(services.PermMemberManage, middleware.ScopeProjectParam), projectHandler.RemoveMember)
+	}
+	
+	// Webhook路由
+	webhooks := api.Group("/webhooks")
+	{
+		webhooks.GET("/events", webhookHandler.ListEvents)
+		webhooks.POST("", perm(services.PermTaskWrite, middleware.ScopeFromRequest), webhookHandler.CreateSubscription)
+		webhooks.GET("", perm(services.PermTaskWrite, middleware.ScopeFromRequest), webhookHandler.ListSubscriptions)
+		webhooks.PUT("/:id", perm(services.PermTaskWrite, middleware.ScopeWebhookParam), webhookHandler.UpdateSubscription)
+		webhooks.DELETE("/:id", perm(services.PermTaskWrite, middleware.ScopeWebhookParam), webhookHandler.DeleteSubscription)
+		webhooks.GET("/:id/deliveries", perm(services.PermTaskWrite, middleware.ScopeWebhookParam), webhookHandler.ListDeliveries)
+		webhooks.POST("/deliveries/:id/replay", perm(services.PermTaskWrite, middleware.ScopeWebhookDeliveryParam), webhookHandler.ReplayDelivery)
+	}
+	
+	// 审计日志路由
+	audit := api.Group("/audit-logs")
+	{
+		audit.GET("", perm(services.PermAuditRead, middleware.ScopeFromRequest), auditHandler.ListAuditLogs)
+		audit.GET("/export", perm(services.PermAuditRead, middleware.ScopeFromRequest), auditHandler.ExportAuditLogs)
 	}
 	
-	// WebSocket路由
-	r.GET("/ws/stats", statsHandler.WebSocketStats)
+	// WebSocket路由（握手时认证）
+	r.GET("/ws/stats", authMiddleware, perm(services.PermStatsRead, middleware.ScopeFromRequest), statsHandler.WebSocketStats)
 	
 	// 记录配置信息到日志
 	logger.Info("配置文件加载完成", zap.String("config_path", *configPath))
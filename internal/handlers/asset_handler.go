@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/models"
+	"go_market_email/internal/services"
+)
+
+type AssetHandler struct {
+	assetService *services.AssetService
+}
+
+func NewAssetHandler(assetService *services.AssetService) *AssetHandler {
+	return &AssetHandler{assetService: assetService}
+}
+
+// UploadAsset 上传模板图片，可选 name 指定模板中引用的文件名
+func (h *AssetHandler) UploadAsset(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败"})
+		return
+	}
+	if file.Size > h.assetService.MaxSize() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("图片大小不能超过 %d 字节", h.assetService.MaxSize())})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败"})
+		return
+	}
+	defer src.Close()
+
+	asset := models.Asset{
+		Name:      c.DefaultPostForm("name", file.Filename),
+		UserID:    c.MustGet("userID").(uint),
+		ProjectID: c.MustGet("projectID").(uint),
+	}
+	if err := h.assetService.Store(&asset, src); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": asset, "url": h.assetService.URL(&asset)})
+}
+
+// ListAssets 分页获取当前项目的图片
+func (h *AssetHandler) ListAssets(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	assets, total, err := h.assetService.ListAssets(c.MustGet("projectID").(uint), c.MustGet("userID").(uint), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      assets,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetAsset 获取图片信息和公开地址
+func (h *AssetHandler) GetAsset(c *gin.Context) {
+	asset, ok := h.asset(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": asset, "url": h.assetService.URL(asset)})
+}
+
+// DeleteAsset 删除图片，仍被启用的模板引用时拒绝
+func (h *AssetHandler) DeleteAsset(c *gin.Context) {
+	asset, ok := h.asset(c)
+	if !ok {
+		return
+	}
+
+	if err := h.assetService.DeleteAsset(asset); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrAssetInUse) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "图片删除成功"})
+}
+
+// ServeAsset 按公开令牌返回图片内容，供邮件客户端加载托管的图片
+func (h *AssetHandler) ServeAsset(c *gin.Context) {
+	asset, err := h.assetService.GetAssetByToken(c.Param("token"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Header("Content-Type", asset.ContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.File(asset.Path)
+}
+
+// asset 读取路由参数 :id 对应的图片，权限已由中间件校验
+func (h *AssetHandler) asset(c *gin.Context) (*models.Asset, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的图片ID"})
+		return nil, false
+	}
+
+	asset, err := h.assetService.GetAsset(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "图片不存在"})
+		return nil, false
+	}
+	return asset, true
+}
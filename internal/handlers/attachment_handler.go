@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/models"
+	"go_market_email/internal/services"
+)
+
+type AttachmentHandler struct {
+	attachmentService *services.AttachmentService
+}
+
+func NewAttachmentHandler(attachmentService *services.AttachmentService) *AttachmentHandler {
+	return &AttachmentHandler{attachmentService: attachmentService}
+}
+
+// UploadAttachment 上传文件到附件库，可选 name 指定发送时的文件名
+func (h *AttachmentHandler) UploadAttachment(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败"})
+		return
+	}
+	if file.Size > h.attachmentService.MaxSize() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("附件大小不能超过 %d 字节", h.attachmentService.MaxSize())})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败"})
+		return
+	}
+	defer src.Close()
+
+	attachment := models.Attachment{
+		Name:      c.DefaultPostForm("name", file.Filename),
+		UserID:    c.MustGet("userID").(uint),
+		ProjectID: c.MustGet("projectID").(uint),
+	}
+	if err := h.attachmentService.Store(&attachment, src); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": attachment})
+}
+
+// ListAttachments 分页获取当前项目的附件
+func (h *AttachmentHandler) ListAttachments(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	attachments, total, err := h.attachmentService.ListAttachments(c.MustGet("projectID").(uint), c.MustGet("userID").(uint), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      attachments,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetAttachment 获取附件信息
+func (h *AttachmentHandler) GetAttachment(c *gin.Context) {
+	attachment, ok := h.attachment(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": attachment})
+}
+
+// DownloadAttachment 下载附件内容
+func (h *AttachmentHandler) DownloadAttachment(c *gin.Context) {
+	attachment, ok := h.attachment(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", attachment.ContentType)
+	c.FileAttachment(attachment.Path, attachment.Name)
+}
+
+// DeleteAttachment 删除附件，仍被模板或未完成的任务使用时拒绝
+func (h *AttachmentHandler) DeleteAttachment(c *gin.Context) {
+	attachment, ok := h.attachment(c)
+	if !ok {
+		return
+	}
+
+	if err := h.attachmentService.DeleteAttachment(attachment); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrAttachmentInUse) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "附件删除成功"})
+}
+
+// attachment 读取路由参数 :id 对应的附件，权限已由中间件校验
+func (h *AttachmentHandler) attachment(c *gin.Context) (*models.Attachment, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的附件ID"})
+		return nil, false
+	}
+
+	attachment, err := h.attachmentService.GetAttachment(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "附件不存在"})
+		return nil, false
+	}
+	return attachment, true
+}
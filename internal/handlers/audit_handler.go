@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/services"
+)
+
+type AuditHandler struct {
+	auditService *services.AuditService
+}
+
+func NewAuditHandler(auditService *services.AuditService) *AuditHandler {
+	return &AuditHandler{auditService: auditService}
+}
+
+// ListAuditLogs 查询审计日志，支持时间范围、操作者、动作和资源过滤
+func (h *AuditHandler) ListAuditLogs(c *gin.Context) {
+	filter, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	logs, total, err := h.auditService.Query(filter, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      logs,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// ExportAuditLogs 按查询条件导出审计日志，format=csv|json
+func (h *AuditHandler) ExportAuditLogs(c *gin.Context) {
+	filter, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case "csv":
+	case "json":
+		contentType = "application/json; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format只支持csv或json"})
+		return
+	}
+
+	filename := fmt.Sprintf("audit_%s.%s", time.Now().Format("20060102150405"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Status(http.StatusOK)
+
+	if err := h.auditService.Export(c.Writer, filter, format); err != nil {
+		// 响应头已发送，只能中断输出
+		c.Error(err)
+		c.Abort()
+	}
+}
+
+// auditFilter 从查询参数构造过滤条件，并限定在当前项目内；
+// 个人空间下只能查看自己的操作
+func auditFilter(c *gin.Context) (services.AuditFilter, error) {
+	var filter services.AuditFilter
+	var err error
+
+	if filter.From, err = services.ParseAuditTime(c.Query("from"), false); err != nil {
+		return filter, err
+	}
+	if filter.To, err = services.ParseAuditTime(c.Query("to"), true); err != nil {
+		return filter, err
+	}
+	if actor := c.Query("actor_id"); actor != "" {
+		id, err := strconv.ParseUint(actor, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("无效的操作者ID")
+		}
+		filter.ActorID = uint(id)
+	}
+	filter.Action = c.Query("action")
+	filter.ResourceType = c.Query("resource_type")
+	filter.ResourceID = c.Query("resource_id")
+
+	projectID := c.MustGet("projectID").(uint)
+	filter.ProjectID = &projectID
+	if projectID == 0 {
+		filter.ActorID = c.MustGet("userID").(uint)
+	}
+	return filter, nil
+}
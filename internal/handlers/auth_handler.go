@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/services"
+)
+
+type AuthHandler struct {
+	authService *services.AuthService
+}
+
+func NewAuthHandler(authService *services.AuthService) *AuthHandler {
+	return &AuthHandler{authService: authService}
+}
+
+// Login 用户名密码登录，返回短期会话令牌
+func (h *AuthHandler) Login(c *gin.Context) {
+	var request struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, expiresAt, user, err := h.authService.Login(request.Username, request.Password)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"token":      token,
+			"expires_at": expiresAt,
+			"user":       user,
+		},
+	})
+}
+
+// RefreshSession 使用当前凭证换取新的会话令牌，会话令牌续期不会延长登录后的最长会话时间
+func (h *AuthHandler) RefreshSession(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	authTime, _ := c.Get("authTime")
+	token, expiresAt, err := h.authService.RefreshSession(userID.(uint), authTime.(time.Time))
+	if errors.Is(err, services.ErrSessionTooOld) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"token": token, "expires_at": expiresAt}})
+}
+
+// Me 获取当前用户
+func (h *AuthHandler) Me(c *gin.Context) {
+	user, _ := c.Get("user")
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// CreateAPIKey 创建API密钥
+func (h *AuthHandler) CreateAPIKey(c *gin.Context) {
+	var request struct {
+		Name          string `json:"name" binding:"required"`
+		ExpiresInDays int    `json:"expires_in_days"` // 0表示永不过期
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var expiresAt *time.Time
+	if request.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, request.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	userID, _ := c.Get("userID")
+	plain, apiKey, err := h.authService.CreateAPIKey(userID.(uint), request.Name, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 明文密钥只在创建时返回一次
+	c.JSON(http.StatusCreated, gin.H{"data": apiKey, "key": plain})
+}
+
+// ListAPIKeys 获取API密钥列表
+func (h *AuthHandler) ListAPIKeys(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	keys, err := h.authService.ListAPIKeys(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+// RevokeAPIKey 吊销API密钥
+func (h *AuthHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的密钥ID"})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	if err := h.authService.RevokeAPIKey(uint(id), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "密钥不存在或已吊销"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "密钥已吊销"})
+}
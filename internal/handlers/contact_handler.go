@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/models"
+	"go_market_email/internal/services"
+)
+
+type ContactHandler struct {
+	contactService *services.ContactService
+	mappingService *services.ImportMappingService
+}
+
+func NewContactHandler(contactService *services.ContactService, mappingService *services.ImportMappingService) *ContactHandler {
+	return &ContactHandler{contactService: contactService, mappingService: mappingService}
+}
+
+// CreateContact 创建联系人
+func (h *ContactHandler) CreateContact(c *gin.Context) {
+	var request struct {
+		Email      string                 `json:"email" binding:"required"`
+		Attributes map[string]interface{} `json:"attributes"`
+		Tags       []string               `json:"tags"`
+		Status     string                 `json:"status"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contact := models.Contact{
+		Email:     request.Email,
+		Status:    request.Status,
+		UserID:    c.MustGet("userID").(uint),
+		ProjectID: c.MustGet("projectID").(uint),
+	}
+
+	if err := h.contactService.CreateContact(&contact, request.Attributes, request.Tags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": contact})
+}
+
+// SearchContacts 分页搜索当前项目的联系人
+func (h *ContactHandler) SearchContacts(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 200 {
+		pageSize = 20
+	}
+
+	contacts, total, err := h.contactService.Search(c.MustGet("projectID").(uint), c.MustGet("userID").(uint),
+		contactFilter(c), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      contacts,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetContact 获取联系人及其所属列表
+func (h *ContactHandler) GetContact(c *gin.Context) {
+	contact, ok := h.contact(c)
+	if !ok {
+		return
+	}
+
+	lists, err := h.contactService.ContactLists(contact.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": contact, "lists": lists})
+}
+
+// UpdateContact 更新联系人属性、标签和订阅状态
+func (h *ContactHandler) UpdateContact(c *gin.Context) {
+	contact, ok := h.contact(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Attributes map[string]interface{} `json:"attributes"`
+		Tags       []string               `json:"tags"`
+		Status     string                 `json:"status"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.contactService.UpdateContact(contact, request.Attributes, request.Tags, request.Status); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contact, _ = h.contactService.GetContact(contact.ID)
+	c.JSON(http.StatusOK, gin.H{"data": contact})
+}
+
+// DeleteContact 删除联系人
+func (h *ContactHandler) DeleteContact(c *gin.Context) {
+	contact, ok := h.contact(c)
+	if !ok {
+		return
+	}
+
+	if err := h.contactService.DeleteContact(contact.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "联系人删除成功"})
+}
+
+// ImportContacts 从CSV或Excel文件按邮箱导入联系人，可指定 list_id、逗号分隔的 tags，
+// 以及CSV的 encoding 和 delimiter（默认自动识别）
+func (h *ContactHandler) ImportContacts(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败"})
+		return
+	}
+
+	// 检查文件大小 (50MB)
+	if file.Size > 50*1024*1024 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件大小不能超过50MB"})
+		return
+	}
+
+	options, ok := h.importOptions(c, c.PostForm("list_id"), strings.Split(c.PostForm("tags"), ","))
+	if !ok {
+		return
+	}
+
+	// 使用随机文件名保存，避免并发上传同名文件互相覆盖
+	temp, err := os.CreateTemp("", "contacts-*"+filepath.Ext(file.Filename))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+		return
+	}
+	temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := c.SaveUploadedFile(file, temp.Name()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+		return
+	}
+
+	var readerOptions services.ReaderOptions
+	c.ShouldBind(&readerOptions)
+	if readerOptions.Mapping, ok = importMappingRules(c, h.mappingService); !ok {
+		return
+	}
+	result, err := h.contactService.ImportFile(c.MustGet("projectID").(uint), c.MustGet("userID").(uint),
+		temp.Name(), file.Filename, readerOptions, options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "data": result})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// ImportContactsSQL 从外部数据源的只读查询导入联系人
+func (h *ContactHandler) ImportContactsSQL(c *gin.Context) {
+	var request struct {
+		DataSource string                 `json:"data_source" binding:"required"`
+		Query      string                 `json:"query" binding:"required"`
+		Params     map[string]interface{} `json:"params"`
+		ListID     uint                   `json:"list_id"`
+		Tags       []string               `json:"tags"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	options, ok := h.importOptions(c, strconv.FormatUint(uint64(request.ListID), 10), request.Tags)
+	if !ok {
+		return
+	}
+
+	result, err := h.contactService.ImportSQL(c.Request.Context(), c.MustGet("projectID").(uint), c.MustGet("userID").(uint),
+		request.DataSource, request.Query, request.Params, options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "data": result})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// DedupeContacts 合并指向同一信箱的联系人（大小写、已知邮箱服务的子地址、Gmail 点号不同），
+// dry_run=true 时只返回将被合并的分组
+func (h *ContactHandler) DedupeContacts(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+	groups, removed, err := h.contactService.Dedupe(c.MustGet("projectID").(uint), c.MustGet("userID").(uint), dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "removed": removed})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": groups, "removed": removed, "dry_run": dryRun})
+}
+
+// ExportContacts 按搜索条件导出联系人，format=csv|json
+func (h *ContactHandler) ExportContacts(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case "csv":
+	case "json":
+		contentType = "application/json; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format只支持csv或json"})
+		return
+	}
+
+	filename := fmt.Sprintf("contacts_%s.%s", time.Now().Format("20060102150405"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Status(http.StatusOK)
+
+	err := h.contactService.Export(c.Writer, c.MustGet("projectID").(uint), c.MustGet("userID").(uint), contactFilter(c), format)
+	if err != nil {
+		// 响应头已发送，只能中断输出
+		c.Error(err)
+		c.Abort()
+	}
+}
+
+// CreateList 创建联系人列表
+func (h *ContactHandler) CreateList(c *gin.Context) {
+	var request struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list := models.ContactList{
+		Name:        request.Name,
+		Description: request.Description,
+		UserID:      c.MustGet("userID").(uint),
+		ProjectID:   c.MustGet("projectID").(uint),
+	}
+
+	if err := h.contactService.CreateList(&list); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": list})
+}
+
+// ListLists 获取当前项目的联系人列表
+func (h *ContactHandler) ListLists(c *gin.Context) {
+	lists, err := h.contactService.ListLists(c.MustGet("projectID").(uint), c.MustGet("userID").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": lists})
+}
+
+// GetList 获取联系人列表
+func (h *ContactHandler) GetList(c *gin.Context) {
+	list, ok := h.list(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// UpdateList 更新联系人列表
+func (h *ContactHandler) UpdateList(c *gin.Context) {
+	list, ok := h.list(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name        string  `json:"name"`
+		Description *string `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if request.Name != "" {
+		updates["name"] = request.Name
+	}
+	if request.Description != nil {
+		updates["description"] = *request.Description
+	}
+
+	if err := h.contactService.UpdateList(list, updates); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// DeleteList 删除联系人列表
+func (h *ContactHandler) DeleteList(c *gin.Context) {
+	list, ok := h.list(c)
+	if !ok {
+		return
+	}
+
+	if err := h.contactService.DeleteList(list.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "列表删除成功"})
+}
+
+// AddListMembers 把联系人加入列表
+func (h *ContactHandler) AddListMembers(c *gin.Context) {
+	list, ok := h.list(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		ContactIDs []uint `json:"contact_ids" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	added, err := h.contactService.AddMembers(list, request.ContactIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"added": added})
+}
+
+// RemoveListMembers 从列表中移除联系人
+func (h *ContactHandler) RemoveListMembers(c *gin.Context) {
+	list, ok := h.list(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		ContactIDs []uint `json:"contact_ids" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.contactService.RemoveMembers(list.ID, request.ContactIDs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已移出列表"})
+}
+
+// importOptions 校验导入目标列表属于当前项目
+func (h *ContactHandler) importOptions(c *gin.Context, listID string, tags []string) (services.ContactImportOptions, bool) {
+	options := services.ContactImportOptions{Tags: tags}
+	if listID == "" || listID == "0" {
+		return options, true
+	}
+
+	id, err := strconv.ParseUint(listID, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的列表ID"})
+		return options, false
+	}
+	list, err := h.contactService.GetList(uint(id))
+	projectID := c.MustGet("projectID").(uint)
+	if err != nil || list.ProjectID != projectID || (projectID == 0 && list.UserID != c.MustGet("userID").(uint)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "列表不存在或不属于当前项目"})
+		return options, false
+	}
+	options.ListID = list.ID
+	return options, true
+}
+
+// contact 读取路由参数 :id 对应的联系人，权限已由中间件校验
+func (h *ContactHandler) contact(c *gin.Context) (*models.Contact, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的联系人ID"})
+		return nil, false
+	}
+
+	contact, err := h.contactService.GetContact(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "联系人不存在"})
+		return nil, false
+	}
+	return contact, true
+}
+
+// list 读取路由参数 :id 对应的联系人列表，权限已由中间件校验
+func (h *ContactHandler) list(c *gin.Context) (*models.ContactList, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的列表ID"})
+		return nil, false
+	}
+
+	list, err := h.contactService.GetList(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "列表不存在"})
+		return nil, false
+	}
+	return list, true
+}
+
+// contactFilter 从查询参数构造联系人过滤条件
+func contactFilter(c *gin.Context) services.ContactFilter {
+	filter := services.ContactFilter{
+		Query:  c.Query("q"),
+		Tag:    c.Query("tag"),
+		Status: c.Query("status"),
+	}
+	if listID, err := strconv.ParseUint(c.Query("list_id"), 10, 32); err == nil {
+		filter.ListID = uint(listID)
+	}
+	return filter
+}
@@ -1,84 +1,101 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
-	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go_market_email/internal/models"
 	"go_market_email/internal/services"
 )
 
 type DataHandler struct {
-	dataService *services.DataService
+	dataService    *services.DataService
+	datasetService *services.DatasetService
+	rbacService    *services.RBACService
 }
 
-func NewDataHandler(dataService *services.DataService) *DataHandler {
-	return &DataHandler{dataService: dataService}
+func NewDataHandler(dataService *services.DataService, datasetService *services.DatasetService, rbacService *services.RBACService) *DataHandler {
+	return &DataHandler{dataService: dataService, datasetService: datasetService, rbacService: rbacService}
 }
 
-// UploadFile 上传文件
+// UploadFile 上传文件创建数据集，指定 task_id 时导入后关联到该任务
 func (h *DataHandler) UploadFile(c *gin.Context) {
+	var taskID uint
+	if value := c.PostForm("task_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务ID"})
+			return
+		}
+		taskID = uint(id)
+	}
+	importUpload(c, h.datasetService, h.dataService, h.rbacService, taskID)
+}
+
+// ListSheets 列出上传的Excel文件中的工作表和前几行预览，用于选择工作表和标题行
+func (h *DataHandler) ListSheets(c *gin.Context) {
 	file, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败"})
 		return
 	}
 
-	// 检查文件大小 (50MB)
-	if file.Size > 50*1024*1024 {
+	if file.Size > maxUploadSize {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "文件大小不能超过50MB"})
 		return
 	}
 
-	// 生成临时任务ID
-	taskID := uint(1)
-	if taskIDStr := c.PostForm("task_id"); taskIDStr != "" {
-		if id, err := strconv.ParseUint(taskIDStr, 10, 32); err == nil {
-			taskID = uint(id)
-		}
+	temp, err := os.CreateTemp("", "sheets-*"+filepath.Ext(file.Filename))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+		return
 	}
+	temp.Close()
+	defer os.Remove(temp.Name())
 
-	// 保存文件到临时目录
-	tempPath := "/tmp/" + file.Filename
-	if err := c.SaveUploadedFile(file, tempPath); err != nil {
+	if err := c.SaveUploadedFile(file, temp.Name()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
 		return
 	}
 
-	// 根据文件扩展名处理
-	var data []map[string]interface{}
-	filename := strings.ToLower(file.Filename)
-	if strings.HasSuffix(filename, ".csv") {
-		// 读取CSV文件内容
-		content, err := os.ReadFile(tempPath)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "读取文件失败"})
-			return
-		}
-		data, err = h.dataService.ImportCSVData(string(content), taskID)
-	} else {
-		// 处理Excel文件
-		data, err = h.dataService.ImportExcelData(tempPath, taskID)
+	sheets, err := services.ListSheets(temp.Name(), file.Filename)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// 清理临时文件
-	os.Remove(tempPath)
+	c.JSON(http.StatusOK, gin.H{"data": sheets})
+}
 
+// GetImportProgress 获取任务最近一次文件导入的进度
+func (h *DataHandler) GetImportProgress(c *gin.Context) {
+	taskID, err := strconv.ParseUint(c.Query("task_id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务ID"})
+		return
+	}
+
+	progress, err := h.dataService.GetImportProgress(uint(taskID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有导入记录"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": data})
+	c.JSON(http.StatusOK, gin.H{"data": progress})
 }
 
-// ExecuteSQL 执行SQL查询
+// ExecuteSQL 在外部数据源上执行只读查询并保存为任务数据
 func (h *DataHandler) ExecuteSQL(c *gin.Context) {
 	var request struct {
-		Query  string `json:"query" binding:"required"`
-		TaskID uint   `json:"task_id" binding:"required"`
+		DataSource string                 `json:"data_source" binding:"required"`
+		Query      string                 `json:"query" binding:"required"`
+		Params     map[string]interface{} `json:"params"`
+		TaskID     uint                   `json:"task_id" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -86,21 +103,195 @@ func (h *DataHandler) ExecuteSQL(c *gin.Context) {
 		return
 	}
 
-	data, err := h.dataService.ExecuteSQLQuery(request.Query, request.TaskID)
+	result, err := h.dataService.ExecuteSQLQuery(c.Request.Context(), c.MustGet("projectID").(uint), request.DataSource, request.Query, request.Params, request.TaskID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result.Rows, "columns": result.Columns, "truncated": result.Truncated})
+}
+
+// ListDataSources 获取当前项目可以使用的外部数据源
+func (h *DataHandler) ListDataSources(c *gin.Context) {
+	sources := h.dataService.GetDataSourceService()
+	if sources == nil {
+		c.JSON(http.StatusOK, gin.H{"data": []services.DataSourceInfo{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": sources.List(c.MustGet("projectID").(uint))})
+}
+
+// CreateSavedQuery 保存查询
+func (h *DataHandler) CreateSavedQuery(c *gin.Context) {
+	var request struct {
+		Name        string                 `json:"name" binding:"required"`
+		Description string                 `json:"description"`
+		DataSource  string                 `json:"data_source" binding:"required"`
+		Query       string                 `json:"query" binding:"required"`
+		Defaults    map[string]interface{} `json:"defaults"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := models.SavedQuery{
+		Name:        request.Name,
+		Description: request.Description,
+		DataSource:  request.DataSource,
+		Query:       request.Query,
+		UserID:      c.MustGet("userID").(uint),
+		ProjectID:   c.MustGet("projectID").(uint),
+	}
+
+	if err := h.dataService.CreateSavedQuery(&query, request.Defaults); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": query})
+}
+
+// ListSavedQueries 获取当前项目保存的查询
+func (h *DataHandler) ListSavedQueries(c *gin.Context) {
+	queries, err := h.dataService.ListSavedQueries(c.MustGet("projectID").(uint), c.MustGet("userID").(uint))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": data})
+	c.JSON(http.StatusOK, gin.H{"data": queries})
+}
+
+// GetSavedQuery 获取保存的查询
+func (h *DataHandler) GetSavedQuery(c *gin.Context) {
+	query, ok := h.savedQuery(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": query})
+}
+
+// UpdateSavedQuery 更新保存的查询
+func (h *DataHandler) UpdateSavedQuery(c *gin.Context) {
+	query, ok := h.savedQuery(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name        string                 `json:"name"`
+		Description *string                `json:"description"`
+		DataSource  string                 `json:"data_source"`
+		Query       string                 `json:"query"`
+		Defaults    map[string]interface{} `json:"defaults"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Name != "" {
+		query.Name = request.Name
+	}
+	if request.Description != nil {
+		query.Description = *request.Description
+	}
+	if request.DataSource != "" {
+		query.DataSource = request.DataSource
+	}
+	if request.Query != "" {
+		query.Query = request.Query
+	}
+	defaults := request.Defaults
+	if defaults == nil && query.Defaults != "" {
+		json.Unmarshal([]byte(query.Defaults), &defaults)
+	}
+
+	if err := h.dataService.UpdateSavedQuery(query, defaults); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": query})
+}
+
+// DeleteSavedQuery 删除保存的查询
+func (h *DataHandler) DeleteSavedQuery(c *gin.Context) {
+	query, ok := h.savedQuery(c)
+	if !ok {
+		return
+	}
+
+	if err := h.dataService.DeleteSavedQuery(query.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "查询删除成功"})
+}
+
+// RunSavedQuery 绑定参数执行保存的查询，指定 task_id 时把结果保存为同一项目任务的数据
+func (h *DataHandler) RunSavedQuery(c *gin.Context) {
+	query, ok := h.savedQuery(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Params map[string]interface{} `json:"params"`
+		Limit  int                    `json:"limit"`
+		TaskID uint                   `json:"task_id"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.TaskID != 0 {
+		projectID, ownerID, err := h.rbacService.TaskScope(request.TaskID)
+		if err != nil || projectID != query.ProjectID || (projectID == 0 && ownerID != query.UserID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "任务不存在或不属于同一项目"})
+			return
+		}
+	}
+
+	result, err := h.dataService.RunSavedQuery(c.Request.Context(), query, request.Params, request.Limit, request.TaskID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result.Rows, "columns": result.Columns, "truncated": result.Truncated})
+}
+
+// savedQuery 读取路由参数 :id 对应的查询，权限已由中间件校验
+func (h *DataHandler) savedQuery(c *gin.Context) (*models.SavedQuery, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的查询ID"})
+		return nil, false
+	}
+
+	query, err := h.dataService.GetSavedQuery(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "查询不存在"})
+		return nil, false
+	}
+	return query, true
 }
 
 // SaveManualData 保存手动输入的数据
 func (h *DataHandler) SaveManualData(c *gin.Context) {
 	var request struct {
-		TaskID      uint                     `json:"task_id" binding:"required"`
-		Data        []map[string]interface{} `json:"data" binding:"required"`
-		Description string                   `json:"description"`
+		TaskID      uint                       `json:"task_id" binding:"required"`
+		Data        []map[string]interface{}   `json:"data" binding:"required"`
+		Description string                     `json:"description"`
+		Validation  services.ValidationOptions `json:"validation"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -108,10 +299,154 @@ func (h *DataHandler) SaveManualData(c *gin.Context) {
 		return
 	}
 
-	if err := h.dataService.SaveManualData(request.Data, request.TaskID); err != nil {
+	report, err := h.dataService.SaveManualData(c.Request.Context(), request.Data, request.TaskID, request.Validation)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "数据保存成功"})
-}
\ No newline at end of file
+	c.JSON(http.StatusOK, gin.H{"message": "数据保存成功", "validation": report})
+}
+
+// ValidateTaskData 重新检查任务数据的收件人地址
+func (h *DataHandler) ValidateTaskData(c *gin.Context) {
+	var request struct {
+		TaskID uint `json:"task_id" binding:"required"`
+		services.ValidationOptions
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := h.dataService.ValidateTaskData(c.Request.Context(), request.TaskID, request.ValidationOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+
+// GetValidationReport 获取任务最近一次的地址检查报告
+func (h *DataHandler) GetValidationReport(c *gin.Context) {
+	taskID, err := strconv.ParseUint(c.Query("task_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务ID"})
+		return
+	}
+
+	report, err := h.dataService.GetValidationReport(uint(taskID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有地址检查报告"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+// GetTaskData 分页浏览和搜索任务数据（q 为搜索词，column 限定搜索的列）
+func (h *DataHandler) GetTaskData(c *gin.Context) {
+	taskID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务ID"})
+		return
+	}
+
+	page, err := h.dataService.QueryTaskData(uint(taskID), dataQuery(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": page})
+}
+
+// GetTaskDataStats 获取任务数据的列统计
+func (h *DataHandler) GetTaskDataStats(c *gin.Context) {
+	taskID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务ID"})
+		return
+	}
+
+	stats, err := h.dataService.TaskDataStats(uint(taskID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": stats})
+}
+
+// UpdateTaskRow 修改任务数据中的一条记录，请求体为要修改的字段，值为null时删除该字段
+func (h *DataHandler) UpdateTaskRow(c *gin.Context) {
+	taskID, row, ok := taskRowParams(c)
+	if !ok {
+		return
+	}
+
+	var fields map[string]interface{}
+	if err := c.ShouldBindJSON(&fields); err != nil || len(fields) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请提供要修改的字段"})
+		return
+	}
+
+	record, err := h.dataService.UpdateTaskRow(taskID, row, fields)
+	if err != nil {
+		c.JSON(rowErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": services.DataRow{Row: row, Record: record}})
+}
+
+// DeleteTaskRow 删除任务数据中的一条记录
+func (h *DataHandler) DeleteTaskRow(c *gin.Context) {
+	taskID, row, ok := taskRowParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.dataService.DeleteTaskRow(taskID, row); err != nil {
+		c.JSON(rowErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "记录删除成功"})
+}
+
+// taskRowParams 读取路由参数 :id 和 :row
+func taskRowParams(c *gin.Context) (uint, int, bool) {
+	taskID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务ID"})
+		return 0, 0, false
+	}
+	row, err := strconv.Atoi(c.Param("row"))
+	if err != nil || row < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的记录位置"})
+		return 0, 0, false
+	}
+	return uint(taskID), row, true
+}
+
+func rowErrorStatus(err error) int {
+	if errors.Is(err, services.ErrRowNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// dataQuery 读取分页和搜索参数，每页默认20条，最多100条
+func dataQuery(c *gin.Context) services.DataQuery {
+	var query services.DataQuery
+	c.ShouldBindQuery(&query)
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PageSize < 1 || query.PageSize > 100 {
+		query.PageSize = 20
+	}
+	return query
+}
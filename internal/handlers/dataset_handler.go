@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/models"
+	"go_market_email/internal/services"
+)
+
+// maxUploadSize 上传文件大小上限 (50MB)
+const maxUploadSize = 50 * 1024 * 1024
+
+type DatasetHandler struct {
+	datasetService *services.DatasetService
+	dataService    *services.DataService
+	rbacService    *services.RBACService
+}
+
+func NewDatasetHandler(datasetService *services.DatasetService, dataService *services.DataService, rbacService *services.RBACService) *DatasetHandler {
+	return &DatasetHandler{datasetService: datasetService, dataService: dataService, rbacService: rbacService}
+}
+
+// UploadDataset 上传文件创建数据集，可选 task_id 导入后直接关联到任务
+func (h *DatasetHandler) UploadDataset(c *gin.Context) {
+	var taskID uint
+	if value := c.PostForm("task_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务ID"})
+			return
+		}
+		taskID = uint(id)
+	}
+	importUpload(c, h.datasetService, h.dataService, h.rbacService, taskID)
+}
+
+// importUpload 保存上传文件并导入为数据集，taskID 不为0时关联到同一项目的任务
+func importUpload(c *gin.Context, datasets *services.DatasetService, data *services.DataService, rbac *services.RBACService, taskID uint) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败"})
+		return
+	}
+
+	if file.Size > maxUploadSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "文件大小不能超过50MB"})
+		return
+	}
+
+	userID := c.MustGet("userID").(uint)
+	projectID := c.MustGet("projectID").(uint)
+	if taskID != 0 {
+		taskProject, taskOwner, err := rbac.TaskScope(taskID)
+		if err != nil || taskProject != projectID || (projectID == 0 && taskOwner != userID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "任务不存在或不属于当前项目"})
+			return
+		}
+	}
+
+	var options services.ReaderOptions
+	c.ShouldBind(&options)
+	mapping, ok := importMappingRules(c, data.GetImportMappingService())
+	if !ok {
+		return
+	}
+	options.Mapping = mapping
+	var validation services.ValidationOptions
+	c.ShouldBind(&validation)
+
+	// 上传文件保存为上传目录中的随机文件名，导入后删除
+	path, err := datasets.UploadPath(file.Filename)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+		return
+	}
+	defer os.Remove(path)
+	if err := c.SaveUploadedFile(file, path); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+		return
+	}
+
+	dataset := models.Dataset{
+		Name:      c.PostForm("name"),
+		Filename:  file.Filename,
+		Size:      file.Size,
+		UserID:    userID,
+		ProjectID: projectID,
+	}
+	summary, err := datasets.Import(c.Request.Context(), &dataset, path, options, validation, taskID)
+	if err != nil {
+		response := gin.H{"error": err.Error(), "summary": summary}
+		if dataset.ID != 0 {
+			response["dataset"] = dataset
+		}
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dataset":    dataset,
+		"data":       summary.Preview,
+		"total":      summary.Total,
+		"columns":    summary.Columns,
+		"sheet":      summary.Sheet,
+		"skipped":    summary.Skipped,
+		"errors":     summary.Errors,
+		"encoding":   summary.Encoding,
+		"delimiter":  summary.Delimiter,
+		"validation": summary.Validation,
+	})
+}
+
+// ListDatasets 分页获取当前项目未过期的数据集
+func (h *DatasetHandler) ListDatasets(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	datasets, total, err := h.datasetService.ListDatasets(c.MustGet("projectID").(uint), c.MustGet("userID").(uint), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      datasets,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetDataset 获取数据集
+func (h *DatasetHandler) GetDataset(c *gin.Context) {
+	dataset, ok := h.dataset(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": dataset})
+}
+
+// DeleteDataset 删除数据集，已关联任务的数据不受影响
+func (h *DatasetHandler) DeleteDataset(c *gin.Context) {
+	dataset, ok := h.dataset(c)
+	if !ok {
+		return
+	}
+
+	if err := h.datasetService.DeleteDataset(dataset.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "数据集删除成功"})
+}
+
+// GetImportProgress 获取数据集的导入进度
+func (h *DatasetHandler) GetImportProgress(c *gin.Context) {
+	dataset, ok := h.dataset(c)
+	if !ok {
+		return
+	}
+
+	progress, err := h.dataService.GetDatasetImportProgress(dataset.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有导入记录"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": progress})
+}
+
+// AttachDataset 把数据集关联到同一项目的一个或多个任务，每个任务的数据被替换为数据集的副本
+func (h *DatasetHandler) AttachDataset(c *gin.Context) {
+	dataset, ok := h.dataset(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		TaskIDs []uint `json:"task_ids" binding:"required,min=1"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 先检查全部任务，避免只关联了一部分
+	for _, taskID := range request.TaskIDs {
+		projectID, ownerID, err := h.rbacService.TaskScope(taskID)
+		if err != nil || projectID != dataset.ProjectID || (projectID == 0 && ownerID != dataset.UserID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "任务不存在或不属于同一项目", "task_id": taskID})
+			return
+		}
+	}
+
+	attached := make([]gin.H, 0, len(request.TaskIDs))
+	for _, taskID := range request.TaskIDs {
+		count, err := h.datasetService.Attach(c.Request.Context(), dataset, taskID)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, services.ErrDatasetExpired) {
+				status = http.StatusGone
+			}
+			c.JSON(status, gin.H{"error": err.Error(), "task_id": taskID, "attached": attached})
+			return
+		}
+		attached = append(attached, gin.H{"task_id": taskID, "rows": count})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": attached})
+}
+
+// dataset 读取路由参数 :id 对应的数据集，权限已由中间件校验
+func (h *DatasetHandler) dataset(c *gin.Context) (*models.Dataset, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的数据集ID"})
+		return nil, false
+	}
+
+	dataset, err := h.datasetService.GetDataset(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "数据集不存在"})
+		return nil, false
+	}
+	return dataset, true
+}
+
+// GetDatasetRows 分页浏览和搜索数据集的记录
+func (h *DatasetHandler) GetDatasetRows(c *gin.Context) {
+	dataset, ok := h.dataset(c)
+	if !ok {
+		return
+	}
+
+	page, err := h.datasetService.QueryRows(dataset.ID, dataQuery(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": page})
+}
+
+// GetDatasetStats 获取数据集的列统计
+func (h *DatasetHandler) GetDatasetStats(c *gin.Context) {
+	dataset, ok := h.dataset(c)
+	if !ok {
+		return
+	}
+
+	stats, err := h.datasetService.Stats(dataset.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": stats})
+}
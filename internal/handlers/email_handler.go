@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -17,15 +19,17 @@ type EmailHandler struct {
 	emailService    *services.EmailService
 	templateService *services.TemplateService
 	dataService     *services.DataService
+	datasetService  *services.DatasetService
 	aiService       *services.AIService
 }
 
 func NewEmailHandler(emailService *services.EmailService, templateService *services.TemplateService, 
-	dataService *services.DataService, aiService *services.AIService) *EmailHandler {
+	dataService *services.DataService, datasetService *services.DatasetService, aiService *services.AIService) *EmailHandler {
 	return &EmailHandler{
 		emailService:    emailService,
 		templateService: templateService,
 		dataService:     dataService,
+		datasetService:  datasetService,
 		aiService:       aiService,
 	}
 }
@@ -80,29 +84,89 @@ func (h *EmailHandler) SendTestEmail(c *gin.Context) {
 	subject := h.templateService.ReplaceVariables(template.Subject, data)
 	content := h.templateService.ReplaceVariables(template.Content, data)
 	
-	// 处理附件
+	// 模板附件和 attachment_ids 指定的附件库文件
+	attachmentService := h.emailService.GetAttachmentService()
+	ids, err := parseIDList(c.PostForm("attachment_ids"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的附件ID"})
+		return
+	}
+	if err := attachmentService.CheckAttachments(ids, c.MustGet("projectID").(uint), c.MustGet("userID").(uint)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	attachments, err := attachmentService.Resolve(template.AttachmentIDs, ids)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	// 模板引用的图片改写为内嵌附件或公开地址
+	content, inline, err := h.emailService.GetAssetService().RenderImages(template, content, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	attachments = append(attachments, inline...)
+	
+	// 和批量发送一样内联CSS并删除脚本
+	content, _ = services.ProcessHTML(content)
+	
+	// 模板引用的附件模板用测试数据生成PDF
+	pdfTemplates, err := h.templateService.GetPDFTemplates(template.PDFTemplateIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range pdfTemplates {
+		pdfSubject := h.templateService.ReplaceVariables(pdfTemplates[i].Subject, data)
+		pdfContent := h.templateService.ReplaceVariables(pdfTemplates[i].Content, data)
+		attachment, err := h.emailService.GetPDFService().RenderAttachment(&pdfTemplates[i], pdfSubject, pdfContent)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		attachments = append(attachments, *attachment)
+	}
+	
+	// 处理上传的附件，保存为随机文件名，发送时使用原文件名
 	form, err := c.MultipartForm()
-	var attachments []string
 	if err == nil && form.File["attachments"] != nil {
-		// 确保临时目录存在
-		os.MkdirAll("./temp", 0755)
+		var uploaded []string
+		// 发送完成后清理临时文件
+		defer func() {
+			for _, path := range uploaded {
+				os.Remove(path)
+			}
+		}()
 		
 		for _, file := range form.File["attachments"] {
-			// 保存附件到临时目录
-			dst := "./temp/" + file.Filename
-			if err := c.SaveUploadedFile(file, dst); err != nil {
+			if file.Size > attachmentService.MaxSize() {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("附件 %s 超过大小上限", file.Filename)})
+				return
+			}
+			tmp, err := os.CreateTemp("", "test-attachment-*")
+			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "保存附件失败"})
 				return
 			}
-			attachments = append(attachments, dst)
-		}
-		
-		// 发送完成后清理临时文件
-		defer func() {
-			for _, attachment := range attachments {
-				os.Remove(attachment)
+			tmp.Close()
+			uploaded = append(uploaded, tmp.Name())
+			if err := c.SaveUploadedFile(file, tmp.Name()); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "保存附件失败"})
+				return
 			}
-		}()
+			attachments = append(attachments, services.MailAttachment{
+				Name:        filepath.Base(file.Filename),
+				ContentType: file.Header.Get("Content-Type"),
+				Path:        tmp.Name(),
+				Size:        file.Size,
+			})
+		}
+	}
+	if err := attachmentService.CheckTotalSize(attachments); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 	
 	// 发送邮件
@@ -111,7 +175,7 @@ func (h *EmailHandler) SendTestEmail(c *gin.Context) {
 		h.emailService.GetLogger().Error("测试邮件发送失败",
 			zap.String("email", email),
 			zap.String("subject", subject),
-			zap.Strings("attachments", attachments),
+			zap.Int("attachments", len(attachments)),
 			zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -130,7 +194,75 @@ func (h *EmailHandler) CreateEmailTask(c *gin.Context) {
 	
 	userID, _ := c.Get("userID")
 	task.UserID = userID.(uint)
+	task.ProjectID = c.MustGet("projectID").(uint)
 	
+	// 模板必须属于同一个项目
+	if task.TemplateID != 0 {
+		template, err := h.templateService.GetTemplate(task.TemplateID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "模板不存在"})
+			return
+		}
+		if template.ProjectID != task.ProjectID || (task.ProjectID == 0 && template.UserID != task.UserID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "模板不属于当前项目"})
+			return
+		}
+		if template.Type == services.TemplateTypePDF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "附件模板不能作为邮件模板"})
+			return
+		}
+	}
+
+	// 分群同样必须属于同一个项目，收件人在发送时解析
+	if task.SegmentID != 0 {
+		segmentService := h.emailService.GetSegmentService()
+		segment, err := segmentService.GetSegment(task.SegmentID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "分群不存在"})
+			return
+		}
+		if segment.ProjectID != task.ProjectID || (task.ProjectID == 0 && segment.UserID != task.UserID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "分群不属于当前项目"})
+			return
+		}
+		if task.SegmentVersion != 0 {
+			if _, err := segmentService.GetVersion(segment, task.SegmentVersion); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "分群版本不存在"})
+				return
+			}
+		}
+		task.DataSource = "segment"
+	}
+
+	// 数据集同样必须属于同一个项目，任务创建后复制为任务数据
+	var dataset *models.Dataset
+	if task.DatasetID != 0 {
+		if task.SegmentID != 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不能同时指定分群和数据集"})
+			return
+		}
+		var err error
+		dataset, err = h.datasetService.GetDataset(task.DatasetID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "数据集不存在"})
+			return
+		}
+		if dataset.ProjectID != task.ProjectID || (task.ProjectID == 0 && dataset.UserID != task.UserID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "数据集不属于当前项目"})
+			return
+		}
+	}
+
+	// 附件和附件模板同样必须属于同一个项目
+	if err := h.emailService.GetAttachmentService().CheckAttachments(task.AttachmentIDs, task.ProjectID, task.UserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.templateService.CheckPDFTemplates(task.PDFTemplateIDs, task.ProjectID, task.UserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 初始化JSON字段
 	if task.Recipients == "" {
 		task.Recipients = "[]"
@@ -143,6 +275,14 @@ func (h *EmailHandler) CreateEmailTask(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+
+	if dataset != nil {
+		if _, err := h.datasetService.Attach(c.Request.Context(), dataset, task.ID); err != nil {
+			h.emailService.DB.Unscoped().Delete(&task)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 	
 	c.JSON(http.StatusCreated, gin.H{"data": task})
 }
@@ -150,11 +290,12 @@ func (h *EmailHandler) CreateEmailTask(c *gin.Context) {
 // ListTasks 获取任务列表
 func (h *EmailHandler) ListTasks(c *gin.Context) {
 	userID, _ := c.Get("userID")
+	projectID := c.MustGet("projectID").(uint)
 	status := c.Query("status")
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
 	
-	query := h.emailService.DB.Model(&models.EmailTask{}).Where("user_id = ?", userID)
+	query := h.emailService.DB.Model(&models.EmailTask{}).Scopes(services.InProject(projectID, userID.(uint)))
 	if status != "" {
 		query = query.Where("status = ?", status)
 	}
@@ -200,6 +341,44 @@ func (h *EmailHandler) GetTaskLogs(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": logs})
 }
 
+// ListLogs 获取当前项目的发送日志
+func (h *EmailHandler) ListLogs(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projectID := c.MustGet("projectID").(uint)
+	status := c.Query("status")
+	recipient := c.Query("recipient")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	
+	tasks := h.emailService.DB.Model(&models.EmailTask{}).Select("id").
+		Scopes(services.InProject(projectID, userID.(uint)))
+	query := h.emailService.DB.Model(&models.EmailLog{}).Where("task_id IN (?)", tasks)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if recipient != "" {
+		query = query.Where("recipient = ?", recipient)
+	}
+	
+	var total int64
+	query.Count(&total)
+	
+	var logs []models.EmailLog
+	offset := (page - 1) * pageSize
+	err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&logs).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"data":      logs,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
 // StartTask 启动任务
 func (h *EmailHandler) StartTask(c *gin.Context) {
 	taskID, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -208,6 +387,27 @@ func (h *EmailHandler) StartTask(c *gin.Context) {
 		return
 	}
 	
+	// 配置了最低分时，模板检查得分不足的任务不能启动
+	if minScore := h.emailService.LintMinScore(); minScore > 0 {
+		var task models.EmailTask
+		if err := h.emailService.DB.Preload("Template").First(&task, taskID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+			return
+		}
+		result, err := h.emailService.LintTask(&task, h.dataService)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if result.Score < minScore {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("模板检查得分 %d 低于 %d，任务未启动", result.Score, minScore),
+				"data":  result,
+			})
+			return
+		}
+	}
+	
 	// 更新任务状态
 	h.emailService.DB.Model(&models.EmailTask{}).Where("id = ?", taskID).Update("status", "pending")
 	
@@ -238,4 +438,20 @@ func (h *EmailHandler) DeleteTask(c *gin.Context) {
 	}
 	
 	c.JSON(http.StatusOK, gin.H{"message": "任务删除成功"})
-}
\ No newline at end of file
+}
+// parseIDList 解析逗号分隔的ID列表
+func parseIDList(value string) (models.IDList, error) {
+	var ids models.IDList
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
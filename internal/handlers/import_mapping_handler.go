@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/models"
+	"go_market_email/internal/services"
+)
+
+type ImportMappingHandler struct {
+	mappingService *services.ImportMappingService
+}
+
+func NewImportMappingHandler(mappingService *services.ImportMappingService) *ImportMappingHandler {
+	return &ImportMappingHandler{mappingService: mappingService}
+}
+
+// CreateMapping 保存列映射预设
+func (h *ImportMappingHandler) CreateMapping(c *gin.Context) {
+	var request struct {
+		Name        string                      `json:"name" binding:"required"`
+		Description string                      `json:"description"`
+		Rules       services.ImportMappingRules `json:"rules" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	mapping := models.ImportMapping{
+		Name:        request.Name,
+		Description: request.Description,
+		UserID:      c.MustGet("userID").(uint),
+		ProjectID:   c.MustGet("projectID").(uint),
+	}
+
+	if err := h.mappingService.CreateMapping(&mapping, &request.Rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": mapping})
+}
+
+// ListMappings 获取当前项目的列映射预设
+func (h *ImportMappingHandler) ListMappings(c *gin.Context) {
+	mappings, err := h.mappingService.ListMappings(c.MustGet("projectID").(uint), c.MustGet("userID").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": mappings})
+}
+
+// GetMapping 获取列映射预设
+func (h *ImportMappingHandler) GetMapping(c *gin.Context) {
+	mapping, ok := h.mapping(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": mapping})
+}
+
+// UpdateMapping 更新列映射预设
+func (h *ImportMappingHandler) UpdateMapping(c *gin.Context) {
+	mapping, ok := h.mapping(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name        string                       `json:"name"`
+		Description *string                      `json:"description"`
+		Rules       *services.ImportMappingRules `json:"rules"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Name != "" {
+		mapping.Name = request.Name
+	}
+	if request.Description != nil {
+		mapping.Description = *request.Description
+	}
+	rules := request.Rules
+	if rules == nil {
+		var err error
+		if rules, err = services.MappingRules(mapping); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := h.mappingService.UpdateMapping(mapping, rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": mapping})
+}
+
+// DeleteMapping 删除列映射预设
+func (h *ImportMappingHandler) DeleteMapping(c *gin.Context) {
+	mapping, ok := h.mapping(c)
+	if !ok {
+		return
+	}
+
+	if err := h.mappingService.DeleteMapping(mapping.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "映射预设删除成功"})
+}
+
+// mapping 读取路由参数 :id 对应的映射预设，权限已由中间件校验
+func (h *ImportMappingHandler) mapping(c *gin.Context) (*models.ImportMapping, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的映射预设ID"})
+		return nil, false
+	}
+
+	mapping, err := h.mappingService.GetMapping(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "映射预设不存在"})
+		return nil, false
+	}
+	return mapping, true
+}
+
+// importMappingRules 读取上传表单中的列映射：mapping 为JSON格式的规则，
+// mapping_id 为同一项目中保存的预设，都没有时返回nil
+func importMappingRules(c *gin.Context, mappingService *services.ImportMappingService) (*services.ImportMappingRules, bool) {
+	if raw := c.PostForm("mapping"); raw != "" {
+		var rules services.ImportMappingRules
+		if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "映射规则格式错误"})
+			return nil, false
+		}
+		return &rules, true
+	}
+
+	mappingID := c.PostForm("mapping_id")
+	if mappingID == "" || mappingID == "0" {
+		return nil, true
+	}
+	id, err := strconv.ParseUint(mappingID, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的映射预设ID"})
+		return nil, false
+	}
+
+	mapping, err := mappingService.GetMapping(uint(id))
+	projectID := c.MustGet("projectID").(uint)
+	if err != nil || mapping.ProjectID != projectID || (projectID == 0 && mapping.UserID != c.MustGet("userID").(uint)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "映射预设不存在或不属于当前项目"})
+		return nil, false
+	}
+
+	rules, err := services.MappingRules(mapping)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return rules, true
+}
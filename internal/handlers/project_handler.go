@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/models"
+	"go_market_email/internal/services"
+)
+
+type ProjectHandler struct {
+	projectService *services.ProjectService
+	rbacService    *services.RBACService
+}
+
+func NewProjectHandler(projectService *services.ProjectService, rbacService *services.RBACService) *ProjectHandler {
+	return &ProjectHandler{
+		projectService: projectService,
+		rbacService:    rbacService,
+	}
+}
+
+// CreateProject 创建项目
+func (h *ProjectHandler) CreateProject(c *gin.Context) {
+	var request struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+	project := models.Project{
+		Name:        request.Name,
+		Description: request.Description,
+		UserID:      userID.(uint),
+		Status:      "active",
+	}
+
+	if err := h.projectService.CreateProject(&project); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": project})
+}
+
+// ListProjects 获取当前用户创建或参与的项目
+func (h *ProjectHandler) ListProjects(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	projects, err := h.projectService.ListProjects(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": projects})
+}
+
+// GetProject 获取项目详情及当前用户的角色
+func (h *ProjectHandler) GetProject(c *gin.Context) {
+	project, err := h.projectService.GetProject(c.MustGet("projectID").(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "项目不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": project, "role": c.MustGet("projectRole")})
+}
+
+// UpdateProject 更新项目
+func (h *ProjectHandler) UpdateProject(c *gin.Context) {
+	var request struct {
+		Name        string  `json:"name"`
+		Description *string `json:"description"`
+		Status      string  `json:"status" binding:"omitempty,oneof=active inactive"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if request.Name != "" {
+		updates["name"] = request.Name
+	}
+	if request.Description != nil {
+		updates["description"] = *request.Description
+	}
+	if request.Status != "" {
+		updates["status"] = request.Status
+	}
+
+	if err := h.projectService.UpdateProject(c.MustGet("projectID").(uint), updates); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "项目更新成功"})
+}
+
+// DeleteProject 删除项目
+func (h *ProjectHandler) DeleteProject(c *gin.Context) {
+	if err := h.projectService.DeleteProject(c.MustGet("projectID").(uint)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "项目删除成功"})
+}
+
+// SwitchProject 切换当前项目，project_id为0时切换回个人空间
+func (h *ProjectHandler) SwitchProject(c *gin.Context) {
+	var request struct {
+		ProjectID uint `json:"project_id"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.MustGet("userID").(uint)
+	if request.ProjectID != 0 {
+		if _, err := h.rbacService.GetRole(request.ProjectID, userID); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "不是项目成员"})
+			return
+		}
+	}
+
+	if err := h.projectService.SwitchProject(userID, request.ProjectID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已切换项目", "project_id": request.ProjectID})
+}
+
+// ListMembers 获取项目成员
+func (h *ProjectHandler) ListMembers(c *gin.Context) {
+	projectID := c.MustGet("projectID").(uint)
+
+	members, err := h.rbacService.ListMembers(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": members})
+}
+
+// AddMember 添加项目成员
+func (h *ProjectHandler) AddMember(c *gin.Context) {
+	var request struct {
+		UserID uint   `json:"user_id" binding:"required"`
+		Role   string `json:"role" binding:"required,oneof=owner editor sender viewer"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	projectID := c.MustGet("projectID").(uint)
+	member, err := h.rbacService.AddMember(projectID, request.UserID, request.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": member})
+}
+
+// UpdateMember 修改成员角色
+func (h *ProjectHandler) UpdateMember(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+
+	var request struct {
+		Role string `json:"role" binding:"required,oneof=owner editor sender viewer"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	projectID := c.MustGet("projectID").(uint)
+	if err := h.rbacService.UpdateMemberRole(projectID, uint(userID), request.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "成员角色已更新"})
+}
+
+// RemoveMember 移除项目成员
+func (h *ProjectHandler) RemoveMember(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("userId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+
+	projectID := c.MustGet("projectID").(uint)
+	if err := h.rbacService.RemoveMember(projectID, uint(userID)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "成员已移除"})
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/models"
+	"go_market_email/internal/services"
+)
+
+type SegmentHandler struct {
+	segmentService *services.SegmentService
+}
+
+func NewSegmentHandler(segmentService *services.SegmentService) *SegmentHandler {
+	return &SegmentHandler{segmentService: segmentService}
+}
+
+// CreateSegment 创建分群
+func (h *SegmentHandler) CreateSegment(c *gin.Context) {
+	var request struct {
+		Name        string                     `json:"name" binding:"required"`
+		Description string                     `json:"description"`
+		Definition  services.SegmentDefinition `json:"definition" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	segment := models.Segment{
+		Name:        request.Name,
+		Description: request.Description,
+		UserID:      c.MustGet("userID").(uint),
+		ProjectID:   c.MustGet("projectID").(uint),
+	}
+
+	if err := h.segmentService.CreateSegment(&segment, request.Definition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": segment})
+}
+
+// ListSegments 获取当前项目的分群
+func (h *SegmentHandler) ListSegments(c *gin.Context) {
+	segments, err := h.segmentService.ListSegments(c.MustGet("projectID").(uint), c.MustGet("userID").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": segments})
+}
+
+// GetSegment 获取分群及其当前版本的定义
+func (h *SegmentHandler) GetSegment(c *gin.Context) {
+	segment, ok := h.segment(c)
+	if !ok {
+		return
+	}
+
+	version, err := h.segmentService.GetVersion(segment, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": segment, "version": version})
+}
+
+// UpdateSegment 更新分群，提交 definition 时保存为新版本
+func (h *SegmentHandler) UpdateSegment(c *gin.Context) {
+	segment, ok := h.segment(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name        string                      `json:"name"`
+		Description *string                     `json:"description"`
+		Definition  *services.SegmentDefinition `json:"definition"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if request.Name != "" {
+		updates["name"] = request.Name
+	}
+	if request.Description != nil {
+		updates["description"] = *request.Description
+	}
+
+	if err := h.segmentService.UpdateSegment(segment, updates, request.Definition, c.MustGet("userID").(uint)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	segment, _ = h.segmentService.GetSegment(segment.ID)
+	c.JSON(http.StatusOK, gin.H{"data": segment})
+}
+
+// DeleteSegment 删除分群
+func (h *SegmentHandler) DeleteSegment(c *gin.Context) {
+	segment, ok := h.segment(c)
+	if !ok {
+		return
+	}
+
+	if err := h.segmentService.DeleteSegment(segment.ID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "分群删除成功"})
+}
+
+// ListVersions 获取分群的历史版本
+func (h *SegmentHandler) ListVersions(c *gin.Context) {
+	segment, ok := h.segment(c)
+	if !ok {
+		return
+	}
+
+	versions, err := h.segmentService.ListVersions(segment.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": versions})
+}
+
+// PreviewSegment 预览分群的数量和样本，可通过 version 查询参数指定版本
+func (h *SegmentHandler) PreviewSegment(c *gin.Context) {
+	segment, ok := h.segment(c)
+	if !ok {
+		return
+	}
+
+	versionNumber, _ := strconv.Atoi(c.DefaultQuery("version", "0"))
+	sampleSize, _ := strconv.Atoi(c.DefaultQuery("sample", "10"))
+
+	version, err := h.segmentService.GetVersion(segment, versionNumber)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "分群版本不存在"})
+		return
+	}
+
+	preview, err := h.segmentService.Preview(c.Request.Context(), segment, version, sampleSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": preview})
+}
+
+// PreviewDefinition 预览尚未保存的分群定义
+func (h *SegmentHandler) PreviewDefinition(c *gin.Context) {
+	var request struct {
+		Definition services.SegmentDefinition `json:"definition" binding:"required"`
+		Sample     int                        `json:"sample"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preview, err := h.segmentService.PreviewDefinition(c.Request.Context(),
+		c.MustGet("projectID").(uint), c.MustGet("userID").(uint), request.Definition, request.Sample)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": preview})
+}
+
+// segment 读取路由参数 :id 对应的分群，权限已由中间件校验
+func (h *SegmentHandler) segment(c *gin.Context) (*models.Segment, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的分群ID"})
+		return nil, false
+	}
+
+	segment, err := h.segmentService.GetSegment(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "分群不存在"})
+		return nil, false
+	}
+	return segment, true
+}
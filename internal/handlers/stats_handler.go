@@ -2,8 +2,13 @@ package handlers
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -20,51 +25,130 @@ type StatsHandler struct {
 	rdb          *redis.Client
 	logger       *zap.Logger
 	emailService *services.EmailService
+	rbac         *services.RBACService
+	upgrader     websocket.Upgrader
 }
 
-func NewStatsHandler(db *gorm.DB, rdb *redis.Client, logger *zap.Logger, emailService *services.EmailService) *StatsHandler {
+func NewStatsHandler(db *gorm.DB, rdb *redis.Client, logger *zap.Logger, emailService *services.EmailService, rbac *services.RBACService, allowedOrigins []string) *StatsHandler {
 	return &StatsHandler{
 		db:           db,
 		rdb:          rdb,
 		logger:       logger,
 		emailService: emailService,
+		rbac:         rbac,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: originChecker(allowedOrigins),
+		},
 	}
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// WebSocket保活参数
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// originChecker 同源或在允许列表中的Origin才能建立WebSocket连接
+func originChecker(allowedOrigins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// 非浏览器客户端不携带Origin
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" || strings.EqualFold(allowed, origin) {
+				return true
+			}
+		}
+		return false
+	}
 }
 
-// GetStats 获取统计数据
-func (h *StatsHandler) GetStats(c *gin.Context) {
-	var stats struct {
-		TemplateCount int64 `json:"template_count"`
-		PendingCount  int64 `json:"pending_count"`
-		SentCount     int64 `json:"sent_count"`
-		FailedCount   int64 `json:"failed_count"`
+// wsSubscription WebSocket连接的订阅状态，读写协程共享
+type wsSubscription struct {
+	mu        sync.RWMutex
+	userID    uint
+	taskIDs   map[uint]bool
+	projectID uint
+}
+
+// wsMessage 客户端订阅消息
+type wsMessage struct {
+	Action    string `json:"action"` // subscribe, unsubscribe
+	TaskIDs   []uint `json:"task_ids"`
+	ProjectID uint   `json:"project_id"`
+}
+
+// match 未指定任务和项目时只推送当前用户的个人任务；
+// 订阅的任务和项目在订阅时已校验过权限，之后由revalidate定期复查
+func (s *wsSubscription) match(event services.TaskEvent) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.taskIDs) == 0 && s.projectID == 0 {
+		return event.UserID == s.userID && event.ProjectID == 0
 	}
+	return s.taskIDs[event.TaskID] || (s.projectID != 0 && event.ProjectID == s.projectID)
+}
+
+func (s *wsSubscription) state() gin.H {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	taskIDs := make([]uint, 0, len(s.taskIDs))
+	for id := range s.taskIDs {
+		taskIDs = append(taskIDs, id)
+	}
+	return gin.H{"task_ids": taskIDs, "project_id": s.projectID}
+}
+
+// statsCounts 统计数据
+type statsCounts struct {
+	TemplateCount int64 `json:"template_count"`
+	PendingCount  int64 `json:"pending_count"`
+	SentCount     int64 `json:"sent_count"`
+	FailedCount   int64 `json:"failed_count"`
+}
+
+// GetStats 获取当前项目的统计数据
+func (h *StatsHandler) GetStats(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	projectID := c.MustGet("projectID").(uint)
+
+	c.JSON(http.StatusOK, gin.H{"data": h.countStats(projectID, userID)})
+}
+
+// countStats 统计项目（或个人空间）的模板、任务和发送数量
+func (h *StatsHandler) countStats(projectID, userID uint) statsCounts {
+	var stats statsCounts
+	scope := services.InProject(projectID, userID)
 
 	// 模板数量
-	h.db.Model(&models.EmailTemplate{}).Where("status = ?", "active").Count(&stats.TemplateCount)
+	h.db.Model(&models.EmailTemplate{}).Scopes(scope).Where("status = ?", "active").Count(&stats.TemplateCount)
 
 	// 待发送邮件数量
-	h.db.Model(&models.EmailTask{}).Where("status IN ?", []string{"pending", "running"}).Count(&stats.PendingCount)
+	h.db.Model(&models.EmailTask{}).Scopes(scope).Where("status IN ?", []string{"pending", "running"}).Count(&stats.PendingCount)
 
-	// 发送成功数量
-	h.db.Model(&models.EmailLog{}).Where("status = ?", "sent").Count(&stats.SentCount)
+	// 发送成功和失败数量
+	tasks := h.db.Model(&models.EmailTask{}).Select("id").Scopes(scope)
+	h.db.Model(&models.EmailLog{}).Where("status = ? AND task_id IN (?)", "sent", tasks).Count(&stats.SentCount)
+	h.db.Model(&models.EmailLog{}).Where("status = ? AND task_id IN (?)", "failed", tasks).Count(&stats.FailedCount)
 
-	// 发送失败数量
-	h.db.Model(&models.EmailLog{}).Where("status = ?", "failed").Count(&stats.FailedCount)
-
-	c.JSON(http.StatusOK, gin.H{"data": stats})
+	return stats
 }
 
 // GetRunningTasks 获取运行中的任务
 func (h *StatsHandler) GetRunningTasks(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	projectID := c.MustGet("projectID").(uint)
+
 	var tasks []models.EmailTask
-	err := h.db.Where("status IN ?", []string{"running", "paused"}).
+	err := h.db.Scopes(services.InProject(projectID, userID)).Where("status IN ?", []string{"running", "paused"}).
 		Preload("Template").Find(&tasks).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -91,54 +175,257 @@ func (h *StatsHandler) GetRunningTasks(c *gin.Context) {
 }
 
 // WebSocketStats WebSocket实时统计
+// 连接建立时推送当前项目的统计快照，之后通过Redis事件流推送该项目任务的增量更新。
+// 客户端可发送 {"action":"subscribe","task_ids":[1,2]} 或 {"action":"subscribe","project_id":1}
+// 只关注指定任务或项目，发送 unsubscribe 取消；也可通过 task_id=1,2 查询参数设置初始订阅。
 func (h *StatsHandler) WebSocketStats(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	userID, _ := c.Get("userID")
+	sub := &wsSubscription{userID: userID.(uint), taskIDs: make(map[uint]bool)}
+	sub.projectID = c.MustGet("projectID").(uint)
+	for _, id := range parseTaskIDs(c.Query("task_id")) {
+		if h.canReadTask(sub.userID, id) {
+			sub.taskIDs[id] = true
+		}
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		h.logger.Error("WebSocket升级失败", zap.Error(err))
 		return
 	}
 	defer conn.Close()
 
-	ctx := context.Background()
-	ticker := time.NewTicker(5 * time.Second)
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	events := h.emailService.GetEventService().Subscribe(ctx, sub.match)
+	replies := make(chan gin.H, 8)
+
+	// 读协程：处理订阅消息和pong，客户端断开或超时未响应时结束连接
+	go func() {
+		defer cancel()
+
+		conn.SetReadLimit(4096)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+
+		for {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					h.logger.Warn("WebSocket连接异常断开", zap.Error(err))
+				}
+				return
+			}
+
+			reply := h.applySubscription(sub, msg)
+			select {
+			case replies <- reply:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// 所有写操作都在当前协程中完成
+	write := func(v interface{}) bool {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := conn.WriteJSON(v); err != nil {
+			h.logger.Warn("WebSocket发送数据失败", zap.Error(err))
+			return false
+		}
+		return true
+	}
+
+	if !write(gin.H{"type": "snapshot", "data": h.snapshot(sub.projectID, sub.userID)}) {
+		return
+	}
+
+	ticker := time.NewTicker(wsPingPeriod)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteWait))
+				return
+			}
+			if !write(gin.H{"type": "event", "data": event}) {
+				return
+			}
+		case reply := <-replies:
+			if !write(reply) {
+				return
+			}
 		case <-ticker.C:
-			// 获取统计数据
-			var stats struct {
-				TemplateCount int64 `json:"template_count"`
-				PendingCount  int64 `json:"pending_count"`
-				SentCount     int64 `json:"sent_count"`
-				FailedCount   int64 `json:"failed_count"`
+			if h.revalidate(sub) && !write(gin.H{"type": "unsubscribed", "data": sub.state()}) {
+				return
+			}
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
 			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-			h.db.Model(&models.EmailTemplate{}).Where("status = ?", "active").Count(&stats.TemplateCount)
-			h.db.Model(&models.EmailTask{}).Where("status IN ?", []string{"pending", "running"}).Count(&stats.PendingCount)
-			h.db.Model(&models.EmailLog{}).Where("status = ?", "sent").Count(&stats.SentCount)
-			h.db.Model(&models.EmailLog{}).Where("status = ?", "failed").Count(&stats.FailedCount)
+// applySubscription 处理客户端订阅消息，返回应答
+func (h *StatsHandler) applySubscription(sub *wsSubscription, msg wsMessage) gin.H {
+	if msg.Action != "subscribe" && msg.Action != "unsubscribe" {
+		return gin.H{"type": "error", "error": "未知的操作: " + msg.Action}
+	}
 
-			// 获取运行中的任务
-			var tasks []models.EmailTask
-			h.db.Where("status IN ?", []string{"running", "paused"}).
-				Preload("Template").Find(&tasks)
+	for _, id := range msg.TaskIDs {
+		if msg.Action == "subscribe" && !h.canReadTask(sub.userID, id) {
+			return gin.H{"type": "error", "error": fmt.Sprintf("无权订阅任务: %d", id)}
+		}
+	}
+	if msg.Action == "subscribe" && msg.ProjectID != 0 && !h.canReadProject(sub.userID, msg.ProjectID) {
+		return gin.H{"type": "error", "error": fmt.Sprintf("无权订阅项目: %d", msg.ProjectID)}
+	}
 
-			// 发送数据
-			data := map[string]interface{}{
-				"stats": stats,
-				"tasks": tasks,
-			}
+	sub.mu.Lock()
+	for _, id := range msg.TaskIDs {
+		if msg.Action == "subscribe" {
+			sub.taskIDs[id] = true
+		} else {
+			delete(sub.taskIDs, id)
+		}
+	}
+	if msg.ProjectID != 0 {
+		if msg.Action == "subscribe" {
+			sub.projectID = msg.ProjectID
+		} else if sub.projectID == msg.ProjectID {
+			sub.projectID = 0
+		}
+	}
+	sub.mu.Unlock()
 
-			if err := conn.WriteJSON(data); err != nil {
-				h.logger.Error("WebSocket发送数据失败", zap.Error(err))
-				return
+	return gin.H{"type": "subscribed", "data": sub.state()}
+}
+
+// revalidate 重新校验订阅的任务和项目权限，移除已无权访问的订阅（例如用户被移出项目），
+// 有订阅被移除时返回true
+func (h *StatsHandler) revalidate(sub *wsSubscription) bool {
+	sub.mu.RLock()
+	userID, projectID := sub.userID, sub.projectID
+	taskIDs := make([]uint, 0, len(sub.taskIDs))
+	for id := range sub.taskIDs {
+		taskIDs = append(taskIDs, id)
+	}
+	sub.mu.RUnlock()
+
+	// 权限查询不持有锁，避免阻塞事件匹配
+	var revokedTasks []uint
+	for _, id := range taskIDs {
+		if !h.canReadTask(userID, id) {
+			revokedTasks = append(revokedTasks, id)
+		}
+	}
+	revokedProject := projectID != 0 && !h.canReadProject(userID, projectID)
+	if len(revokedTasks) == 0 && !revokedProject {
+		return false
+	}
+
+	sub.mu.Lock()
+	for _, id := range revokedTasks {
+		delete(sub.taskIDs, id)
+	}
+	if revokedProject && sub.projectID == projectID {
+		sub.projectID = 0
+	}
+	sub.mu.Unlock()
+
+	h.logger.Info("移除无权访问的实时订阅",
+		zap.Uint("user_id", userID), zap.Uints("task_ids", revokedTasks), zap.Bool("project", revokedProject))
+	return true
+}
+
+func (h *StatsHandler) canReadTask(userID, taskID uint) bool {
+	projectID, ownerID, err := h.rbac.TaskScope(taskID)
+	if err != nil {
+		return false
+	}
+	if projectID == 0 {
+		return ownerID == userID
+	}
+	role, err := h.rbac.GetRole(projectID, userID)
+	return err == nil && h.rbac.Can(role, services.PermTaskRead)
+}
+
+func (h *StatsHandler) canReadProject(userID, projectID uint) bool {
+	role, err := h.rbac.GetRole(projectID, userID)
+	return err == nil && h.rbac.Can(role, services.PermStatsRead)
+}
+
+// StreamEvents SSE实时事件流，参数同WebSocketStats
+func (h *StatsHandler) StreamEvents(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	sub := &wsSubscription{userID: userID.(uint), taskIDs: make(map[uint]bool)}
+	sub.projectID = c.MustGet("projectID").(uint)
+	for _, id := range parseTaskIDs(c.Query("task_id")) {
+		if h.canReadTask(sub.userID, id) {
+			sub.taskIDs[id] = true
+		}
+	}
+	events := h.emailService.GetEventService().Subscribe(c.Request.Context(), sub.match)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent("snapshot", h.snapshot(sub.projectID, sub.userID))
+	c.Writer.Flush()
+
+	// 与WebSocket保活同周期复查订阅权限
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
 			}
+			c.SSEvent(event.Type, event)
+		case <-ticker.C:
+			if h.revalidate(sub) {
+				c.SSEvent("unsubscribed", sub.state())
+			}
+		}
+		return true
+	})
+}
 
-		case <-ctx.Done():
-			return
+// snapshot 查询项目（或个人空间）当前的统计和运行中任务
+func (h *StatsHandler) snapshot(projectID, userID uint) gin.H {
+	var tasks []models.EmailTask
+	h.db.Scopes(services.InProject(projectID, userID)).Where("status IN ?", []string{"running", "paused"}).
+		Preload("Template").Find(&tasks)
+
+	return gin.H{
+		"stats": h.countStats(projectID, userID),
+		"tasks": tasks,
+	}
+}
+
+// parseTaskIDs 解析逗号分隔的任务ID
+func parseTaskIDs(taskIDs string) []uint {
+	var ids []uint
+	if taskIDs == "" {
+		return ids
+	}
+	for _, idStr := range strings.Split(taskIDs, ",") {
+		if id, err := strconv.ParseUint(strings.TrimSpace(idStr), 10, 32); err == nil {
+			ids = append(ids, uint(id))
 		}
 	}
+	return ids
 }
 
 // PauseTask 暂停任务
@@ -154,9 +441,6 @@ func (h *StatsHandler) PauseTask(c *gin.Context) {
 		return
 	}
 
-	// 更新数据库状态
-	h.db.Model(&models.EmailTask{}).Where("id = ?", taskID).Update("status", "paused")
-
 	c.JSON(http.StatusOK, gin.H{"message": "任务已暂停"})
 }
 
@@ -173,8 +457,7 @@ func (h *StatsHandler) ResumeTask(c *gin.Context) {
 		return
 	}
 
-	// 更新数据库状态并重新加入队列
-	h.db.Model(&models.EmailTask{}).Where("id = ?", taskID).Update("status", "running")
+	// 重新加入队列
 	h.emailService.QueueEmailTask(uint(taskID))
 
 	c.JSON(http.StatusOK, gin.H{"message": "任务已恢复"})
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 	
 	"github.com/gin-gonic/gin"
 	"go_market_email/internal/models"
@@ -10,11 +13,22 @@ import (
 )
 
 type TemplateHandler struct {
-	templateService *services.TemplateService
+	templateService   *services.TemplateService
+	attachmentService *services.AttachmentService
+	assetService      *services.AssetService
+	pdfService        *services.PDFService
+	linkChecker       *services.LinkChecker
 }
 
-func NewTemplateHandler(templateService *services.TemplateService) *TemplateHandler {
-	return &TemplateHandler{templateService: templateService}
+func NewTemplateHandler(templateService *services.TemplateService, attachmentService *services.AttachmentService,
+	assetService *services.AssetService, pdfService *services.PDFService, linkChecker *services.LinkChecker) *TemplateHandler {
+	return &TemplateHandler{
+		templateService:   templateService,
+		attachmentService: attachmentService,
+		assetService:      assetService,
+		pdfService:        pdfService,
+		linkChecker:       linkChecker,
+	}
 }
 
 // CreateTemplate 创建模板
@@ -31,9 +45,20 @@ func (h *TemplateHandler) CreateTemplate(c *gin.Context) {
 		return
 	}
 	
-	// 从上下文获取用户信息
+	// 从上下文获取用户和项目信息
 	userID, _ := c.Get("userID")
 	template.UserID = userID.(uint)
+	template.ProjectID = c.MustGet("projectID").(uint)
+	
+	// 附件和附件模板必须属于同一个项目
+	if err := h.attachmentService.CheckAttachments(template.AttachmentIDs, template.ProjectID, template.UserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.templateService.CheckPDFTemplates(template.PDFTemplateIDs, template.ProjectID, template.UserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	
 	if err := h.templateService.CreateTemplate(&template); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -63,11 +88,11 @@ func (h *TemplateHandler) GetTemplate(c *gin.Context) {
 // ListTemplates 获取模板列表
 func (h *TemplateHandler) ListTemplates(c *gin.Context) {
 	userID, _ := c.Get("userID")
-	projectID, _ := strconv.ParseUint(c.DefaultQuery("project_id", "1"), 10, 32)
+	projectID := c.MustGet("projectID").(uint)
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
 	
-	templates, total, err := h.templateService.ListTemplates(userID.(uint), uint(projectID), page, pageSize)
+	templates, total, err := h.templateService.ListTemplates(userID.(uint), projectID, c.Query("type"), page, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -95,7 +120,21 @@ func (h *TemplateHandler) UpdateTemplate(c *gin.Context) {
 		return
 	}
 	
+	projectID, userID := c.MustGet("projectID").(uint), c.MustGet("userID").(uint)
+	if err := h.attachmentService.CheckAttachments(updates.AttachmentIDs, projectID, userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.templateService.CheckPDFTemplates(updates.PDFTemplateIDs, projectID, userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
 	if err := h.templateService.UpdateTemplate(uint(id), &updates); err != nil {
+		if errors.Is(err, services.ErrInvalidTemplate) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -157,10 +196,150 @@ func (h *TemplateHandler) PreviewTemplate(c *gin.Context) {
 	subject := h.templateService.ReplaceVariables(template.Subject, request.Data)
 	content := h.templateService.ReplaceVariables(template.Content, request.Data)
 	
+	// 预览时图片资源使用公开地址
+	content, _, err = h.assetService.RenderImages(template, content, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	// 返回发送时实际使用的内容，html_report 说明内联、删除的内容和大小
+	content, report := services.ProcessHTML(content)
+	
 	c.JSON(http.StatusOK, gin.H{
 		"data": gin.H{
-			"subject": subject,
-			"content": content,
+			"subject":     subject,
+			"content":     content,
+			"html_report": report,
 		},
 	})
-}
\ No newline at end of file
+}
+// PreviewPDF 用一条示例记录生成附件模板的PDF并直接返回
+func (h *TemplateHandler) PreviewPDF(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的模板ID"})
+		return
+	}
+	
+	var request struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	template, err := h.templateService.GetTemplate(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "模板不存在"})
+		return
+	}
+	if template.Type != services.TemplateTypePDF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不是附件模板"})
+		return
+	}
+	
+	subject := h.templateService.ReplaceVariables(template.Subject, request.Data)
+	content := h.templateService.ReplaceVariables(template.Content, request.Data)
+	attachment, err := h.pdfService.RenderAttachment(template, subject, content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachment.Name}))
+	c.Data(http.StatusOK, attachment.ContentType, attachment.Content)
+}
+
+// LintTemplate 检查模板并打分，?columns=name,email 指定数据列时检查未知变量
+func (h *TemplateHandler) LintTemplate(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的模板ID"})
+		return
+	}
+	
+	template, err := h.templateService.GetTemplate(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "模板不存在"})
+		return
+	}
+	
+	// 没有指定列时不检查未知变量
+	var columns []string
+	if value, ok := c.GetQuery("columns"); ok {
+		for _, column := range strings.Split(value, ",") {
+			if column = strings.TrimSpace(column); column != "" {
+				columns = append(columns, column)
+			}
+		}
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"data": h.templateService.LintTemplate(template, columns)})
+}
+
+// StartLinkCheck 用示例数据渲染模板，在后台检查其中的链接和图片地址
+func (h *TemplateHandler) StartLinkCheck(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的模板ID"})
+		return
+	}
+	
+	var request struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	template, err := h.templateService.GetTemplate(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "模板不存在"})
+		return
+	}
+	
+	// 与预览相同：图片资源使用公开地址，检查发送前处理后的内容
+	content := h.templateService.ReplaceVariables(template.Content, request.Data)
+	content, _, err = h.assetService.RenderImages(template, content, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	content, _ = services.ProcessHTML(content)
+	
+	report, err := h.linkChecker.Start(template.ID, content)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrLinkCheckRunning) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusAccepted, gin.H{"data": report})
+}
+
+// GetLinkCheck 获取模板最近一次链接检查的进度和结果
+func (h *TemplateHandler) GetLinkCheck(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的模板ID"})
+		return
+	}
+	
+	report, err := h.linkChecker.GetReport(uint(id))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrLinkCheckNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
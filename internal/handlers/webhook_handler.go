@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/models"
+	"go_market_email/internal/services"
+	"gorm.io/gorm"
+)
+
+type WebhookHandler struct {
+	webhookService *services.WebhookService
+}
+
+func NewWebhookHandler(webhookService *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService}
+}
+
+// CreateSubscription 创建Webhook订阅
+func (h *WebhookHandler) CreateSubscription(c *gin.Context) {
+	var request struct {
+		Name      string   `json:"name" binding:"required"`
+		URL       string   `json:"url" binding:"required,url"`
+		Secret    string   `json:"secret"`
+		Events    []string `json:"events" binding:"required"`
+		ProjectID uint     `json:"project_id"` // 由权限中间件校验成员身份，处理时使用上下文中的项目
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 未指定密钥时自动生成
+	secret := request.Secret
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "生成签名密钥失败"})
+			return
+		}
+		secret = hex.EncodeToString(buf)
+	}
+
+	sub := models.WebhookSubscription{
+		Name:      request.Name,
+		URL:       request.URL,
+		Secret:    secret,
+		UserID:    c.MustGet("userID").(uint),
+		ProjectID: c.MustGet("projectID").(uint),
+		Status:    "active",
+	}
+
+	if err := h.webhookService.CreateSubscription(&sub, request.Events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 密钥只在创建时返回一次
+	c.JSON(http.StatusCreated, gin.H{"data": sub, "secret": secret})
+}
+
+// ListSubscriptions 获取当前项目的Webhook订阅列表
+func (h *WebhookHandler) ListSubscriptions(c *gin.Context) {
+	subs, err := h.webhookService.ListSubscriptions(c.MustGet("projectID").(uint), c.MustGet("userID").(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": subs})
+}
+
+// UpdateSubscription 更新Webhook订阅
+func (h *WebhookHandler) UpdateSubscription(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的订阅ID"})
+		return
+	}
+
+	var request struct {
+		Name   string   `json:"name"`
+		URL    string   `json:"url" binding:"omitempty,url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+		Status string   `json:"status" binding:"omitempty,oneof=active inactive"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if request.Name != "" {
+		updates["name"] = request.Name
+	}
+	if request.URL != "" {
+		updates["url"] = request.URL
+	}
+	if request.Secret != "" {
+		updates["secret"] = request.Secret
+	}
+	if request.Status != "" {
+		updates["status"] = request.Status
+	}
+
+	if err := h.webhookService.UpdateSubscription(uint(id), updates, request.Events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "订阅更新成功"})
+}
+
+// DeleteSubscription 删除Webhook订阅
+func (h *WebhookHandler) DeleteSubscription(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的订阅ID"})
+		return
+	}
+
+	if err := h.webhookService.DeleteSubscription(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "订阅删除成功"})
+}
+
+// ListDeliveries 获取订阅的投递记录
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的订阅ID"})
+		return
+	}
+
+	if _, err := h.webhookService.GetSubscription(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "订阅不存在"})
+		return
+	}
+
+	status := c.Query("status")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	deliveries, total, err := h.webhookService.ListDeliveries(uint(id), status, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":      deliveries,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// ReplayDelivery 重新投递
+func (h *WebhookHandler) ReplayDelivery(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的投递ID"})
+		return
+	}
+
+	if err := h.webhookService.Replay(uint(id)); err != nil {
+		switch {
+		case errors.Is(err, services.ErrDeliveryPending):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "投递记录不存在"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已重新加入投递队列"})
+}
+
+// ListEvents 获取支持的事件类型
+func (h *WebhookHandler) ListEvents(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": services.WebhookEvents})
+}
@@ -0,0 +1,247 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/models"
+	"go_market_email/internal/services"
+)
+
+// maxAuditResponseSize 为提取新建资源快照而缓存的最大响应长度
+const maxAuditResponseSize = 1 << 20
+
+// auditRoute 路由对应的审计动作和资源类型，资源ID取自路由参数 :id
+type auditRoute struct {
+	action   string
+	resource string
+}
+
+// auditRoutes 以 "方法 路由" 为键，未列出的修改类请求按方法和路径记录
+var auditRoutes = map[string]auditRoute{
+	"POST /api/v1/auth/refresh":                   {"auth.refresh", "user"},
+	"POST /api/v1/auth/api-keys":                  {"api_key.create", "api_key"},
+	"DELETE /api/v1/auth/api-keys/:id":            {"api_key.revoke", "api_key"},
+	"POST /api/v1/templates":                      {"template.create", "template"},
+	"PUT /api/v1/templates/:id":                   {"template.update", "template"},
+	"DELETE /api/v1/templates/:id":                {"template.delete", "template"},
+	"POST /api/v1/emails/test":                    {"email.test", "template"},
+	"POST /api/v1/tasks":                          {"task.create", "task"},
+	"POST /api/v1/tasks/:id/start":                {"task.start", "task"},
+	"POST /api/v1/tasks/:id/pause":                {"task.pause", "task"},
+	"POST /api/v1/tasks/:id/resume":               {"task.resume", "task"},
+	"DELETE /api/v1/tasks/:id":                    {"task.delete", "task"},
+	"PUT /api/v1/tasks/:id/data/:row":             {"task.data.update", "task"},
+	"DELETE /api/v1/tasks/:id/data/:row":          {"task.data.delete", "task"},
+	"POST /api/v1/data/upload":                    {"data.upload", "task"},
+	"POST /api/v1/data/sql":                       {"data.sql", "task"},
+	"POST /api/v1/data/save":                      {"data.save", "task"},
+	"POST /api/v1/data/validate":                  {"data.validate", "task"},
+	"POST /api/v1/datasets":                       {"dataset.create", "dataset"},
+	"DELETE /api/v1/datasets/:id":                 {"dataset.delete", "dataset"},
+	"POST /api/v1/datasets/:id/attach":            {"dataset.attach", "dataset"},
+	"POST /api/v1/attachments":                    {"attachment.create", "attachment"},
+	"DELETE /api/v1/attachments/:id":              {"attachment.delete", "attachment"},
+	"POST /api/v1/assets":                         {"asset.create", "asset"},
+	"DELETE /api/v1/assets/:id":                   {"asset.delete", "asset"},
+	"POST /api/v1/queries":                        {"saved_query.create", "saved_query"},
+	"PUT /api/v1/queries/:id":                     {"saved_query.update", "saved_query"},
+	"DELETE /api/v1/queries/:id":                  {"saved_query.delete", "saved_query"},
+	"POST /api/v1/queries/:id/run":                {"saved_query.run", "saved_query"},
+	"POST /api/v1/import-mappings":                {"import_mapping.create", "import_mapping"},
+	"PUT /api/v1/import-mappings/:id":             {"import_mapping.update", "import_mapping"},
+	"DELETE /api/v1/import-mappings/:id":          {"import_mapping.delete", "import_mapping"},
+	"POST /api/v1/segments":                       {"segment.create", "segment"},
+	"PUT /api/v1/segments/:id":                    {"segment.update", "segment"},
+	"DELETE /api/v1/segments/:id":                 {"segment.delete", "segment"},
+	"POST /api/v1/contacts":                       {"contact.create", "contact"},
+	"PUT /api/v1/contacts/:id":                    {"contact.update", "contact"},
+	"DELETE /api/v1/contacts/:id":                 {"contact.delete", "contact"},
+	"POST /api/v1/contacts/import":                {"contact.import", "contact"},
+	"POST /api/v1/contacts/import/sql":            {"contact.import", "contact"},
+	"POST /api/v1/contacts/dedupe":                {"contact.dedupe", "contact"},
+	"POST /api/v1/contact-lists":                  {"contact_list.create", "contact_list"},
+	"PUT /api/v1/contact-lists/:id":               {"contact_list.update", "contact_list"},
+	"DELETE /api/v1/contact-lists/:id":            {"contact_list.delete", "contact_list"},
+	"POST /api/v1/contact-lists/:id/members":      {"contact_list.member.add", "contact_list"},
+	"DELETE /api/v1/contact-lists/:id/members":    {"contact_list.member.remove", "contact_list"},
+	"POST /api/v1/ai/generate":                    {"ai.generate", "ai"},
+	"POST /api/v1/projects":                       {"project.create", "project"},
+	"POST /api/v1/projects/switch":                {"project.switch", "project"},
+	"PUT /api/v1/projects/:id":                    {"project.update", "project"},
+	"DELETE /api/v1/projects/:id":                 {"project.delete", "project"},
+	"POST /api/v1/projects/:id/members":           {"project.member.add", "project"},
+	"PUT /api/v1/projects/:id/members/:userId":    {"project.member.update", "project"},
+	"DELETE /api/v1/projects/:id/members/:userId": {"project.member.remove", "project"},
+	"POST /api/v1/webhooks":                       {"webhook.create", "webhook"},
+	"PUT /api/v1/webhooks/:id":                    {"webhook.update", "webhook"},
+	"DELETE /api/v1/webhooks/:id":                 {"webhook.delete", "webhook"},
+	"POST /api/v1/webhooks/deliveries/:id/replay": {"webhook.replay", "webhook_delivery"},
+}
+
+// auditIDFields 资源ID不在路由参数中，而是从请求参数读取的路由
+var auditIDFields = map[string]string{
+	"POST /api/v1/data/upload": "task_id",
+	"POST /api/v1/data/sql":    "task_id",
+	"POST /api/v1/data/save":   "task_id",
+}
+
+// auditReadOnlyRoutes 使用POST但不修改数据的路由，不记录审计日志
+var auditReadOnlyRoutes = map[string]bool{
+	"POST /api/v1/templates/extract-variables": true,
+	"POST /api/v1/templates/preview":           true,
+	"POST /api/v1/templates/:id/pdf-preview":   true,
+	"POST /api/v1/templates/:id/link-check":    true,
+	"POST /api/v1/ai/extract-variables":        true,
+	"POST /api/v1/segments/preview":            true,
+	"POST /api/v1/segments/:id/preview":        true,
+	"POST /api/v1/data/sheets":                 true,
+}
+
+// auditWriter 缓存响应体，用于获取新建资源的快照
+type auditWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *auditWriter) Write(data []byte) (int, error) {
+	if w.body.Len()+len(data) <= maxAuditResponseSize {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// AuditMiddleware 为每个修改类API请求写入审计日志，
+// 记录操作者、动作、资源、修改前后快照和客户端IP，被拒绝或失败的请求同样记录
+func AuditMiddleware(audit *services.AuditService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		key := method + " " + c.FullPath()
+		if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions || auditReadOnlyRoutes[key] {
+			c.Next()
+			return
+		}
+
+		route, ok := auditRoutes[key]
+		if !ok {
+			route = auditRoute{action: strings.ToLower(method) + " " + c.FullPath()}
+		}
+
+		var resourceID uint
+		if field, ok := auditIDFields[key]; ok {
+			resourceID, _ = requestUint(c, field)
+		} else if id, err := strconv.ParseUint(c.Param("id"), 10, 32); err == nil {
+			resourceID = uint(id)
+		}
+
+		entry := &models.AuditLog{
+			Source:       services.AuditSourceAPI,
+			Action:       route.action,
+			ResourceType: route.resource,
+			Method:       method,
+			Path:         c.Request.URL.Path,
+			ClientIP:     c.ClientIP(),
+			UserAgent:    c.Request.UserAgent(),
+		}
+		// 权限检查在路由中间件中进行，修改前的快照要在处理前读取，但只在请求成功时保存
+		before := audit.Snapshot(route.resource, resourceID)
+		if resourceID != 0 {
+			entry.ResourceID = strconv.FormatUint(uint64(resourceID), 10)
+		}
+
+		if c.ContentType() == gin.MIMEJSON {
+			body, err := io.ReadAll(c.Request.Body)
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			if err == nil && len(body) > 0 {
+				entry.Request = services.RedactAuditJSON(body)
+			}
+		}
+
+		writer := &auditWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+		c.Writer = writer
+
+		c.Next()
+
+		entry.StatusCode = c.Writer.Status()
+		if entry.Request == "" {
+			entry.Request = auditForm(c)
+		}
+		if userID, ok := c.Get("userID"); ok {
+			entry.ActorID = userID.(uint)
+		}
+		if user, ok := c.Get("user"); ok {
+			entry.ActorName = user.(*models.User).Username
+		}
+		if projectID, ok := c.Get("projectID"); ok {
+			entry.ProjectID = projectID.(uint)
+		}
+
+		// 被拒绝或失败的请求不保存快照，避免无权访问的用户通过自己的审计日志看到资源内容
+		if entry.StatusCode < http.StatusBadRequest {
+			entry.Before = before
+			if method != http.MethodDelete {
+				entry.After = audit.Snapshot(route.resource, resourceID)
+				if entry.After == "" && resourceID == 0 && strings.HasSuffix(route.action, ".create") {
+					entry.After, entry.ResourceID = responseData(writer.body.Bytes(), entry.ResourceID)
+				}
+			}
+		}
+
+		audit.Record(entry)
+	}
+}
+
+// responseData 从响应的 data 字段提取新建资源的快照和ID
+func responseData(body []byte, resourceID string) (string, string) {
+	var response struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if json.Unmarshal(body, &response) != nil || len(response.Data) == 0 {
+		return "", resourceID
+	}
+
+	if resourceID == "" {
+		var resource struct {
+			ID uint `json:"id"`
+		}
+		if json.Unmarshal(response.Data, &resource) == nil && resource.ID != 0 {
+			resourceID = strconv.FormatUint(uint64(resource.ID), 10)
+		}
+	}
+	return services.RedactAuditJSON(response.Data), resourceID
+}
+
+// auditForm 记录表单字段和上传的文件名，不保存文件内容
+func auditForm(c *gin.Context) string {
+	fields := map[string]interface{}{}
+	if c.Request.MultipartForm != nil {
+		for key, values := range c.Request.MultipartForm.Value {
+			fields[key] = values
+		}
+		for key, files := range c.Request.MultipartForm.File {
+			names := make([]string, 0, len(files))
+			for _, file := range files {
+				names = append(names, file.Filename)
+			}
+			fields[key] = names
+		}
+	} else {
+		for key, values := range c.Request.PostForm {
+			fields[key] = values
+		}
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return ""
+	}
+	return services.RedactAuditJSON(data)
+}
@@ -5,45 +5,44 @@ import (
 	"strings"
 	
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
-	"go_market_email/internal/utils"
+	"go_market_email/internal/services"
 )
 
-func AuthMiddleware(config utils.AuthConfig, logger *zap.Logger) gin.HandlerFunc {
+// AuthMiddleware 校验API密钥或会话令牌，并将用户信息写入上下文
+func AuthMiddleware(authService *services.AuthService, logger *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.GetHeader("Authorization")
 		
-		// 记录认证信息
-		logger.Info("认证请求",
-			zap.String("path", c.Request.URL.Path),
-			zap.String("auth_header", token),
-			zap.String("config_token", config.Token))
+		// 浏览器无法为WebSocket握手设置请求头，允许通过token查询参数传递
+		if token == "" && websocket.IsWebSocketUpgrade(c.Request) {
+			token = c.Query("token")
+		}
 		
 		if token == "" {
-			logger.Warn("认证失败", zap.String("reason", "缺少Authorization Header"))
+			logger.Warn("认证失败", zap.String("path", c.Request.URL.Path), zap.String("reason", "缺少Authorization Header"))
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "缺少认证令牌"})
 			c.Abort()
 			return
 		}
 		
 		// 移除 "Bearer " 前缀
-		if strings.HasPrefix(token, "Bearer ") {
-			token = strings.TrimPrefix(token, "Bearer ")
-		}
+		token = strings.TrimPrefix(token, "Bearer ")
 		
-		if token != config.Token {
+		user, authTime, err := authService.Authenticate(token)
+		if err != nil {
 			logger.Warn("认证失败",
-				zap.String("reason", "Token不匹配"),
-				zap.String("received_token", token),
-				zap.String("expected_token", config.Token))
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证令牌"})
+				zap.String("path", c.Request.URL.Path),
+				zap.String("reason", err.Error()))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 		
-		logger.Info("认证成功", zap.String("path", c.Request.URL.Path))
-		// 设置用户ID（简单实现）
-		c.Set("userID", uint(1))
+		c.Set("userID", user.ID)
+		c.Set("user", user)
+		c.Set("authTime", authTime)
 		c.Next()
 	}
 }
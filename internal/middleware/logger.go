@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queryTokenPattern 匹配URL中的令牌类查询参数
+var queryTokenPattern = regexp.MustCompile(`(?i)((?:token|api_key|access_token)=)[^&]*`)
+
+// AccessLogger 与gin默认访问日志格式相同，但会遮盖URL中的令牌（如WebSocket握手的 ?token=）
+func AccessLogger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		if param.Latency > time.Minute {
+			param.Latency = param.Latency.Truncate(time.Second)
+		}
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			param.StatusCode,
+			param.Latency,
+			param.ClientIP,
+			param.Method,
+			queryTokenPattern.ReplaceAllString(param.Path, "${1}******"),
+			param.ErrorMessage,
+		)
+	})
+}
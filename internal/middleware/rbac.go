@@ -0,0 +1,265 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go_market_email/internal/models"
+	"go_market_email/internal/services"
+	"gorm.io/gorm"
+)
+
+// ProjectScope 解析请求所操作资源的项目ID和创建者ID。
+// 项目ID为0表示个人资源，只有创建者本人可以访问；创建者ID为0表示新资源。
+type ProjectScope func(c *gin.Context, rbac *services.RBACService) (projectID, ownerID uint, err error)
+
+var errMissingResource = errors.New("缺少资源ID")
+
+// RequirePermission 校验当前用户在资源所属项目中拥有指定权限，
+// 通过后在上下文中设置 projectID 和 projectRole
+func RequirePermission(rbac *services.RBACService, permission string, scope ProjectScope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectID, ownerID, err := scope(c, rbac)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "资源不存在"})
+			} else {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			}
+			c.Abort()
+			return
+		}
+
+		userID := c.MustGet("userID").(uint)
+
+		// 个人资源：只有创建者拥有全部权限
+		if projectID == 0 {
+			if ownerID != 0 && ownerID != userID {
+				c.JSON(http.StatusForbidden, gin.H{"error": "无权访问该资源"})
+				c.Abort()
+				return
+			}
+			c.Set("projectID", uint(0))
+			c.Set("projectRole", services.RoleOwner)
+			c.Next()
+			return
+		}
+
+		role, err := rbac.GetRole(projectID, userID)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "不是项目成员"})
+			c.Abort()
+			return
+		}
+		if !rbac.Can(role, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "权限不足", "required": permission, "role": role})
+			c.Abort()
+			return
+		}
+
+		c.Set("projectID", projectID)
+		c.Set("projectRole", role)
+		c.Next()
+	}
+}
+
+// ScopeFromRequest 从 X-Project-ID 头、查询参数、表单或JSON请求体的 project_id 中获取项目，
+// 都未提供时使用用户的当前项目
+func ScopeFromRequest(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	if header := c.GetHeader("X-Project-ID"); header != "" {
+		id, err := strconv.ParseUint(header, 10, 32)
+		if err != nil {
+			return 0, 0, errors.New("无效的项目ID")
+		}
+		return uint(id), 0, nil
+	}
+	if id, ok := requestUint(c, "project_id"); ok {
+		return id, 0, nil
+	}
+	if user, ok := c.Get("user"); ok {
+		return user.(*models.User).CurrentProjectID, 0, nil
+	}
+	return 0, 0, nil
+}
+
+// ScopeProjectParam 路由参数 :id 为项目ID
+func ScopeProjectParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || id == 0 {
+		return 0, 0, errors.New("无效的项目ID")
+	}
+	return uint(id), 0, nil
+}
+
+// ScopeTemplateParam 路由参数 :id 为模板ID
+func ScopeTemplateParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的模板ID")
+	}
+	return rbac.TemplateScope(uint(id))
+}
+
+// ScopeTaskParam 路由参数 :id 为任务ID
+func ScopeTaskParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的任务ID")
+	}
+	return rbac.TaskScope(uint(id))
+}
+
+// ScopeSavedQueryParam 路由参数 :id 为保存的查询ID
+func ScopeSavedQueryParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的查询ID")
+	}
+	return rbac.SavedQueryScope(uint(id))
+}
+
+// ScopeImportMappingParam 路由参数 :id 为列映射预设ID
+func ScopeImportMappingParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的映射预设ID")
+	}
+	return rbac.ImportMappingScope(uint(id))
+}
+
+// ScopeDatasetParam 路由参数 :id 为数据集ID
+func ScopeDatasetParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的数据集ID")
+	}
+	return rbac.DatasetScope(uint(id))
+}
+
+// ScopeAttachmentParam 路由参数 :id 为附件ID
+func ScopeAttachmentParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的附件ID")
+	}
+	return rbac.AttachmentScope(uint(id))
+}
+
+// ScopeAssetParam 路由参数 :id 为图片资源ID
+func ScopeAssetParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的图片ID")
+	}
+	return rbac.AssetScope(uint(id))
+}
+
+// ScopeSegmentParam 路由参数 :id 为分群ID
+func ScopeSegmentParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的分群ID")
+	}
+	return rbac.SegmentScope(uint(id))
+}
+
+// ScopeContactParam 路由参数 :id 为联系人ID
+func ScopeContactParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的联系人ID")
+	}
+	return rbac.ContactScope(uint(id))
+}
+
+// ScopeContactListParam 路由参数 :id 为联系人列表ID
+func ScopeContactListParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的列表ID")
+	}
+	return rbac.ContactListScope(uint(id))
+}
+
+// ScopeWebhookParam 路由参数 :id 为Webhook订阅ID
+func ScopeWebhookParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的订阅ID")
+	}
+	return rbac.WebhookScope(uint(id))
+}
+
+// ScopeWebhookDeliveryParam 路由参数 :id 为Webhook投递记录ID
+func ScopeWebhookDeliveryParam(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return 0, 0, errors.New("无效的投递ID")
+	}
+	return rbac.WebhookDeliveryScope(uint(id))
+}
+
+// ScopeTemplateFromRequest 请求中的 template_id 指定模板
+func ScopeTemplateFromRequest(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, ok := requestUint(c, "template_id")
+	if !ok {
+		return 0, 0, errMissingResource
+	}
+	return rbac.TemplateScope(id)
+}
+
+// ScopeTaskFromRequest 请求中的 task_id 指定任务，未提供时退回到 ScopeFromRequest
+func ScopeTaskFromRequest(c *gin.Context, rbac *services.RBACService) (uint, uint, error) {
+	id, ok := requestUint(c, "task_id")
+	if !ok {
+		return ScopeFromRequest(c, rbac)
+	}
+	return rbac.TaskScope(id)
+}
+
+// requestUint 依次从查询参数、表单和JSON请求体中读取无符号整数字段，
+// 读取JSON后恢复请求体，不影响处理器再次绑定
+func requestUint(c *gin.Context, key string) (uint, bool) {
+	if value := c.Query(key); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		return uint(id), err == nil
+	}
+
+	if c.ContentType() == gin.MIMEJSON {
+		body, err := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return 0, false
+		}
+
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(body, &fields) != nil {
+			return 0, false
+		}
+		raw, ok := fields[key]
+		if !ok {
+			return 0, false
+		}
+		var id uint
+		if json.Unmarshal(raw, &id) == nil {
+			return id, true
+		}
+		var idStr string
+		if json.Unmarshal(raw, &idStr) == nil {
+			parsed, err := strconv.ParseUint(idStr, 10, 32)
+			return uint(parsed), err == nil
+		}
+		return 0, false
+	}
+
+	if value := c.PostForm(key); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		return uint(id), err == nil
+	}
+	return 0, false
+}
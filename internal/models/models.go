@@ -1,24 +1,57 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 	"gorm.io/gorm"
 )
 
+// IDList 以JSON数组保存的ID列表
+type IDList []uint
+
+// Value 实现 driver.Valuer
+func (l IDList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+// Scan 实现 sql.Scanner
+func (l *IDList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	}
+	return fmt.Errorf("无法解析ID列表: %T", value)
+}
+
 // EmailTemplate 邮件模板
 type EmailTemplate struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	Name        string         `json:"name" gorm:"size:255;not null"`
-	Subject     string         `json:"subject" gorm:"size:500;not null"`
-	Content     string         `json:"content" gorm:"type:text;not null"`
-	Variables   string         `json:"variables" gorm:"type:json"` // JSON格式存储变量
-	Version     int            `json:"version" gorm:"default:1"`
-	UserID      uint           `json:"user_id"`
-	ProjectID   uint           `json:"project_id"`
-	Status      string         `json:"status" gorm:"default:'active'"` // active, inactive
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	Name           string         `json:"name" gorm:"size:255;not null"`
+	Type           string         `json:"type" gorm:"size:20;default:'email'"` // email 邮件模板, pdf 附件模板（主题为文件名）
+	Subject        string         `json:"subject" gorm:"size:500;not null"`
+	Content        string         `json:"content" gorm:"type:text;not null"`
+	Variables      string         `json:"variables" gorm:"type:json"` // JSON格式存储变量
+	Version        int            `json:"version" gorm:"default:1"`
+	AttachmentIDs  IDList         `json:"attachment_ids" gorm:"type:json"`   // 附件库中的附件，随每封邮件发送
+	ImageMode      string         `json:"image_mode" gorm:"size:20"`         // 图片资源的引用方式：embed 内嵌（默认）, hosted 托管
+	PDFTemplateIDs IDList         `json:"pdf_template_ids" gorm:"type:json"` // 为每个收件人生成PDF附件的附件模板
+	UserID         uint           `json:"user_id"`
+	ProjectID      uint           `json:"project_id"`
+	Status         string         `json:"status" gorm:"default:'active'"` // active, inactive
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `json:"deleted_at" gorm:"index"`
 }
 
 // EmailTask 邮件发送任务
@@ -27,7 +60,7 @@ type EmailTask struct {
 	Name               string         `json:"name" gorm:"size:255;not null"`
 	TemplateID         uint           `json:"template_id"`
 	Template           EmailTemplate  `json:"template" gorm:"foreignKey:TemplateID"`
-	DataSource         string         `json:"data_source"` // excel, sql, manual
+	DataSource         string         `json:"data_source"` // excel, sql, manual, segment
 	DataContent        string         `json:"data_content" gorm:"type:text"`
 	AIPrompt           string         `json:"ai_prompt" gorm:"type:text"`
 	Recipients         string         `json:"recipients" gorm:"type:json"` // JSON格式存储收件人列表
@@ -37,6 +70,12 @@ type EmailTask struct {
 	FailCount          int            `json:"fail_count" gorm:"default:0"`
 	Progress           float64        `json:"progress" gorm:"-"` // 计算字段，不存储到数据库
 	EstimatedRemaining string         `json:"estimated_remaining" gorm:"-"` // 计算字段
+	SegmentID          uint           `json:"segment_id"`      // 发送时从分群实时解析收件人
+	SegmentVersion     int            `json:"segment_version"` // 0表示使用最新版本，开始发送时记录实际版本
+	DatasetID          uint           `json:"dataset_id"`      // 最近一次关联的数据集，关联时复制为任务数据
+	AttachmentIDs      IDList         `json:"attachment_ids" gorm:"type:json"` // 附件库中的附件，与模板的附件一起发送
+	AttachmentColumn   string         `json:"attachment_column"`               // 按该列的值为每个收件人选择附件文件
+	PDFTemplateIDs     IDList         `json:"pdf_template_ids" gorm:"type:json"` // 与模板的附件模板一起为每个收件人生成PDF
 	UserID             uint           `json:"user_id"`
 	ProjectID          uint           `json:"project_id"`
 	ScheduledAt        *time.Time     `json:"scheduled_at"`
@@ -62,16 +101,32 @@ type EmailLog struct {
 	CreatedAt  time.Time `json:"created_at"`
 }
 
+
 // User 用户
 type User struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	Username  string         `json:"username" gorm:"size:100;uniqueIndex;not null"`
-	Email     string         `json:"email" gorm:"size:255;uniqueIndex;not null"`
-	Token     string         `json:"token" gorm:"size:255"`
-	Status    string         `json:"status" gorm:"default:'active'"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	ID               uint           `json:"id" gorm:"primaryKey"`
+	Username         string         `json:"username" gorm:"size:100;uniqueIndex;not null"`
+	Email            string         `json:"email" gorm:"size:255;uniqueIndex;not null"`
+	Token            string         `json:"-" gorm:"size:255"`
+	PasswordHash     string         `json:"-" gorm:"size:255"`
+	CurrentProjectID uint           `json:"current_project_id"`             // 当前项目，0表示个人空间
+	Status           string         `json:"status" gorm:"default:'active'"` // active, disabled
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// APIKey 用户API密钥，只保存哈希值
+type APIKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	UserID     uint       `json:"user_id" gorm:"index"`
+	Name       string     `json:"name" gorm:"size:255;not null"`
+	Prefix     string     `json:"prefix" gorm:"size:20"` // 明文前缀，便于识别
+	KeyHash    string     `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
 }
 
 // Project 项目
@@ -85,4 +140,212 @@ type Project struct {
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
-}
\ No newline at end of file
+}
+
+// WebhookSubscription Webhook订阅
+type WebhookSubscription struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	Name      string         `json:"name" gorm:"size:255;not null"`
+	URL       string         `json:"url" gorm:"size:1000;not null"`
+	Secret    string         `json:"-" gorm:"size:255"`       // HMAC签名密钥，不对外返回
+	Events    string         `json:"events" gorm:"type:json"` // JSON格式存储订阅的事件类型，"*"表示全部
+	UserID    uint           `json:"user_id"`
+	ProjectID uint           `json:"project_id"`
+	Status    string         `json:"status" gorm:"default:'active'"` // active, inactive
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// WebhookDelivery Webhook投递记录
+type WebhookDelivery struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	SubscriptionID uint       `json:"subscription_id" gorm:"index"` // 0表示配置文件中的默认Webhook
+	URL            string     `json:"url" gorm:"size:1000"`
+	Event          string     `json:"event" gorm:"size:100;index"`
+	Payload        string     `json:"payload" gorm:"type:text"`
+	Status         string     `json:"status" gorm:"default:'pending'"` // pending, success, failed
+	Attempts       int        `json:"attempts" gorm:"default:0"`
+	ResponseStatus int        `json:"response_status"`
+	ResponseBody   string     `json:"-" gorm:"type:text"` // 仅供排查，不通过接口返回
+	Error          string     `json:"error" gorm:"type:text"`
+	NextRetryAt    *time.Time `json:"next_retry_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ProjectMember 项目成员及角色
+type ProjectMember struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ProjectID uint      `json:"project_id" gorm:"uniqueIndex:idx_project_member"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex:idx_project_member"`
+	User      User      `json:"user" gorm:"foreignKey:UserID"`
+	Role      string    `json:"role" gorm:"size:20;not null"` // owner, editor, sender, viewer
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AuditLog 审计日志，只追加不修改
+type AuditLog struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	ActorID      uint      `json:"actor_id" gorm:"index"` // 0表示CLI等非用户操作
+	ActorName    string    `json:"actor_name" gorm:"size:100"`
+	Source       string    `json:"source" gorm:"size:20"` // api, cli
+	Action       string    `json:"action" gorm:"size:100;index"`
+	ResourceType string    `json:"resource_type" gorm:"size:50;index:idx_audit_resource"`
+	ResourceID   string    `json:"resource_id" gorm:"size:100;index:idx_audit_resource"`
+	ProjectID    uint      `json:"project_id" gorm:"index"`
+	Method       string    `json:"method" gorm:"size:10"`
+	Path         string    `json:"path" gorm:"size:500"`
+	StatusCode   int       `json:"status_code"`
+	Request      string    `json:"request" gorm:"type:text"` // 请求参数，敏感字段已脱敏
+	Before       string    `json:"before" gorm:"type:mediumtext"`
+	After        string    `json:"after" gorm:"type:mediumtext"`
+	ClientIP     string    `json:"client_ip" gorm:"size:64"`
+	UserAgent    string    `json:"user_agent" gorm:"size:500"`
+	CreatedAt    time.Time `json:"created_at" gorm:"index"`
+}
+
+// SavedQuery 保存的数据源查询，查询中的 :name 参数在执行时绑定
+type SavedQuery struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Name        string         `json:"name" gorm:"size:255;not null"`
+	Description string         `json:"description" gorm:"size:500"`
+	DataSource  string         `json:"data_source" gorm:"size:100;not null"`
+	Query       string         `json:"query" gorm:"type:text;not null"`
+	Params      string         `json:"params" gorm:"type:json"`   // 查询中的参数名
+	Defaults    string         `json:"defaults" gorm:"type:json"` // 参数默认值
+	UserID      uint           `json:"user_id"`
+	ProjectID   uint           `json:"project_id" gorm:"index"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// ImportMapping 保存的导入列映射预设
+type ImportMapping struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Name        string         `json:"name" gorm:"size:255;not null"`
+	Description string         `json:"description" gorm:"size:500"`
+	Rules       string         `json:"rules" gorm:"type:json;not null"` // 列映射和转换规则
+	UserID      uint           `json:"user_id"`
+	ProjectID   uint           `json:"project_id" gorm:"index"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// Dataset 上传的数据集，记录按块保存在Redis中，过期后删除，可以关联到多个任务
+type Dataset struct {
+	ID         uint           `json:"id" gorm:"primaryKey"`
+	Name       string         `json:"name" gorm:"size:255;not null"`
+	Filename   string         `json:"filename" gorm:"size:255"`
+	Format     string         `json:"format" gorm:"size:20"` // csv, excel, json, jsonl, parquet
+	Size       int64          `json:"size"`
+	Sheet      string         `json:"sheet" gorm:"size:255"`
+	Columns    string         `json:"columns" gorm:"type:json"`
+	RowCount   int            `json:"row_count"`
+	Skipped    int            `json:"skipped"`
+	Status     string         `json:"status" gorm:"size:20;default:'importing'"` // importing, ready, failed
+	Error      string         `json:"error" gorm:"type:text"`
+	Validation string         `json:"validation" gorm:"type:json"` // 地址检查报告
+	UserID     uint           `json:"user_id"`
+	ProjectID  uint           `json:"project_id" gorm:"index"`
+	ExpiresAt  time.Time      `json:"expires_at" gorm:"index"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// Attachment 附件库中的文件
+type Attachment struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Name        string         `json:"name" gorm:"size:255;not null"` // 发送时使用的文件名
+	ContentType string         `json:"content_type" gorm:"size:100"`
+	Size        int64          `json:"size"`
+	Checksum    string         `json:"checksum" gorm:"size:64"` // SHA-256
+	Path        string         `json:"-" gorm:"size:500"`
+	UserID      uint           `json:"user_id"`
+	ProjectID   uint           `json:"project_id" gorm:"index"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// Asset 模板引用的图片资源，Token 用于公开访问地址
+type Asset struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Name        string         `json:"name" gorm:"size:255;not null;index"` // 模板中相对路径按文件名匹配
+	ContentType string         `json:"content_type" gorm:"size:100"`
+	Size        int64          `json:"size"`
+	Checksum    string         `json:"checksum" gorm:"size:64"` // SHA-256
+	Token       string         `json:"token" gorm:"size:64;uniqueIndex"`
+	Path        string         `json:"-" gorm:"size:500"`
+	UserID      uint           `json:"user_id"`
+	ProjectID   uint           `json:"project_id" gorm:"index"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// Contact 联系人
+type Contact struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ScopeKey   string    `json:"-" gorm:"size:32;uniqueIndex:idx_contact_email;not null"` // 项目或个人空间，同一空间内邮箱唯一
+	Email      string    `json:"email" gorm:"size:255;uniqueIndex:idx_contact_email;not null"`
+	Attributes string    `json:"attributes" gorm:"type:json"`                      // 自定义属性
+	Tags       string    `json:"tags" gorm:"type:json"`                            // 标签数组
+	Status     string    `json:"status" gorm:"size:20;default:'subscribed';index"` // subscribed, unsubscribed, bounced
+	UserID     uint      `json:"user_id"`
+	ProjectID  uint      `json:"project_id" gorm:"index"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ContactList 联系人列表
+type ContactList struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Name        string         `json:"name" gorm:"size:255;not null"`
+	Description string         `json:"description" gorm:"size:500"`
+	MemberCount int64          `json:"member_count" gorm:"-"`
+	UserID      uint           `json:"user_id"`
+	ProjectID   uint           `json:"project_id" gorm:"index"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// ContactListMember 联系人与列表的成员关系
+type ContactListMember struct {
+	ListID    uint      `json:"list_id" gorm:"primaryKey"`
+	ContactID uint      `json:"contact_id" gorm:"primaryKey;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Segment 受众分群，定义保存在版本中
+type Segment struct {
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	Name           string         `json:"name" gorm:"size:255;not null"`
+	Description    string         `json:"description" gorm:"size:500"`
+	CurrentVersion int            `json:"current_version" gorm:"default:1"`
+	UserID         uint           `json:"user_id"`
+	ProjectID      uint           `json:"project_id" gorm:"index"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+}
+
+// SegmentVersion 分群定义的一个版本，创建后不再修改
+type SegmentVersion struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	SegmentID  uint      `json:"segment_id" gorm:"uniqueIndex:idx_segment_version"`
+	Version    int       `json:"version" gorm:"uniqueIndex:idx_segment_version"`
+	Type       string    `json:"type" gorm:"size:20;not null"` // sql, filter
+	DataSource string    `json:"data_source" gorm:"size:100"`
+	Query      string    `json:"query" gorm:"type:text"`
+	Params     string    `json:"params" gorm:"type:json"` // SQL参数值
+	Rules      string    `json:"rules" gorm:"type:json"`  // 联系人过滤规则
+	UserID     uint      `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
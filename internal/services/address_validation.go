@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_market_email/internal/utils"
+)
+
+// 地址问题类型
+const (
+	IssueInvalid    = "invalid"
+	IssueDuplicate  = "duplicate"
+	IssueNoMX       = "no_mx"
+	IssueDisposable = "disposable"
+	IssueRole       = "role"
+)
+
+const (
+	validationMaxIssues = 1000
+	mxLookupTimeout     = 5 * time.Second
+)
+
+// ValidationOptions 导入后的地址检查选项。默认去掉无效和重复的地址，
+// 一次性邮箱和角色账号只标记不去掉
+type ValidationOptions struct {
+	CheckMX           bool `json:"check_mx" form:"check_mx"`
+	KeepInvalid       bool `json:"keep_invalid" form:"keep_invalid"`
+	KeepDuplicates    bool `json:"keep_duplicates" form:"keep_duplicates"`
+	ExcludeDisposable bool `json:"exclude_disposable" form:"exclude_disposable"`
+	ExcludeRole       bool `json:"exclude_role" form:"exclude_role"`
+}
+
+// AddressIssue 有问题的记录，Row 为数据中的第几条记录（从1开始）
+type AddressIssue struct {
+	Row     int    `json:"row"`
+	Email   string `json:"email"`
+	Type    string `json:"type"`
+	Reason  string `json:"reason,omitempty"`
+	Removed bool   `json:"removed"`
+}
+
+// ValidationReport 任务数据的地址检查报告
+type ValidationReport struct {
+	TaskID     uint              `json:"task_id"`
+	DatasetID  uint              `json:"dataset_id,omitempty"`
+	Options    ValidationOptions `json:"options"`
+	Total      int               `json:"total"`
+	Valid      int               `json:"valid"`
+	Invalid    int               `json:"invalid"`
+	Duplicates int               `json:"duplicates"`
+	Normalized int               `json:"normalized"` // 规范化后地址有变化的记录数
+	Disposable int               `json:"disposable"`
+	Role       int               `json:"role"`
+	NoMX       int               `json:"no_mx"`
+	MXErrors   int               `json:"mx_errors"` // DNS查询失败、无法判断的域名数
+	Removed    int               `json:"removed"`
+	Kept       int               `json:"kept"`
+	Issues     []AddressIssue    `json:"issues"` // 至多 validationMaxIssues 条
+	CheckedAt  int64             `json:"checked_at"`
+}
+
+// addressPipeline 逐条检查记录中的收件人地址，规范化后写回记录
+type addressPipeline struct {
+	ctx       context.Context
+	validator *EmailValidator
+	options   ValidationOptions
+	report    *ValidationReport
+	seen      map[string]bool
+	mx        map[string]bool // 域名是否能接收邮件，DNS失败的域名不缓存结果
+	mxFailed  map[string]bool
+}
+
+func newAddressPipeline(ctx context.Context, validator *EmailValidator, taskID uint, options ValidationOptions) *addressPipeline {
+	return &addressPipeline{
+		ctx:       ctx,
+		validator: validator,
+		options:   options,
+		report:    &ValidationReport{TaskID: taskID, Options: options, Issues: []AddressIssue{}},
+		seen:      map[string]bool{},
+		mx:        map[string]bool{},
+		mxFailed:  map[string]bool{},
+	}
+}
+
+// check 检查一条记录，返回是否保留
+func (p *addressPipeline) check(record map[string]interface{}) bool {
+	keep := p.inspect(record)
+	if keep {
+		p.report.Kept++
+	}
+	return keep
+}
+
+func (p *addressPipeline) inspect(record map[string]interface{}) bool {
+	p.report.Total++
+	row := p.report.Total
+	original := mappingValue(record[RecipientField])
+
+	result := p.validator.Check(original)
+	if !result.Valid {
+		p.report.Invalid++
+		return p.issue(row, original, IssueInvalid, result.Reason, !p.options.KeepInvalid)
+	}
+
+	if result.Email != original {
+		p.report.Normalized++
+		record[RecipientField] = result.Email
+	}
+
+	key := strings.ToLower(result.Email)
+	if p.seen[key] {
+		p.report.Duplicates++
+		return p.issue(row, result.Email, IssueDuplicate, "", !p.options.KeepDuplicates)
+	}
+	p.seen[key] = true
+
+	if p.options.CheckMX && !p.hasMX(result.Domain) {
+		p.report.NoMX++
+		return p.issue(row, result.Email, IssueNoMX, "域名没有MX记录", !p.options.KeepInvalid)
+	}
+
+	p.report.Valid++
+	if result.Disposable {
+		p.report.Disposable++
+		if !p.issue(row, result.Email, IssueDisposable, "", p.options.ExcludeDisposable) {
+			return false
+		}
+	}
+	if result.Role {
+		p.report.Role++
+		return p.issue(row, result.Email, IssueRole, "", p.options.ExcludeRole)
+	}
+	return true
+}
+
+// issue 记录问题，remove 为true时去掉该记录；返回是否保留
+func (p *addressPipeline) issue(row int, email, issueType, reason string, remove bool) bool {
+	if len(p.report.Issues) < validationMaxIssues {
+		p.report.Issues = append(p.report.Issues, AddressIssue{
+			Row: row, Email: email, Type: issueType, Reason: reason, Removed: remove,
+		})
+	}
+	if remove {
+		p.report.Removed++
+		return false
+	}
+	return true
+}
+
+func (p *addressPipeline) hasMX(domain string) bool {
+	if ok, cached := p.mx[domain]; cached {
+		return ok
+	}
+	if p.mxFailed[domain] {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(p.ctx, mxLookupTimeout)
+	defer cancel()
+	ok, err := p.validator.HasMX(ctx, domain)
+	if err != nil {
+		// 无法判断时不去掉地址
+		p.mxFailed[domain] = true
+		p.report.MXErrors++
+		return true
+	}
+	p.mx[domain] = ok
+	return ok
+}
+
+// finishValidation 记录检查时间，任务数据的报告保存到Redis，数据集的报告由调用方保存
+func (s *DataService) finishValidation(pipeline *addressPipeline) *ValidationReport {
+	report := pipeline.report
+	report.CheckedAt = time.Now().Unix()
+	if report.TaskID != 0 {
+		s.saveValidationReport(report)
+	}
+	return report
+}
+
+func (s *DataService) saveValidationReport(report *ValidationReport) {
+	data, _ := json.Marshal(report)
+	s.rdb.Set(context.Background(), validationKey(report.TaskID), data, taskDataTTL)
+}
+
+func validationKey(taskID uint) string {
+	return utils.ValidationKey + strconv.FormatUint(uint64(taskID), 10)
+}
+
+// ValidateTaskData 重新检查任务数据中的收件人地址，按选项去掉有问题的记录并保存报告
+func (s *DataService) ValidateTaskData(ctx context.Context, taskID uint, options ValidationOptions) (*ValidationReport, error) {
+	pipeline := newAddressPipeline(ctx, s.validator, taskID, options)
+	writer := s.NewTaskDataWriter(taskID)
+	err := s.EachTaskDataChunk(taskID, func(chunk []map[string]interface{}) error {
+		for _, record := range chunk {
+			if !pipeline.check(record) {
+				continue
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		writer.Abort()
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return s.finishValidation(pipeline), nil
+}
+
+// GetValidationReport 获取任务最近一次的地址检查报告
+func (s *DataService) GetValidationReport(taskID uint) (*ValidationReport, error) {
+	data, err := s.rdb.Get(context.Background(), validationKey(taskID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var report ValidationReport
+	err = json.Unmarshal([]byte(data), &report)
+	return &report, err
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestAddressPipeline(t *testing.T) {
+	resolver := &fakeResolver{
+		records: map[string][]*net.MX{
+			"example.com":             {{Host: "mx.example.com."}},
+			"mailinator.com":          {{Host: "mx.mailinator.com."}},
+			"xn--bcher-kva.de":        {{Host: "mx.xn--bcher-kva.de."}},
+			"xn--fsqu00a.xn--0zwm56d": {{Host: "mx.example.cn."}},
+		},
+		errs: map[string]error{"flaky.com": errors.New("fake dns failure")},
+	}
+	rows := []string{
+		"alice@example.com",
+		"ALICE@Example.com",     // 与第1条重复，只是大小写不同
+		"user@bücher.de",        // 规范化为punycode
+		"user@xn--bcher-kva.de", // 与第3条规范化后重复
+		"not-an-email",          // 无效
+		"bob@mailinator.com",    // 一次性邮箱，默认只标记
+		"info@example.com",      // 角色账号，默认只标记
+		"carol@nomx.com",        // 没有MX记录
+		"dave@flaky.com",        // DNS失败，不去掉
+		"erin@flaky.com",        // 同一域名失败后不再查询
+		"user@例子.测试",            // IDN
+		"  frank@example.com  ", // 去掉空白
+	}
+
+	pipeline := newAddressPipeline(context.Background(), NewEmailValidator(resolver), 7, ValidationOptions{CheckMX: true})
+	var kept []string
+	for _, email := range rows {
+		record := map[string]interface{}{RecipientField: email}
+		if pipeline.check(record) {
+			kept = append(kept, record[RecipientField].(string))
+		}
+	}
+
+	want := []string{
+		"alice@example.com", "user@xn--bcher-kva.de", "bob@mailinator.com", "info@example.com",
+		"dave@flaky.com", "erin@flaky.com", "user@xn--fsqu00a.xn--0zwm56d", "frank@example.com",
+	}
+	if !equalStrings(kept, want) {
+		t.Errorf("kept = %v, want %v", kept, want)
+	}
+
+	report := pipeline.report
+	counts := map[string][2]int{
+		"total":      {report.Total, 12},
+		"valid":      {report.Valid, 8},
+		"invalid":    {report.Invalid, 1},
+		"duplicates": {report.Duplicates, 2},
+		"normalized": {report.Normalized, 4},
+		"disposable": {report.Disposable, 1},
+		"role":       {report.Role, 1},
+		"no_mx":      {report.NoMX, 1},
+		"mx_errors":  {report.MXErrors, 1},
+		"removed":    {report.Removed, 4},
+		"kept":       {report.Kept, 8},
+	}
+	for name, count := range counts {
+		if count[0] != count[1] {
+			t.Errorf("%s = %d, want %d", name, count[0], count[1])
+		}
+	}
+	if resolver.lookups["example.com"] != 1 || resolver.lookups["flaky.com"] != 1 {
+		t.Errorf("lookups = %v, want one lookup per domain", resolver.lookups)
+	}
+}
+
+func TestAddressPipelineOptions(t *testing.T) {
+	rows := []string{"a@example.com", "A@example.com", "bad", "b@yopmail.com", "admin@example.com"}
+	tests := []struct {
+		name    string
+		options ValidationOptions
+		kept    []string
+	}{
+		{name: "default", kept: []string{"a@example.com", "b@yopmail.com", "admin@example.com"}},
+		{name: "keep duplicates", options: ValidationOptions{KeepDuplicates: true}, kept: []string{"a@example.com", "A@example.com", "b@yopmail.com", "admin@example.com"}},
+		{name: "keep invalid", options: ValidationOptions{KeepInvalid: true}, kept: []string{"a@example.com", "bad", "b@yopmail.com", "admin@example.com"}},
+		{name: "exclude disposable", options: ValidationOptions{ExcludeDisposable: true}, kept: []string{"a@example.com", "admin@example.com"}},
+		{name: "exclude role", options: ValidationOptions{ExcludeRole: true}, kept: []string{"a@example.com", "b@yopmail.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := newAddressPipeline(context.Background(), NewEmailValidator(&fakeResolver{}), 0, tt.options)
+			var kept []string
+			for _, email := range rows {
+				record := map[string]interface{}{RecipientField: email}
+				if pipeline.check(record) {
+					kept = append(kept, record[RecipientField].(string))
+				}
+			}
+			if !equalStrings(kept, tt.kept) {
+				t.Errorf("kept = %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
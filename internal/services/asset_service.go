@@ -0,0 +1,300 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go_market_email/internal/models"
+	"go_market_email/internal/utils"
+	"gorm.io/gorm"
+)
+
+// 模板图片的引用方式
+const (
+	ImageModeEmbed  = "embed"  // 作为内嵌附件（CID）随邮件发送
+	ImageModeHosted = "hosted" // 引用资源接口的公开地址
+)
+
+const defaultAssetMaxSize = 2 * 1024 * 1024
+
+// AssetRefPrefix 模板中按ID或文件名引用图片资源的前缀，如 asset:12 或 asset:logo.png
+const AssetRefPrefix = "asset:"
+
+// ErrAssetInUse 图片仍被启用的模板引用
+var ErrAssetInUse = errors.New("图片正在被模板使用")
+
+// 支持的图片类型，不接受可以携带脚本的SVG
+var assetTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// imageSrcPattern 匹配 img 标签的 src 属性，分组为属性之前的部分和属性值
+var imageSrcPattern = regexp.MustCompile(`(?i)(<img\b[^>]*?\ssrc\s*=\s*)("[^"]*"|'[^']*'|[^\s"'>]+)`)
+
+// AssetService 管理模板引用的图片资源，发送时把图片改写为内嵌附件或公开地址
+type AssetService struct {
+	db      *gorm.DB
+	dir     string
+	maxSize int64
+	baseURL string
+}
+
+func NewAssetService(db *gorm.DB, config utils.AssetConfig) *AssetService {
+	s := &AssetService{
+		db:      db,
+		dir:     config.Dir,
+		maxSize: config.MaxSize,
+		baseURL: strings.TrimRight(config.BaseURL, "/"),
+	}
+	if s.dir == "" {
+		s.dir = "./data/assets"
+	}
+	if s.maxSize <= 0 {
+		s.maxSize = defaultAssetMaxSize
+	}
+	return s
+}
+
+// MaxSize 单张图片的大小上限
+func (s *AssetService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Store 保存上传的图片，内容类型按内容识别，只接受PNG、JPEG、GIF和WebP
+func (s *AssetService) Store(asset *models.Asset, src io.Reader) error {
+	asset.Name = filepath.Base(strings.TrimSpace(asset.Name))
+	if asset.Name == "" || asset.Name == "." || asset.Name == string(filepath.Separator) {
+		return fmt.Errorf("图片文件名不能为空")
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	asset.Token = hex.EncodeToString(token)
+
+	dir := filepath.Join(s.dir, scopeDir(asset.ProjectID, asset.UserID))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, "asset-*")
+	if err != nil {
+		return err
+	}
+	filePath := file.Name()
+
+	head := &headBuffer{}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash, head), io.LimitReader(src, s.maxSize+1))
+	file.Close()
+	if err == nil && size > s.maxSize {
+		err = fmt.Errorf("图片大小不能超过 %d 字节", s.maxSize)
+	}
+	if err == nil {
+		asset.ContentType = mediaType(http.DetectContentType(head.data))
+		if !assetTypes[asset.ContentType] {
+			err = fmt.Errorf("不支持的图片类型: %s", asset.ContentType)
+		}
+	}
+	if err != nil {
+		os.Remove(filePath)
+		return err
+	}
+
+	asset.Size = size
+	asset.Checksum = hex.EncodeToString(hash.Sum(nil))
+	asset.Path = filePath
+	if err := s.db.Create(asset).Error; err != nil {
+		os.Remove(filePath)
+		return err
+	}
+	return nil
+}
+
+// ListAssets 分页获取项目中的图片
+func (s *AssetService) ListAssets(projectID, userID uint, page, pageSize int) ([]models.Asset, int64, error) {
+	query := s.db.Model(&models.Asset{}).Scopes(InProject(projectID, userID))
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var assets []models.Asset
+	err := query.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&assets).Error
+	return assets, total, err
+}
+
+// GetAsset 获取图片
+func (s *AssetService) GetAsset(id uint) (*models.Asset, error) {
+	var asset models.Asset
+	err := s.db.First(&asset, id).Error
+	return &asset, err
+}
+
+// GetAssetByToken 按公开访问令牌获取图片
+func (s *AssetService) GetAssetByToken(token string) (*models.Asset, error) {
+	var asset models.Asset
+	err := s.db.Where("token = ?", token).First(&asset).Error
+	return &asset, err
+}
+
+// DeleteAsset 删除图片和文件，启用的模板按ID或公开地址引用时返回 ErrAssetInUse。
+// 已发送的邮件中托管的图片在删除后无法显示
+func (s *AssetService) DeleteAsset(asset *models.Asset) error {
+	var templates int64
+	s.db.Model(&models.EmailTemplate{}).
+		Where("status = ? AND (content LIKE ? OR content LIKE ?)", "active",
+			"%"+AssetRefPrefix+strconv.FormatUint(uint64(asset.ID), 10)+"%", "%"+asset.Token+"%").
+		Count(&templates)
+	if templates > 0 {
+		return ErrAssetInUse
+	}
+
+	if err := s.db.Delete(asset).Error; err != nil {
+		return err
+	}
+	os.Remove(asset.Path)
+	return nil
+}
+
+// URL 图片的公开访问地址，未配置 base_url 时返回相对地址
+func (s *AssetService) URL(asset *models.Asset) string {
+	return s.baseURL + "/assets/" + asset.Token + "/" + url.PathEscape(asset.Name)
+}
+
+// contentID 内嵌图片的 Content-ID
+func contentID(asset *models.Asset) string {
+	return "asset-" + strconv.FormatUint(uint64(asset.ID), 10) + "@go-market-email"
+}
+
+// RenderImages 改写模板内容中 img 标签引用的图片：
+// asset:<ID或文件名> 和相对路径按文件名匹配项目中的图片资源，按模板的 image_mode 改为 cid: 内嵌引用
+// 或公开地址，内嵌时同时返回要附加的图片；没有匹配资源的相对路径在配置了 base_url 时改写为绝对地址。
+// 外部地址、data: 和包含模板变量的地址保持不变。preview 为 true 时一律使用公开地址，便于在浏览器中显示
+func (s *AssetService) RenderImages(template *models.EmailTemplate, content string, preview bool) (string, []MailAttachment, error) {
+	mode := template.ImageMode
+	if mode == "" {
+		mode = ImageModeEmbed
+	}
+	if mode != ImageModeEmbed && mode != ImageModeHosted {
+		return "", nil, fmt.Errorf("不支持的图片引用方式: %s", mode)
+	}
+	if mode == ImageModeHosted && !preview && s.baseURL == "" {
+		return "", nil, fmt.Errorf("托管图片需要配置 asset.base_url")
+	}
+
+	var (
+		inline   []MailAttachment
+		embedded = map[uint]bool{}
+		cache    = map[string]*models.Asset{}
+		firstErr error
+	)
+	rendered := imageSrcPattern.ReplaceAllStringFunc(content, func(tag string) string {
+		parts := imageSrcPattern.FindStringSubmatch(tag)
+		src, suffix := parts[2], ""
+		// 不带引号的值会连同自闭合标签的 / 一起匹配
+		if !strings.HasPrefix(src, `"`) && !strings.HasPrefix(src, "'") && strings.HasSuffix(src, "/") {
+			src, suffix = strings.TrimSuffix(src, "/"), "/"
+		}
+		src = strings.TrimSpace(strings.Trim(src, `"'`))
+
+		name, explicit, ok := assetReference(src)
+		if !ok {
+			return tag
+		}
+		asset, found := cache[name]
+		if !found {
+			asset = s.findAsset(template.ProjectID, template.UserID, name, explicit)
+			cache[name] = asset
+		}
+
+		var target string
+		switch {
+		case asset == nil && explicit:
+			if firstErr == nil {
+				firstErr = fmt.Errorf("图片资源不存在: %s", src)
+			}
+			return tag
+		case asset == nil:
+			if s.baseURL == "" {
+				return tag
+			}
+			target = s.baseURL + "/" + strings.TrimPrefix(path.Clean("/"+src), "/")
+		case mode == ImageModeHosted || preview:
+			target = s.URL(asset)
+		default:
+			target = "cid:" + contentID(asset)
+			if !embedded[asset.ID] {
+				embedded[asset.ID] = true
+				inline = append(inline, MailAttachment{
+					Name:        asset.Name,
+					ContentType: asset.ContentType,
+					Path:        asset.Path,
+					Size:        asset.Size,
+					ContentID:   contentID(asset),
+				})
+			}
+		}
+		return parts[1] + `"` + target + `"` + suffix
+	})
+	if firstErr != nil {
+		return "", nil, firstErr
+	}
+	return rendered, inline, nil
+}
+
+// assetReference 判断地址是否引用图片资源，返回要查找的ID或文件名，explicit 表示使用了 asset: 前缀
+func assetReference(src string) (string, bool, bool) {
+	if src == "" || strings.Contains(src, "{{") || strings.HasPrefix(src, "//") || strings.HasPrefix(src, "#") {
+		return "", false, false
+	}
+	if strings.HasPrefix(strings.ToLower(src), AssetRefPrefix) {
+		return strings.TrimSpace(src[len(AssetRefPrefix):]), true, true
+	}
+	if parsed, err := url.Parse(src); err != nil || parsed.Scheme != "" {
+		return "", false, false
+	}
+	// 相对路径去掉查询参数后按文件名匹配
+	if i := strings.IndexAny(src, "?#"); i >= 0 {
+		src = src[:i]
+	}
+	name := path.Base(src)
+	if name == "." || name == "/" {
+		return "", false, false
+	}
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return name, false, true
+}
+
+// findAsset 在项目中按文件名查找图片，同名时使用最新上传的；byID 为 true 时数字按ID查找
+func (s *AssetService) findAsset(projectID, userID uint, name string, byID bool) *models.Asset {
+	query := s.db.Model(&models.Asset{}).Scopes(InProject(projectID, userID))
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil && byID {
+		query = query.Where("id = ?", id)
+	} else {
+		query = query.Where("name = ?", name)
+	}
+	var asset models.Asset
+	if err := query.Order("id DESC").First(&asset).Error; err != nil {
+		return nil
+	}
+	return &asset
+}
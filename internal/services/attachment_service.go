@@ -0,0 +1,373 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go_market_email/internal/models"
+	"go_market_email/internal/utils"
+	"gorm.io/gorm"
+)
+
+// 附件大小默认上限
+const (
+	defaultAttachmentMaxSize  = 10 * 1024 * 1024
+	defaultAttachmentMaxTotal = 20 * 1024 * 1024
+)
+
+// ErrAttachmentInUse 附件仍被模板或未完成的任务引用
+var ErrAttachmentInUse = errors.New("附件正在被模板或任务使用")
+
+// 默认允许的内容类型，以 / 或 . 结尾的按前缀匹配
+var defaultAttachmentTypes = []string{
+	"application/pdf", "image/", "text/plain", "text/csv", "text/calendar", "application/zip",
+	"application/msword", "application/vnd.ms-excel", "application/vnd.ms-powerpoint",
+	"application/vnd.openxmlformats-officedocument.", "application/vnd.oasis.opendocument.",
+}
+
+// 常见附件扩展名对应的内容类型，不依赖系统的MIME配置
+var attachmentExtensionTypes = map[string]string{
+	".pdf":  "application/pdf",
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".txt":  "text/plain",
+	".csv":  "text/csv",
+	".ics":  "text/calendar",
+	".zip":  "application/zip",
+	".doc":  "application/msword",
+	".xls":  "application/vnd.ms-excel",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+}
+
+// 可执行文件会被大多数邮件服务商拒收，不允许作为附件
+var blockedAttachmentExtensions = map[string]bool{
+	".exe": true, ".bat": true, ".cmd": true, ".com": true, ".scr": true, ".msi": true,
+	".js": true, ".vbs": true, ".ps1": true, ".jar": true, ".sh": true, ".dll": true,
+}
+
+// MailAttachment 发送时附加到邮件的文件，ContentID 不为空时作为HTML内嵌图片；
+// 生成的附件（如PDF）内容保存在 Content 中，没有文件路径
+type MailAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Path        string `json:"-"`
+	Content     []byte `json:"-"`
+	Size        int64  `json:"size"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+// AttachmentService 管理附件库，并为每个收件人解析要发送的附件
+type AttachmentService struct {
+	db       *gorm.DB
+	dir      string
+	maxSize  int64
+	maxTotal int64
+	allowed  []string
+	rowDir   string
+}
+
+func NewAttachmentService(db *gorm.DB, config utils.AttachmentConfig) *AttachmentService {
+	s := &AttachmentService{
+		db:       db,
+		dir:      config.Dir,
+		maxSize:  config.MaxSize,
+		maxTotal: config.MaxTotalSize,
+		allowed:  config.AllowedTypes,
+		rowDir:   config.RowDir,
+	}
+	if s.dir == "" {
+		s.dir = "./data/attachments"
+	}
+	if s.maxSize <= 0 {
+		s.maxSize = defaultAttachmentMaxSize
+	}
+	if s.maxTotal <= 0 {
+		s.maxTotal = defaultAttachmentMaxTotal
+	}
+	if len(s.allowed) == 0 {
+		s.allowed = defaultAttachmentTypes
+	}
+	return s
+}
+
+// MaxSize 单个附件的大小上限
+func (s *AttachmentService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Store 把上传的内容保存到附件库，检查大小、扩展名和内容类型，计算SHA-256
+func (s *AttachmentService) Store(attachment *models.Attachment, src io.Reader) error {
+	attachment.Name = filepath.Base(strings.TrimSpace(attachment.Name))
+	if attachment.Name == "" || attachment.Name == "." || attachment.Name == string(filepath.Separator) {
+		return fmt.Errorf("附件文件名不能为空")
+	}
+	if blockedAttachmentExtensions[strings.ToLower(filepath.Ext(attachment.Name))] {
+		return fmt.Errorf("不允许上传可执行文件: %s", attachment.Name)
+	}
+
+	dir := filepath.Join(s.dir, scopeDir(attachment.ProjectID, attachment.UserID))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, "attachment-*")
+	if err != nil {
+		return err
+	}
+	path := file.Name()
+
+	head := &headBuffer{}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash, head), io.LimitReader(src, s.maxSize+1))
+	file.Close()
+	if err == nil && size > s.maxSize {
+		err = fmt.Errorf("附件大小不能超过 %d 字节", s.maxSize)
+	}
+	if err == nil {
+		attachment.ContentType, err = s.contentType(attachment.Name, head.data)
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	attachment.Size = size
+	attachment.Checksum = hex.EncodeToString(hash.Sum(nil))
+	attachment.Path = path
+	if err := s.db.Create(attachment).Error; err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// headBuffer 保存写入内容的前512字节，用于识别内容类型
+type headBuffer struct {
+	data []byte
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if rest := 512 - len(b.data); rest > 0 {
+		if len(p) < rest {
+			rest = len(p)
+		}
+		b.data = append(b.data, p[:rest]...)
+	}
+	return len(p), nil
+}
+
+// contentType 按扩展名确定内容类型，无法确定时按内容识别。
+// 内容是HTML而扩展名不是时拒绝，避免伪装成文档的网页
+func (s *AttachmentService) contentType(name string, head []byte) (string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	sniffed := mediaType(http.DetectContentType(head))
+
+	contentType := attachmentExtensionTypes[ext]
+	if contentType == "" {
+		contentType = mediaType(mime.TypeByExtension(ext))
+	}
+	if contentType == "" {
+		contentType = sniffed
+	}
+	if sniffed == "text/html" && contentType != "text/html" {
+		return "", fmt.Errorf("附件内容与扩展名不符: %s", name)
+	}
+
+	for _, allowed := range s.allowed {
+		if contentType == allowed ||
+			(strings.HasSuffix(allowed, "/") || strings.HasSuffix(allowed, ".")) && strings.HasPrefix(contentType, allowed) {
+			return contentType, nil
+		}
+	}
+	return "", fmt.Errorf("不支持的附件类型: %s", contentType)
+}
+
+func mediaType(contentType string) string {
+	if parsed, _, err := mime.ParseMediaType(contentType); err == nil {
+		return parsed
+	}
+	return contentType
+}
+
+// scopeDir 项目或个人空间在附件目录下的子目录
+func scopeDir(projectID, userID uint) string {
+	if projectID != 0 {
+		return "project-" + strconv.FormatUint(uint64(projectID), 10)
+	}
+	return "user-" + strconv.FormatUint(uint64(userID), 10)
+}
+
+// ListAttachments 分页获取项目中的附件
+func (s *AttachmentService) ListAttachments(projectID, userID uint, page, pageSize int) ([]models.Attachment, int64, error) {
+	query := s.db.Model(&models.Attachment{}).Scopes(InProject(projectID, userID))
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var attachments []models.Attachment
+	err := query.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&attachments).Error
+	return attachments, total, err
+}
+
+// GetAttachment 获取附件
+func (s *AttachmentService) GetAttachment(id uint) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := s.db.First(&attachment, id).Error
+	return &attachment, err
+}
+
+// DeleteAttachment 删除附件和文件，仍被启用的模板或未完成的任务引用时返回 ErrAttachmentInUse
+func (s *AttachmentService) DeleteAttachment(attachment *models.Attachment) error {
+	id := strconv.FormatUint(uint64(attachment.ID), 10)
+
+	var templates, tasks int64
+	s.db.Model(&models.EmailTemplate{}).
+		Where("status = ? AND JSON_CONTAINS(attachment_ids, ?)", "active", id).Count(&templates)
+	s.db.Model(&models.EmailTask{}).
+		Where("status IN ? AND JSON_CONTAINS(attachment_ids, ?)", []string{"pending", "running", "paused"}, id).Count(&tasks)
+	if templates > 0 || tasks > 0 {
+		return ErrAttachmentInUse
+	}
+
+	if err := s.db.Delete(attachment).Error; err != nil {
+		return err
+	}
+	os.Remove(attachment.Path)
+	return nil
+}
+
+// CheckAttachments 检查附件都存在且属于同一项目（个人空间为同一用户）
+func (s *AttachmentService) CheckAttachments(ids models.IDList, projectID, userID uint) error {
+	for _, id := range ids {
+		attachment, err := s.GetAttachment(id)
+		if err != nil {
+			return fmt.Errorf("附件不存在: %d", id)
+		}
+		if attachment.ProjectID != projectID || (projectID == 0 && attachment.UserID != userID) {
+			return fmt.Errorf("附件不属于当前项目: %d", id)
+		}
+	}
+	return nil
+}
+
+// Resolve 按顺序加载附件库中的附件，重复的ID只保留一个
+func (s *AttachmentService) Resolve(lists ...models.IDList) ([]MailAttachment, error) {
+	var attachments []MailAttachment
+	seen := map[uint]bool{}
+	for _, ids := range lists {
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			attachment, err := s.GetAttachment(id)
+			if err != nil {
+				return nil, fmt.Errorf("附件不存在: %d", id)
+			}
+			attachments = append(attachments, MailAttachment{
+				Name:        attachment.Name,
+				ContentType: attachment.ContentType,
+				Path:        attachment.Path,
+				Size:        attachment.Size,
+			})
+		}
+	}
+	return attachments, nil
+}
+
+// RowAttachments 按任务的 AttachmentColumn 为一条记录选择附件，列中可以用分号分隔多个文件。
+// 文件路径相对于 row_dir 下项目（个人任务为用户）的子目录，不能访问子目录之外的文件；
+// 该列为空的记录没有逐条附件
+func (s *AttachmentService) RowAttachments(task *models.EmailTask, record map[string]interface{}) ([]MailAttachment, error) {
+	if task.AttachmentColumn == "" {
+		return nil, nil
+	}
+	value := strings.TrimSpace(FormatValue(record[task.AttachmentColumn]))
+	if value == "" {
+		return nil, nil
+	}
+	if s.rowDir == "" {
+		return nil, fmt.Errorf("未配置按数据列选择的附件目录")
+	}
+
+	base, err := filepath.EvalSymlinks(filepath.Join(s.rowDir, scopeDir(task.ProjectID, task.UserID)))
+	if err != nil {
+		return nil, fmt.Errorf("附件目录不存在")
+	}
+
+	var attachments []MailAttachment
+	for _, name := range strings.Split(value, ";") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		attachment, err := s.rowAttachment(base, name)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, nil
+}
+
+func (s *AttachmentService) rowAttachment(base, name string) (*MailAttachment, error) {
+	// 先按根路径清理，去掉 .. 等跳出目录的部分
+	path := filepath.Join(base, filepath.Clean("/"+filepath.ToSlash(name)))
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, fmt.Errorf("附件不存在: %s", name)
+	}
+	if rel, err := filepath.Rel(base, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("附件路径无效: %s", name)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil || info.IsDir() {
+		return nil, fmt.Errorf("附件不存在: %s", name)
+	}
+	if info.Size() > s.maxSize {
+		return nil, fmt.Errorf("附件大小不能超过 %d 字节: %s", s.maxSize, name)
+	}
+
+	file, err := os.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	file.Close()
+
+	contentType, err := s.contentType(info.Name(), head[:n])
+	if err != nil {
+		return nil, err
+	}
+	return &MailAttachment{Name: filepath.Base(path), ContentType: contentType, Path: resolved, Size: info.Size()}, nil
+}
+
+// CheckTotalSize 检查一封邮件的附件总大小
+func (s *AttachmentService) CheckTotalSize(attachments []MailAttachment) error {
+	var total int64
+	for _, attachment := range attachments {
+		total += attachment.Size
+	}
+	if total > s.maxTotal {
+		return fmt.Errorf("附件总大小 %d 字节超过上限 %d 字节", total, s.maxTotal)
+	}
+	return nil
+}
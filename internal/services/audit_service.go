@@ -0,0 +1,309 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go_market_email/internal/models"
+	"gorm.io/gorm"
+)
+
+// 审计日志来源
+const (
+	AuditSourceAPI = "api"
+	AuditSourceCLI = "cli"
+)
+
+// maxAuditFieldSize 单个快照或请求参数的最大保存长度
+const maxAuditFieldSize = 64 * 1024
+
+// auditResources 支持记录前后快照的资源类型
+var auditResources = map[string]func() interface{}{
+	"template": func() interface{} { return &models.EmailTemplate{} },
+	"task":     func() interface{} { return &models.EmailTask{} },
+	"project":  func() interface{} { return &models.Project{} },
+	"webhook":  func() interface{} { return &models.WebhookSubscription{} },
+	"api_key":  func() interface{} { return &models.APIKey{} },
+
+	"saved_query":    func() interface{} { return &models.SavedQuery{} },
+	"import_mapping": func() interface{} { return &models.ImportMapping{} },
+	"dataset":        func() interface{} { return &models.Dataset{} },
+	"attachment":     func() interface{} { return &models.Attachment{} },
+	"asset":          func() interface{} { return &models.Asset{} },
+	"segment":        func() interface{} { return &models.Segment{} },
+
+	"contact":      func() interface{} { return &models.Contact{} },
+	"contact_list": func() interface{} { return &models.ContactList{} },
+}
+
+// AuditFilter 审计日志查询条件
+type AuditFilter struct {
+	From         *time.Time
+	To           *time.Time
+	ActorID      uint
+	Action       string
+	ResourceType string
+	ResourceID   string
+	ProjectID    *uint
+}
+
+type AuditService struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewAuditService(db *gorm.DB, logger *zap.Logger) *AuditService {
+	return &AuditService{db: db, logger: logger}
+}
+
+// Record 追加一条审计日志，写入失败只记录错误，不影响业务请求
+func (s *AuditService) Record(entry *models.AuditLog) {
+	entry.Request = truncateAudit(entry.Request)
+	entry.Before = truncateAudit(entry.Before)
+	entry.After = truncateAudit(entry.After)
+
+	if err := s.db.Create(entry).Error; err != nil {
+		s.logger.Error("写入审计日志失败",
+			zap.String("action", entry.Action),
+			zap.Uint("actor_id", entry.ActorID),
+			zap.Error(err))
+	}
+}
+
+// Snapshot 获取资源当前状态的JSON快照，资源不存在或不支持时返回空字符串
+func (s *AuditService) Snapshot(resourceType string, id uint) string {
+	newModel, ok := auditResources[resourceType]
+	if !ok || id == 0 {
+		return ""
+	}
+
+	record := newModel()
+	if err := s.db.First(record, id).Error; err != nil {
+		return ""
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// Query 分页查询审计日志
+func (s *AuditService) Query(filter AuditFilter, page, pageSize int) ([]models.AuditLog, int64, error) {
+	query := s.filtered(filter)
+
+	var total int64
+	query.Count(&total)
+
+	var logs []models.AuditLog
+	offset := (page - 1) * pageSize
+	err := query.Offset(offset).Limit(pageSize).Order("created_at DESC, id DESC").Find(&logs).Error
+	return logs, total, err
+}
+
+// Export 按条件导出审计日志，format 为 csv 或 json
+func (s *AuditService) Export(w io.Writer, filter AuditFilter, format string) error {
+	switch format {
+	case "csv":
+		return s.exportCSV(w, filter)
+	case "json":
+		return s.exportJSON(w, filter)
+	default:
+		return fmt.Errorf("不支持的导出格式: %s", format)
+	}
+}
+
+func (s *AuditService) exportCSV(w io.Writer, filter AuditFilter) error {
+	writer := csv.NewWriter(w)
+	header := []string{"id", "created_at", "actor_id", "actor_name", "source", "action",
+		"resource_type", "resource_id", "project_id", "method", "path", "status_code",
+		"client_ip", "user_agent", "request", "before", "after"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	err := s.eachBatch(filter, func(logs []models.AuditLog) error {
+		for _, l := range logs {
+			row := []string{
+				strconv.FormatUint(uint64(l.ID), 10),
+				l.CreatedAt.Format(time.RFC3339),
+				strconv.FormatUint(uint64(l.ActorID), 10),
+				l.ActorName,
+				l.Source,
+				l.Action,
+				l.ResourceType,
+				l.ResourceID,
+				strconv.FormatUint(uint64(l.ProjectID), 10),
+				l.Method,
+				l.Path,
+				strconv.Itoa(l.StatusCode),
+				l.ClientIP,
+				l.UserAgent,
+				l.Request,
+				l.Before,
+				l.After,
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func (s *AuditService) exportJSON(w io.Writer, filter AuditFilter) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	first := true
+	err := s.eachBatch(filter, func(logs []models.AuditLog) error {
+		for _, l := range logs {
+			if !first {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+
+			data, err := json.Marshal(l)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]")
+	return err
+}
+
+// eachBatch 按ID顺序分批读取，避免一次性加载全部日志
+func (s *AuditService) eachBatch(filter AuditFilter, fn func([]models.AuditLog) error) error {
+	var logs []models.AuditLog
+	result := s.filtered(filter).FindInBatches(&logs, 500, func(tx *gorm.DB, batch int) error {
+		return fn(logs)
+	})
+	return result.Error
+}
+
+func (s *AuditService) filtered(filter AuditFilter) *gorm.DB {
+	query := s.db.Model(&models.AuditLog{})
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at <= ?", *filter.To)
+	}
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.ResourceType != "" {
+		query = query.Where("resource_type = ?", filter.ResourceType)
+	}
+	if filter.ResourceID != "" {
+		query = query.Where("resource_id = ?", filter.ResourceID)
+	}
+	if filter.ProjectID != nil {
+		query = query.Where("project_id = ?", *filter.ProjectID)
+	}
+	return query
+}
+
+// auditSensitiveKeys 请求参数中需要脱敏的字段
+var auditSensitiveKeys = []string{"password", "secret", "token", "api_key", "authorization"}
+
+// RedactAuditJSON 把JSON中的密码、密钥等字段替换为 ******，无法解析时原样返回
+func RedactAuditJSON(data []byte) string {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return string(data)
+	}
+	redacted, err := json.Marshal(redactAuditValue(value))
+	if err != nil {
+		return string(data)
+	}
+	return string(redacted)
+}
+
+func redactAuditValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if isSensitiveAuditKey(key) {
+				v[key] = "******"
+				continue
+			}
+			v[key] = redactAuditValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactAuditValue(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func isSensitiveAuditKey(key string) bool {
+	key = strings.ToLower(key)
+	if key == "key" {
+		return true
+	}
+	for _, sensitive := range auditSensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func truncateAudit(value string) string {
+	if len(value) <= maxAuditFieldSize {
+		return value
+	}
+	return value[:maxAuditFieldSize] + "...(truncated)"
+}
+
+// ParseAuditTime 解析查询时间，支持 RFC3339 和 2006-01-02 格式，
+// 只有日期的结束时间包含当天全天
+func ParseAuditTime(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("无效的时间: %s", value)
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return &t, nil
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go_market_email/internal/models"
+	"go_market_email/internal/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix API密钥的固定前缀，用于区分API密钥和会话令牌
+const APIKeyPrefix = "gme_"
+
+var (
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+	ErrInvalidToken       = errors.New("无效的认证令牌")
+	ErrUserDisabled       = errors.New("用户已被禁用")
+	ErrSessionTooOld      = errors.New("会话已超过最长有效期，请重新登录")
+)
+
+// sessionClaims 会话令牌的声明，AuthTime 为用户出示密码或API密钥的时间，续期时保持不变
+type sessionClaims struct {
+	jwt.RegisteredClaims
+	AuthTime int64 `json:"auth_time"`
+}
+
+type AuthService struct {
+	db     *gorm.DB
+	config utils.AuthConfig
+}
+
+func NewAuthService(db *gorm.DB, config utils.AuthConfig) *AuthService {
+	return &AuthService{db: db, config: config}
+}
+
+// CreateUser 创建用户
+func (s *AuthService) CreateUser(username, email, password string) (*models.User, error) {
+	if len(password) < 8 {
+		return nil, fmt.Errorf("密码长度不能少于8位")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Username:     username,
+		Email:        email,
+		PasswordHash: string(hash),
+		Status:       "active",
+	}
+	if err := s.db.Create(user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Login 校验用户名密码并签发会话令牌
+func (s *AuthService) Login(username, password string) (string, time.Time, *models.User, error) {
+	var user models.User
+	if err := s.db.Where("username = ?", username).First(&user).Error; err != nil {
+		return "", time.Time{}, nil, ErrInvalidCredentials
+	}
+	if user.PasswordHash == "" ||
+		bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return "", time.Time{}, nil, ErrInvalidCredentials
+	}
+	if user.Status != "active" {
+		return "", time.Time{}, nil, ErrUserDisabled
+	}
+
+	token, expiresAt, err := s.IssueSession(user.ID, time.Now())
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+	return token, expiresAt, &user, nil
+}
+
+// IssueSession 签发短期JWT会话令牌，authTime 为本次认证的时间，令牌有效期不超过 authTime 加最长会话时间
+func (s *AuthService) IssueSession(userID uint, authTime time.Time) (string, time.Time, error) {
+	if s.config.JWTSecret == "" {
+		return "", time.Time{}, fmt.Errorf("未配置会话签名密钥")
+	}
+
+	ttl := time.Duration(s.config.SessionTTL) * time.Minute
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	if deadline := authTime.Add(s.maxSessionAge()); expiresAt.After(deadline) {
+		expiresAt = deadline
+	}
+	if !expiresAt.After(now) {
+		return "", time.Time{}, ErrSessionTooOld
+	}
+
+	claims := sessionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		AuthTime: authTime.Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.config.JWTSecret))
+	return token, expiresAt, err
+}
+
+// RefreshSession 为当前会话续期，沿用原始认证时间，超过最长会话时间后必须重新登录
+func (s *AuthService) RefreshSession(userID uint, authTime time.Time) (string, time.Time, error) {
+	if time.Since(authTime) >= s.maxSessionAge() {
+		return "", time.Time{}, ErrSessionTooOld
+	}
+	return s.IssueSession(userID, authTime)
+}
+
+func (s *AuthService) maxSessionAge() time.Duration {
+	age := time.Duration(s.config.MaxSessionAge) * time.Minute
+	if age <= 0 {
+		age = 12 * time.Hour
+	}
+	return age
+}
+
+// Authenticate 校验API密钥或会话令牌，返回对应的有效用户和认证时间。
+// API密钥每次请求都是一次完整认证，认证时间为当前时间；会话令牌返回登录时的认证时间
+func (s *AuthService) Authenticate(token string) (*models.User, time.Time, error) {
+	var userID uint
+	var authTime time.Time
+	var err error
+	if strings.HasPrefix(token, APIKeyPrefix) {
+		userID, err = s.authenticateAPIKey(token)
+		authTime = time.Now()
+	} else {
+		userID, authTime, err = s.parseSession(token)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, time.Time{}, ErrInvalidToken
+	}
+	if user.Status != "active" {
+		return nil, time.Time{}, ErrUserDisabled
+	}
+	return &user, authTime, nil
+}
+
+func (s *AuthService) parseSession(token string) (uint, time.Time, error) {
+	if s.config.JWTSecret == "" {
+		return 0, time.Time{}, ErrInvalidToken
+	}
+
+	claims := &sessionClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(s.config.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || claims.AuthTime == 0 {
+		return 0, time.Time{}, ErrInvalidToken
+	}
+
+	userID, err := strconv.ParseUint(claims.Subject, 10, 32)
+	if err != nil {
+		return 0, time.Time{}, ErrInvalidToken
+	}
+	return uint(userID), time.Unix(claims.AuthTime, 0), nil
+}
+
+func (s *AuthService) authenticateAPIKey(key string) (uint, error) {
+	var apiKey models.APIKey
+	if err := s.db.Where("key_hash = ?", hashAPIKey(key)).First(&apiKey).Error; err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && apiKey.ExpiresAt.Before(now)) {
+		return 0, ErrInvalidToken
+	}
+
+	// 最后使用时间精确到分钟即可，避免每个请求都写库
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > time.Minute {
+		s.db.Model(&apiKey).Update("last_used_at", &now)
+	}
+	return apiKey.UserID, nil
+}
+
+// CreateAPIKey 为用户创建API密钥，明文只在创建时返回一次
+func (s *AuthService) CreateAPIKey(userID uint, name string, expiresAt *time.Time) (string, *models.APIKey, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	plain := APIKeyPrefix + hex.EncodeToString(buf)
+
+	apiKey := &models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    plain[:len(APIKeyPrefix)+6],
+		KeyHash:   hashAPIKey(plain),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(apiKey).Error; err != nil {
+		return "", nil, err
+	}
+	return plain, apiKey, nil
+}
+
+// ListAPIKeys 获取用户的API密钥
+func (s *AuthService) ListAPIKeys(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey 吊销API密钥
+func (s *AuthService) RevokeAPIKey(id, userID uint) error {
+	now := time.Now()
+	result := s.db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", &now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// hashAPIKey API密钥本身是高熵随机值，使用SHA-256即可安全存储并支持按哈希查找
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,760 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_market_email/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 联系人订阅状态
+const (
+	ContactSubscribed   = "subscribed"
+	ContactUnsubscribed = "unsubscribed"
+	ContactBounced      = "bounced"
+)
+
+// contactImportChunk 导入时每批写入的联系人数
+const contactImportChunk = 500
+
+// ContactImportOptions 导入选项
+type ContactImportOptions struct {
+	ListID uint     // 导入后加入的列表，0表示不加入
+	Tags   []string // 为导入的联系人追加的标签
+}
+
+// ContactImportError 导入失败的行，行号从1开始且不含标题行
+type ContactImportError struct {
+	Row   int    `json:"row"`
+	Email string `json:"email"`
+	Error string `json:"error"`
+}
+
+// ContactImportResult 导入结果
+type ContactImportResult struct {
+	Total      int                  `json:"total"`
+	Created    int                  `json:"created"`
+	Updated    int                  `json:"updated"`
+	Duplicates int                  `json:"duplicates"` // 文件内重复的邮箱，已合并到同一联系人
+	Failed     int                  `json:"failed"`
+	Errors     []ContactImportError `json:"errors"`
+}
+
+// ContactFilter 联系人查询条件
+type ContactFilter struct {
+	Query  string // 邮箱模糊匹配
+	Tag    string
+	Status string
+	ListID uint
+}
+
+type ContactService struct {
+	db      *gorm.DB
+	sources *DataSourceService
+}
+
+func NewContactService(db *gorm.DB, sources *DataSourceService) *ContactService {
+	return &ContactService{db: db, sources: sources}
+}
+
+// ContactScopeKey 联系人所属空间，项目内或个人空间内邮箱唯一
+func ContactScopeKey(projectID, userID uint) string {
+	if projectID != 0 {
+		return "p:" + strconv.FormatUint(uint64(projectID), 10)
+	}
+	return "u:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+// NormalizeEmail 去掉首尾空白并转为小写
+func NormalizeEmail(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// contactImporter 分块导入联系人，文件内重复的邮箱合并到同一联系人
+type contactImporter struct {
+	db      *gorm.DB
+	scope   string
+	project uint
+	user    uint
+	options ContactImportOptions
+	result  *ContactImportResult
+
+	row     int
+	seen    map[string]bool            // 已出现过的邮箱，用于统计文件内重复
+	pending map[string]*models.Contact // 等待写入的联系人
+	repeat  map[string]bool            // 等待写入但在之前的块中已导入过的邮箱
+	order   []string
+}
+
+func (s *ContactService) newImporter(projectID, userID uint, options ContactImportOptions) *contactImporter {
+	return &contactImporter{
+		db:      s.db,
+		scope:   ContactScopeKey(projectID, userID),
+		project: projectID,
+		user:    userID,
+		options: options,
+		result:  &ContactImportResult{Errors: []ContactImportError{}},
+		seen:    make(map[string]bool),
+		pending: make(map[string]*models.Contact),
+		repeat:  make(map[string]bool),
+	}
+}
+
+// add 加入一行记录，待写入的联系人达到一块时写入数据库
+func (im *contactImporter) add(record map[string]interface{}) error {
+	im.row++
+	im.result.Total++
+
+	email, attributes, tags := splitContactRecord(record)
+	email = NormalizeEmail(email)
+	if email == "" || !strings.Contains(email, "@") {
+		im.fail(email, "邮箱为空或格式错误")
+		return nil
+	}
+	tags = mergeTags(tags, im.options.Tags)
+
+	if im.seen[email] {
+		im.result.Duplicates++
+	}
+	if contact, ok := im.pending[email]; ok {
+		mergeContact(contact, attributes, tags)
+		return nil
+	}
+	if im.seen[email] {
+		im.repeat[email] = true
+	}
+	im.seen[email] = true
+
+	contact := &models.Contact{Email: email}
+	mergeContact(contact, attributes, tags)
+	im.pending[email] = contact
+	im.order = append(im.order, email)
+
+	if len(im.order) >= contactImportChunk {
+		return im.flush()
+	}
+	return nil
+}
+
+// fail 记录导入失败的行
+func (im *contactImporter) fail(email, reason string) {
+	im.result.Failed++
+	im.result.Errors = append(im.result.Errors, ContactImportError{Row: im.row, Email: email, Error: reason})
+}
+
+// flush 按邮箱更新或创建待写入的联系人
+func (im *contactImporter) flush() error {
+	if len(im.order) == 0 {
+		return nil
+	}
+
+	err := im.db.Transaction(func(tx *gorm.DB) error {
+		var existing []models.Contact
+		if err := tx.Where("scope_key = ? AND email IN ?", im.scope, im.order).Find(&existing).Error; err != nil {
+			return err
+		}
+		byEmail := make(map[string]*models.Contact, len(existing))
+		for i := range existing {
+			byEmail[NormalizeEmail(existing[i].Email)] = &existing[i]
+		}
+
+		var ids []uint
+		for _, email := range im.order {
+			incoming := im.pending[email]
+			if contact, ok := byEmail[email]; ok {
+				attributes, tags := decodeContact(*incoming)
+				mergeContact(contact, attributes, tags)
+				if err := tx.Model(contact).Updates(map[string]interface{}{
+					"attributes": contact.Attributes,
+					"tags":       contact.Tags,
+				}).Error; err != nil {
+					return err
+				}
+				if !im.repeat[email] {
+					im.result.Updated++
+				}
+				ids = append(ids, contact.ID)
+				continue
+			}
+
+			incoming.ScopeKey = im.scope
+			incoming.Status = ContactSubscribed
+			incoming.UserID = im.user
+			incoming.ProjectID = im.project
+			if err := tx.Create(incoming).Error; err != nil {
+				return err
+			}
+			im.result.Created++
+			ids = append(ids, incoming.ID)
+		}
+
+		if im.options.ListID != 0 {
+			return addListMembers(tx, im.options.ListID, ids)
+		}
+		return nil
+	})
+
+	im.pending = make(map[string]*models.Contact)
+	im.repeat = make(map[string]bool)
+	im.order = im.order[:0]
+	return err
+}
+
+// Import 按邮箱更新或创建联系人。已存在的联系人合并属性和标签，订阅状态保持不变，
+// 避免导入把已退订的联系人重新订阅
+func (s *ContactService) Import(projectID, userID uint, records []map[string]interface{}, options ContactImportOptions) (*ContactImportResult, error) {
+	importer := s.newImporter(projectID, userID, options)
+	for _, record := range records {
+		if err := importer.add(record); err != nil {
+			return importer.result, err
+		}
+	}
+	return importer.result, importer.flush()
+}
+
+// ImportFile 流式读取CSV或Excel文件导入联系人
+func (s *ContactService) ImportFile(projectID, userID uint, path, filename string, readerOptions ReaderOptions, options ContactImportOptions) (*ContactImportResult, error) {
+	reader, err := OpenRecordReader(path, filename, readerOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	importer := s.newImporter(projectID, userID, options)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *ImportRowError
+		if errors.As(err, &rowErr) {
+			importer.row++
+			importer.result.Total++
+			importer.fail("", rowErr.Error())
+			continue
+		}
+		if err != nil {
+			return importer.result, err
+		}
+		if err := importer.add(record); err != nil {
+			return importer.result, err
+		}
+	}
+	return importer.result, importer.flush()
+}
+
+// ImportSQL 从外部数据源的只读查询导入联系人，结果必须包含email列
+func (s *ContactService) ImportSQL(ctx context.Context, projectID, userID uint, source, query string, params map[string]interface{}, options ContactImportOptions) (*ContactImportResult, error) {
+	if s.sources == nil {
+		return nil, fmt.Errorf("未配置数据源")
+	}
+	result, err := s.sources.Query(ctx, projectID, source, query, params, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !containsString(result.Columns, "email") {
+		return nil, fmt.Errorf("查询结果缺少email列")
+	}
+	return s.Import(projectID, userID, result.Rows, options)
+}
+
+// Search 分页查询联系人
+func (s *ContactService) Search(projectID, userID uint, filter ContactFilter, page, pageSize int) ([]models.Contact, int64, error) {
+	var contacts []models.Contact
+	var total int64
+
+	query := s.filtered(projectID, userID, filter)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * pageSize
+	err := query.Order("id DESC").Offset(offset).Limit(pageSize).Find(&contacts).Error
+	return contacts, total, err
+}
+
+// GetContact 获取联系人
+func (s *ContactService) GetContact(id uint) (*models.Contact, error) {
+	var contact models.Contact
+	err := s.db.First(&contact, id).Error
+	return &contact, err
+}
+
+// CreateContact 创建单个联系人，邮箱已存在时返回错误
+func (s *ContactService) CreateContact(contact *models.Contact, attributes map[string]interface{}, tags []string) error {
+	contact.Email = NormalizeEmail(contact.Email)
+	if !strings.Contains(contact.Email, "@") {
+		return fmt.Errorf("邮箱格式错误")
+	}
+	if contact.Status == "" {
+		contact.Status = ContactSubscribed
+	}
+	if err := validateContactStatus(contact.Status); err != nil {
+		return err
+	}
+	contact.ScopeKey = ContactScopeKey(contact.ProjectID, contact.UserID)
+	mergeContact(contact, attributes, tags)
+
+	var count int64
+	s.db.Model(&models.Contact{}).Where("scope_key = ? AND email = ?", contact.ScopeKey, contact.Email).Count(&count)
+	if count > 0 {
+		return fmt.Errorf("联系人已存在: %s", contact.Email)
+	}
+	return s.db.Create(contact).Error
+}
+
+// UpdateContact 更新联系人；attributes 和 tags 不为nil时整体替换
+func (s *ContactService) UpdateContact(contact *models.Contact, attributes map[string]interface{}, tags []string, status string) error {
+	updates := map[string]interface{}{}
+	if attributes != nil {
+		data, _ := json.Marshal(attributes)
+		updates["attributes"] = string(data)
+	}
+	if tags != nil {
+		data, _ := json.Marshal(mergeTags(nil, tags))
+		updates["tags"] = string(data)
+	}
+	if status != "" {
+		if err := validateContactStatus(status); err != nil {
+			return err
+		}
+		updates["status"] = status
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return s.db.Model(contact).Updates(updates).Error
+}
+
+// DeleteContact 删除联系人及其列表成员关系
+func (s *ContactService) DeleteContact(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("contact_id = ?", id).Delete(&models.ContactListMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Contact{}, id).Error
+	})
+}
+
+// DedupeGroup 一组指向同一信箱的联系人，保留最早创建的联系人，其余合并后删除
+type DedupeGroup struct {
+	Mailbox      string   `json:"mailbox"`
+	KeepID       uint     `json:"keep_id"`
+	Keep         string   `json:"keep"`
+	DuplicateIDs []uint   `json:"duplicate_ids"`
+	Duplicates   []string `json:"duplicates"`
+}
+
+// Dedupe 合并空间内指向同一信箱的联系人：只对已知支持 + 子地址的邮箱服务（见 subaddressDomains）
+// 忽略 + 后的部分，Gmail 地址还忽略本地部分的点号和 googlemail.com 域名，其他域名只合并大小写不同的写法。
+// 保留最早创建的联系人及其邮箱。dryRun 为true时只返回分组，不修改数据；否则返回分组和被合并删除的数量
+func (s *ContactService) Dedupe(projectID, userID uint, dryRun bool) ([]DedupeGroup, int, error) {
+	var all []models.Contact
+	var contacts []models.Contact
+	result := s.db.Where("scope_key = ?", ContactScopeKey(projectID, userID)).Order("id").
+		FindInBatches(&contacts, 1000, func(tx *gorm.DB, batch int) error {
+			all = append(all, contacts...)
+			return nil
+		})
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	duplicates := groupByMailbox(all)
+	groups := make([]DedupeGroup, 0, len(duplicates))
+	for _, group := range duplicates {
+		dedupeGroup := DedupeGroup{Mailbox: mailboxKey(group[0].Email), KeepID: group[0].ID, Keep: group[0].Email}
+		for _, duplicate := range group[1:] {
+			dedupeGroup.DuplicateIDs = append(dedupeGroup.DuplicateIDs, duplicate.ID)
+			dedupeGroup.Duplicates = append(dedupeGroup.Duplicates, duplicate.Email)
+		}
+		groups = append(groups, dedupeGroup)
+	}
+	if dryRun {
+		return groups, 0, nil
+	}
+
+	removed := 0
+	for _, group := range duplicates {
+		if err := s.mergeDuplicates(group); err != nil {
+			return groups, removed, err
+		}
+		removed += len(group) - 1
+	}
+	return groups, removed, nil
+}
+
+// groupByMailbox 按信箱分组，只返回包含多个联系人的组，组内和组间都按联系人ID排序
+func groupByMailbox(contacts []models.Contact) [][]models.Contact {
+	index := make(map[string]int)
+	var groups [][]models.Contact
+	sorted := append([]models.Contact(nil), contacts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	for _, contact := range sorted {
+		key := mailboxKey(contact.Email)
+		if i, ok := index[key]; ok {
+			groups[i] = append(groups[i], contact)
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, []models.Contact{contact})
+	}
+
+	duplicates := groups[:0]
+	for _, group := range groups {
+		if len(group) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+	return duplicates
+}
+
+// mergeDuplicates 将组内其余联系人的属性、标签、状态和列表成员关系合并到第一个联系人后删除
+func (s *ContactService) mergeDuplicates(group []models.Contact) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		keep := group[0]
+		for _, duplicate := range group[1:] {
+			attributes, tags := decodeContact(duplicate)
+			mergeContact(&keep, attributes, tags)
+			// 任一记录退订或退信时保留该状态
+			if duplicate.Status != ContactSubscribed {
+				keep.Status = duplicate.Status
+			}
+
+			var members []models.ContactListMember
+			if err := tx.Where("contact_id = ?", duplicate.ID).Find(&members).Error; err != nil {
+				return err
+			}
+			for i := range members {
+				members[i].ContactID = keep.ID
+			}
+			if len(members) > 0 {
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Where("contact_id = ?", duplicate.ID).Delete(&models.ContactListMember{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&models.Contact{}, duplicate.ID).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&keep).Updates(map[string]interface{}{
+			"attributes": keep.Attributes,
+			"tags":       keep.Tags,
+			"status":     keep.Status,
+		}).Error
+	})
+}
+
+// subaddressDomains 已知把 user+tag 投递到 user 信箱的邮箱服务。
+// 其他域名的 + 地址可能是不同的信箱，不能合并
+var subaddressDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+	"outlook.com":    true,
+	"hotmail.com":    true,
+	"live.com":       true,
+	"msn.com":        true,
+	"icloud.com":     true,
+	"me.com":         true,
+	"mac.com":        true,
+	"fastmail.com":   true,
+	"fastmail.fm":    true,
+	"proton.me":      true,
+	"protonmail.com": true,
+	"pm.me":          true,
+}
+
+// mailboxKey 返回用于合并联系人的信箱标识：邮箱转为小写，subaddressDomains 中的服务去掉 + 子地址，
+// Gmail 去掉本地部分的点号并将 googlemail.com 视为 gmail.com
+func mailboxKey(address string) string {
+	address = NormalizeEmail(address)
+	at := strings.LastIndex(address, "@")
+	if at <= 0 {
+		return address
+	}
+	local, domain := address[:at], address[at+1:]
+	if !subaddressDomains[domain] {
+		return address
+	}
+	if plus := strings.Index(local, "+"); plus > 0 {
+		local = local[:plus]
+	}
+	if domain == "googlemail.com" {
+		domain = "gmail.com"
+	}
+	if domain == "gmail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	return local + "@" + domain
+}
+
+// Export 按查询条件导出联系人，format=csv|json
+func (s *ContactService) Export(w io.Writer, projectID, userID uint, filter ContactFilter, format string) error {
+	switch format {
+	case "csv":
+		return s.exportCSV(w, projectID, userID, filter)
+	case "json":
+		return s.exportJSON(w, projectID, userID, filter)
+	default:
+		return fmt.Errorf("不支持的导出格式: %s", format)
+	}
+}
+
+func (s *ContactService) exportCSV(w io.Writer, projectID, userID uint, filter ContactFilter) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"id", "email", "status", "tags", "attributes", "created_at", "updated_at"}); err != nil {
+		return err
+	}
+
+	err := s.eachBatch(projectID, userID, filter, func(contacts []models.Contact) error {
+		for _, contact := range contacts {
+			_, tags := decodeContact(contact)
+			row := []string{
+				strconv.FormatUint(uint64(contact.ID), 10),
+				contact.Email,
+				contact.Status,
+				strings.Join(tags, ","),
+				contact.Attributes,
+				contact.CreatedAt.Format(time.RFC3339),
+				contact.UpdatedAt.Format(time.RFC3339),
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func (s *ContactService) exportJSON(w io.Writer, projectID, userID uint, filter ContactFilter) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	first := true
+	err := s.eachBatch(projectID, userID, filter, func(contacts []models.Contact) error {
+		for _, contact := range contacts {
+			if !first {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+
+			data, err := json.Marshal(contact)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]")
+	return err
+}
+
+// eachBatch 按ID顺序分批读取，避免一次性加载全部联系人
+func (s *ContactService) eachBatch(projectID, userID uint, filter ContactFilter, fn func([]models.Contact) error) error {
+	var contacts []models.Contact
+	result := s.filtered(projectID, userID, filter).FindInBatches(&contacts, 500, func(tx *gorm.DB, batch int) error {
+		return fn(contacts)
+	})
+	return result.Error
+}
+
+func (s *ContactService) filtered(projectID, userID uint, filter ContactFilter) *gorm.DB {
+	query := s.db.Model(&models.Contact{}).Where("scope_key = ?", ContactScopeKey(projectID, userID))
+	if filter.Query != "" {
+		query = query.Where("email LIKE ?", "%"+filter.Query+"%")
+	}
+	if filter.Tag != "" {
+		query = query.Where("JSON_CONTAINS(tags, JSON_QUOTE(?))", filter.Tag)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.ListID != 0 {
+		query = query.Where("id IN (?)", s.db.Model(&models.ContactListMember{}).Select("contact_id").Where("list_id = ?", filter.ListID))
+	}
+	return query
+}
+
+// CreateList 创建联系人列表
+func (s *ContactService) CreateList(list *models.ContactList) error {
+	return s.db.Create(list).Error
+}
+
+// ListLists 获取项目中的联系人列表及成员数
+func (s *ContactService) ListLists(projectID, userID uint) ([]models.ContactList, error) {
+	var lists []models.ContactList
+	if err := s.db.Scopes(InProject(projectID, userID)).Order("updated_at DESC").Find(&lists).Error; err != nil {
+		return nil, err
+	}
+	if len(lists) == 0 {
+		return lists, nil
+	}
+
+	ids := make([]uint, len(lists))
+	for i, list := range lists {
+		ids[i] = list.ID
+	}
+	var counts []struct {
+		ListID uint
+		Count  int64
+	}
+	s.db.Model(&models.ContactListMember{}).Select("list_id, COUNT(*) AS count").
+		Where("list_id IN ?", ids).Group("list_id").Scan(&counts)
+	byList := make(map[uint]int64, len(counts))
+	for _, count := range counts {
+		byList[count.ListID] = count.Count
+	}
+	for i := range lists {
+		lists[i].MemberCount = byList[lists[i].ID]
+	}
+	return lists, nil
+}
+
+// GetList 获取联系人列表
+func (s *ContactService) GetList(id uint) (*models.ContactList, error) {
+	var list models.ContactList
+	if err := s.db.First(&list, id).Error; err != nil {
+		return nil, err
+	}
+	s.db.Model(&models.ContactListMember{}).Where("list_id = ?", id).Count(&list.MemberCount)
+	return &list, nil
+}
+
+// UpdateList 更新联系人列表
+func (s *ContactService) UpdateList(list *models.ContactList, updates map[string]interface{}) error {
+	return s.db.Model(list).Updates(updates).Error
+}
+
+// DeleteList 删除联系人列表，联系人本身保留
+func (s *ContactService) DeleteList(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("list_id = ?", id).Delete(&models.ContactListMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.ContactList{}, id).Error
+	})
+}
+
+// AddMembers 把同一空间内的联系人加入列表，返回实际加入的数量
+func (s *ContactService) AddMembers(list *models.ContactList, contactIDs []uint) (int, error) {
+	var ids []uint
+	if err := s.db.Model(&models.Contact{}).
+		Where("id IN ? AND scope_key = ?", contactIDs, ContactScopeKey(list.ProjectID, list.UserID)).
+		Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	return len(ids), addListMembers(s.db, list.ID, ids)
+}
+
+// RemoveMembers 从列表中移除联系人
+func (s *ContactService) RemoveMembers(listID uint, contactIDs []uint) error {
+	return s.db.Where("list_id = ? AND contact_id IN ?", listID, contactIDs).Delete(&models.ContactListMember{}).Error
+}
+
+// ContactLists 获取联系人所属的列表ID
+func (s *ContactService) ContactLists(contactID uint) ([]uint, error) {
+	var ids []uint
+	err := s.db.Model(&models.ContactListMember{}).Where("contact_id = ?", contactID).Pluck("list_id", &ids).Error
+	return ids, err
+}
+
+func addListMembers(tx *gorm.DB, listID uint, contactIDs []uint) error {
+	if len(contactIDs) == 0 {
+		return nil
+	}
+	members := make([]models.ContactListMember, len(contactIDs))
+	for i, id := range contactIDs {
+		members[i] = models.ContactListMember{ListID: listID, ContactID: id}
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error
+}
+
+// splitContactRecord 从导入的行中取出邮箱和标签，其余列作为属性。
+// 列名不区分大小写，tags 列用逗号或分号分隔
+func splitContactRecord(record map[string]interface{}) (string, map[string]interface{}, []string) {
+	var email string
+	var tags []string
+	attributes := make(map[string]interface{}, len(record))
+	for key, value := range record {
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "email":
+			email = fmt.Sprint(value)
+		case "tags":
+			for _, tag := range strings.FieldsFunc(fmt.Sprint(value), func(r rune) bool { return r == ',' || r == ';' }) {
+				tags = append(tags, tag)
+			}
+		case "contact_id":
+			// 分群导出的行带有联系人ID，不作为属性
+		default:
+			if value != nil && fmt.Sprint(value) != "" {
+				attributes[key] = value
+			}
+		}
+	}
+	return email, attributes, tags
+}
+
+// mergeContact 把属性和标签合并到联系人，新值覆盖同名属性
+func mergeContact(contact *models.Contact, attributes map[string]interface{}, tags []string) {
+	current, currentTags := decodeContact(*contact)
+	for key, value := range attributes {
+		current[key] = value
+	}
+	attributesJSON, _ := json.Marshal(current)
+	tagsJSON, _ := json.Marshal(mergeTags(currentTags, tags))
+	contact.Attributes = string(attributesJSON)
+	contact.Tags = string(tagsJSON)
+}
+
+// mergeTags 合并标签，去掉空白和重复项并排序
+func mergeTags(tags []string, extra []string) []string {
+	seen := make(map[string]bool)
+	merged := []string{}
+	for _, tag := range append(append([]string{}, tags...), extra...) {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		merged = append(merged, tag)
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+func validateContactStatus(status string) error {
+	switch status {
+	case ContactSubscribed, ContactUnsubscribed, ContactBounced:
+		return nil
+	}
+	return fmt.Errorf("无效的订阅状态: %s", status)
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"testing"
+
+	"go_market_email/internal/models"
+)
+
+func TestMailboxKey(t *testing.T) {
+	tests := []struct {
+		email string
+		key   string
+	}{
+		{"Alice@Example.com", "alice@example.com"},
+		{" sales+eu@corp.com ", "sales+eu@corp.com"},
+		{"a.lice@example.com", "a.lice@example.com"},
+		{"A.Lice+promo@gmail.com", "alice@gmail.com"},
+		{"alice@googlemail.com", "alice@gmail.com"},
+		{"bob+news@outlook.com", "bob@outlook.com"},
+		{"b.ob+news@fastmail.com", "b.ob@fastmail.com"},
+		{"+tag@gmail.com", "+tag@gmail.com"},
+		{"not-an-email", "not-an-email"},
+	}
+	for _, tt := range tests {
+		if key := mailboxKey(tt.email); key != tt.key {
+			t.Errorf("mailboxKey(%q) = %q, want %q", tt.email, key, tt.key)
+		}
+	}
+}
+
+func TestGroupByMailbox(t *testing.T) {
+	contacts := []models.Contact{
+		{ID: 5, Email: "alice+promo@gmail.com"},
+		{ID: 1, Email: "a.lice@gmail.com"},
+		{ID: 2, Email: "sales+eu@corp.com"},
+		{ID: 3, Email: "sales+us@corp.com"},
+		{ID: 4, Email: "carol@example.com"},
+		{ID: 6, Email: "alice@googlemail.com"},
+	}
+	groups := groupByMailbox(contacts)
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1: %+v", len(groups), groups)
+	}
+	var ids []uint
+	for _, contact := range groups[0] {
+		ids = append(ids, contact.ID)
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 5 || ids[2] != 6 {
+		t.Errorf("group ids = %v, want [1 5 6]", ids)
+	}
+}
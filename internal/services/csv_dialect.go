@@ -0,0 +1,198 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// CSV文件编码
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingGBK     = "gbk"
+	EncodingGB18030 = "gb18030"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+)
+
+// csvSampleSize 用于识别编码和分隔符的样本字节数
+const csvSampleSize = 64 * 1024
+
+// csvDelimiters 自动识别时候选的分隔符
+var csvDelimiters = []rune{',', ';', '\t', '|'}
+
+// ReaderOptions 导入文件的读取选项，编码和分隔符为空时自动识别
+type ReaderOptions struct {
+	Encoding  string              `json:"encoding" form:"encoding"`
+	Delimiter string              `json:"delimiter" form:"delimiter"`
+	Sheet     string              `json:"sheet" form:"sheet"`           // Excel工作表名称或从1开始的序号，为空时读取第一个可见的工作表
+	HeaderRow int                 `json:"header_row" form:"header_row"` // 标题所在行，从1开始，之前的行被跳过
+	Mapping   *ImportMappingRules `json:"mapping,omitempty" form:"-"`   // 列映射规则，为空时按原始列导入
+}
+
+func (o ReaderOptions) headerRow() int {
+	if o.HeaderRow < 1 {
+		return 1
+	}
+	return o.HeaderRow
+}
+
+// csvEncoding 返回编码对应的解码器
+func csvEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "_", "-")) {
+	case EncodingUTF8, "utf8":
+		return unicode.UTF8, nil
+	case EncodingGBK, "cp936":
+		return simplifiedchinese.GBK, nil
+	case EncodingGB18030:
+		return simplifiedchinese.GB18030, nil
+	case EncodingUTF16LE, "utf-16":
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), nil
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM), nil
+	}
+	return nil, fmt.Errorf("不支持的文件编码: %s", name)
+}
+
+// detectEncoding 根据BOM和样本内容识别编码。
+// 没有BOM时，大量零字节说明是UTF-16，不是合法UTF-8时按GB18030（兼容GBK）处理
+func detectEncoding(sample []byte) string {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE
+	}
+
+	var evenZeros, oddZeros int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenZeros++
+		} else {
+			oddZeros++
+		}
+	}
+	if half := len(sample) / 2; half > 0 {
+		if oddZeros > half/2 {
+			return EncodingUTF16LE
+		}
+		if evenZeros > half/2 {
+			return EncodingUTF16BE
+		}
+	}
+
+	// 样本可能在多字节字符中间截断
+	for i := 0; i < utf8.UTFMax && len(sample) > 0 && !utf8.FullRune(sample[lastRuneStart(sample):]); i++ {
+		sample = sample[:lastRuneStart(sample)]
+	}
+	if utf8.Valid(sample) {
+		return EncodingUTF8
+	}
+	return EncodingGB18030
+}
+
+func lastRuneStart(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			return i
+		}
+	}
+	return len(data) - 1
+}
+
+// parseDelimiter 解析用户指定的分隔符，支持 \t 和 tab 写法
+func parseDelimiter(value string) (rune, error) {
+	switch value {
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(value)
+	if size == 0 || size != len(value) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("无效的分隔符: %q", value)
+	}
+	return r, nil
+}
+
+// sniffDelimiter 统计样本前若干行引号外各候选分隔符的数量，
+// 选择在最多行中与标题行数量一致的分隔符，默认为逗号
+func sniffDelimiter(sample string) rune {
+	var lines []string
+	for _, line := range strings.Split(sample, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+		if len(lines) >= 20 {
+			break
+		}
+	}
+	// 最后一行可能被样本截断
+	if len(lines) > 2 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ','
+	}
+
+	best, bestScore, bestFields := ',', -1, 0
+	for _, delimiter := range csvDelimiters {
+		header := countOutsideQuotes(lines[0], delimiter)
+		if header == 0 {
+			continue
+		}
+		score := 0
+		for _, line := range lines[1:] {
+			if countOutsideQuotes(line, delimiter) == header {
+				score++
+			}
+		}
+		if score > bestScore || (score == bestScore && header > bestFields) {
+			best, bestScore, bestFields = delimiter, score, header
+		}
+	}
+	return best
+}
+
+func countOutsideQuotes(line string, delimiter rune) int {
+	count := 0
+	quoted := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == delimiter && !quoted:
+			count++
+		}
+	}
+	return count
+}
+
+// sampleLines 截取到最后一个完整行，避免解码时遇到被截断的字符
+func sampleLines(sample []byte, encodingName string) []byte {
+	newline := []byte{'\n'}
+	switch encodingName {
+	case EncodingUTF16LE:
+		newline = []byte{'\n', 0}
+	case EncodingUTF16BE:
+		newline = []byte{0, '\n'}
+	}
+
+	end := bytes.LastIndex(sample, newline)
+	// UTF-16的换行必须落在偶数位置
+	for end > 0 && len(newline) == 2 && end%2 != 0 {
+		end = bytes.LastIndex(sample[:end], newline)
+	}
+	if end < 0 {
+		return sample
+	}
+	return sample[:end+len(newline)]
+}
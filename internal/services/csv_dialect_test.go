@@ -0,0 +1,135 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// encodeUTF16 把文本编码为不带BOM的UTF-16
+func encodeUTF16(t *testing.T, text string, endianness unicode.Endianness) []byte {
+	t.Helper()
+	data, err := unicode.UTF16(endianness, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encodeGBK(t *testing.T, text string) []byte {
+	t.Helper()
+	data, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDetectEncoding(t *testing.T) {
+	chinese := []byte("邮箱,姓名\nzhang@example.com,张三\n")
+
+	tests := []struct {
+		name   string
+		sample []byte
+		want   string
+	}{
+		{name: "ascii", sample: []byte("email,name\na@example.com,A\n"), want: EncodingUTF8},
+		{name: "utf-8", sample: chinese, want: EncodingUTF8},
+		{name: "utf-8 bom", sample: append([]byte{0xEF, 0xBB, 0xBF}, chinese...), want: EncodingUTF8},
+		{name: "utf-8 truncated in rune", sample: chinese[:len("邮箱,姓名\nzhang@example.com,张")+1], want: EncodingUTF8},
+		{name: "utf-16le bom", sample: append([]byte{0xFF, 0xFE}, encodeUTF16(t, "email\n", unicode.LittleEndian)...), want: EncodingUTF16LE},
+		{name: "utf-16be bom", sample: append([]byte{0xFE, 0xFF}, encodeUTF16(t, "email\n", unicode.BigEndian)...), want: EncodingUTF16BE},
+		{name: "utf-16le without bom", sample: encodeUTF16(t, "email,name\na@example.com,A\n", unicode.LittleEndian), want: EncodingUTF16LE},
+		{name: "utf-16be without bom", sample: encodeUTF16(t, "email,name\na@example.com,A\n", unicode.BigEndian), want: EncodingUTF16BE},
+		{name: "gbk", sample: encodeGBK(t, "邮箱,姓名\nzhang@example.com,张三\n"), want: EncodingGB18030},
+		{name: "empty", sample: nil, want: EncodingUTF8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectEncoding(tt.sample); got != tt.want {
+				t.Errorf("detectEncoding = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVEncoding(t *testing.T) {
+	for _, name := range []string{"UTF-8", "utf8", "GBK", "cp936", "gb18030", "utf_16le", "utf-16", "UTF-16BE"} {
+		if _, err := csvEncoding(name); err != nil {
+			t.Errorf("csvEncoding(%q) = %v", name, err)
+		}
+	}
+	if _, err := csvEncoding("latin1"); err == nil {
+		t.Error("unsupported encoding should be rejected")
+	}
+}
+
+func TestSniffDelimiter(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample string
+		want   rune
+	}{
+		{name: "comma", sample: "email,name,city\na@example.com,A,Beijing\nb@example.com,B,Shanghai\n", want: ','},
+		{name: "semicolon", sample: "email;name;amount\na@example.com;A;1,5\nb@example.com;B;2,5\n", want: ';'},
+		{name: "tab", sample: "email\tname\na@example.com\tA, Jr.\nb@example.com\tB\n", want: '\t'},
+		{name: "pipe", sample: "email|name\na@example.com|A\n", want: '|'},
+		{name: "quoted delimiter", sample: "\"name;alias\",email\n\"A;B\",a@example.com\n\"C;D\",c@example.com\n", want: ','},
+		{name: "truncated last line", sample: "email;name\na@example.com;A\nb@example.com;B\nc@exa,mple", want: ';'},
+		{name: "single column", sample: "email\na@example.com\n", want: ','},
+		{name: "empty", sample: "", want: ','},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffDelimiter(tt.sample); got != tt.want {
+				t.Errorf("sniffDelimiter = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  rune
+		ok    bool
+	}{
+		{value: ",", want: ',', ok: true},
+		{value: `\t`, want: '\t', ok: true},
+		{value: "tab", want: '\t', ok: true},
+		{value: "；", want: '；', ok: true},
+		{value: ""},
+		{value: ";;"},
+		{value: `"`},
+		{value: "\n"},
+	}
+	for _, tt := range tests {
+		got, err := parseDelimiter(tt.value)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("parseDelimiter(%q) = %q, %v, want %q, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSampleLines(t *testing.T) {
+	if got := sampleLines([]byte("a,b\nc,d\ne,"), EncodingUTF8); string(got) != "a,b\nc,d\n" {
+		t.Errorf("utf-8 sample = %q", got)
+	}
+	if got := sampleLines([]byte("no newline"), EncodingUTF8); string(got) != "no newline" {
+		t.Errorf("sample without newline = %q", got)
+	}
+
+	// UTF-16LE 中 U+0A41 U+4E00 编码为 41 0A 00 4E，奇数位置的 0A 00 不是换行
+	data := encodeUTF16(t, "a\n\u0a41\u4e00", unicode.LittleEndian)
+	got := sampleLines(data, EncodingUTF16LE)
+	if !bytes.Equal(got, encodeUTF16(t, "a\n", unicode.LittleEndian)) {
+		t.Errorf("utf-16le sample = % x", got)
+	}
+
+	data = encodeUTF16(t, "a\nb\nc", unicode.BigEndian)
+	if got := sampleLines(data, EncodingUTF16BE); !bytes.Equal(got, encodeUTF16(t, "a\nb\n", unicode.BigEndian)) {
+		t.Errorf("utf-16be sample = % x", got)
+	}
+}
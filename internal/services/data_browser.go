@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"go_market_email/internal/models"
+)
+
+// 统计时每列最多跟踪的不同值数量和示例值数量
+const (
+	statsMaxDistinct = 10000
+	statsSampleSize  = 5
+)
+
+// errStopScan 提前结束逐块读取
+var errStopScan = errors.New("stop scan")
+
+// ErrRowNotFound 指定的记录不存在
+var ErrRowNotFound = errors.New("记录不存在")
+
+// DataQuery 分页和搜索条件
+type DataQuery struct {
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+	Search   string `form:"q"`      // 不区分大小写的包含匹配
+	Column   string `form:"column"` // 只在该列中搜索，为空时搜索全部列
+}
+
+// DataRow 一条记录，Row 为记录在数据中的位置（从1开始），用于修改和删除
+type DataRow struct {
+	Row    int                    `json:"row"`
+	Record map[string]interface{} `json:"record"`
+}
+
+// DataPage 一页记录，Total 为符合条件的记录数
+type DataPage struct {
+	Rows     []DataRow `json:"rows"`
+	Total    int       `json:"total"`
+	Page     int       `json:"page"`
+	PageSize int       `json:"page_size"`
+}
+
+// ColumnStats 单列统计
+type ColumnStats struct {
+	Column         string   `json:"column"`
+	Filled         int      `json:"filled"`    // 非空值的数量
+	FillRate       float64  `json:"fill_rate"` // 非空值占全部记录的比例，0-1
+	Distinct       int      `json:"distinct"`
+	DistinctCapped bool     `json:"distinct_capped"` // 不同值超过 statsMaxDistinct 时只统计到上限
+	Samples        []string `json:"samples"`
+}
+
+// DataStats 数据的列统计
+type DataStats struct {
+	Total   int           `json:"total"`
+	Columns []ColumnStats `json:"columns"`
+}
+
+// QueryTaskData 分页读取任务数据，指定 Search 时只返回匹配的记录
+func (s *DataService) QueryTaskData(taskID uint, query DataQuery) (*DataPage, error) {
+	return s.queryData(taskDataKey(taskID), query)
+}
+
+// TaskDataStats 统计任务数据各列的填充率、不同值数量和示例值
+func (s *DataService) TaskDataStats(taskID uint) (*DataStats, error) {
+	return s.dataStats(taskDataKey(taskID))
+}
+
+// TaskDataColumns 任务数据全部记录中出现的列，没有数据时返回nil
+func (s *DataService) TaskDataColumns(taskID uint) ([]string, error) {
+	var columns []string
+	seen := map[string]bool{}
+	err := s.eachDataChunk(taskDataKey(taskID), func(chunk []map[string]interface{}) error {
+		for _, record := range chunk {
+			for key := range record {
+				if !seen[key] {
+					seen[key] = true
+					columns = append(columns, key)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(columns)
+	return columns, nil
+}
+
+func (s *DataService) queryData(base string, query DataQuery) (*DataPage, error) {
+	page := &DataPage{Rows: []DataRow{}, Page: query.Page, PageSize: query.PageSize}
+	offset := (query.Page - 1) * query.PageSize
+	search := strings.ToLower(strings.TrimSpace(query.Search))
+
+	// 不搜索时总数直接读取计数，取满一页即可结束
+	if search == "" {
+		total, err := s.dataCount(base)
+		if err != nil {
+			return nil, err
+		}
+		page.Total = total
+	}
+
+	row, matched := 0, 0
+	err := s.eachDataChunk(base, func(chunk []map[string]interface{}) error {
+		if search == "" && row+len(chunk) <= offset {
+			row += len(chunk)
+			matched += len(chunk)
+			return nil
+		}
+		for _, record := range chunk {
+			row++
+			if search != "" && !recordMatches(record, search, query.Column) {
+				continue
+			}
+			matched++
+			if matched > offset && len(page.Rows) < query.PageSize {
+				page.Rows = append(page.Rows, DataRow{Row: row, Record: record})
+			}
+			if search == "" && len(page.Rows) == query.PageSize {
+				return errStopScan
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errStopScan {
+		return nil, err
+	}
+	if search != "" {
+		page.Total = matched
+	}
+	return page, nil
+}
+
+func recordMatches(record map[string]interface{}, search, column string) bool {
+	if column != "" {
+		return strings.Contains(strings.ToLower(FormatValue(record[column])), search)
+	}
+	for _, value := range record {
+		if strings.Contains(strings.ToLower(FormatValue(value)), search) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *DataService) dataStats(base string) (*DataStats, error) {
+	type columnState struct {
+		stats  ColumnStats
+		values map[string]bool
+	}
+	columns := map[string]*columnState{}
+	total := 0
+
+	err := s.eachDataChunk(base, func(chunk []map[string]interface{}) error {
+		for _, record := range chunk {
+			total++
+			for key, value := range record {
+				state := columns[key]
+				if state == nil {
+					state = &columnState{stats: ColumnStats{Column: key, Samples: []string{}}, values: map[string]bool{}}
+					columns[key] = state
+				}
+				text := FormatValue(value)
+				if strings.TrimSpace(text) == "" {
+					continue
+				}
+				state.stats.Filled++
+				if state.values[text] {
+					continue
+				}
+				if len(state.values) >= statsMaxDistinct {
+					state.stats.DistinctCapped = true
+					continue
+				}
+				state.values[text] = true
+				if len(state.stats.Samples) < statsSampleSize {
+					state.stats.Samples = append(state.stats.Samples, text)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &DataStats{Total: total, Columns: make([]ColumnStats, 0, len(columns))}
+	for _, state := range columns {
+		state.stats.Distinct = len(state.values)
+		if total > 0 {
+			state.stats.FillRate = float64(state.stats.Filled) / float64(total)
+		}
+		stats.Columns = append(stats.Columns, state.stats)
+	}
+	// 收件人列在前，其余按列名排序
+	sort.Slice(stats.Columns, func(i, j int) bool {
+		a, b := stats.Columns[i].Column, stats.Columns[j].Column
+		if (a == RecipientField) != (b == RecipientField) {
+			return a == RecipientField
+		}
+		return a < b
+	})
+	return stats, nil
+}
+
+// rowLocation 记录所在的块，chunk 为-1表示旧版本保存的整块数据
+type rowLocation struct {
+	chunk   int64
+	offset  int
+	records []map[string]interface{}
+}
+
+// locateRow 找到第 row 条记录（从1开始）所在的块
+func (s *DataService) locateRow(base string, row int) (*rowLocation, error) {
+	if row < 1 {
+		return nil, ErrRowNotFound
+	}
+	ctx := context.Background()
+	chunks, err := s.rdb.LLen(ctx, chunksKey(base)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if chunks == 0 {
+		dataJSON, err := s.rdb.Get(ctx, base).Result()
+		if err == redis.Nil {
+			return nil, ErrRowNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		var records []map[string]interface{}
+		if err := json.Unmarshal([]byte(dataJSON), &records); err != nil {
+			return nil, err
+		}
+		if row > len(records) {
+			return nil, ErrRowNotFound
+		}
+		return &rowLocation{chunk: -1, offset: row - 1, records: records}, nil
+	}
+
+	for i := int64(0); i < chunks; i++ {
+		chunkJSON, err := s.rdb.LIndex(ctx, chunksKey(base), i).Result()
+		if err != nil {
+			return nil, err
+		}
+		var records []map[string]interface{}
+		if err := json.Unmarshal([]byte(chunkJSON), &records); err != nil {
+			return nil, err
+		}
+		if row <= len(records) {
+			return &rowLocation{chunk: i, offset: row - 1, records: records}, nil
+		}
+		row -= len(records)
+	}
+	return nil, ErrRowNotFound
+}
+
+// saveChunk 写回修改后的块，保留原有的过期时间；delta 为记录数的变化
+func (s *DataService) saveChunk(base string, location *rowLocation, delta int) error {
+	data, err := json.Marshal(location.records)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if location.chunk < 0 {
+		return s.rdb.Set(ctx, base, data, redis.KeepTTL).Err()
+	}
+
+	pipe := s.rdb.TxPipeline()
+	pipe.LSet(ctx, chunksKey(base), location.chunk, data)
+	if delta != 0 {
+		pipe.IncrBy(ctx, countKey(base), int64(delta))
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// editableTask 只有尚未开始发送的任务可以修改数据
+func (s *DataService) editableTask(taskID uint) error {
+	var task models.EmailTask
+	if err := s.db.Select("id", "status").First(&task, taskID).Error; err != nil {
+		return err
+	}
+	if task.Status != "pending" {
+		return fmt.Errorf("任务已开始发送，不能修改数据")
+	}
+	return nil
+}
+
+// UpdateTaskRow 修改任务数据中的一条记录：fields 中的字段覆盖原值，值为null时删除该字段。
+// 修改收件人地址时按导入时的规则检查并规范化
+func (s *DataService) UpdateTaskRow(taskID uint, row int, fields map[string]interface{}) (map[string]interface{}, error) {
+	if err := s.editableTask(taskID); err != nil {
+		return nil, err
+	}
+	base := taskDataKey(taskID)
+	location, err := s.locateRow(base, row)
+	if err != nil {
+		return nil, err
+	}
+
+	record := location.records[location.offset]
+	for key, value := range fields {
+		if value == nil {
+			delete(record, key)
+			continue
+		}
+		record[key] = value
+	}
+	if _, ok := fields[RecipientField]; ok {
+		check := s.validator.Check(mappingValue(record[RecipientField]))
+		if !check.Valid {
+			return nil, fmt.Errorf("收件人地址无效: %s", check.Reason)
+		}
+		record[RecipientField] = check.Email
+	}
+
+	if err := s.saveChunk(base, location, 0); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// DeleteTaskRow 删除任务数据中的一条记录，之后的记录位置前移
+func (s *DataService) DeleteTaskRow(taskID uint, row int) error {
+	if err := s.editableTask(taskID); err != nil {
+		return err
+	}
+	base := taskDataKey(taskID)
+	location, err := s.locateRow(base, row)
+	if err != nil {
+		return err
+	}
+
+	location.records = append(location.records[:location.offset], location.records[location.offset+1:]...)
+	return s.saveChunk(base, location, -1)
+}
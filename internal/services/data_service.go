@@ -3,236 +3,288 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
-	"strings"
-	"time"
+	"io"
 	
 	"github.com/go-redis/redis/v8"
-	"github.com/xuri/excelize/v2"
+	"go_market_email/internal/models"
 	"go_market_email/internal/utils"
 	"gorm.io/gorm"
 )
 
 type DataService struct {
-	db  *gorm.DB
-	rdb *redis.Client
+	db      *gorm.DB
+	rdb     *redis.Client
+	sources   *DataSourceService
+	mappings  *ImportMappingService
+	validator *EmailValidator
 }
 
-func NewDataService(db *gorm.DB, rdb *redis.Client) *DataService {
-	return &DataService{db: db, rdb: rdb}
+func NewDataService(db *gorm.DB, rdb *redis.Client, sources *DataSourceService) *DataService {
+	return &DataService{
+		db:        db,
+		rdb:       rdb,
+		sources:   sources,
+		mappings:  NewImportMappingService(db),
+		validator: NewEmailValidator(nil),
+	}
 }
 
-// ImportExcelData 导入Excel数据
-func (s *DataService) ImportExcelData(filePath string, taskID uint) ([]map[string]interface{}, error) {
-	f, err := excelize.OpenFile(filePath)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	
-	// 获取第一个工作表
-	sheetName := f.GetSheetName(0)
-	rows, err := f.GetRows(sheetName)
+// GetDataSourceService 获取外部数据源服务
+func (s *DataService) GetDataSourceService() *DataSourceService {
+	return s.sources
+}
+
+// GetImportMappingService 获取列映射预设服务
+func (s *DataService) GetImportMappingService() *ImportMappingService {
+	return s.mappings
+}
+
+// ImportSummary 文件导入结果
+type ImportSummary struct {
+	Total      int                      `json:"total"`
+	Skipped    int                      `json:"skipped"` // 无法解析而跳过的行数
+	Columns    []string                 `json:"columns"`
+	Sheet      string                   `json:"sheet,omitempty"`
+	Encoding   string                   `json:"encoding,omitempty"`
+	Delimiter  string                   `json:"delimiter,omitempty"`
+	Preview    []map[string]interface{} `json:"preview"`
+	Errors     []ImportRowError         `json:"errors"` // 至多返回 importMaxRowErrors 条
+	Validation *ValidationReport        `json:"validation"`
+}
+
+// 导入结果中返回的预览行数和错误行数
+const (
+	importPreviewSize  = 100
+	importMaxRowErrors = 100
+)
+
+// importFile 流式读取文件，逐块写入 writer 并更新导入进度。
+// 无法解析的行会被跳过并在结果中报告，收件人地址由 pipeline 检查和去重
+func (s *DataService) importFile(ctx context.Context, filePath, filename string, options ReaderOptions, writer *TaskDataWriter, pipeline *addressPipeline, progress *ImportProgress) (*ImportSummary, error) {
+	reader, err := OpenRecordReader(filePath, filename, options)
 	if err != nil {
 		return nil, err
 	}
+	defer reader.Close()
 	
-	if len(rows) < 2 {
-		return nil, fmt.Errorf("Excel文件至少需要包含标题行和数据行")
+	summary := &ImportSummary{
+		Columns: reader.Columns(),
+		Preview: []map[string]interface{}{},
+		Errors:  []ImportRowError{},
 	}
+	summary.Encoding, summary.Delimiter = readerDialect(reader)
+	summary.Sheet = readerSheet(reader)
 	
-	// 第一行作为标题
-	headers := rows[0]
-	var data []map[string]interface{}
-	
-	// 处理数据行
-	for i := 1; i < len(rows); i++ {
-		row := rows[i]
-		record := make(map[string]interface{})
-		
-		for j, header := range headers {
-			if j < len(row) {
-				record[header] = row[j]
-			} else {
-				record[header] = ""
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *ImportRowError
+		if errors.As(err, &rowErr) {
+			summary.Skipped++
+			if len(summary.Errors) < importMaxRowErrors {
+				summary.Errors = append(summary.Errors, *rowErr)
 			}
+			continue
 		}
-		data = append(data, record)
-	}
-	
-	// 存储到Redis
-	key := utils.EmailDataKey + strconv.Itoa(int(taskID))
-	dataJSON, _ := json.Marshal(data)
-	
-	ctx := context.Background()
-	err = s.rdb.Set(ctx, key, dataJSON, 24*time.Hour).Err()
-	if err != nil {
-		return nil, err
-	}
-	
-	return data, nil
-}
-
-// ImportCSVData 导入CSV数据
-func (s *DataService) ImportCSVData(content string, taskID uint) ([]map[string]interface{}, error) {
-	lines := strings.Split(content, "\n")
-	if len(lines) < 2 {
-		return nil, fmt.Errorf("CSV文件至少需要包含标题行和数据行")
-	}
-	
-	// 解析标题行
-	headers := strings.Split(lines[0], ",")
-	for i, header := range headers {
-		headers[i] = strings.TrimSpace(header)
-	}
-	
-	var data []map[string]interface{}
-	
-	// 处理数据行
-	for i := 1; i < len(lines); i++ {
-		line := strings.TrimSpace(lines[i])
-		if line == "" {
+		if err != nil {
+			writer.Abort()
+			return nil, err
+		}
+		if !pipeline.check(record) {
 			continue
 		}
 		
-		values := strings.Split(line, ",")
-		record := make(map[string]interface{})
+		if err := writer.Write(record); err != nil {
+			writer.Abort()
+			return nil, err
+		}
+		if len(summary.Preview) < importPreviewSize {
+			summary.Preview = append(summary.Preview, record)
+		}
 		
-		for j, header := range headers {
-			if j < len(values) {
-				record[header] = strings.TrimSpace(values[j])
-			} else {
-				record[header] = ""
-			}
+		// 每写满一块更新一次进度
+		if writer.Count()%taskDataChunkSize == 0 {
+			progress.Rows = writer.Count()
+			progress.BytesRead = reader.BytesRead()
+			s.saveImportProgress(progress)
 		}
-		data = append(data, record)
 	}
 	
-	// 存储到Redis
-	key := utils.EmailDataKey + strconv.Itoa(int(taskID))
-	dataJSON, _ := json.Marshal(data)
-	
-	ctx := context.Background()
-	err := s.rdb.Set(ctx, key, dataJSON, 24*time.Hour).Err()
-	if err != nil {
+	summary.Validation = s.finishValidation(pipeline)
+	if writer.Count() == 0 {
+		writer.Abort()
+		return summary, fmt.Errorf("文件中没有可导入的数据行")
+	}
+	if err := writer.Close(); err != nil {
 		return nil, err
 	}
 	
-	return data, nil
+	summary.Total = writer.Count()
+	progress.Rows = summary.Total
+	progress.BytesRead = progress.TotalBytes
+	return summary, nil
 }
 
-// ExecuteSQLQuery 执行SQL查询
-func (s *DataService) ExecuteSQLQuery(query string, taskID uint) ([]map[string]interface{}, error) {
-	// 安全检查：只允许SELECT和INSERT语句
-	upperQuery := strings.ToUpper(strings.TrimSpace(query))
-	if !strings.HasPrefix(upperQuery, "SELECT") && !strings.HasPrefix(upperQuery, "INSERT") {
-		return nil, fmt.Errorf("只允许执行SELECT和INSERT语句")
-	}
-	
-	// 禁止危险操作
-	dangerousKeywords := []string{"DROP", "DELETE", "UPDATE", "TRUNCATE", "ALTER"}
-	for _, keyword := range dangerousKeywords {
-		if strings.Contains(upperQuery, keyword) {
-			return nil, fmt.Errorf("查询包含危险关键字: %s", keyword)
-		}
+// ExecuteSQLQuery 在项目可用的外部数据源上执行只读查询，结果作为任务数据
+func (s *DataService) ExecuteSQLQuery(ctx context.Context, projectID uint, source, query string, params map[string]interface{}, taskID uint) (*QueryResult, error) {
+	if s.sources == nil {
+		return nil, fmt.Errorf("未配置数据源")
 	}
-	
-	sqlDB, err := s.db.DB()
+
+	result, err := s.sources.Query(ctx, projectID, source, query, params, 0)
 	if err != nil {
 		return nil, err
 	}
-	
-	rows, err := sqlDB.Query(query)
-	if err != nil {
+
+	if _, err := s.SaveManualData(ctx, result.Rows, taskID, ValidationOptions{}); err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	
-	// 获取列名
-	columns, err := rows.Columns()
+	return result, nil
+}
+
+// CreateSavedQuery 保存查询，记录查询中的参数名
+func (s *DataService) CreateSavedQuery(query *models.SavedQuery, defaults map[string]interface{}) error {
+	if err := s.prepareSavedQuery(query, defaults); err != nil {
+		return err
+	}
+	return s.db.Create(query).Error
+}
+
+// UpdateSavedQuery 更新保存的查询
+func (s *DataService) UpdateSavedQuery(query *models.SavedQuery, defaults map[string]interface{}) error {
+	if err := s.prepareSavedQuery(query, defaults); err != nil {
+		return err
+	}
+	return s.db.Save(query).Error
+}
+
+// prepareSavedQuery 校验数据源和只读查询，并提取参数名
+func (s *DataService) prepareSavedQuery(query *models.SavedQuery, defaults map[string]interface{}) error {
+	if s.sources == nil {
+		return fmt.Errorf("未配置数据源")
+	}
+	dialect, err := s.sources.Dialect(query.ProjectID, query.DataSource)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	
-	var data []map[string]interface{}
-	
-	// 处理查询结果
-	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
-		
-		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, err
-		}
-		
-		record := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			
-			// 处理不同数据类型
-			if b, ok := val.([]byte); ok {
-				record[col] = string(b)
-			} else if val == nil {
-				record[col] = ""
-			} else {
-				record[col] = val
-			}
+	if err := utils.CheckReadOnlyQuery(query.Query, dialect); err != nil {
+		return err
+	}
+
+	names, err := utils.QueryParams(query.Query, dialect)
+	if err != nil {
+		return err
+	}
+	if names == nil {
+		names = []string{}
+	}
+	if defaults == nil {
+		defaults = map[string]interface{}{}
+	}
+	for name := range defaults {
+		if !containsString(names, name) {
+			return fmt.Errorf("查询中没有参数: %s", name)
 		}
-		
-		data = append(data, record)
 	}
-	
-	// 存储到Redis
-	key := utils.EmailDataKey + strconv.Itoa(int(taskID))
-	dataJSON, _ := json.Marshal(data)
-	
-	ctx := context.Background()
-	err = s.rdb.Set(ctx, key, dataJSON, 24*time.Hour).Err()
+
+	paramsJSON, _ := json.Marshal(names)
+	defaultsJSON, _ := json.Marshal(defaults)
+	query.Params = string(paramsJSON)
+	query.Defaults = string(defaultsJSON)
+	return nil
+}
+
+// ListSavedQueries 获取项目中保存的查询
+func (s *DataService) ListSavedQueries(projectID, userID uint) ([]models.SavedQuery, error) {
+	var queries []models.SavedQuery
+	err := s.db.Scopes(InProject(projectID, userID)).Order("updated_at DESC").Find(&queries).Error
+	return queries, err
+}
+
+// GetSavedQuery 获取保存的查询
+func (s *DataService) GetSavedQuery(id uint) (*models.SavedQuery, error) {
+	var query models.SavedQuery
+	err := s.db.First(&query, id).Error
+	return &query, err
+}
+
+// DeleteSavedQuery 删除保存的查询
+func (s *DataService) DeleteSavedQuery(id uint) error {
+	return s.db.Delete(&models.SavedQuery{}, id).Error
+}
+
+// RunSavedQuery 执行保存的查询，请求参数覆盖默认值；taskID不为0时把结果保存为任务数据
+func (s *DataService) RunSavedQuery(ctx context.Context, query *models.SavedQuery, params map[string]interface{}, limit int, taskID uint) (*QueryResult, error) {
+	if s.sources == nil {
+		return nil, fmt.Errorf("未配置数据源")
+	}
+
+	bound := map[string]interface{}{}
+	if query.Defaults != "" {
+		json.Unmarshal([]byte(query.Defaults), &bound)
+	}
+	for name, value := range params {
+		bound[name] = value
+	}
+
+	result, err := s.sources.Query(ctx, query.ProjectID, query.DataSource, query.Query, bound, limit)
 	if err != nil {
 		return nil, err
 	}
-	
-	return data, nil
+
+	if taskID != 0 {
+		if _, err := s.SaveManualData(ctx, result.Rows, taskID, ValidationOptions{}); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
 }
 
-// SaveManualData 保存手动输入的数据
-func (s *DataService) SaveManualData(data []map[string]interface{}, taskID uint) error {
-	key := utils.EmailDataKey + strconv.Itoa(int(taskID))
-	dataJSON, _ := json.Marshal(data)
-	
-	ctx := context.Background()
-	return s.rdb.Set(ctx, key, dataJSON, 24*time.Hour).Err()
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
 }
 
-// GetTaskData 获取任务数据
-func (s *DataService) GetTaskData(taskID uint) ([]map[string]interface{}, error) {
-	key := utils.EmailDataKey + strconv.Itoa(int(taskID))
-	
-	ctx := context.Background()
-	dataJSON, err := s.rdb.Get(ctx, key).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return []map[string]interface{}{}, nil
+// SaveManualData 检查收件人地址后保存数据，返回地址检查报告
+func (s *DataService) SaveManualData(ctx context.Context, data []map[string]interface{}, taskID uint, validation ValidationOptions) (*ValidationReport, error) {
+	pipeline := newAddressPipeline(ctx, s.validator, taskID, validation)
+	writer := s.NewTaskDataWriter(taskID)
+	for _, record := range data {
+		if !pipeline.check(record) {
+			continue
+		}
+		if err := writer.Write(record); err != nil {
+			writer.Abort()
+			return nil, err
 		}
+	}
+	if err := writer.Close(); err != nil {
 		return nil, err
 	}
-	
-	var data []map[string]interface{}
-	err = json.Unmarshal([]byte(dataJSON), &data)
+	return s.finishValidation(pipeline), nil
+}
+
+// GetTaskData 获取任务的全部数据，大量数据应使用 EachTaskDataChunk 逐块读取
+func (s *DataService) GetTaskData(taskID uint) ([]map[string]interface{}, error) {
+	data := []map[string]interface{}{}
+	err := s.EachTaskDataChunk(taskID, func(chunk []map[string]interface{}) error {
+		data = append(data, chunk...)
+		return nil
+	})
 	return data, err
 }
 
 // DeleteTaskData 删除任务数据
 func (s *DataService) DeleteTaskData(taskID uint) error {
-	key := utils.EmailDataKey + strconv.Itoa(int(taskID))
-	
-	ctx := context.Background()
-	return s.rdb.Del(ctx, key).Err()
+	return s.deleteData(taskDataKey(taskID))
 }
 
 // ValidateDataStructure 验证数据结构
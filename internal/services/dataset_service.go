@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_market_email/internal/models"
+	"go_market_email/internal/utils"
+	"gorm.io/gorm"
+)
+
+// 数据集状态
+const (
+	DatasetImporting = "importing"
+	DatasetReady     = "ready"
+	DatasetFailed    = "failed"
+)
+
+const defaultDatasetTTL = 72 * time.Hour
+
+// ErrDatasetExpired 数据集已过期，记录已从Redis中删除
+var ErrDatasetExpired = errors.New("数据集已过期")
+
+// DatasetService 管理上传的数据集：保存上传文件、导入记录、关联任务和过期清理
+type DatasetService struct {
+	db   *gorm.DB
+	data *DataService
+	dir  string
+	ttl  time.Duration
+}
+
+func NewDatasetService(db *gorm.DB, data *DataService, config utils.UploadConfig) *DatasetService {
+	dir := config.Dir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "go_market_email", "uploads")
+	}
+	ttl := time.Duration(config.DatasetTTL) * time.Hour
+	if ttl <= 0 {
+		ttl = defaultDatasetTTL
+	}
+	return &DatasetService{db: db, data: data, dir: dir, ttl: ttl}
+}
+
+func datasetDataKey(datasetID uint) string {
+	return utils.DatasetDataKey + strconv.FormatUint(uint64(datasetID), 10)
+}
+
+// UploadPath 在上传目录中创建一个只有当前进程用户可读写的唯一文件，用于保存上传内容。
+// 文件名随机生成，只保留原文件名的扩展名，并发上传同名文件不会互相覆盖
+func (s *DatasetService) UploadPath(filename string) (string, error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return "", err
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	if len(ext) > 10 || strings.ContainsAny(ext, `/\`) {
+		ext = ""
+	}
+	file, err := os.CreateTemp(s.dir, "upload-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	return file.Name(), nil
+}
+
+// Import 创建数据集并流式导入文件，taskID 不为0时导入完成后关联到该任务。
+// 导入失败时数据集标记为失败并记录原因
+func (s *DatasetService) Import(ctx context.Context, dataset *models.Dataset, filePath string, options ReaderOptions, validation ValidationOptions, taskID uint) (*ImportSummary, error) {
+	if dataset.Name == "" {
+		dataset.Name = dataset.Filename
+	}
+	dataset.Format = ImportFormat(dataset.Filename)
+	dataset.Status = DatasetImporting
+	dataset.Columns = "[]"
+	dataset.ExpiresAt = time.Now().Add(s.ttl)
+	if err := s.db.Create(dataset).Error; err != nil {
+		return nil, err
+	}
+
+	progress := &ImportProgress{TaskID: taskID, DatasetID: dataset.ID, Status: ImportRunning}
+	if info, err := os.Stat(filePath); err == nil {
+		progress.TotalBytes = info.Size()
+	}
+	s.data.saveImportProgress(progress)
+
+	pipeline := newAddressPipeline(ctx, s.data.validator, 0, validation)
+	pipeline.report.DatasetID = dataset.ID
+	writer := s.data.newDataWriter(datasetDataKey(dataset.ID), s.ttl)
+	summary, err := s.data.importFile(ctx, filePath, dataset.Filename, options, writer, pipeline, progress)
+	s.finishImport(dataset, summary, err)
+
+	if err == nil && taskID != 0 {
+		_, err = s.Attach(ctx, dataset, taskID)
+	}
+
+	if err != nil {
+		progress.Status = ImportFailed
+		progress.Error = err.Error()
+	} else {
+		progress.Status = ImportCompleted
+	}
+	s.data.saveImportProgress(progress)
+	return summary, err
+}
+
+// finishImport 保存导入结果
+func (s *DatasetService) finishImport(dataset *models.Dataset, summary *ImportSummary, err error) {
+	if summary != nil {
+		columns, _ := json.Marshal(summary.Columns)
+		dataset.Columns = string(columns)
+		dataset.Sheet = summary.Sheet
+		dataset.RowCount = summary.Total
+		dataset.Skipped = summary.Skipped
+		if summary.Validation != nil {
+			report, _ := json.Marshal(summary.Validation)
+			dataset.Validation = string(report)
+		}
+	}
+	if err != nil {
+		dataset.Status = DatasetFailed
+		dataset.Error = err.Error()
+	} else {
+		dataset.Status = DatasetReady
+	}
+	s.db.Save(dataset)
+}
+
+// Attach 把数据集的记录复制为任务数据，替换任务原有数据并返回记录数。
+// 每个任务保存独立的副本，之后修改任务数据或删除数据集不会相互影响
+func (s *DatasetService) Attach(ctx context.Context, dataset *models.Dataset, taskID uint) (int, error) {
+	if dataset.Status != DatasetReady {
+		return 0, fmt.Errorf("数据集未导入成功")
+	}
+	if !dataset.ExpiresAt.After(time.Now()) {
+		return 0, ErrDatasetExpired
+	}
+	var task models.EmailTask
+	if err := s.db.First(&task, taskID).Error; err != nil {
+		return 0, err
+	}
+	if task.Status == "running" {
+		return 0, fmt.Errorf("任务正在发送，不能替换数据")
+	}
+
+	writer := s.data.NewTaskDataWriter(task.ID)
+	err := s.data.eachDataChunk(datasetDataKey(dataset.ID), func(chunk []map[string]interface{}) error {
+		for _, record := range chunk {
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		writer.Abort()
+		return 0, err
+	}
+	if writer.Count() == 0 && dataset.RowCount > 0 {
+		writer.Abort()
+		return 0, ErrDatasetExpired
+	}
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+
+	// 地址检查报告一并复制给任务
+	if dataset.Validation != "" {
+		var report ValidationReport
+		if json.Unmarshal([]byte(dataset.Validation), &report) == nil {
+			report.TaskID = task.ID
+			s.data.saveValidationReport(&report)
+		}
+	}
+
+	if err := s.db.Model(&task).Update("dataset_id", dataset.ID).Error; err != nil {
+		return 0, err
+	}
+	return writer.Count(), nil
+}
+
+// ListDatasets 分页获取项目中未过期的数据集
+func (s *DatasetService) ListDatasets(projectID, userID uint, page, pageSize int) ([]models.Dataset, int64, error) {
+	query := s.db.Model(&models.Dataset{}).Scopes(InProject(projectID, userID)).
+		Where("expires_at > ?", time.Now())
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var datasets []models.Dataset
+	err := query.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&datasets).Error
+	return datasets, total, err
+}
+
+// GetDataset 获取数据集
+func (s *DatasetService) GetDataset(id uint) (*models.Dataset, error) {
+	var dataset models.Dataset
+	err := s.db.First(&dataset, id).Error
+	return &dataset, err
+}
+
+// DeleteDataset 删除数据集及其记录，已关联任务的数据保留
+func (s *DatasetService) DeleteDataset(id uint) error {
+	s.data.deleteData(datasetDataKey(id))
+	return s.db.Delete(&models.Dataset{}, id).Error
+}
+
+// PurgeExpired 删除已过期的数据集，返回删除的数量
+func (s *DatasetService) PurgeExpired() (int, error) {
+	var ids []uint
+	err := s.db.Model(&models.Dataset{}).Where("expires_at <= ?", time.Now()).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	for _, id := range ids {
+		s.data.deleteData(datasetDataKey(id))
+	}
+	err = s.db.Delete(&models.Dataset{}, ids).Error
+	return len(ids), err
+}
+
+// QueryRows 分页读取数据集的记录
+func (s *DatasetService) QueryRows(datasetID uint, query DataQuery) (*DataPage, error) {
+	return s.data.queryData(datasetDataKey(datasetID), query)
+}
+
+// Stats 统计数据集各列的填充率、不同值数量和示例值
+func (s *DatasetService) Stats(datasetID uint) (*DataStats, error) {
+	return s.data.dataStats(datasetDataKey(datasetID))
+}
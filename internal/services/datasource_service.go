@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"go.uber.org/zap"
+	"go_market_email/internal/utils"
+	_ "modernc.org/sqlite"
+)
+
+// 数据源默认限制
+const (
+	defaultDataSourceMaxRows = 10000
+	defaultDataSourceTimeout = 30
+)
+
+// driverNames 配置中的驱动名对应的 database/sql 驱动
+var driverNames = map[string]string{
+	utils.DialectMySQL:    "mysql",
+	utils.DialectPostgres: "postgres",
+	utils.DialectSQLite:   "sqlite",
+}
+
+// DataSourceInfo 数据源的公开信息，不包含连接串
+type DataSourceInfo struct {
+	Name    string `json:"name"`
+	Driver  string `json:"driver"`
+	MaxRows int    `json:"max_rows"`
+	Timeout int    `json:"timeout"`
+}
+
+// QueryResult 查询结果
+type QueryResult struct {
+	Columns   []string                 `json:"columns"`
+	Rows      []map[string]interface{} `json:"rows"`
+	Truncated bool                     `json:"truncated"` // 结果超过行数限制被截断
+}
+
+type DataSourceService struct {
+	configs map[string]utils.DataSourceConfig
+	logger  *zap.Logger
+
+	mu  sync.Mutex
+	dbs map[string]*sql.DB
+}
+
+func NewDataSourceService(configs map[string]utils.DataSourceConfig, logger *zap.Logger) *DataSourceService {
+	return &DataSourceService{
+		configs: configs,
+		logger:  logger,
+		dbs:     make(map[string]*sql.DB),
+	}
+}
+
+// List 列出项目可以使用的数据源
+func (s *DataSourceService) List(projectID uint) []DataSourceInfo {
+	infos := make([]DataSourceInfo, 0, len(s.configs))
+	for name, config := range s.configs {
+		if !granted(config, projectID) {
+			continue
+		}
+		infos = append(infos, DataSourceInfo{
+			Name:    name,
+			Driver:  config.Driver,
+			MaxRows: maxRows(config),
+			Timeout: timeoutSeconds(config),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Dialect 返回项目可用数据源的SQL方言
+func (s *DataSourceService) Dialect(projectID uint, name string) (string, error) {
+	config, err := s.config(projectID, name)
+	if err != nil {
+		return "", err
+	}
+	return config.Driver, nil
+}
+
+// Query 在只读事务中执行单条SELECT查询，查询中的 :name 参数通过占位符绑定。
+// 数据源必须授权给 projectID；limit 为0或超过数据源上限时使用数据源的行数上限
+func (s *DataSourceService) Query(ctx context.Context, projectID uint, name, query string, params map[string]interface{}, limit int) (*QueryResult, error) {
+	config, err := s.config(projectID, name)
+	if err != nil {
+		return nil, err
+	}
+	dialect := config.Driver
+
+	if err := utils.CheckReadOnlyQuery(query, dialect); err != nil {
+		return nil, err
+	}
+	bound, args, err := utils.BindNamedParams(query, dialect, params)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := s.open(name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 || limit > maxRows(config) {
+		limit = maxRows(config)
+	}
+	timeout := time.Duration(timeoutSeconds(config)) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// 固定一个连接，保证会话级超时设置和只读事务在同一连接上
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if dialect == utils.DialectMySQL {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET SESSION max_execution_time = %d", timeout.Milliseconds())); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	// 只读查询不需要提交
+	defer tx.Rollback()
+
+	if dialect == utils.DialectPostgres {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds())); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	rows, err := tx.QueryContext(ctx, bound, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result, err := scanRows(rows, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("数据源查询完成",
+		zap.String("datasource", name),
+		zap.Int("rows", len(result.Rows)),
+		zap.Bool("truncated", result.Truncated),
+		zap.Duration("duration", time.Since(start)))
+	return result, nil
+}
+
+// config 返回授权给项目的数据源配置，未授权的数据源与不存在的数据源返回相同的错误
+func (s *DataSourceService) config(projectID uint, name string) (utils.DataSourceConfig, error) {
+	config, ok := s.configs[name]
+	if !ok || !granted(config, projectID) {
+		return utils.DataSourceConfig{}, fmt.Errorf("数据源不存在: %s", name)
+	}
+	return config, nil
+}
+
+// granted 数据源是否授权给项目，未配置 projects 且未开启 all_projects 的数据源不能使用
+func granted(config utils.DataSourceConfig, projectID uint) bool {
+	if config.AllProjects {
+		return true
+	}
+	for _, id := range config.Projects {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// open 按需建立连接池
+func (s *DataSourceService) open(name string, config utils.DataSourceConfig) (*sql.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if db, ok := s.dbs[name]; ok {
+		return db, nil
+	}
+
+	driver, ok := driverNames[config.Driver]
+	if !ok {
+		return nil, fmt.Errorf("不支持的数据源驱动: %s", config.Driver)
+	}
+
+	dsn := config.DSN
+	if config.Driver == utils.DialectSQLite {
+		dsn = readOnlySQLiteDSN(dsn)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	maxOpen := config.MaxOpenConns
+	if maxOpen <= 0 {
+		maxOpen = 5
+	}
+	db.SetMaxOpenConns(maxOpen)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	s.dbs[name] = db
+	return db, nil
+}
+
+// Close 关闭所有数据源连接
+func (s *DataSourceService) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, db := range s.dbs {
+		db.Close()
+		delete(s.dbs, name)
+	}
+}
+
+// readOnlySQLiteDSN 以只读模式打开SQLite文件，并开启 query_only
+func readOnlySQLiteDSN(dsn string) string {
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	query := url.Values{}
+	query.Set("mode", "ro")
+	query.Add("_pragma", "query_only(1)")
+	return dsn + separator + query.Encode()
+}
+
+// scanRows 读取至多 limit 行，多读一行用于判断是否截断
+func scanRows(rows *sql.Rows, limit int) (*QueryResult, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &QueryResult{Columns: columns, Rows: []map[string]interface{}{}}
+	for rows.Next() {
+		if len(result.Rows) >= limit {
+			result.Truncated = true
+			break
+		}
+
+		values := make([]interface{}, len(columns))
+		valuePtrs := make([]interface{}, len(columns))
+		for i := range columns {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, err
+		}
+
+		record := make(map[string]interface{})
+		for i, col := range columns {
+			// 处理不同数据类型
+			switch val := values[i].(type) {
+			case []byte:
+				record[col] = string(val)
+			case nil:
+				record[col] = ""
+			default:
+				record[col] = val
+			}
+		}
+		result.Rows = append(result.Rows, record)
+	}
+	return result, rows.Err()
+}
+
+func maxRows(config utils.DataSourceConfig) int {
+	if config.MaxRows > 0 {
+		return config.MaxRows
+	}
+	return defaultDataSourceMaxRows
+}
+
+func timeoutSeconds(config utils.DataSourceConfig) int {
+	if config.Timeout > 0 {
+		return config.Timeout
+	}
+	return defaultDataSourceTimeout
+}
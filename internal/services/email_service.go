@@ -4,10 +4,10 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"io"
 	"net/smtp"
+	"os"
 	"strconv"
 	"time"
 	
@@ -20,21 +20,71 @@ import (
 )
 
 type EmailService struct {
-	DB     *gorm.DB
-	rdb    *redis.Client
-	config utils.Config
-	logger *zap.Logger
+	DB          *gorm.DB
+	rdb         *redis.Client
+	config      utils.Config
+	logger      *zap.Logger
+	webhooks    *WebhookService
+	events      *EventService
+	sources     *DataSourceService
+	segments    *SegmentService
+	attachments *AttachmentService
+	assets      *AssetService
+	pdf         *PDFService
 }
 
 func NewEmailService(db *gorm.DB, rdb *redis.Client, config utils.Config, logger *zap.Logger) *EmailService {
+	sources := NewDataSourceService(config.DataSources, logger)
 	return &EmailService{
-		DB:     db,
-		rdb:    rdb,
-		config: config,
-		logger: logger,
+		DB:          db,
+		rdb:         rdb,
+		config:      config,
+		logger:      logger,
+		webhooks:    NewWebhookService(db, rdb, config.Webhook, logger),
+		events:      NewEventService(rdb, logger),
+		sources:     sources,
+		segments:    NewSegmentService(db, sources),
+		attachments: NewAttachmentService(db, config.Attachment),
+		assets:      NewAssetService(db, config.Asset),
+		pdf:         NewPDFService(config.PDF),
 	}
 }
 
+// GetEventService 获取事件流服务
+func (s *EmailService) GetEventService() *EventService {
+	return s.events
+}
+
+// GetWebhookService 获取Webhook服务
+func (s *EmailService) GetWebhookService() *WebhookService {
+	return s.webhooks
+}
+
+// GetDataSourceService 获取外部数据源服务
+func (s *EmailService) GetDataSourceService() *DataSourceService {
+	return s.sources
+}
+
+// GetSegmentService 获取分群服务
+func (s *EmailService) GetSegmentService() *SegmentService {
+	return s.segments
+}
+
+// GetAttachmentService 获取附件服务
+func (s *EmailService) GetAttachmentService() *AttachmentService {
+	return s.attachments
+}
+
+// GetAssetService 获取图片资源服务
+func (s *EmailService) GetAssetService() *AssetService {
+	return s.assets
+}
+
+// GetPDFService 获取PDF生成服务
+func (s *EmailService) GetPDFService() *PDFService {
+	return s.pdf
+}
+
 // GetLogger 获取日志器
 func (s *EmailService) GetLogger() *zap.Logger {
 	return s.logger
@@ -42,15 +92,17 @@ func (s *EmailService) GetLogger() *zap.Logger {
 
 // SendSingleEmail 发送单封邮件
 func (s *EmailService) SendSingleEmail(to, subject, content string) error {
+	return s.SendSingleEmailWithAttachments(to, subject, content, nil)
+}
+
+// SendSingleEmailWithAttachments 发送带附件的单封邮件
+func (s *EmailService) SendSingleEmailWithAttachments(to, subject, content string, attachments []MailAttachment) error {
 	// 验证邮件地址格式
 	if to == "" {
 		return fmt.Errorf("收件人邮箱不能为空")
 	}
 	
-	// 调试日志：输出SMTP配置
-	fmt.Printf("[DEBUG] SMTP配置: Host=%s, Port=%d, Username=%s, FromName=%s, HasPassword=%t\n", 
-		s.config.SMTP.Host, s.config.SMTP.Port, s.config.SMTP.Username, s.config.SMTP.FromName, s.config.SMTP.Password != "")
-	s.logger.Info("SMTP配置检查",
+	s.logger.Debug("SMTP配置检查",
 		zap.String("smtp_host", s.config.SMTP.Host),
 		zap.Int("smtp_port", s.config.SMTP.Port),
 		zap.String("smtp_username", s.config.SMTP.Username),
@@ -62,68 +114,17 @@ func (s *EmailService) SendSingleEmail(to, subject, content string) error {
 		return fmt.Errorf("SMTP用户名未配置")
 	}
 	
-	e := email.NewEmail()
-	e.From = s.config.SMTP.Username // 直接使用邮箱地址
-	e.To = []string{to}
-	e.Subject = subject
-	e.HTML = []byte(content)
-	
-	addr := fmt.Sprintf("%s:%d", s.config.SMTP.Host, s.config.SMTP.Port)
-	
-	// 记录发送尝试
-	s.logger.Info("尝试发送邮件",
-		zap.String("to", to),
-		zap.String("subject", subject),
-		zap.String("smtp_host", s.config.SMTP.Host),
-		zap.Int("smtp_port", s.config.SMTP.Port),
-		zap.String("smtp_username", s.config.SMTP.Username))
-	
-	// 配置TLS
-	tlsConfig := &tls.Config{
-		ServerName: s.config.SMTP.Host,
-	}
-	
-	// 使用STARTTLS发送邮件
-	err := e.SendWithStartTLS(addr, smtp.PlainAuth("", s.config.SMTP.Username, s.config.SMTP.Password, s.config.SMTP.Host), tlsConfig)
-	if err != nil {
-		s.logger.Error("邮件发送失败",
-			zap.String("to", to),
-			zap.String("smtp_addr", addr),
-			zap.Error(err))
-	} else {
-		s.logger.Info("邮件发送成功",
-			zap.String("to", to),
-			zap.String("subject", subject))
-	}
-	
-	return err
-}
-
-// SendSingleEmailWithAttachments 发送带附件的单封邮件
-func (s *EmailService) SendSingleEmailWithAttachments(to, subject, content string, attachments []string) error {
-	// 验证邮件地址格式
-	if to == "" {
-		return fmt.Errorf("收件人邮箱不能为空")
-	}
-	
-	// 验证发件人配置
-	if s.config.SMTP.Username == "" {
-		return fmt.Errorf("SMTP用户名未配置")
-	}
-	
 	e := email.NewEmail()
 	e.From = s.config.SMTP.Username
 	e.To = []string{to}
 	e.Subject = subject
 	e.HTML = []byte(content)
 	
-	// 添加附件
+	// 添加附件，任一附件无法读取时不发送
 	for _, attachment := range attachments {
-		if attachment != "" {
-			if _, err := e.AttachFile(attachment); err != nil {
-				s.logger.Error("附件添加失败", zap.String("file", attachment), zap.Error(err))
-				continue
-			}
+		if err := attachFile(e, attachment); err != nil {
+			s.logger.Error("附件添加失败", zap.String("file", attachment.Name), zap.Error(err))
+			return fmt.Errorf("附件添加失败: %s", attachment.Name)
 		}
 	}
 	
@@ -159,6 +160,54 @@ func (s *EmailService) SendSingleEmailWithAttachments(to, subject, content strin
 	return err
 }
 
+// attachFile 以附件记录的文件名和内容类型添加附件
+func attachFile(e *email.Email, attachment MailAttachment) error {
+	var reader io.Reader = bytes.NewReader(attachment.Content)
+	if attachment.Content == nil {
+		file, err := os.Open(attachment.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	at, err := e.Attach(reader, attachment.Name, contentType)
+	if err != nil {
+		return err
+	}
+	if attachment.ContentID != "" {
+		at.HTMLRelated = true
+		at.Header.Set("Content-ID", "<"+attachment.ContentID+">")
+	}
+	return nil
+}
+
+// LintMinScore 启动任务要求的模板检查最低分，0表示不检查
+func (s *EmailService) LintMinScore() int {
+	return s.config.Email.LintMinScore
+}
+
+// LintTask 按任务数据的列检查任务的模板，关联分群的任务在发送时才解析数据，不检查未知变量
+func (s *EmailService) LintTask(task *models.EmailTask, dataService *DataService) (*LintResult, error) {
+	var columns []string
+	if task.SegmentID == 0 {
+		var err error
+		columns, err = dataService.TaskDataColumns(task.ID)
+		if err != nil {
+			return nil, err
+		}
+		// 还没有上传数据时无法判断变量是否存在，跳过未知变量检查
+		if columns != nil && task.AIPrompt != "" {
+			columns = append(columns, "ai_result")
+		}
+	}
+	return NewTemplateService(s.DB).LintTemplate(&task.Template, columns), nil
+}
+
 // QueueEmailTask 将邮件任务加入队列
 func (s *EmailService) QueueEmailTask(taskID uint) error {
 	ctx := context.Background()
@@ -194,6 +243,35 @@ func (s *EmailService) ProcessEmailQueue() error {
 	return s.ProcessEmailTask(uint(taskID))
 }
 
+// taskData 获取任务的收件人数量和逐块读取数据的函数。
+// 关联分群的任务在发送时实时解析分群，其余任务从Redis中逐块读取导入的数据
+func (s *EmailService) taskData(task *models.EmailTask, dataService *DataService) (int, func(func([]map[string]interface{}) error) error, error) {
+	if task.SegmentID == 0 {
+		total, err := dataService.TaskDataCount(task.ID)
+		if err != nil {
+			return 0, nil, err
+		}
+		each := func(fn func([]map[string]interface{}) error) error {
+			return dataService.EachTaskDataChunk(task.ID, fn)
+		}
+		return total, each, nil
+	}
+
+	data, version, err := s.segments.Resolve(context.Background(), task.SegmentID, task.SegmentVersion)
+	if err != nil {
+		return 0, nil, fmt.Errorf("解析分群失败: %w", err)
+	}
+	// 记录实际使用的版本，便于追溯
+	if task.SegmentVersion != version {
+		task.SegmentVersion = version
+		s.DB.Model(task).Update("segment_version", version)
+	}
+	each := func(fn func([]map[string]interface{}) error) error {
+		return fn(data)
+	}
+	return len(data), each, nil
+}
+
 // ProcessEmailTask 处理邮件任务
 func (s *EmailService) ProcessEmailTask(taskID uint) error {
 	// 检查任务是否暂停
@@ -214,15 +292,39 @@ func (s *EmailService) ProcessEmailTask(taskID uint) error {
 		"status":     "running",
 		"started_at": &now,
 	})
+	task.Status = "running"
 	
 	// 获取数据
-	dataService := NewDataService(s.DB, s.rdb)
-	data, err := dataService.GetTaskData(taskID)
+	dataService := NewDataService(s.DB, s.rdb, s.sources)
+	total, eachChunk, err := s.taskData(&task, dataService)
+	if err != nil {
+		s.updateTaskStatus(taskID, "failed", err.Error())
+		s.emit(EventTaskFailed, taskID, "", err.Error())
+		return err
+	}
+	
+	// 模板和任务的附件每封邮件都相同，只加载一次
+	attachments, err := s.attachments.Resolve(task.Template.AttachmentIDs, task.AttachmentIDs)
+	if err == nil {
+		// 模板引用的图片改写为内嵌附件或公开地址
+		var inline []MailAttachment
+		task.Template.Content, inline, err = s.assets.RenderImages(&task.Template, task.Template.Content, false)
+		attachments = append(attachments, inline...)
+	}
+	var pdfTemplates []models.EmailTemplate
+	if err == nil {
+		pdfTemplates, err = NewTemplateService(s.DB).GetPDFTemplates(task.Template.PDFTemplateIDs, task.PDFTemplateIDs)
+	}
 	if err != nil {
 		s.updateTaskStatus(taskID, "failed", err.Error())
+		s.emit(EventTaskFailed, taskID, "", err.Error())
 		return err
 	}
 	
+	s.DB.Model(&task).Update("total_count", total)
+	task.TotalCount = total
+	s.emitTask(EventTaskStarted, &task, "", "")
+	
 	// 处理AI提示词
 	var aiService *AIService
 	if task.AIPrompt != "" {
@@ -230,21 +332,31 @@ func (s *EmailService) ProcessEmailTask(taskID uint) error {
 		aiService = NewAIService(aiConfig)
 	}
 	
-	// 批量发送邮件
+	// 逐块读取数据，批量发送邮件
 	batchSize := s.config.Email.BatchSize
-	for i := 0; i < len(data); i += batchSize {
-		end := i + batchSize
-		if end > len(data) {
-			end = len(data)
-		}
-		
-		batch := data[i:end]
-		s.processBatch(task, batch, aiService)
-		
-		// 发送间隔控制
-		if end < len(data) {
-			time.Sleep(time.Duration(s.config.Email.SendInterval) * time.Second)
+	processed := 0
+	err = eachChunk(func(chunk []map[string]interface{}) error {
+		for i := 0; i < len(chunk); i += batchSize {
+			end := i + batchSize
+			if end > len(chunk) {
+				end = len(chunk)
+			}
+			
+			// 发送间隔控制
+			if processed > 0 {
+				time.Sleep(time.Duration(s.config.Email.SendInterval) * time.Second)
+			}
+			
+			batch := chunk[i:end]
+			s.processBatch(&task, batch, aiService, attachments, pdfTemplates)
+			processed += len(batch)
 		}
+		return nil
+	})
+	if err != nil {
+		s.updateTaskStatus(taskID, "failed", err.Error())
+		s.emit(EventTaskFailed, taskID, "", err.Error())
+		return err
 	}
 	
 	// 更新任务完成状态
@@ -253,6 +365,9 @@ func (s *EmailService) ProcessEmailTask(taskID uint) error {
 		"status":       "completed",
 		"completed_at": &completedAt,
 	})
+	task.Status = "completed"
+	
+	s.emitTask(EventTaskCompleted, &task, "", "")
 	
 	// 清理数据
 	dataService.DeleteTaskData(taskID)
@@ -261,7 +376,9 @@ func (s *EmailService) ProcessEmailTask(taskID uint) error {
 }
 
 // processBatch 处理批量邮件
-func (s *EmailService) processBatch(task models.EmailTask, batch []map[string]interface{}, aiService *AIService) {
+// attachments 为所有收件人共用的附件，任务设置了附件列时再附加该记录对应的文件，
+// pdfTemplates 为每个收件人生成PDF附件。task 的发送计数随发送更新，用于事件通知
+func (s *EmailService) processBatch(task *models.EmailTask, batch []map[string]interface{}, aiService *AIService, attachments []MailAttachment, pdfTemplates []models.EmailTemplate) {
 	templateService := NewTemplateService(s.DB)
 	
 	for _, record := range batch {
@@ -288,8 +405,27 @@ func (s *EmailService) processBatch(task models.EmailTask, batch []map[string]in
 		subject := templateService.ReplaceVariables(task.Template.Subject, record)
 		content := templateService.ReplaceVariables(task.Template.Content, record)
 		
+		// 替换后的内容可能包含AI生成的HTML，发送前内联CSS并删除脚本
+		content, report := ProcessHTML(content)
+		if len(report.RemovedElements) > 0 || len(report.RemovedAttributes) > 0 || report.Clipped {
+			s.logger.Warn("邮件内容已处理",
+				zap.String("email", email),
+				zap.Uint("taskID", task.ID),
+				zap.Strings("removedElements", report.RemovedElements),
+				zap.Strings("removedAttributes", report.RemovedAttributes),
+				zap.Int("size", report.Size),
+				zap.Bool("clipped", report.Clipped))
+		}
+		
+		// 逐条附件无法读取或生成时该收件人记为失败，不发送缺少附件的邮件
+		mailAttachments, err := s.recordAttachments(task, record, attachments, pdfTemplates)
+		if err != nil {
+			s.failRecipient(task, email, subject, content, err)
+			continue
+		}
+		
 		// 发送邮件
-		err := s.sendEmailWithRetry(email, subject, content, task.ID)
+		err = s.sendEmailWithRetry(task, email, subject, content, mailAttachments)
 		if err != nil {
 			s.logger.Error("邮件发送失败", 
 				zap.String("email", email), 
@@ -299,12 +435,57 @@ func (s *EmailService) processBatch(task models.EmailTask, batch []map[string]in
 	}
 }
 
+// recordAttachments 合并共用附件、记录的逐条附件和为记录生成的PDF，并检查总大小
+func (s *EmailService) recordAttachments(task *models.EmailTask, record map[string]interface{}, shared []MailAttachment, pdfTemplates []models.EmailTemplate) ([]MailAttachment, error) {
+	rowAttachments, err := s.attachments.RowAttachments(task, record)
+	if err != nil {
+		return nil, err
+	}
+	templateService := NewTemplateService(s.DB)
+	for i := range pdfTemplates {
+		subject := templateService.ReplaceVariables(pdfTemplates[i].Subject, record)
+		content := templateService.ReplaceVariables(pdfTemplates[i].Content, record)
+		attachment, err := s.pdf.RenderAttachment(&pdfTemplates[i], subject, content)
+		if err != nil {
+			return nil, err
+		}
+		rowAttachments = append(rowAttachments, *attachment)
+	}
+	if len(rowAttachments) == 0 {
+		rowAttachments = shared
+	} else {
+		rowAttachments = append(append([]MailAttachment{}, shared...), rowAttachments...)
+	}
+	if err := s.attachments.CheckTotalSize(rowAttachments); err != nil {
+		return nil, err
+	}
+	return rowAttachments, nil
+}
+
+// failRecipient 记录未发送的收件人
+func (s *EmailService) failRecipient(task *models.EmailTask, to, subject, content string, err error) {
+	s.logger.Error("邮件未发送",
+		zap.String("email", to),
+		zap.Uint("taskID", task.ID),
+		zap.Error(err))
+	s.DB.Create(&models.EmailLog{
+		TaskID:    task.ID,
+		Recipient: to,
+		Subject:   subject,
+		Content:   content,
+		Status:    "failed",
+		Error:     err.Error(),
+	})
+	s.updateTaskStats(task, false)
+	s.emitTask(EventEmailFailed, task, to, err.Error())
+}
+
 // sendEmailWithRetry 带重试的邮件发送
-func (s *EmailService) sendEmailWithRetry(to, subject, content string, taskID uint) error {
+func (s *EmailService) sendEmailWithRetry(task *models.EmailTask, to, subject, content string, attachments []MailAttachment) error {
 	var lastErr error
 	
 	for i := 0; i <= s.config.Email.RetryTimes; i++ {
-		err := s.SendSingleEmail(to, subject, content)
+		err := s.SendSingleEmailWithAttachments(to, subject, content, attachments)
 		
 		// 记录发送日志
 		status := "sent"
@@ -314,7 +495,7 @@ func (s *EmailService) sendEmailWithRetry(to, subject, content string, taskID ui
 		}
 		
 		log := models.EmailLog{
-			TaskID:     taskID,
+			TaskID:     task.ID,
 			Recipient:  to,
 			Subject:    subject,
 			Content:    content,
@@ -333,10 +514,10 @@ func (s *EmailService) sendEmailWithRetry(to, subject, content string, taskID ui
 		
 		if err == nil {
 			// 发送成功，更新统计
-			s.updateTaskStats(taskID, true)
+			s.updateTaskStats(task, true)
 			
-			// 发送webhook通知
-			s.sendWebhook(taskID, to, status, "")
+			// 发送事件通知
+			s.emitTask(EventEmailSent, task, to, "")
 			return nil
 		}
 		
@@ -347,22 +528,64 @@ func (s *EmailService) sendEmailWithRetry(to, subject, content string, taskID ui
 	}
 	
 	// 所有重试都失败
-	s.updateTaskStats(taskID, false)
-	s.sendWebhook(taskID, to, "failed", lastErr.Error())
+	s.updateTaskStats(task, false)
+	s.emitTask(EventEmailFailed, task, to, lastErr.Error())
 	return lastErr
 }
 
-// updateTaskStats 更新任务统计
-func (s *EmailService) updateTaskStats(taskID uint, success bool) {
+// updateTaskStats 更新任务统计，同时更新内存中的计数
+func (s *EmailService) updateTaskStats(task *models.EmailTask, success bool) {
 	if success {
-		s.DB.Model(&models.EmailTask{}).Where("id = ?", taskID).
+		s.DB.Model(&models.EmailTask{}).Where("id = ?", task.ID).
 			Update("sent_count", gorm.Expr("sent_count + 1"))
+		task.SentCount++
 	} else {
-		s.DB.Model(&models.EmailTask{}).Where("id = ?", taskID).
+		s.DB.Model(&models.EmailTask{}).Where("id = ?", task.ID).
 			Update("fail_count", gorm.Expr("fail_count + 1"))
+		task.FailCount++
 	}
 }
 
+// emit 为不在发送循环中的任务事件（暂停、恢复、启动失败）读取任务计数后发布
+func (s *EmailService) emit(eventType string, taskID uint, recipient, errMsg string) {
+	var task models.EmailTask
+	s.DB.Select("id", "user_id", "project_id", "status", "total_count", "sent_count", "fail_count").First(&task, taskID)
+	s.emitTask(eventType, &task, recipient, errMsg)
+}
+
+// emitTask 使用调用方持有的任务计数发布事件，发送循环中每个收件人都会调用，不再查询数据库
+func (s *EmailService) emitTask(eventType string, task *models.EmailTask, recipient, errMsg string) {
+	event := TaskEvent{
+		Type:       eventType,
+		TaskID:     task.ID,
+		UserID:     task.UserID,
+		ProjectID:  task.ProjectID,
+		Status:     task.Status,
+		Recipient:  recipient,
+		Error:      errMsg,
+		TotalCount: task.TotalCount,
+		SentCount:  task.SentCount,
+		FailCount:  task.FailCount,
+		Timestamp:  time.Now().Unix(),
+	}
+	s.events.Publish(event)
+
+	data := map[string]interface{}{
+		"task_id":     task.ID,
+		"status":      task.Status,
+		"total_count": task.TotalCount,
+		"sent_count":  task.SentCount,
+		"fail_count":  task.FailCount,
+	}
+	if recipient != "" {
+		data["recipient"] = recipient
+	}
+	if errMsg != "" {
+		data["error"] = errMsg
+	}
+	s.webhooks.Publish(eventType, task.ProjectID, task.UserID, data)
+}
+
 // updateTaskStatus 更新任务状态
 func (s *EmailService) updateTaskStatus(taskID uint, status, errorMsg string) {
 	updates := map[string]interface{}{"status": status}
@@ -376,14 +599,26 @@ func (s *EmailService) updateTaskStatus(taskID uint, status, errorMsg string) {
 func (s *EmailService) PauseTask(taskID uint) error {
 	ctx := context.Background()
 	key := utils.TaskPauseKey + strconv.Itoa(int(taskID))
-	return s.rdb.Set(ctx, key, "paused", 0).Err()
+	if err := s.rdb.Set(ctx, key, "paused", 0).Err(); err != nil {
+		return err
+	}
+	
+	s.DB.Model(&models.EmailTask{}).Where("id = ?", taskID).Update("status", "paused")
+	s.emit(EventTaskPaused, taskID, "", "")
+	return nil
 }
 
 // ResumeTask 恢复任务
 func (s *EmailService) ResumeTask(taskID uint) error {
 	ctx := context.Background()
 	key := utils.TaskPauseKey + strconv.Itoa(int(taskID))
-	return s.rdb.Del(ctx, key).Err()
+	if err := s.rdb.Del(ctx, key).Err(); err != nil {
+		return err
+	}
+	
+	s.DB.Model(&models.EmailTask{}).Where("id = ?", taskID).Update("status", "running")
+	s.emit(EventTaskResumed, taskID, "", "")
+	return nil
 }
 
 // IsTaskPaused 检查任务是否暂停
@@ -394,32 +629,6 @@ func (s *EmailService) IsTaskPaused(taskID uint) bool {
 	return err == nil
 }
 
-// sendWebhook 发送webhook通知
-func (s *EmailService) sendWebhook(taskID uint, recipient, status, error string) {
-	if s.config.Webhook.URL == "" {
-		return
-	}
-	
-	data := map[string]interface{}{
-		"task_id":   taskID,
-		"recipient": recipient,
-		"status":    status,
-		"error":     error,
-		"timestamp": time.Now().Unix(),
-	}
-	
-	jsonData, _ := json.Marshal(data)
-	
-	client := &http.Client{
-		Timeout: time.Duration(s.config.Webhook.Timeout) * time.Second,
-	}
-	
-	_, err := client.Post(s.config.Webhook.URL, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		s.logger.Error("Webhook发送失败", zap.Error(err))
-	}
-}
-
 // GetTaskStats 获取任务统计
 func (s *EmailService) GetTaskStats(taskID uint) (map[string]interface{}, error) {
 	var task models.EmailTask
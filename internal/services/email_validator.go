@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// 邮箱长度限制（RFC 5321）
+const (
+	maxLocalPartLength = 64
+	maxDomainLength    = 253
+	maxAddressLength   = 254
+)
+
+// MXResolver 查询域名的MX记录，*net.Resolver 满足该接口，测试时可以替换
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// AddressCheck 单个邮箱的检查结果
+type AddressCheck struct {
+	Email      string `json:"email"` // 规范化后的地址，域名为小写的ASCII（punycode）形式
+	Domain     string `json:"domain"`
+	Valid      bool   `json:"valid"`
+	Reason     string `json:"reason,omitempty"`
+	Disposable bool   `json:"disposable"`
+	Role       bool   `json:"role"`
+}
+
+// EmailValidator 检查邮箱语法、规范化地址并标记一次性邮箱和角色账号
+type EmailValidator struct {
+	resolver   MXResolver
+	disposable map[string]bool
+	roles      map[string]bool
+}
+
+// NewEmailValidator 创建邮箱检查器，resolver 为nil时使用系统DNS
+func NewEmailValidator(resolver MXResolver) *EmailValidator {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &EmailValidator{
+		resolver:   resolver,
+		disposable: toSet(disposableDomains),
+		roles:      toSet(roleAccounts),
+	}
+}
+
+// 常见的一次性邮箱域名，子域名同样匹配
+var disposableDomains = []string{
+	"10minutemail.com", "20minutemail.com", "33mail.com", "dispostable.com", "emailondeck.com",
+	"fakeinbox.com", "getairmail.com", "getnada.com", "guerrillamail.com", "guerrillamail.net",
+	"guerrillamailblock.com", "mailcatch.com", "maildrop.cc", "mailinator.com", "mailnesia.com",
+	"mintemail.com", "mohmal.com", "mytemp.email", "sharklasers.com", "spamgourmet.com",
+	"temp-mail.org", "tempail.com", "tempmail.com", "tempmailo.com", "tempr.email",
+	"throwawaymail.com", "trashmail.com", "yopmail.com", "yopmail.net", "moakt.com",
+}
+
+// 常见的角色账号，通常由多人或系统处理，不适合营销邮件
+var roleAccounts = []string{
+	"abuse", "admin", "administrator", "billing", "careers", "contact", "enquiries", "help",
+	"hostmaster", "hr", "info", "jobs", "mail", "mailer-daemon", "marketing", "newsletter",
+	"no-reply", "noc", "noreply", "office", "postmaster", "root", "sales", "security",
+	"service", "support", "team", "webmaster",
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// Check 按 RFC 5322 检查地址语法，国际化域名转换为punycode，域名转为小写。
+// 本地部分保持原样，支持 RFC 6531 的UTF-8字符
+func (v *EmailValidator) Check(address string) AddressCheck {
+	address = strings.TrimSpace(address)
+	// 兼容 "姓名 <user@example.com>" 形式
+	if strings.ContainsAny(address, "<>") {
+		if parsed, err := mail.ParseAddress(address); err == nil {
+			address = parsed.Address
+		}
+	}
+	check := AddressCheck{Email: address}
+	if address == "" {
+		check.Reason = "邮箱为空"
+		return check
+	}
+
+	at := strings.LastIndex(address, "@")
+	if at <= 0 || at == len(address)-1 {
+		check.Reason = "缺少@或本地部分、域名为空"
+		return check
+	}
+	local, domain := address[:at], address[at+1:]
+
+	if reason := checkLocalPart(local); reason != "" {
+		check.Reason = reason
+		return check
+	}
+	domain, reason := normalizeDomain(domain)
+	if reason != "" {
+		check.Reason = reason
+		return check
+	}
+
+	check.Email = local + "@" + domain
+	check.Domain = domain
+	if len(check.Email) > maxAddressLength {
+		check.Reason = "邮箱长度超过254个字符"
+		return check
+	}
+
+	check.Valid = true
+	check.Disposable = v.isDisposable(domain)
+	check.Role = v.roles[roleName(local)]
+	return check
+}
+
+// HasMX 查询域名是否能接收邮件。没有MX记录或只有空MX（RFC 7505）时返回false，
+// DNS临时错误时返回错误，调用方不应据此判定地址无效
+func (v *EmailValidator) HasMX(ctx context.Context, domain string) (bool, error) {
+	if strings.HasPrefix(domain, "[") {
+		return true, nil
+	}
+	records, err := v.resolver.LookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, record := range records {
+		if host := strings.TrimSuffix(record.Host, "."); host != "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (v *EmailValidator) isDisposable(domain string) bool {
+	for domain != "" {
+		if v.disposable[domain] {
+			return true
+		}
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return false
+}
+
+// roleName 去掉 + 后的子地址并转为小写
+func roleName(local string) string {
+	if plus := strings.Index(local, "+"); plus > 0 {
+		local = local[:plus]
+	}
+	return strings.ToLower(local)
+}
+
+// checkLocalPart 检查本地部分，支持dot-atom和带引号的字符串
+func checkLocalPart(local string) string {
+	if len(local) > maxLocalPartLength {
+		return "本地部分超过64个字符"
+	}
+	if !utf8.ValidString(local) {
+		return "本地部分包含无效字符"
+	}
+
+	if strings.HasPrefix(local, `"`) {
+		if len(local) < 2 || !strings.HasSuffix(local, `"`) {
+			return "引号未闭合"
+		}
+		escaped := false
+		for _, r := range local[1 : len(local)-1] {
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\':
+				escaped = true
+			case r == '"' || r < 0x20 || r == 0x7f:
+				return "引号内包含无效字符"
+			}
+		}
+		if escaped {
+			return "引号未闭合"
+		}
+		return ""
+	}
+
+	for _, atom := range strings.Split(local, ".") {
+		if atom == "" {
+			return "本地部分的点号位置无效"
+		}
+		for _, r := range atom {
+			if !isAtext(r) {
+				return "本地部分包含无效字符: " + string(r)
+			}
+		}
+	}
+	return ""
+}
+
+// isAtext RFC 5322 的atext，加上 RFC 6531 允许的非ASCII字符
+func isAtext(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case r >= 0x80:
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", r)
+}
+
+// normalizeDomain 把域名转换为小写的ASCII形式并检查标签格式，支持IP地址字面量
+func normalizeDomain(domain string) (string, string) {
+	if strings.HasPrefix(domain, "[") {
+		if !strings.HasSuffix(domain, "]") {
+			return "", "域名中的IP地址格式无效"
+		}
+		literal := domain[1 : len(domain)-1]
+		if strings.HasPrefix(strings.ToLower(literal), "ipv6:") {
+			literal = literal[len("ipv6:"):]
+			if ip := net.ParseIP(literal); ip == nil || ip.To4() != nil {
+				return "", "域名中的IP地址格式无效"
+			}
+			return "[IPv6:" + strings.ToLower(literal) + "]", ""
+		}
+		if ip := net.ParseIP(literal); ip == nil || ip.To4() == nil {
+			return "", "域名中的IP地址格式无效"
+		}
+		return domain, ""
+	}
+
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return "", "域名无效"
+	}
+	ascii = strings.ToLower(ascii)
+	if len(ascii) > maxDomainLength {
+		return "", "域名超过253个字符"
+	}
+
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "", "域名缺少顶级域"
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 {
+			return "", "域名标签长度无效"
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return "", "域名标签不能以连字符开头或结尾"
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return "", "域名包含无效字符"
+			}
+		}
+	}
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "", "顶级域不能全为数字"
+	}
+	return ascii, ""
+}
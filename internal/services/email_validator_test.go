@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestEmailValidatorCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		address    string
+		valid      bool
+		email      string
+		domain     string
+		disposable bool
+		role       bool
+	}{
+		{name: "simple", address: "alice@example.com", valid: true, email: "alice@example.com", domain: "example.com"},
+		{name: "trim and lowercase domain", address: "  Alice@Example.COM ", valid: true, email: "Alice@example.com", domain: "example.com"},
+		{name: "display name", address: "Alice <alice@example.com>", valid: true, email: "alice@example.com", domain: "example.com"},
+		{name: "trailing dot", address: "alice@example.com.", valid: true, email: "alice@example.com", domain: "example.com"},
+		{name: "plus address", address: "alice+news@example.com", valid: true, email: "alice+news@example.com", domain: "example.com"},
+		{name: "quoted local part", address: `"alice smith"@example.com`, valid: true, email: `"alice smith"@example.com`, domain: "example.com"},
+		{name: "utf8 local part", address: "用户@example.com", valid: true, email: "用户@example.com", domain: "example.com"},
+		{name: "idn domain", address: "user@例子.测试", valid: true, email: "user@xn--fsqu00a.xn--0zwm56d", domain: "xn--fsqu00a.xn--0zwm56d"},
+		{name: "idn uppercase", address: "user@BÜCHER.de", valid: true, email: "user@xn--bcher-kva.de", domain: "xn--bcher-kva.de"},
+		{name: "punycode domain", address: "user@xn--bcher-kva.de", valid: true, email: "user@xn--bcher-kva.de", domain: "xn--bcher-kva.de"},
+		{name: "ipv4 literal", address: "user@[192.168.0.1]", valid: true, email: "user@[192.168.0.1]", domain: "[192.168.0.1]"},
+		{name: "ipv6 literal", address: "user@[IPv6:2001:DB8::1]", valid: true, email: "user@[IPv6:2001:db8::1]", domain: "[IPv6:2001:db8::1]"},
+		{name: "disposable", address: "bob@mailinator.com", valid: true, email: "bob@mailinator.com", domain: "mailinator.com", disposable: true},
+		{name: "disposable subdomain", address: "bob@eu.mailinator.com", valid: true, email: "bob@eu.mailinator.com", domain: "eu.mailinator.com", disposable: true},
+		{name: "role", address: "Info@example.com", valid: true, email: "Info@example.com", domain: "example.com", role: true},
+		{name: "role with plus", address: "support+cn@example.com", valid: true, email: "support+cn@example.com", domain: "example.com", role: true},
+		{name: "disposable role", address: "admin@yopmail.com", valid: true, email: "admin@yopmail.com", domain: "yopmail.com", disposable: true, role: true},
+		{name: "empty", address: "", valid: false},
+		{name: "missing at", address: "alice.example.com", valid: false},
+		{name: "empty domain", address: "alice@", valid: false},
+		{name: "consecutive dots", address: "alice..smith@example.com", valid: false},
+		{name: "leading dot", address: ".alice@example.com", valid: false},
+		{name: "space in local part", address: "alice smith@example.com", valid: false},
+		{name: "unclosed quote", address: `"alice@example.com`, valid: false},
+		{name: "no tld", address: "alice@localhost", valid: false},
+		{name: "numeric tld", address: "alice@example.123", valid: false},
+		{name: "hyphen label", address: "alice@-example.com", valid: false},
+		{name: "underscore domain", address: "alice@exa_mple.com", valid: false},
+		{name: "bad ipv4 literal", address: "user@[999.1.1.1]", valid: false},
+		{name: "local part too long", address: strings.Repeat("a", 65) + "@example.com", valid: false},
+	}
+
+	validator := NewEmailValidator(&fakeResolver{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := validator.Check(tt.address)
+			if check.Valid != tt.valid {
+				t.Fatalf("Valid = %v (%s), want %v", check.Valid, check.Reason, tt.valid)
+			}
+			if !tt.valid {
+				if check.Reason == "" {
+					t.Error("invalid address should have a reason")
+				}
+				return
+			}
+			if check.Email != tt.email || check.Domain != tt.domain {
+				t.Errorf("Email, Domain = %q, %q, want %q, %q", check.Email, check.Domain, tt.email, tt.domain)
+			}
+			if check.Disposable != tt.disposable || check.Role != tt.role {
+				t.Errorf("Disposable, Role = %v, %v, want %v, %v", check.Disposable, check.Role, tt.disposable, tt.role)
+			}
+		})
+	}
+}
+
+func TestEmailValidatorHasMX(t *testing.T) {
+	resolver := &fakeResolver{records: map[string][]*net.MX{
+		"example.com":  {{Host: "mx1.example.com.", Pref: 10}},
+		"null-mx.com":  {{Host: ".", Pref: 0}},
+		"empty-mx.com": {},
+	}, errs: map[string]error{
+		"missing.com": &net.DNSError{Err: "no such host", Name: "missing.com", IsNotFound: true},
+		"timeout.com": &net.DNSError{Err: "i/o timeout", Name: "timeout.com", IsTimeout: true},
+	}}
+	validator := NewEmailValidator(resolver)
+
+	tests := []struct {
+		domain  string
+		ok      bool
+		wantErr bool
+	}{
+		{domain: "example.com", ok: true},
+		{domain: "null-mx.com", ok: false},
+		{domain: "empty-mx.com", ok: false},
+		{domain: "missing.com", ok: false},
+		{domain: "timeout.com", wantErr: true},
+		{domain: "[192.168.0.1]", ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			ok, err := validator.HasMX(context.Background(), tt.domain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.ok {
+				t.Errorf("HasMX = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+	if resolver.lookups["[192.168.0.1]"] != 0 {
+		t.Error("IP literal should not be looked up")
+	}
+}
+
+// fakeResolver 按域名返回预设的MX记录或错误，并记录查询次数
+type fakeResolver struct {
+	records map[string][]*net.MX
+	errs    map[string]error
+	lookups map[string]int
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if r.lookups == nil {
+		r.lookups = map[string]int{}
+	}
+	r.lookups[name]++
+	if err, ok := r.errs[name]; ok {
+		return nil, err
+	}
+	if records, ok := r.records[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"go_market_email/internal/utils"
+)
+
+// TaskEvent 任务生命周期和单封发送事件
+type TaskEvent struct {
+	Type       string `json:"type"`
+	TaskID     uint   `json:"task_id"`
+	UserID     uint   `json:"user_id"`
+	ProjectID  uint   `json:"project_id"`
+	Status     string `json:"status,omitempty"`
+	Recipient  string `json:"recipient,omitempty"`
+	Error      string `json:"error,omitempty"`
+	TotalCount int    `json:"total_count"`
+	SentCount  int    `json:"sent_count"`
+	FailCount  int    `json:"fail_count"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// EventService 基于Redis发布订阅的事件流
+type EventService struct {
+	rdb    *redis.Client
+	logger *zap.Logger
+}
+
+func NewEventService(rdb *redis.Client, logger *zap.Logger) *EventService {
+	return &EventService{rdb: rdb, logger: logger}
+}
+
+// Publish 发布事件到Redis频道
+func (s *EventService) Publish(event TaskEvent) {
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	data, _ := json.Marshal(event)
+	if err := s.rdb.Publish(context.Background(), utils.TaskEventChannel, data).Err(); err != nil {
+		s.logger.Error("发布任务事件失败", zap.String("type", event.Type), zap.Uint("taskID", event.TaskID), zap.Error(err))
+	}
+}
+
+// Subscribe 订阅事件流，filter为nil时接收全部事件；ctx取消后通道关闭
+func (s *EventService) Subscribe(ctx context.Context, filter func(TaskEvent) bool) <-chan TaskEvent {
+	pubsub := s.rdb.Subscribe(ctx, utils.TaskEventChannel)
+	events := make(chan TaskEvent, 64)
+
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				var event TaskEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					s.logger.Warn("解析任务事件失败", zap.Error(err))
+					continue
+				}
+				if filter != nil && !filter(event) {
+					continue
+				}
+
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return events
+}
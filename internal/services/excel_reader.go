@@ -0,0 +1,741 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// sheetPreviewRows 列出工作表时每个工作表返回的预览行数
+const sheetPreviewRows = 10
+
+// SheetInfo 工作表信息和前几行预览，预览按原始行号排列，便于选择标题行
+type SheetInfo struct {
+	Index     int             `json:"index"` // 从1开始
+	Name      string          `json:"name"`
+	Hidden    bool            `json:"hidden"`
+	Dimension string          `json:"dimension,omitempty"` // 工作表记录的使用范围，如 A1:F120
+	Preview   [][]interface{} `json:"preview"`
+}
+
+// ListSheets 列出Excel文件中的工作表及预览
+func ListSheets(path, filename string) ([]SheetInfo, error) {
+	if ImportFormat(filename) != FormatExcel {
+		return nil, fmt.Errorf("只有Excel文件包含工作表")
+	}
+	book, err := openExcelWorkbook(path)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	sheets := make([]SheetInfo, 0, len(book.sheets))
+	for i, sheet := range book.sheets {
+		info := SheetInfo{Index: i + 1, Name: sheet.name, Hidden: sheet.hidden, Preview: [][]interface{}{}}
+		rows, err := book.openRows(sheet)
+		if err != nil {
+			return nil, err
+		}
+		for len(info.Preview) < sheetPreviewRows {
+			values, ok, err := rows.next()
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			info.Preview = append(info.Preview, trimTrailingEmpty(values))
+		}
+		info.Dimension = rows.scanner.dimension
+		rows.Close()
+		sheets = append(sheets, info)
+	}
+	return sheets, nil
+}
+
+// 数字单元格的格式类别
+type numberKind int
+
+const (
+	numberGeneral numberKind = iota // 常规格式，按数字读取
+	numberDate                      // 日期时间格式，转换为日期字符串
+	numberOther                     // 百分比、货币等格式，按显示文本读取
+)
+
+type excelSheet struct {
+	name   string
+	path   string
+	hidden bool
+}
+
+// excelWorkbook 流式读取工作表：显示文本由 excelize 的行迭代器按数字格式生成，
+// 单元格类型和样式由 sheetScanner 从同一个工作表XML中读取
+type excelWorkbook struct {
+	file     *excelize.File
+	archive  *zip.ReadCloser
+	sheets   []excelSheet
+	date1904 bool
+	formats  []numberKind // 按单元格样式序号
+}
+
+func openExcelWorkbook(filePath string) (*excelWorkbook, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取Excel文件: %w", err)
+	}
+	book := &excelWorkbook{archive: archive}
+	if err := book.readWorkbook(); err != nil {
+		archive.Close()
+		return nil, err
+	}
+	if err := book.readStyles(); err != nil {
+		archive.Close()
+		return nil, err
+	}
+
+	if book.file, err = excelize.OpenFile(filePath); err != nil {
+		archive.Close()
+		return nil, err
+	}
+	return book, nil
+}
+
+func (b *excelWorkbook) Close() error {
+	b.archive.Close()
+	return b.file.Close()
+}
+
+func (b *excelWorkbook) decodePart(name string, v interface{}) (bool, error) {
+	entry, err := b.archive.Open(name)
+	if err != nil {
+		return false, nil
+	}
+	defer entry.Close()
+	if err := xml.NewDecoder(entry).Decode(v); err != nil {
+		return true, fmt.Errorf("无法解析 %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// readWorkbook 读取工作表列表、对应的XML路径和日期系统
+func (b *excelWorkbook) readWorkbook() error {
+	var workbook struct {
+		WorkbookPr struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name  string     `xml:"name,attr"`
+			State string     `xml:"state,attr"`
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if found, err := b.decodePart("xl/workbook.xml", &workbook); err != nil || !found {
+		if err == nil {
+			err = fmt.Errorf("不是有效的Excel文件")
+		}
+		return err
+	}
+	if _, err := b.decodePart("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return err
+	}
+
+	targets := map[string]string{}
+	for _, rel := range rels.Relationships {
+		target := strings.TrimPrefix(rel.Target, "/")
+		if !strings.HasPrefix(rel.Target, "/") {
+			target = path.Join("xl", rel.Target)
+		}
+		targets[rel.ID] = target
+	}
+	for _, sheet := range workbook.Sheets {
+		var target string
+		for _, attr := range sheet.Attrs {
+			// 关系ID的命名空间在标准和严格格式中不同，只按本地名匹配
+			if attr.Name.Local == "id" {
+				target = targets[attr.Value]
+			}
+		}
+		if target == "" {
+			continue
+		}
+		b.sheets = append(b.sheets, excelSheet{name: sheet.Name, path: target, hidden: sheet.State != "" && sheet.State != "visible"})
+	}
+	if len(b.sheets) == 0 {
+		return fmt.Errorf("Excel文件中没有工作表")
+	}
+	b.date1904 = workbook.WorkbookPr.Date1904 == "1" || workbook.WorkbookPr.Date1904 == "true"
+	return nil
+}
+
+// readStyles 读取单元格样式对应的数字格式
+func (b *excelWorkbook) readStyles() error {
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if _, err := b.decodePart("xl/styles.xml", &styles); err != nil {
+		return err
+	}
+
+	custom := map[int]string{}
+	for _, numFmt := range styles.NumFmts {
+		custom[numFmt.ID] = numFmt.Code
+	}
+	b.formats = make([]numberKind, len(styles.CellXfs))
+	for i, xf := range styles.CellXfs {
+		if code, ok := custom[xf.NumFmtID]; ok {
+			b.formats[i] = formatCodeKind(code)
+		} else {
+			b.formats[i] = builtinFormatKind(xf.NumFmtID)
+		}
+	}
+	return nil
+}
+
+// builtinFormatKind 内置数字格式的类别，27-36、50-58 为中日韩地区的日期格式
+func builtinFormatKind(id int) numberKind {
+	switch {
+	case id == 0:
+		return numberGeneral
+	case id >= 14 && id <= 22, id >= 27 && id <= 36, id >= 45 && id <= 47, id >= 50 && id <= 58:
+		return numberDate
+	}
+	return numberOther
+}
+
+// formatCodeKind 判断自定义格式是否为日期时间：去掉引号内文字、转义字符和方括号中的颜色、
+// 区域设置后仍包含年月日时秒占位符。[h]:mm 等经过时间按显示文本读取
+func formatCodeKind(code string) numberKind {
+	lower := strings.ToLower(code)
+	if lower == "general" {
+		return numberGeneral
+	}
+	var plain strings.Builder
+	for i := 0; i < len(lower); i++ {
+		switch lower[i] {
+		case '"':
+			if end := strings.IndexByte(lower[i+1:], '"'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(lower)
+			}
+		case '\\', '_', '*':
+			i++
+		case '[':
+			end := strings.IndexByte(lower[i:], ']')
+			if end < 0 {
+				i = len(lower)
+				break
+			}
+			if strings.Trim(lower[i+1:i+end], "hms") == "" {
+				return numberOther
+			}
+			i += end
+		default:
+			plain.WriteByte(lower[i])
+		}
+	}
+	if strings.ContainsAny(plain.String(), "ydhs") || strings.Contains(plain.String(), "m") && !strings.ContainsAny(plain.String(), "0#?") {
+		return numberDate
+	}
+	return numberOther
+}
+
+func (b *excelWorkbook) formatKind(style int) numberKind {
+	if style >= 0 && style < len(b.formats) {
+		return b.formats[style]
+	}
+	return numberGeneral
+}
+
+// selectSheet 按名称（不区分大小写）或从1开始的序号选择工作表，为空时选择第一个可见的工作表
+func (b *excelWorkbook) selectSheet(name string) (excelSheet, error) {
+	if name == "" {
+		for _, sheet := range b.sheets {
+			if !sheet.hidden {
+				return sheet, nil
+			}
+		}
+		return b.sheets[0], nil
+	}
+	for _, sheet := range b.sheets {
+		if strings.EqualFold(sheet.name, name) {
+			return sheet, nil
+		}
+	}
+	if index, err := strconv.Atoi(name); err == nil && index >= 1 && index <= len(b.sheets) {
+		return b.sheets[index-1], nil
+	}
+	return excelSheet{}, fmt.Errorf("工作表不存在: %s", name)
+}
+
+// cellValue 把单元格转换为带类型的值：布尔值、常规格式的数字、日期字符串，
+// 文本和其他数字格式使用Excel中显示的文本
+func (b *excelWorkbook) cellValue(cell sheetCell, formatted string) interface{} {
+	switch cell.kind {
+	case "b":
+		return cell.value == "1" || strings.EqualFold(cell.value, "true")
+	case "d":
+		if t, err := time.Parse("2006-01-02T15:04:05", strings.TrimSuffix(cell.value, "Z")); err == nil {
+			return t.Format("2006-01-02 15:04:05")
+		}
+		return strings.TrimSpace(formatted)
+	case "", "n":
+	default:
+		return strings.TrimSpace(formatted)
+	}
+
+	number, err := strconv.ParseFloat(cell.value, 64)
+	if err != nil {
+		return strings.TrimSpace(formatted)
+	}
+	switch b.formatKind(cell.style) {
+	case numberGeneral:
+		if number == math.Trunc(number) && math.Abs(number) < 1<<53 {
+			return int64(number)
+		}
+		return number
+	case numberDate:
+		t, err := excelize.ExcelDateToTime(number, b.date1904)
+		if err != nil {
+			return strings.TrimSpace(formatted)
+		}
+		t = t.Round(time.Second)
+		switch {
+		case number == math.Trunc(number):
+			return t.Format("2006-01-02")
+		case number < 1:
+			return t.Format("15:04:05")
+		}
+		return t.Format("2006-01-02 15:04:05")
+	}
+	return strings.TrimSpace(formatted)
+}
+
+// mergeRange 合并单元格区域，行列从1开始
+type mergeRange struct {
+	top, left, bottom, right int
+}
+
+// excelRowReader 逐行读取工作表，返回带类型的值，合并区域内的单元格填充左上角的值
+type excelRowReader struct {
+	book        *excelWorkbook
+	rows        *excelize.Rows
+	scanner     *sheetScanner
+	merges      []mergeRange
+	mergeValues map[int]interface{}
+	row         int
+}
+
+func (b *excelWorkbook) openRows(sheet excelSheet) (*excelRowReader, error) {
+	merges, err := b.mergeRanges(sheet)
+	if err != nil {
+		return nil, err
+	}
+	scanner, err := b.openScanner(sheet)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := b.file.Rows(sheet.name)
+	if err != nil {
+		scanner.Close()
+		return nil, err
+	}
+	return &excelRowReader{
+		book:        b,
+		rows:        rows,
+		scanner:     scanner,
+		merges:      merges,
+		mergeValues: map[int]interface{}{},
+	}, nil
+}
+
+// next 读取下一行，没有数据的行返回空切片；读完后 ok 为false
+func (r *excelRowReader) next() ([]interface{}, bool, error) {
+	if !r.rows.Next() {
+		return nil, false, r.rows.Error()
+	}
+	r.row++
+	formatted, err := r.rows.Columns()
+	if err != nil {
+		return nil, false, err
+	}
+	cells, err := r.scanner.rowCells(r.row)
+	if err != nil {
+		return nil, false, err
+	}
+
+	values := make([]interface{}, len(formatted))
+	for i, text := range formatted {
+		values[i] = strings.TrimSpace(text)
+	}
+	for _, cell := range cells {
+		if cell.col >= 1 && cell.col <= len(values) {
+			values[cell.col-1] = r.book.cellValue(cell, formatted[cell.col-1])
+		}
+	}
+
+	for i, merge := range r.merges {
+		if r.row < merge.top || r.row > merge.bottom {
+			continue
+		}
+		if r.row == merge.top {
+			var value interface{}
+			if merge.left <= len(values) {
+				value = values[merge.left-1]
+			}
+			r.mergeValues[i] = value
+		}
+		for len(values) < merge.right {
+			values = append(values, "")
+		}
+		for col := merge.left; col <= merge.right; col++ {
+			values[col-1] = r.mergeValues[i]
+		}
+		if r.row == merge.bottom {
+			delete(r.mergeValues, i)
+		}
+	}
+	return values, true, nil
+}
+
+func (r *excelRowReader) Close() error {
+	r.rows.Close()
+	return r.scanner.Close()
+}
+
+// mergeRanges 读取工作表的合并单元格。合并信息位于单元格数据之后，需要单独扫描一遍
+func (b *excelWorkbook) mergeRanges(sheet excelSheet) ([]mergeRange, error) {
+	entry, err := b.archive.Open(sheet.path)
+	if err != nil {
+		return nil, fmt.Errorf("工作表数据不存在: %s", sheet.name)
+	}
+	defer entry.Close()
+
+	var merges []mergeRange
+	decoder := xml.NewDecoder(entry)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return merges, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "sheetData":
+			if err := decoder.Skip(); err != nil {
+				return nil, err
+			}
+		case "mergeCell":
+			cells := strings.Split(xmlAttr(start, "ref"), ":")
+			if len(cells) != 2 {
+				continue
+			}
+			left, top, err1 := excelize.CellNameToCoordinates(cells[0])
+			right, bottom, err2 := excelize.CellNameToCoordinates(cells[1])
+			if err1 == nil && err2 == nil {
+				merges = append(merges, mergeRange{top: top, left: left, bottom: bottom, right: right})
+			}
+		}
+	}
+}
+
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// sheetCell 单元格的类型、样式和原始值，col 从1开始
+type sheetCell struct {
+	col   int
+	kind  string
+	style int
+	value string
+}
+
+type sheetRow struct {
+	num   int
+	cells []sheetCell
+}
+
+// sheetScanner 顺序读取工作表XML中每行单元格的类型和原始值
+type sheetScanner struct {
+	entry     io.ReadCloser
+	decoder   *xml.Decoder
+	pending   *sheetRow
+	lastRow   int
+	done      bool
+	dimension string
+}
+
+func (b *excelWorkbook) openScanner(sheet excelSheet) (*sheetScanner, error) {
+	entry, err := b.archive.Open(sheet.path)
+	if err != nil {
+		return nil, fmt.Errorf("工作表数据不存在: %s", sheet.name)
+	}
+	return &sheetScanner{entry: entry, decoder: xml.NewDecoder(entry)}, nil
+}
+
+func (s *sheetScanner) Close() error { return s.entry.Close() }
+
+// rowCells 返回第 num 行的单元格，行号必须递增
+func (s *sheetScanner) rowCells(num int) ([]sheetCell, error) {
+	for {
+		if s.pending == nil {
+			if s.done {
+				return nil, nil
+			}
+			row, err := s.nextRow()
+			if err != nil {
+				return nil, err
+			}
+			if row == nil {
+				s.done = true
+				return nil, nil
+			}
+			s.pending = row
+		}
+		switch {
+		case s.pending.num == num:
+			cells := s.pending.cells
+			s.pending = nil
+			return cells, nil
+		case s.pending.num > num:
+			return nil, nil
+		}
+		s.pending = nil
+	}
+}
+
+func (s *sheetScanner) nextRow() (*sheetRow, error) {
+	for {
+		token, err := s.decoder.Token()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch element.Name.Local {
+			case "dimension":
+				s.dimension = xmlAttr(element, "ref")
+			case "row":
+				return s.readRow(element)
+			}
+		case xml.EndElement:
+			if element.Name.Local == "sheetData" {
+				return nil, nil
+			}
+		}
+	}
+}
+
+func (s *sheetScanner) readRow(start xml.StartElement) (*sheetRow, error) {
+	row := &sheetRow{num: s.lastRow + 1}
+	if num, err := strconv.Atoi(xmlAttr(start, "r")); err == nil && num > 0 {
+		row.num = num
+	}
+	s.lastRow = row.num
+
+	col := 0
+	for {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			if element.Name.Local != "c" {
+				if err := s.decoder.Skip(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			col++
+			if ref := xmlAttr(element, "r"); ref != "" {
+				if c, _, err := excelize.CellNameToCoordinates(ref); err == nil {
+					col = c
+				}
+			}
+			cell := sheetCell{col: col, kind: xmlAttr(element, "t")}
+			cell.style, _ = strconv.Atoi(xmlAttr(element, "s"))
+			if cell.value, err = s.cellValue(); err != nil {
+				return nil, err
+			}
+			row.cells = append(row.cells, cell)
+		case xml.EndElement:
+			if element.Name.Local == "row" {
+				return row, nil
+			}
+		}
+	}
+}
+
+// cellValue 读取单元格的 <v> 原始值并消费到单元格结束
+func (s *sheetScanner) cellValue() (string, error) {
+	var value string
+	for {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return "", err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			if element.Name.Local != "v" {
+				if err := s.decoder.Skip(); err != nil {
+					return "", err
+				}
+				continue
+			}
+			var text string
+			if err := s.decoder.DecodeElement(&text, &element); err != nil {
+				return "", err
+			}
+			value = text
+		case xml.EndElement:
+			if element.Name.Local == "c" {
+				return value, nil
+			}
+		}
+	}
+}
+
+// excelRecordReader 以指定的标题行读取工作表
+type excelRecordReader struct {
+	book    *excelWorkbook
+	rows    *excelRowReader
+	sheet   string
+	headers []string
+}
+
+func newExcelRecordReader(path string, options ReaderOptions) (*excelRecordReader, error) {
+	book, err := openExcelWorkbook(path)
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := book.selectSheet(options.Sheet)
+	if err != nil {
+		book.Close()
+		return nil, err
+	}
+	rows, err := book.openRows(sheet)
+	if err != nil {
+		book.Close()
+		return nil, err
+	}
+	reader := &excelRecordReader{book: book, rows: rows, sheet: sheet.name}
+
+	headerRow := options.headerRow()
+	var values []interface{}
+	for i := 1; i <= headerRow; i++ {
+		var ok bool
+		if values, ok, err = rows.next(); err != nil || !ok {
+			reader.Close()
+			if err == nil {
+				err = fmt.Errorf("工作表 %s 没有第%d行", sheet.name, headerRow)
+			}
+			return nil, err
+		}
+	}
+	if reader.headers = excelHeaders(values); len(reader.headers) == 0 {
+		reader.Close()
+		return nil, fmt.Errorf("第%d行标题为空", headerRow)
+	}
+	return reader, nil
+}
+
+func (r *excelRecordReader) Columns() []string { return r.headers }
+
+func (r *excelRecordReader) Read() (map[string]interface{}, error) {
+	for {
+		values, ok, err := r.rows.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, io.EOF
+		}
+		// 标题之外的尾部空列不读取
+		if len(values) > len(r.headers) {
+			values = values[:len(r.headers)]
+		}
+		if len(trimTrailingEmpty(values)) == 0 {
+			continue
+		}
+
+		record := make(map[string]interface{}, len(r.headers))
+		for i, header := range r.headers {
+			if i < len(values) && values[i] != nil {
+				record[header] = values[i]
+			} else {
+				record[header] = ""
+			}
+		}
+		return record, nil
+	}
+}
+
+func (r *excelRecordReader) BytesRead() int64 { return 0 }
+
+func (r *excelRecordReader) Close() error {
+	r.rows.Close()
+	return r.book.Close()
+}
+
+// excelHeaders 去掉尾部空列，空标题使用列字母，重复的标题加序号
+func excelHeaders(values []interface{}) []string {
+	values = trimTrailingEmpty(values)
+	headers := make([]string, len(values))
+	seen := map[string]int{}
+	for i, value := range values {
+		header := strings.TrimSpace(mappingValue(value))
+		if header == "" {
+			header, _ = excelize.ColumnNumberToName(i + 1)
+		}
+		seen[header]++
+		if seen[header] > 1 {
+			header = fmt.Sprintf("%s_%d", header, seen[header])
+		}
+		headers[i] = header
+	}
+	return headers
+}
+
+func trimTrailingEmpty(values []interface{}) []interface{} {
+	end := len(values)
+	for end > 0 && (values[end-1] == nil || values[end-1] == "") {
+		end--
+	}
+	return values[:end]
+}
@@ -0,0 +1,621 @@
+package services
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// GmailClipSize Gmail 截断邮件正文的大小，超过后只显示前面部分和“查看完整邮件”链接
+const GmailClipSize = 102 * 1024
+
+// 发送前删除的元素，邮件客户端不会执行但可能被当作恶意内容
+var unsafeElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Iframe: true, atom.Frame: true, atom.Frameset: true,
+	atom.Object: true, atom.Embed: true, atom.Applet: true, atom.Base: true,
+	atom.Form: true, atom.Link: true,
+}
+
+// <meta http-equiv> 只保留这些值，refresh 可以跳转到任意地址，set-cookie 可以写入Cookie
+var safeHTTPEquiv = map[string]bool{"content-type": true, "x-ua-compatible": true}
+
+// data: 地址只允许这些图片类型，SVG 和 text/html 可以携带脚本
+var safeDataImages = []string{"data:image/png", "data:image/jpeg", "data:image/jpg", "data:image/gif", "data:image/webp"}
+
+// CSS中可以执行脚本或加载行为的写法
+var unsafeCSSPatterns = []string{"expression(", "javascript:", "vbscript:", "-moz-binding", "behavior:", "@import"}
+
+// 可以省略结束标签的元素，缺少结束标签时不报告
+var optionalEndTags = map[atom.Atom]bool{
+	atom.Html: true, atom.Head: true, atom.Body: true, atom.P: true, atom.Li: true,
+	atom.Dt: true, atom.Dd: true, atom.Tr: true, atom.Td: true, atom.Th: true,
+	atom.Thead: true, atom.Tbody: true, atom.Tfoot: true, atom.Option: true, atom.Colgroup: true,
+}
+
+// 带链接的属性，javascript: 等地址会被删除
+var urlAttributes = map[string]bool{"href": true, "src": true, "action": true, "formaction": true, "background": true}
+
+var cssComments = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// HTMLReport 渲染后HTML处理的结果
+type HTMLReport struct {
+	InlinedRules      int      `json:"inlined_rules"`      // 内联到 style 属性的CSS规则数
+	KeptRules         int      `json:"kept_rules"`         // 无法内联的规则（伪类、@media 等），保留在 <style> 中
+	RemovedElements   []string `json:"removed_elements"`   // 删除的 script、iframe 等元素
+	RemovedAttributes []string `json:"removed_attributes"` // 删除的事件处理属性和 javascript: 链接
+	UnclosedTags      []string `json:"unclosed_tags"`      // 缺少结束标签的元素，输出中已补全
+	StrayEndTags      []string `json:"stray_end_tags"`     // 没有对应开始标签的结束标签，输出中已忽略
+	Size              int      `json:"size"`               // 处理后的字节数
+	Clipped           bool     `json:"clipped"`            // 超过 Gmail 的102KB截断上限
+}
+
+// ProcessHTML 处理替换变量后的邮件内容：删除脚本和事件处理属性、把 <style> 中的CSS内联到
+// style 属性、补全未闭合的标签，并检查大小是否超过 Gmail 的截断上限。内容不是HTML时原样返回
+func ProcessHTML(content string) (string, *HTMLReport) {
+	report := &HTMLReport{RemovedElements: []string{}, RemovedAttributes: []string{}, UnclosedTags: []string{}, StrayEndTags: []string{}}
+	if !strings.Contains(content, "<") {
+		report.Size = len(content)
+		report.Clipped = report.Size > GmailClipSize
+		return content, report
+	}
+
+	report.UnclosedTags, report.StrayEndTags = checkTags(content)
+
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		report.Size = len(content)
+		report.Clipped = report.Size > GmailClipSize
+		return content, report
+	}
+	sanitizeNode(doc, report)
+	inlineCSS(doc, report)
+	// 内联后再检查样式，<style> 中的规则也会经过检查
+	sanitizeStyles(doc, report)
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		report.Size = len(content)
+		report.Clipped = report.Size > GmailClipSize
+		return content, report
+	}
+	report.Size = buf.Len()
+	report.Clipped = report.Size > GmailClipSize
+	return buf.String(), report
+}
+
+// checkTags 按开始和结束标签配对，找出未闭合的元素和多余的结束标签
+func checkTags(content string) ([]string, []string) {
+	unclosed, stray := []string{}, []string{}
+	var stack []string
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			for _, tag := range stack {
+				if !optionalEndTags[atom.Lookup([]byte(tag))] {
+					unclosed = append(unclosed, tag)
+				}
+			}
+			return unclosed, stray
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			if !isVoidElement(string(name)) {
+				stack = append(stack, string(name))
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			i := len(stack) - 1
+			for i >= 0 && stack[i] != tag {
+				i--
+			}
+			if i < 0 {
+				stray = append(stray, tag)
+				continue
+			}
+			// 中间未闭合的元素由结束标签隐式关闭
+			for _, open := range stack[i+1:] {
+				if !optionalEndTags[atom.Lookup([]byte(open))] {
+					unclosed = append(unclosed, open)
+				}
+			}
+			stack = stack[:i]
+		}
+	}
+}
+
+func isVoidElement(tag string) bool {
+	switch tag {
+	case "area", "base", "br", "col", "embed", "hr", "img", "input", "link", "meta", "param", "source", "track", "wbr":
+		return true
+	}
+	return false
+}
+
+// sanitizeNode 删除不安全的元素、on* 事件处理属性和脚本链接（包括 data:text/html 等地址）
+func sanitizeNode(n *html.Node, report *HTMLReport) {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if child.Type == html.ElementNode && (unsafeElements[child.DataAtom] || isUnsafeMeta(child)) {
+			report.RemovedElements = append(report.RemovedElements, child.Data)
+			n.RemoveChild(child)
+		} else {
+			sanitizeNode(child, report)
+		}
+		child = next
+	}
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		if strings.HasPrefix(key, "on") || (urlAttributes[key] && isScriptURL(attr.Val)) {
+			report.RemovedAttributes = append(report.RemovedAttributes, n.Data+"."+key)
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	n.Attr = attrs
+}
+
+func isScriptURL(value string) bool {
+	value = strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, value))
+	if strings.HasPrefix(value, "javascript:") || strings.HasPrefix(value, "vbscript:") {
+		return true
+	}
+	if !strings.HasPrefix(value, "data:") {
+		return false
+	}
+	for _, prefix := range safeDataImages {
+		if strings.HasPrefix(value, prefix+";") || strings.HasPrefix(value, prefix+",") {
+			return false
+		}
+	}
+	return true
+}
+
+func isUnsafeMeta(n *html.Node) bool {
+	if n.DataAtom != atom.Meta {
+		return false
+	}
+	equiv, ok := attribute(n, "http-equiv")
+	return ok && !safeHTTPEquiv[strings.ToLower(strings.TrimSpace(equiv))]
+}
+
+// sanitizeStyles 删除包含 expression()、javascript: 等写法的 style 属性和 <style> 元素
+func sanitizeStyles(n *html.Node, report *HTMLReport) {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if child.Type == html.ElementNode && child.DataAtom == atom.Style && isUnsafeCSS(nodeText(child)) {
+			report.RemovedElements = append(report.RemovedElements, child.Data)
+			n.RemoveChild(child)
+		} else {
+			sanitizeStyles(child, report)
+		}
+		child = next
+	}
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, "style") && isUnsafeCSS(attr.Val) {
+			report.RemovedAttributes = append(report.RemovedAttributes, n.Data+".style")
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	n.Attr = attrs
+}
+
+// isUnsafeCSS 去掉注释和空白后检查危险写法；CSS转义可以拼出任意关键字，出现反斜杠时同样视为不安全
+func isUnsafeCSS(css string) bool {
+	css = strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, cssComments.ReplaceAllString(css, "")))
+	if strings.Contains(css, "\\") {
+		return true
+	}
+	for _, pattern := range unsafeCSSPatterns {
+		if strings.Contains(css, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// cssRule 一条可以内联的规则
+type cssRule struct {
+	selector    cssSelector
+	specificity [3]int
+	order       int
+	decls       []cssDecl
+}
+
+type cssDecl struct {
+	property  string
+	value     string
+	important bool
+}
+
+// cssSelector 由组合符连接的简单选择器，从左到右保存
+type cssSelector struct {
+	parts       []cssCompound
+	combinators []byte // parts[i] 与 parts[i+1] 之间的组合符：' ' 后代，'>' 子元素
+}
+
+type cssCompound struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+// inlineCSS 把 <style> 中支持的规则写入匹配元素的 style 属性，已有的 style 属性优先，
+// !important 的规则除外；伪类、属性选择器和 @ 规则保留在第一个 <style> 中
+func inlineCSS(doc *html.Node, report *HTMLReport) {
+	var styles []*html.Node
+	var find func(*html.Node)
+	find = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Style {
+			styles = append(styles, n)
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			find(child)
+		}
+	}
+	find(doc)
+	if len(styles) == 0 {
+		return
+	}
+
+	var rules []cssRule
+	var kept []string
+	for _, style := range styles {
+		css := ""
+		for child := style.FirstChild; child != nil; child = child.NextSibling {
+			css += child.Data
+		}
+		parsed, rest := parseCSS(css, len(rules))
+		rules = append(rules, parsed...)
+		kept = append(kept, rest...)
+	}
+	report.KeptRules = len(kept)
+
+	// 按特异性和出现顺序排序，后应用的覆盖先应用的
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := rules[i].specificity, rules[j].specificity
+		if a != b {
+			return a[0] < b[0] || a[0] == b[0] && (a[1] < b[1] || a[1] == b[1] && a[2] < b[2])
+		}
+		return rules[i].order < rules[j].order
+	})
+	inlined := map[int]bool{}
+	applyRules(doc, rules, inlined)
+	report.InlinedRules = len(inlined)
+
+	for i, style := range styles {
+		if i == 0 && len(kept) > 0 {
+			for style.FirstChild != nil {
+				style.RemoveChild(style.FirstChild)
+			}
+			style.AppendChild(&html.Node{Type: html.TextNode, Data: strings.Join(kept, "\n")})
+			continue
+		}
+		style.Parent.RemoveChild(style)
+	}
+}
+
+func applyRules(n *html.Node, rules []cssRule, inlined map[int]bool) {
+	if n.Type == html.ElementNode && n.DataAtom != atom.Style {
+		var matched []cssDecl
+		for _, rule := range rules {
+			if rule.selector.matches(n) {
+				matched = append(matched, rule.decls...)
+				inlined[rule.order] = true
+			}
+		}
+		if len(matched) > 0 {
+			mergeStyle(n, matched)
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		applyRules(child, rules, inlined)
+	}
+}
+
+// mergeStyle 合并规则和元素原有的 style 属性
+func mergeStyle(n *html.Node, matched []cssDecl) {
+	index := -1
+	for i, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, "style") {
+			index = i
+		}
+	}
+
+	var order []string
+	values := map[string]cssDecl{}
+	set := func(decl cssDecl, inline bool) {
+		current, exists := values[decl.property]
+		if exists && current.important && !decl.important {
+			return
+		}
+		if inline && exists && current.important {
+			return
+		}
+		if !exists {
+			order = append(order, decl.property)
+		}
+		values[decl.property] = decl
+	}
+	for _, decl := range matched {
+		set(decl, false)
+	}
+	if index >= 0 {
+		for _, decl := range parseDecls(n.Attr[index].Val) {
+			set(decl, true)
+		}
+	}
+
+	parts := make([]string, 0, len(order))
+	for _, property := range order {
+		decl := values[property]
+		value := decl.value
+		if decl.important {
+			value += " !important"
+		}
+		parts = append(parts, property+": "+value)
+	}
+	style := strings.Join(parts, "; ")
+	if index >= 0 {
+		n.Attr[index].Val = style
+	} else {
+		n.Attr = append(n.Attr, html.Attribute{Key: "style", Val: style})
+	}
+}
+
+// parseCSS 解析样式表，返回可以内联的规则和需要保留的规则原文
+func parseCSS(css string, order int) ([]cssRule, []string) {
+	css = cssComments.ReplaceAllString(css, "")
+	var rules []cssRule
+	var kept []string
+	for len(strings.TrimSpace(css)) > 0 {
+		css = strings.TrimSpace(css)
+		open := strings.Index(css, "{")
+		if open < 0 {
+			break
+		}
+		// @ 规则可能包含嵌套的块，整体保留
+		end := matchingBrace(css, open)
+		prelude := strings.TrimSpace(css[:open])
+		body := css[open+1 : end]
+		block := css[:min(end+1, len(css))]
+		css = css[min(end+1, len(css)):]
+
+		if strings.HasPrefix(prelude, "@") {
+			kept = append(kept, block)
+			continue
+		}
+
+		decls := parseDecls(body)
+		var keptSelectors []string
+		for _, text := range strings.Split(prelude, ",") {
+			text = strings.TrimSpace(text)
+			selector, ok := parseSelector(text)
+			if !ok {
+				keptSelectors = append(keptSelectors, text)
+				continue
+			}
+			rules = append(rules, cssRule{selector: selector, specificity: selector.specificity(), order: order, decls: decls})
+			order++
+		}
+		if len(keptSelectors) > 0 {
+			kept = append(kept, strings.Join(keptSelectors, ", ")+" {"+body+"}")
+		}
+	}
+	return rules, kept
+}
+
+// matchingBrace 返回与 open 位置的 { 对应的 } 位置，没有时返回内容末尾
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(css)
+}
+
+// parseDecls 解析声明列表，分号出现在括号或引号中时不拆分，如 url(data:...;base64,...)
+func parseDecls(text string) []cssDecl {
+	var decls []cssDecl
+	var parts []string
+	depth, quote, start := 0, byte(0), 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ';' && depth <= 0:
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, text[start:])
+
+	for _, part := range parts {
+		colon := strings.Index(part, ":")
+		if colon < 0 {
+			continue
+		}
+		property := strings.ToLower(strings.TrimSpace(part[:colon]))
+		value := strings.TrimSpace(part[colon+1:])
+		important := false
+		if lower := strings.ToLower(value); strings.HasSuffix(lower, "!important") {
+			important = true
+			value = strings.TrimSpace(value[:len(value)-len("!important")])
+		}
+		if property == "" || value == "" {
+			continue
+		}
+		decls = append(decls, cssDecl{property: property, value: value, important: important})
+	}
+	return decls
+}
+
+// parseSelector 解析由类型、类、ID选择器和后代、子元素组合符组成的选择器，其他选择器无法内联
+func parseSelector(text string) (cssSelector, bool) {
+	var selector cssSelector
+	if text == "" || strings.ContainsAny(text, ":[+~") {
+		return selector, false
+	}
+	text = strings.ReplaceAll(text, ">", " > ")
+	combinator := byte(' ')
+	for _, token := range strings.Fields(text) {
+		if token == ">" {
+			if len(selector.parts) == 0 {
+				return selector, false
+			}
+			combinator = '>'
+			continue
+		}
+		compound, ok := parseCompound(token)
+		if !ok {
+			return selector, false
+		}
+		if len(selector.parts) > 0 {
+			selector.combinators = append(selector.combinators, combinator)
+		}
+		selector.parts = append(selector.parts, compound)
+		combinator = ' '
+	}
+	return selector, len(selector.parts) > 0 && len(selector.combinators) == len(selector.parts)-1
+}
+
+func parseCompound(token string) (cssCompound, bool) {
+	var compound cssCompound
+	i := strings.IndexAny(token, ".#")
+	if i < 0 {
+		i = len(token)
+	}
+	compound.tag = strings.ToLower(token[:i])
+	rest := token[i:]
+	for rest != "" {
+		kind := rest[0]
+		rest = rest[1:]
+		j := strings.IndexAny(rest, ".#")
+		if j < 0 {
+			j = len(rest)
+		}
+		name := rest[:j]
+		rest = rest[j:]
+		if name == "" {
+			return compound, false
+		}
+		if kind == '#' {
+			compound.id = name
+		} else {
+			compound.classes = append(compound.classes, name)
+		}
+	}
+	return compound, true
+}
+
+func (s cssSelector) specificity() [3]int {
+	var spec [3]int
+	for _, part := range s.parts {
+		if part.id != "" {
+			spec[0]++
+		}
+		spec[1] += len(part.classes)
+		if part.tag != "" && part.tag != "*" {
+			spec[2]++
+		}
+	}
+	return spec
+}
+
+func (s cssSelector) matches(n *html.Node) bool {
+	return s.matchFrom(n, len(s.parts)-1)
+}
+
+// matchFrom 从右向左匹配，parts[i] 匹配 n 后按组合符查找祖先
+func (s cssSelector) matchFrom(n *html.Node, i int) bool {
+	if !s.parts[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	for parent := n.Parent; parent != nil && parent.Type == html.ElementNode; parent = parent.Parent {
+		if s.matchFrom(parent, i-1) {
+			return true
+		}
+		if s.combinators[i-1] == '>' {
+			return false
+		}
+	}
+	return false
+}
+
+func (c cssCompound) matches(n *html.Node) bool {
+	if c.tag != "" && c.tag != "*" && c.tag != n.Data {
+		return false
+	}
+	var id, class string
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "id":
+			id = attr.Val
+		case "class":
+			class = attr.Val
+		}
+	}
+	if c.id != "" && c.id != id {
+		return false
+	}
+	classes := strings.Fields(class)
+	for _, want := range c.classes {
+		found := false
+		for _, have := range classes {
+			if have == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProcessHTMLSanitize(t *testing.T) {
+	tests := []struct {
+		name              string
+		content           string
+		removedElements   []string
+		removedAttributes []string
+		absent            []string
+		present           []string
+	}{
+		{
+			name:            "script",
+			content:         `<p>hi</p><script>alert(1)</script>`,
+			removedElements: []string{"script"},
+			absent:          []string{"alert"},
+			present:         []string{"<p>hi</p>"},
+		},
+		{
+			name:            "meta refresh",
+			content:         `<html><head><meta http-equiv="refresh" content="0;url=https://evil.example"><meta charset="utf-8"></head><body>hi</body></html>`,
+			removedElements: []string{"meta"},
+			absent:          []string{"refresh", "evil.example"},
+			present:         []string{`<meta charset="utf-8"/>`},
+		},
+		{
+			name:    "meta content type",
+			content: `<html><head><meta http-equiv="Content-Type" content="text/html; charset=utf-8"></head><body>hi</body></html>`,
+			present: []string{`http-equiv="Content-Type"`},
+		},
+		{
+			name:            "form",
+			content:         `<form action="https://evil.example/login"><input name="password"></form><p>after</p>`,
+			removedElements: []string{"form"},
+			absent:          []string{"evil.example", "password"},
+			present:         []string{"<p>after</p>"},
+		},
+		{
+			name:            "link",
+			content:         `<html><head><link rel="stylesheet" href="https://evil.example/a.css"></head><body>hi</body></html>`,
+			removedElements: []string{"link"},
+			absent:          []string{"evil.example"},
+		},
+		{
+			name:              "event handler",
+			content:           `<img src="a.png" onerror="alert(1)">`,
+			removedAttributes: []string{"img.onerror"},
+			absent:            []string{"alert"},
+			present:           []string{`src="a.png"`},
+		},
+		{
+			name:              "javascript href",
+			content:           `<a href=" JavaScript:alert(1)">x</a>`,
+			removedAttributes: []string{"a.href"},
+			absent:            []string{"alert"},
+		},
+		{
+			name:              "data text/html href",
+			content:           `<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`,
+			removedAttributes: []string{"a.href"},
+			absent:            []string{"data:text/html"},
+		},
+		{
+			name:              "data svg src",
+			content:           `<img src="data:image/svg+xml;base64,PHN2Zz48L3N2Zz4=">`,
+			removedAttributes: []string{"img.src"},
+			absent:            []string{"data:image/svg"},
+		},
+		{
+			name:    "data png src",
+			content: `<img src="data:image/png;base64,iVBORw0KGgo=">`,
+			present: []string{`src="data:image/png;base64,iVBORw0KGgo="`},
+		},
+		{
+			name:              "style expression",
+			content:           `<div style="width: expression(alert(1))">x</div>`,
+			removedAttributes: []string{"div.style"},
+			absent:            []string{"expression"},
+		},
+		{
+			name:              "style url javascript",
+			content:           `<div style="background: url( 'javascript:alert(1)' )">x</div>`,
+			removedAttributes: []string{"div.style"},
+			absent:            []string{"javascript"},
+		},
+		{
+			name:              "style with css escape",
+			content:           `<div style="width: \65 xpression(alert(1))">x</div>`,
+			removedAttributes: []string{"div.style"},
+			absent:            []string{"alert"},
+		},
+		{
+			name:              "inlined rule with expression",
+			content:           `<style>p { width: expression(alert(1)); }</style><p>x</p>`,
+			removedAttributes: []string{"p.style"},
+			absent:            []string{"expression"},
+		},
+		{
+			name:            "kept rule with javascript url",
+			content:         `<style>a:hover { background: url(javascript:alert(1)); }</style><a href="https://example.com">x</a>`,
+			removedElements: []string{"style"},
+			absent:          []string{"javascript"},
+		},
+		{
+			name:    "safe style",
+			content: `<div style="color: red; background: url(https://example.com/bg.png)">x</div>`,
+			present: []string{`style="color: red; background: url(https://example.com/bg.png)"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, report := ProcessHTML(tt.content)
+			if !equalStrings(report.RemovedElements, tt.removedElements) {
+				t.Errorf("RemovedElements = %v, want %v", report.RemovedElements, tt.removedElements)
+			}
+			if !equalStrings(report.RemovedAttributes, tt.removedAttributes) {
+				t.Errorf("RemovedAttributes = %v, want %v", report.RemovedAttributes, tt.removedAttributes)
+			}
+			for _, text := range tt.absent {
+				if strings.Contains(strings.ToLower(output), strings.ToLower(text)) {
+					t.Errorf("output contains %q: %s", text, output)
+				}
+			}
+			for _, text := range tt.present {
+				if !strings.Contains(output, text) {
+					t.Errorf("output missing %q: %s", text, output)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessHTMLInlineCSS(t *testing.T) {
+	content := `<style>p { color: red; } .note { color: blue !important; } a:hover { color: green; }</style>` +
+		`<p class="note" style="color: black">x</p><p>y</p>`
+	output, report := ProcessHTML(content)
+
+	if report.InlinedRules != 2 || report.KeptRules != 1 {
+		t.Errorf("InlinedRules = %d, KeptRules = %d, want 2, 1", report.InlinedRules, report.KeptRules)
+	}
+	if !strings.Contains(output, `<p class="note" style="color: blue !important">x</p>`) {
+		t.Errorf("!important rule should override inline style: %s", output)
+	}
+	if !strings.Contains(output, `<p style="color: red">y</p>`) {
+		t.Errorf("rule not inlined: %s", output)
+	}
+	if !strings.Contains(output, "a:hover") {
+		t.Errorf("pseudo-class rule should stay in <style>: %s", output)
+	}
+}
+
+func TestProcessHTMLTags(t *testing.T) {
+	_, report := ProcessHTML(`<div><p>text<span>inner</div></em>`)
+	if !equalStrings(report.UnclosedTags, []string{"span"}) {
+		t.Errorf("UnclosedTags = %v, want [span]", report.UnclosedTags)
+	}
+	if !equalStrings(report.StrayEndTags, []string{"em"}) {
+		t.Errorf("StrayEndTags = %v, want [em]", report.StrayEndTags)
+	}
+}
+
+func TestProcessHTMLPlainText(t *testing.T) {
+	content := "hello " + strings.Repeat("a", GmailClipSize)
+	output, report := ProcessHTML(content)
+	if output != content {
+		t.Error("plain text should be returned unchanged")
+	}
+	if !report.Clipped {
+		t.Error("content over the Gmail limit should be reported as clipped")
+	}
+}
+
+func equalStrings(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
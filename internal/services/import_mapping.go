@@ -0,0 +1,300 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go_market_email/internal/models"
+	"gorm.io/gorm"
+)
+
+// 列转换
+const (
+	TransformTrim           = "trim"
+	TransformLower          = "lower"
+	TransformUpper          = "upper"
+	TransformCollapseSpaces = "collapse_spaces"
+)
+
+// RecipientField 收件人邮箱字段，映射结果必须包含该字段
+const RecipientField = "email"
+
+var columnTransforms = map[string]func(string) string{
+	TransformTrim:           strings.TrimSpace,
+	TransformLower:          strings.ToLower,
+	TransformUpper:          strings.ToUpper,
+	TransformCollapseSpaces: func(value string) string { return strings.Join(strings.Fields(value), " ") },
+}
+
+// SplitRule 按分隔符拆分源值并取其中一段，Index 为负数时从末尾计数
+type SplitRule struct {
+	Separator string `json:"separator"`
+	Index     int    `json:"index"`
+}
+
+// ColumnMapping 一个目标列的映射规则。
+// 处理顺序：取源列（多个源列按 Separator 拼接）、拆分、依次执行转换、结果为空时使用默认值
+type ColumnMapping struct {
+	Target     string     `json:"target"`
+	Source     string     `json:"source,omitempty"`
+	Sources    []string   `json:"sources,omitempty"`   // 拼接多个源列
+	Separator  string     `json:"separator,omitempty"` // 拼接时的分隔符
+	Split      *SplitRule `json:"split,omitempty"`
+	Transforms []string   `json:"transforms,omitempty"`
+	Default    string     `json:"default,omitempty"`
+}
+
+// ImportMappingRules 导入列映射规则，目标列作为模板变量，email 列为收件人
+type ImportMappingRules struct {
+	Columns      []ColumnMapping `json:"columns"`
+	KeepUnmapped bool            `json:"keep_unmapped"` // 保留未被引用的源列
+}
+
+// sources 返回映射引用的源列
+func (m ColumnMapping) sources() []string {
+	if len(m.Sources) > 0 {
+		return m.Sources
+	}
+	if m.Source != "" {
+		return []string{m.Source}
+	}
+	return nil
+}
+
+// Validate 校验规则结构，不检查源列是否存在
+func (r *ImportMappingRules) Validate() error {
+	if len(r.Columns) == 0 {
+		return fmt.Errorf("映射规则至少需要一列")
+	}
+
+	targets := map[string]bool{}
+	for _, column := range r.Columns {
+		target := strings.TrimSpace(column.Target)
+		if target == "" {
+			return fmt.Errorf("目标列不能为空")
+		}
+		if targets[target] {
+			return fmt.Errorf("目标列重复: %s", target)
+		}
+		targets[target] = true
+
+		if len(column.sources()) == 0 && column.Default == "" {
+			return fmt.Errorf("目标列 %s 需要指定源列或默认值", target)
+		}
+		if column.Split != nil && column.Split.Separator == "" {
+			return fmt.Errorf("目标列 %s 的拆分分隔符不能为空", target)
+		}
+		for _, name := range column.Transforms {
+			if _, ok := columnTransforms[name]; !ok {
+				return fmt.Errorf("目标列 %s 使用了不支持的转换: %s", target, name)
+			}
+		}
+	}
+
+	if !targets[RecipientField] && !r.KeepUnmapped {
+		return fmt.Errorf("映射规则必须包含收件人列 %s", RecipientField)
+	}
+	return nil
+}
+
+// Check 校验规则并确认引用的源列都在文件标题中
+func (r *ImportMappingRules) Check(headers []string) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	for _, column := range r.Columns {
+		for _, source := range column.sources() {
+			if !containsString(headers, source) {
+				return fmt.Errorf("文件中没有列: %s", source)
+			}
+		}
+	}
+	if !containsString(r.OutputColumns(headers), RecipientField) {
+		return fmt.Errorf("映射结果中没有收件人列 %s", RecipientField)
+	}
+	return nil
+}
+
+// OutputColumns 返回映射后的列，目标列在前，保留的源列在后
+func (r *ImportMappingRules) OutputColumns(headers []string) []string {
+	columns := make([]string, 0, len(r.Columns))
+	for _, column := range r.Columns {
+		columns = append(columns, strings.TrimSpace(column.Target))
+	}
+	if r.KeepUnmapped {
+		used := r.usedSources()
+		for _, header := range headers {
+			if !used[header] && !containsString(columns, header) {
+				columns = append(columns, header)
+			}
+		}
+	}
+	return columns
+}
+
+func (r *ImportMappingRules) usedSources() map[string]bool {
+	used := map[string]bool{}
+	for _, column := range r.Columns {
+		for _, source := range column.sources() {
+			used[source] = true
+		}
+	}
+	return used
+}
+
+// Apply 按规则转换一条记录
+func (r *ImportMappingRules) Apply(record map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(r.Columns))
+	if r.KeepUnmapped {
+		used := r.usedSources()
+		for key, value := range record {
+			if !used[key] {
+				result[key] = value
+			}
+		}
+	}
+
+	for _, column := range r.Columns {
+		result[strings.TrimSpace(column.Target)] = column.apply(record)
+	}
+	return result
+}
+
+func (m ColumnMapping) apply(record map[string]interface{}) string {
+	sources := m.sources()
+	parts := make([]string, 0, len(sources))
+	for _, source := range sources {
+		value := mappingValue(record[source])
+		// 拼接时跳过空值，避免出现多余的分隔符
+		if value != "" || len(sources) == 1 {
+			parts = append(parts, value)
+		}
+	}
+	value := strings.Join(parts, m.Separator)
+
+	if m.Split != nil {
+		pieces := strings.Split(value, m.Split.Separator)
+		index := m.Split.Index
+		if index < 0 {
+			index += len(pieces)
+		}
+		if index >= 0 && index < len(pieces) {
+			value = pieces[index]
+		} else {
+			value = ""
+		}
+	}
+
+	for _, name := range m.Transforms {
+		value = columnTransforms[name](value)
+	}
+
+	if strings.TrimSpace(value) == "" {
+		return m.Default
+	}
+	return value
+}
+
+func mappingValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// mappedRecordReader 读取时按映射规则转换记录
+type mappedRecordReader struct {
+	RecordReader
+	rules   *ImportMappingRules
+	columns []string
+}
+
+func newMappedRecordReader(reader RecordReader, rules *ImportMappingRules) (*mappedRecordReader, error) {
+	if err := rules.Check(reader.Columns()); err != nil {
+		return nil, err
+	}
+	return &mappedRecordReader{
+		RecordReader: reader,
+		rules:        rules,
+		columns:      rules.OutputColumns(reader.Columns()),
+	}, nil
+}
+
+func (r *mappedRecordReader) Columns() []string { return r.columns }
+
+func (r *mappedRecordReader) Read() (map[string]interface{}, error) {
+	record, err := r.RecordReader.Read()
+	if err != nil {
+		return nil, err
+	}
+	return r.rules.Apply(record), nil
+}
+
+// ImportMappingService 管理项目内保存的列映射预设
+type ImportMappingService struct {
+	db *gorm.DB
+}
+
+func NewImportMappingService(db *gorm.DB) *ImportMappingService {
+	return &ImportMappingService{db: db}
+}
+
+// CreateMapping 保存映射预设
+func (s *ImportMappingService) CreateMapping(mapping *models.ImportMapping, rules *ImportMappingRules) error {
+	if err := setMappingRules(mapping, rules); err != nil {
+		return err
+	}
+	return s.db.Create(mapping).Error
+}
+
+// UpdateMapping 更新映射预设
+func (s *ImportMappingService) UpdateMapping(mapping *models.ImportMapping, rules *ImportMappingRules) error {
+	if err := setMappingRules(mapping, rules); err != nil {
+		return err
+	}
+	return s.db.Save(mapping).Error
+}
+
+func setMappingRules(mapping *models.ImportMapping, rules *ImportMappingRules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	mapping.Rules = string(data)
+	return nil
+}
+
+// ListMappings 获取项目中的映射预设
+func (s *ImportMappingService) ListMappings(projectID, userID uint) ([]models.ImportMapping, error) {
+	var mappings []models.ImportMapping
+	err := s.db.Scopes(InProject(projectID, userID)).Order("updated_at DESC").Find(&mappings).Error
+	return mappings, err
+}
+
+// GetMapping 获取映射预设
+func (s *ImportMappingService) GetMapping(id uint) (*models.ImportMapping, error) {
+	var mapping models.ImportMapping
+	err := s.db.First(&mapping, id).Error
+	return &mapping, err
+}
+
+// DeleteMapping 删除映射预设
+func (s *ImportMappingService) DeleteMapping(id uint) error {
+	return s.db.Delete(&models.ImportMapping{}, id).Error
+}
+
+// MappingRules 解析预设中保存的规则
+func MappingRules(mapping *models.ImportMapping) (*ImportMappingRules, error) {
+	var rules ImportMappingRules
+	if err := json.Unmarshal([]byte(mapping.Rules), &rules); err != nil {
+		return nil, fmt.Errorf("映射规则格式错误: %w", err)
+	}
+	return &rules, nil
+}
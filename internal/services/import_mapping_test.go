@@ -0,0 +1,162 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestColumnMappingApply(t *testing.T) {
+	record := map[string]interface{}{
+		"first":    "  Alice ",
+		"last":     "Smith",
+		"middle":   "",
+		"full":     "Bob   van  Dyke",
+		"email":    " Alice@Example.COM ",
+		"tags":     "vip;beijing;2024",
+		"age":      int64(30),
+		"score":    1.5,
+		"nickname": nil,
+	}
+
+	tests := []struct {
+		name    string
+		mapping ColumnMapping
+		want    string
+	}{
+		{name: "source", mapping: ColumnMapping{Source: "last"}, want: "Smith"},
+		{name: "transforms in order", mapping: ColumnMapping{Source: "email", Transforms: []string{TransformTrim, TransformLower}}, want: "alice@example.com"},
+		{name: "upper", mapping: ColumnMapping{Source: "last", Transforms: []string{TransformUpper}}, want: "SMITH"},
+		{name: "collapse spaces", mapping: ColumnMapping{Source: "full", Transforms: []string{TransformCollapseSpaces}}, want: "Bob van Dyke"},
+		{name: "concat", mapping: ColumnMapping{Sources: []string{"last", "first"}, Separator: ", ", Transforms: []string{TransformTrim}}, want: "Smith,   Alice"},
+		{name: "concat skips empty", mapping: ColumnMapping{Sources: []string{"first", "middle", "last"}, Separator: "|"}, want: "  Alice |Smith"},
+		{name: "split", mapping: ColumnMapping{Source: "tags", Split: &SplitRule{Separator: ";", Index: 1}}, want: "beijing"},
+		{name: "split from end", mapping: ColumnMapping{Source: "tags", Split: &SplitRule{Separator: ";", Index: -1}}, want: "2024"},
+		{name: "split out of range uses default", mapping: ColumnMapping{Source: "tags", Split: &SplitRule{Separator: ";", Index: 5}, Default: "none"}, want: "none"},
+		{name: "split then transform", mapping: ColumnMapping{Source: "full", Split: &SplitRule{Separator: " ", Index: 0}, Transforms: []string{TransformLower}}, want: "bob"},
+		{name: "number", mapping: ColumnMapping{Source: "age"}, want: "30"},
+		{name: "float", mapping: ColumnMapping{Source: "score"}, want: "1.5"},
+		{name: "nil uses default", mapping: ColumnMapping{Source: "nickname", Default: "friend"}, want: "friend"},
+		{name: "blank uses default", mapping: ColumnMapping{Source: "middle", Default: "-"}, want: "-"},
+		{name: "missing source uses default", mapping: ColumnMapping{Source: "unknown", Default: "x"}, want: "x"},
+		{name: "default only", mapping: ColumnMapping{Default: "constant"}, want: "constant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mapping.apply(record); got != tt.want {
+				t.Errorf("apply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportMappingRulesValidate(t *testing.T) {
+	email := ColumnMapping{Target: "email", Source: "Email"}
+	tests := []struct {
+		name  string
+		rules ImportMappingRules
+		ok    bool
+	}{
+		{name: "valid", rules: ImportMappingRules{Columns: []ColumnMapping{email, {Target: "name", Sources: []string{"First", "Last"}, Separator: " "}}}, ok: true},
+		{name: "no columns", rules: ImportMappingRules{}},
+		{name: "empty target", rules: ImportMappingRules{Columns: []ColumnMapping{email, {Target: " ", Source: "Name"}}}},
+		{name: "duplicate target", rules: ImportMappingRules{Columns: []ColumnMapping{email, {Target: " email ", Source: "Other"}}}},
+		{name: "no source or default", rules: ImportMappingRules{Columns: []ColumnMapping{email, {Target: "name"}}}},
+		{name: "empty split separator", rules: ImportMappingRules{Columns: []ColumnMapping{{Target: "email", Source: "Email", Split: &SplitRule{}}}}},
+		{name: "unknown transform", rules: ImportMappingRules{Columns: []ColumnMapping{{Target: "email", Source: "Email", Transforms: []string{"reverse"}}}}},
+		{name: "missing email", rules: ImportMappingRules{Columns: []ColumnMapping{{Target: "name", Source: "Name"}}}},
+		{name: "missing email keep unmapped", rules: ImportMappingRules{Columns: []ColumnMapping{{Target: "name", Source: "Name"}}, KeepUnmapped: true}, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rules.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestImportMappingRulesCheck(t *testing.T) {
+	headers := []string{"Email", "Name", "email"}
+	rules := ImportMappingRules{Columns: []ColumnMapping{{Target: "email", Source: "Email"}}}
+	if err := rules.Check(headers); err != nil {
+		t.Errorf("Check = %v", err)
+	}
+
+	missing := ImportMappingRules{Columns: []ColumnMapping{{Target: "email", Source: "Mail"}}}
+	if err := missing.Check(headers); err == nil {
+		t.Error("missing source column should be rejected")
+	}
+
+	// 保留未映射的列时，源文件中的 email 列可以作为收件人
+	keep := ImportMappingRules{Columns: []ColumnMapping{{Target: "name", Source: "Name"}}, KeepUnmapped: true}
+	if err := keep.Check(headers); err != nil {
+		t.Errorf("Check with kept email column = %v", err)
+	}
+	if err := keep.Check([]string{"Name", "Mail"}); err == nil {
+		t.Error("output without email column should be rejected")
+	}
+}
+
+func TestImportMappingRulesApply(t *testing.T) {
+	rules := ImportMappingRules{
+		Columns: []ColumnMapping{
+			{Target: "email", Source: "E-mail", Transforms: []string{TransformTrim, TransformLower}},
+			{Target: "name", Sources: []string{"First", "Last"}, Separator: " "},
+			{Target: "First", Source: "First", Transforms: []string{TransformUpper}},
+		},
+		KeepUnmapped: true,
+	}
+	headers := []string{"E-mail", "First", "Last", "City"}
+
+	if columns := rules.OutputColumns(headers); !reflect.DeepEqual(columns, []string{"email", "name", "First", "City"}) {
+		t.Errorf("OutputColumns = %v", columns)
+	}
+
+	got := rules.Apply(map[string]interface{}{"E-mail": " A@Example.com", "First": "Ann", "Last": "Lee", "City": "Beijing"})
+	want := map[string]interface{}{"email": "a@example.com", "name": "Ann Lee", "First": "ANN", "City": "Beijing"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply = %v, want %v", got, want)
+	}
+
+	rules.KeepUnmapped = false
+	if columns := rules.OutputColumns(headers); !reflect.DeepEqual(columns, []string{"email", "name", "First"}) {
+		t.Errorf("OutputColumns without unmapped = %v", columns)
+	}
+	got = rules.Apply(map[string]interface{}{"E-mail": "b@example.com", "First": "Bo", "Last": "", "City": "Shanghai"})
+	want = map[string]interface{}{"email": "b@example.com", "name": "Bo", "First": "BO"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply without unmapped = %v, want %v", got, want)
+	}
+}
+
+func TestMappedRecordReader(t *testing.T) {
+	data := "E-mail,First,Last\n A@Example.com ,Ann,Lee\nb@example.com,Bo,Xu,extra\n"
+	rules := &ImportMappingRules{Columns: []ColumnMapping{
+		{Target: "email", Source: "E-mail", Transforms: []string{TransformTrim, TransformLower}},
+		{Target: "name", Sources: []string{"First", "Last"}, Separator: " "},
+	}}
+	reader, err := OpenRecordReader(writeImportFile(t, "data.csv", []byte(data)), "data.csv", ReaderOptions{Mapping: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if columns := reader.Columns(); !reflect.DeepEqual(columns, []string{"email", "name"}) {
+		t.Errorf("Columns = %v", columns)
+	}
+	if encoding, delimiter := readerDialect(reader); encoding != EncodingUTF8 || delimiter != "," {
+		t.Errorf("dialect through mapping = %s %q", encoding, delimiter)
+	}
+	records, rowErrors := readAllRecords(t, reader)
+	if !reflect.DeepEqual(records, []map[string]interface{}{{"email": "a@example.com", "name": "Ann Lee"}}) {
+		t.Errorf("records = %v", records)
+	}
+	if len(rowErrors) != 1 || rowErrors[0].Line != 3 {
+		t.Errorf("row errors = %v, want line 3", rowErrors)
+	}
+
+	bad := &ImportMappingRules{Columns: []ColumnMapping{{Target: "email", Source: "Mail"}}}
+	if _, err := OpenRecordReader(writeImportFile(t, "data.csv", []byte(data)), "data.csv", ReaderOptions{Mapping: bad}); err == nil {
+		t.Error("mapping with missing source column should be rejected")
+	}
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// RecordReader 逐行读取导入文件，读完后返回 io.EOF。
+// 单行无法解析时返回 *ImportRowError，调用方可以记录后继续读取
+type RecordReader interface {
+	Columns() []string
+	Read() (map[string]interface{}, error)
+	// BytesRead 已读取的字节数，无法统计时返回0
+	BytesRead() int64
+	Close() error
+}
+
+// ImportRowError 无法解析的行，行号为文件中的行号（标题为第1行）
+type ImportRowError struct {
+	Line    int    `json:"line"`
+	Message string `json:"error"`
+}
+
+func (e *ImportRowError) Error() string {
+	return fmt.Sprintf("第%d行: %s", e.Line, e.Message)
+}
+
+// 导入文件格式
+const (
+	FormatCSV       = "csv"
+	FormatExcel     = "excel"
+	FormatJSON      = "json"
+	FormatJSONLines = "jsonl"
+	FormatParquet   = "parquet"
+)
+
+// ImportFormat 按扩展名判断文件格式，无法识别时按Excel处理
+func ImportFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return FormatCSV
+	case ".json":
+		return FormatJSON
+	case ".jsonl", ".ndjson":
+		return FormatJSONLines
+	case ".parquet":
+		return FormatParquet
+	}
+	return FormatExcel
+}
+
+// OpenRecordReader 按扩展名打开CSV、Excel、JSON、JSON Lines或Parquet文件。
+// CSV和Excel默认第一行作为标题；指定了列映射时返回映射后的记录
+func OpenRecordReader(path, filename string, options ReaderOptions) (RecordReader, error) {
+	var reader RecordReader
+	var err error
+	switch ImportFormat(filename) {
+	case FormatCSV:
+		reader, err = newCSVRecordReader(path, options)
+	case FormatJSON:
+		reader, err = newJSONRecordReader(path)
+	case FormatJSONLines:
+		reader, err = newJSONLinesRecordReader(path)
+	case FormatParquet:
+		reader, err = newParquetRecordReader(path)
+	default:
+		reader, err = newExcelRecordReader(path, options)
+	}
+	if err != nil || options.Mapping == nil {
+		return reader, err
+	}
+
+	mapped, err := newMappedRecordReader(reader, options.Mapping)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return mapped, nil
+}
+
+// readerDialect 返回CSV文件识别出的编码和分隔符，Excel文件返回空值
+func readerDialect(reader RecordReader) (string, string) {
+	if m, ok := reader.(*mappedRecordReader); ok {
+		reader = m.RecordReader
+	}
+	if r, ok := reader.(*csvRecordReader); ok {
+		return r.encoding, string(r.delimiter)
+	}
+	return "", ""
+}
+
+// readerSheet 返回Excel文件读取的工作表名称，CSV文件返回空值
+func readerSheet(reader RecordReader) string {
+	if m, ok := reader.(*mappedRecordReader); ok {
+		reader = m.RecordReader
+	}
+	if r, ok := reader.(*excelRecordReader); ok {
+		return r.sheet
+	}
+	return ""
+}
+
+// countingReader 统计已读取的字节数，用于计算进度
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// csvRecordReader 按 RFC 4180 读取CSV，支持带引号的字段、字段内的分隔符和换行
+type csvRecordReader struct {
+	file      *os.File
+	counter   *countingReader
+	reader    *csv.Reader
+	headers   []string
+	encoding  string
+	delimiter rune
+}
+
+func newCSVRecordReader(path string, options ReaderOptions) (*csvRecordReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := &countingReader{r: file}
+	buffered := bufio.NewReaderSize(counter, csvSampleSize)
+	sample, err := buffered.Peek(csvSampleSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		file.Close()
+		return nil, err
+	}
+
+	encodingName := strings.ToLower(options.Encoding)
+	if encodingName == "" || encodingName == "auto" {
+		encodingName = detectEncoding(sample)
+	}
+	enc, err := csvEncoding(encodingName)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	// BOMOverride 会去掉文件开头的BOM
+	decoder := unicode.BOMOverride(enc.NewDecoder())
+
+	delimiter := ','
+	if options.Delimiter != "" && options.Delimiter != "auto" {
+		if delimiter, err = parseDelimiter(options.Delimiter); err != nil {
+			file.Close()
+			return nil, err
+		}
+	} else {
+		decoded, _, _ := transform.Bytes(unicode.BOMOverride(enc.NewDecoder()), sampleLines(sample, encodingName))
+		delimiter = sniffDelimiter(string(decoded))
+	}
+
+	reader := csv.NewReader(transform.NewReader(buffered, decoder))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+
+	// 跳过标题行之前的内容
+	var headers []string
+	for i := 1; i <= options.headerRow(); i++ {
+		if headers, err = reader.Read(); err != nil {
+			file.Close()
+			if err == io.EOF {
+				return nil, fmt.Errorf("CSV文件至少需要包含标题行和数据行")
+			}
+			return nil, fmt.Errorf("标题行解析失败: %w", err)
+		}
+	}
+
+	return &csvRecordReader{
+		file:      file,
+		counter:   counter,
+		reader:    reader,
+		headers:   trimHeaders(headers),
+		encoding:  encodingName,
+		delimiter: delimiter,
+	}, nil
+}
+
+func (r *csvRecordReader) Columns() []string { return r.headers }
+
+func (r *csvRecordReader) Read() (map[string]interface{}, error) {
+	for {
+		values, err := r.reader.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, &ImportRowError{Line: parseErr.StartLine, Message: csvErrorMessage(parseErr.Err)}
+			}
+			return nil, err
+		}
+		if blankRow(values) {
+			continue
+		}
+		// 列数与标题不一致时报告该行，不按位置硬凑，避免数据错列
+		if len(values) != len(r.headers) {
+			line, _ := r.reader.FieldPos(0)
+			return nil, &ImportRowError{
+				Line:    line,
+				Message: fmt.Sprintf("列数为%d，与标题的%d列不一致", len(values), len(r.headers)),
+			}
+		}
+		return rowRecord(r.headers, values), nil
+	}
+}
+
+func (r *csvRecordReader) BytesRead() int64 { return r.counter.n }
+
+func csvErrorMessage(err error) string {
+	switch err {
+	case csv.ErrBareQuote:
+		return "未加引号的字段中出现引号"
+	case csv.ErrQuote:
+		return "引号未闭合或引号后有多余字符"
+	}
+	return err.Error()
+}
+
+func (r *csvRecordReader) Close() error { return r.file.Close() }
+
+func trimHeaders(headers []string) []string {
+	trimmed := make([]string, len(headers))
+	for i, header := range headers {
+		trimmed[i] = strings.TrimSpace(header)
+	}
+	return trimmed
+}
+
+func blankRow(values []string) bool {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// rowRecord 按标题组装记录，缺少的列补空字符串
+func rowRecord(headers, values []string) map[string]interface{} {
+	record := make(map[string]interface{}, len(headers))
+	for i, header := range headers {
+		if i < len(values) {
+			record[header] = strings.TrimSpace(values[i])
+		} else {
+			record[header] = ""
+		}
+	}
+	return record
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+// writeImportFile 把内容写入临时目录中的文件，返回路径
+func writeImportFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readAllRecords 读取全部记录，行错误单独收集
+func readAllRecords(t *testing.T, reader RecordReader) ([]map[string]interface{}, []*ImportRowError) {
+	t.Helper()
+	var records []map[string]interface{}
+	var rowErrors []*ImportRowError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records, rowErrors
+		}
+		var rowErr *ImportRowError
+		if errors.As(err, &rowErr) {
+			rowErrors = append(rowErrors, rowErr)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestCSVRecordReaderDialect(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		options   ReaderOptions
+		encoding  string
+		delimiter string
+	}{
+		{
+			name:      "utf-8 bom",
+			data:      append([]byte{0xEF, 0xBB, 0xBF}, "email,姓名\nzhang@example.com,张三\n"...),
+			encoding:  EncodingUTF8,
+			delimiter: ",",
+		},
+		{
+			name:      "gbk semicolon",
+			data:      encodeGBK(t, "email;姓名\nzhang@example.com;张三\n"),
+			encoding:  EncodingGB18030,
+			delimiter: ";",
+		},
+		{
+			name:      "explicit gbk",
+			data:      encodeGBK(t, "email,姓名\nzhang@example.com,张三\n"),
+			options:   ReaderOptions{Encoding: "GBK"},
+			encoding:  "gbk",
+			delimiter: ",",
+		},
+		{
+			name:      "utf-16le bom tab",
+			data:      append([]byte{0xFF, 0xFE}, encodeUTF16(t, "email\t姓名\r\nzhang@example.com\t张三\r\n", unicode.LittleEndian)...),
+			encoding:  EncodingUTF16LE,
+			delimiter: "\t",
+		},
+		{
+			name:      "utf-16be without bom",
+			data:      encodeUTF16(t, "email,姓名\nzhang@example.com,张三\n", unicode.BigEndian),
+			encoding:  EncodingUTF16BE,
+			delimiter: ",",
+		},
+		{
+			name:      "explicit tab",
+			data:      []byte("email\t姓名\nzhang@example.com\t张三\n"),
+			options:   ReaderOptions{Delimiter: `\t`},
+			encoding:  EncodingUTF8,
+			delimiter: "\t",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := OpenRecordReader(writeImportFile(t, "data.csv", tt.data), "data.csv", tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+
+			if encoding, delimiter := readerDialect(reader); encoding != tt.encoding || delimiter != tt.delimiter {
+				t.Errorf("dialect = %s %q, want %s %q", encoding, delimiter, tt.encoding, tt.delimiter)
+			}
+			if columns := reader.Columns(); !reflect.DeepEqual(columns, []string{"email", "姓名"}) {
+				t.Errorf("Columns = %q, want [email 姓名]", columns)
+			}
+			records, rowErrors := readAllRecords(t, reader)
+			if len(rowErrors) > 0 || len(records) != 1 || records[0]["email"] != "zhang@example.com" || records[0]["姓名"] != "张三" {
+				t.Errorf("records = %v, row errors = %v", records, rowErrors)
+			}
+		})
+	}
+}
+
+func TestCSVRecordReaderRows(t *testing.T) {
+	data := "说明：第一行会被跳过\n" +
+		"email, note ,city\n" +
+		"a@example.com,\"line one\nline two, with comma\",Beijing\n" +
+		"\n" +
+		",,\n" +
+		"b@example.com,\"say \"\"hi\"\"\",Shanghai\n" +
+		"c@example.com,too,many,columns\n" +
+		"d@example.com,missing\n" +
+		"e@example.com,bare \"quote,Shenzhen\n" +
+		"f@example.com, padded ,Hangzhou\n"
+	reader, err := OpenRecordReader(writeImportFile(t, "data.csv", []byte(data)), "data.csv", ReaderOptions{HeaderRow: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if columns := reader.Columns(); !reflect.DeepEqual(columns, []string{"email", "note", "city"}) {
+		t.Errorf("Columns = %q", columns)
+	}
+
+	records, rowErrors := readAllRecords(t, reader)
+	want := []map[string]interface{}{
+		{"email": "a@example.com", "note": "line one\nline two, with comma", "city": "Beijing"},
+		{"email": "b@example.com", "note": `say "hi"`, "city": "Shanghai"},
+		{"email": "f@example.com", "note": "padded", "city": "Hangzhou"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %v, want %v", records, want)
+	}
+
+	var lines []int
+	for _, rowErr := range rowErrors {
+		lines = append(lines, rowErr.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{8, 9, 10}) {
+		t.Errorf("row error lines = %v, want [8 9 10] (%v)", lines, rowErrors)
+	}
+	if len(rowErrors) == 3 && rowErrors[2].Message != csvErrorMessage(csv.ErrBareQuote) {
+		t.Errorf("bare quote message = %q", rowErrors[2].Message)
+	}
+	if reader.BytesRead() != int64(len(data)) {
+		t.Errorf("BytesRead = %d, want %d", reader.BytesRead(), len(data))
+	}
+}
+
+func TestCSVRecordReaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		options ReaderOptions
+	}{
+		{name: "empty file", data: ""},
+		{name: "header row after end", data: "email\na@example.com\n", options: ReaderOptions{HeaderRow: 3}},
+		{name: "unsupported encoding", data: "email\na@example.com\n", options: ReaderOptions{Encoding: "latin1"}},
+		{name: "invalid delimiter", data: "email\na@example.com\n", options: ReaderOptions{Delimiter: ";;"}},
+		{name: "broken header", data: "\"email\nsome\"x\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := OpenRecordReader(writeImportFile(t, "data.csv", []byte(tt.data)), "data.csv", tt.options)
+			if err == nil {
+				reader.Close()
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestImportFormat(t *testing.T) {
+	tests := map[string]string{
+		"a.CSV":          FormatCSV,
+		"a.json":         FormatJSON,
+		"a.jsonl":        FormatJSONLines,
+		"a.ndjson":       FormatJSONLines,
+		"a.parquet":      FormatParquet,
+		"a.xlsx":         FormatExcel,
+		"no-extension":   FormatExcel,
+		"dir.csv/a.xlsx": FormatExcel,
+	}
+	for filename, want := range tests {
+		if got := ImportFormat(filename); got != want {
+			t.Errorf("ImportFormat(%q) = %s, want %s", filename, got, want)
+		}
+	}
+}
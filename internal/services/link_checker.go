@@ -0,0 +1,434 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"go_market_email/internal/utils"
+	"golang.org/x/net/html"
+)
+
+// 链接检查的默认配置
+const (
+	defaultLinkTimeout      = 10
+	defaultLinkRedirects    = 10
+	defaultLinkConcurrency  = 8
+	defaultLinkCacheTTL     = 3600
+	linkCheckReportTTL      = 24 * time.Hour
+	linkCheckStaleAfter     = 10 * time.Minute // 检查锁的有效期，每检查完一个地址续期，进程中断后锁自动过期
+	linkCheckUserAgent      = "go-market-email-linkcheck/1.0"
+	linkCheckMaxBodyDiscard = 64 * 1024
+)
+
+// ErrLinkCheckRunning 模板的链接检查正在进行
+var ErrLinkCheckRunning = errors.New("链接检查正在进行")
+
+// ErrLinkCheckNotFound 模板没有检查记录或记录已过期
+var ErrLinkCheckNotFound = errors.New("模板尚未检查链接")
+
+var errPrivateAddress = errors.New("拒绝访问内网地址")
+
+// HTTPClient 发送检查请求的客户端，测试时可以替换为假的实现。
+// 客户端不应自动跟随重定向，重定向由 LinkChecker 逐跳记录
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// LinkRedirect 重定向链中的一跳
+type LinkRedirect struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// LinkResult 单个链接的检查结果
+type LinkResult struct {
+	URL        string         `json:"url"`
+	Sources    []string       `json:"sources"`   // 引用该地址的标签，如 a、img
+	Status     int            `json:"status"`    // 最终响应的状态码，请求失败时为0
+	OK         bool           `json:"ok"`        // 最终状态码小于400
+	Redirects  []LinkRedirect `json:"redirects"` // 依次经过的重定向
+	FinalURL   string         `json:"final_url"`
+	Timeout    bool           `json:"timeout"`
+	Error      string         `json:"error,omitempty"`
+	DurationMS int64          `json:"duration_ms"`
+	Cached     bool           `json:"cached"`
+	CheckedAt  time.Time      `json:"checked_at"`
+}
+
+// LinkCheckReport 模板链接检查的进度和结果
+type LinkCheckReport struct {
+	TemplateID uint         `json:"template_id"`
+	Status     string       `json:"status"` // running, completed
+	Total      int          `json:"total"`
+	Checked    int          `json:"checked"`
+	Broken     int          `json:"broken"`
+	Links      []LinkResult `json:"links"`
+	Skipped    []string     `json:"skipped"` // 不检查的 mailto:、cid: 和相对地址等
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at"`
+}
+
+// LinkChecker 检查模板中的链接和图片地址，结果按地址缓存
+type LinkChecker struct {
+	client       HTTPClient
+	cache        *CacheManager
+	logger       *zap.Logger
+	timeout      time.Duration
+	maxRedirects int
+	concurrency  int
+	cacheTTL     time.Duration
+}
+
+// NewLinkChecker 创建链接检查器，client 为 nil 时使用默认客户端，默认客户端按配置拒绝内网地址
+func NewLinkChecker(client HTTPClient, rdb redis.UniversalClient, config utils.LinkCheckConfig, logger *zap.Logger) *LinkChecker {
+	c := &LinkChecker{
+		client:       client,
+		cache:        NewCacheManager(rdb, logger),
+		logger:       logger,
+		timeout:      time.Duration(config.Timeout) * time.Second,
+		maxRedirects: config.MaxRedirects,
+		concurrency:  config.Concurrency,
+		cacheTTL:     time.Duration(config.CacheTTL) * time.Second,
+	}
+	if config.Timeout <= 0 {
+		c.timeout = defaultLinkTimeout * time.Second
+	}
+	if c.maxRedirects <= 0 {
+		c.maxRedirects = defaultLinkRedirects
+	}
+	if c.concurrency <= 0 {
+		c.concurrency = defaultLinkConcurrency
+	}
+	if config.CacheTTL <= 0 {
+		c.cacheTTL = defaultLinkCacheTTL * time.Second
+	}
+	if c.client == nil {
+		c.client = newLinkHTTPClient(c.timeout, config.AllowPrivate)
+	}
+	return c
+}
+
+func newLinkHTTPClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		// 在连接时检查解析后的地址，域名解析到内网地址同样拒绝
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// isPrivateIP 本机、内网、链路本地和未指定地址
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// ExtractLinks 提取HTML中全部元素的 href、src 和 background 地址，按出现顺序去重。
+// 返回要检查的 http(s) 地址和不检查的其他地址
+func ExtractLinks(content string) ([]LinkResult, []string) {
+	links := []LinkResult{}
+	skipped := []string{}
+	index := map[string]int{}
+	seenSkipped := map[string]bool{}
+
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return links, skipped
+	}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, attr := range n.Attr {
+				key := strings.ToLower(attr.Key)
+				if key != "href" && key != "src" && key != "background" {
+					continue
+				}
+				link := strings.TrimSpace(attr.Val)
+				if strings.HasPrefix(link, "//") {
+					link = "https:" + link
+				}
+				parsed, err := url.Parse(link)
+				if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+					if link != "" && !seenSkipped[link] {
+						seenSkipped[link] = true
+						skipped = append(skipped, link)
+					}
+					continue
+				}
+				if i, ok := index[link]; ok {
+					if !containsString(links[i].Sources, n.Data) {
+						links[i].Sources = append(links[i].Sources, n.Data)
+					}
+					continue
+				}
+				index[link] = len(links)
+				links = append(links, LinkResult{URL: link, Sources: []string{n.Data}})
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	return links, skipped
+}
+
+// Start 开始检查渲染后的模板内容，在后台逐个检查并保存进度，通过 GetReport 查看结果。
+// 同一模板同时只能有一个检查，通过 SETNX 加锁，未结束时返回 ErrLinkCheckRunning
+func (c *LinkChecker) Start(templateID uint, content string) (*LinkCheckReport, error) {
+	ctx := context.Background()
+	lockKey := linkCheckLockKey(templateID)
+	locked, err := c.cache.rdb.SetNX(ctx, lockKey, time.Now().Unix(), linkCheckStaleAfter).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, ErrLinkCheckRunning
+	}
+
+	links, skipped := ExtractLinks(content)
+	report := &LinkCheckReport{
+		TemplateID: templateID,
+		Status:     "running",
+		Total:      len(links),
+		Links:      []LinkResult{},
+		Skipped:    skipped,
+		StartedAt:  time.Now(),
+	}
+	if err := c.saveReport(report); err != nil {
+		c.cache.rdb.Del(ctx, lockKey)
+		return nil, err
+	}
+
+	// 返回副本，后台检查会继续修改 report
+	started := *report
+	go c.run(report, links)
+	return &started, nil
+}
+
+// GetReport 获取模板最近一次链接检查的结果
+func (c *LinkChecker) GetReport(templateID uint) (*LinkCheckReport, error) {
+	value, err := c.cache.Get(context.Background(), linkCheckKey(templateID))
+	if err == redis.Nil {
+		return nil, ErrLinkCheckNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report LinkCheckReport
+	if err := json.Unmarshal([]byte(value), &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (c *LinkChecker) run(report *LinkCheckReport, links []LinkResult) {
+	lockKey := linkCheckLockKey(report.TemplateID)
+	defer c.cache.rdb.Del(context.Background(), lockKey)
+
+	var mu sync.Mutex
+	results := make([]LinkResult, len(links))
+	done := make([]bool, len(links))
+	sem := make(chan struct{}, c.concurrency)
+	var wg sync.WaitGroup
+
+	for i := range links {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result := c.Check(context.Background(), links[i].URL)
+			result.Sources = links[i].Sources
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[i], done[i] = result, true
+			report.Checked++
+			if !result.OK {
+				report.Broken++
+			}
+			// 进度中的结果保持提取时的顺序
+			report.Links = report.Links[:0]
+			for j := range results {
+				if done[j] {
+					report.Links = append(report.Links, results[j])
+				}
+			}
+			c.saveReport(report)
+			c.cache.rdb.Expire(context.Background(), lockKey, linkCheckStaleAfter)
+		}(i)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	report.Links = results
+	report.Status = "completed"
+	report.FinishedAt = &now
+	if err := c.saveReport(report); err != nil {
+		c.logger.Error("保存链接检查结果失败", zap.Uint("templateID", report.TemplateID), zap.Error(err))
+	}
+}
+
+// Check 检查单个地址，跟随重定向并记录每一跳。超时以外的结果按地址缓存 cache_ttl
+func (c *LinkChecker) Check(ctx context.Context, link string) LinkResult {
+	key := utils.LinkCacheKey + hashLink(link)
+	if value, err := c.cache.Get(ctx, key); err == nil {
+		var cached LinkResult
+		if json.Unmarshal([]byte(value), &cached) == nil {
+			cached.Cached = true
+			return cached
+		}
+	}
+
+	result := c.check(ctx, link)
+	if !result.Timeout {
+		data, _ := json.Marshal(result)
+		if err := c.cache.Set(ctx, key, string(data), c.cacheTTL); err != nil {
+			c.logger.Error("缓存链接检查结果失败", zap.String("url", link), zap.Error(err))
+		}
+	}
+	return result
+}
+
+func (c *LinkChecker) check(ctx context.Context, link string) LinkResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	result := LinkResult{URL: link, Redirects: []LinkRedirect{}, CheckedAt: start}
+	current := link
+	for {
+		status, location, err := c.request(ctx, current)
+		if err != nil {
+			result.Timeout = isTimeout(ctx, err)
+			result.Error = err.Error()
+			if result.Timeout {
+				result.Error = fmt.Sprintf("请求超时（%s）", c.timeout)
+			}
+			break
+		}
+		if status >= 300 && status < 400 && location != "" {
+			result.Redirects = append(result.Redirects, LinkRedirect{URL: current, Status: status})
+			if len(result.Redirects) > c.maxRedirects {
+				result.Error = fmt.Sprintf("重定向超过 %d 次", c.maxRedirects)
+				break
+			}
+			next, err := resolveRedirect(current, location)
+			if err != nil {
+				result.Error = err.Error()
+				break
+			}
+			current = next
+			continue
+		}
+		result.Status = status
+		result.OK = status < 400
+		break
+	}
+	result.FinalURL = current
+	result.DurationMS = time.Since(start).Milliseconds()
+	return result
+}
+
+// request 先发送 HEAD 请求，服务器不支持或返回错误状态时改用 GET，返回状态码和重定向地址
+func (c *LinkChecker) request(ctx context.Context, link string) (int, string, error) {
+	status, location, err := c.do(ctx, http.MethodHead, link)
+	if err == nil && status < 400 {
+		return status, location, nil
+	}
+	if err != nil && isTimeout(ctx, err) {
+		return 0, "", err
+	}
+	return c.do(ctx, http.MethodGet, link)
+}
+
+func (c *LinkChecker) do(ctx context.Context, method, link string) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, link, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("User-Agent", linkCheckUserAgent)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if errors.Is(err, errPrivateAddress) {
+			return 0, "", errPrivateAddress
+		}
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, linkCheckMaxBodyDiscard))
+	return resp.StatusCode, resp.Header.Get("Location"), nil
+}
+
+// resolveRedirect 按当前地址解析相对的 Location，只跟随到 http(s) 地址
+func resolveRedirect(current, location string) (string, error) {
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	next, err := base.Parse(location)
+	if err != nil || (next.Scheme != "http" && next.Scheme != "https") {
+		return "", fmt.Errorf("重定向地址无效: %s", location)
+	}
+	return next.String(), nil
+}
+
+func isTimeout(ctx context.Context, err error) bool {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (c *LinkChecker) saveReport(report *LinkCheckReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return c.cache.Set(context.Background(), linkCheckKey(report.TemplateID), string(data), linkCheckReportTTL)
+}
+
+func linkCheckKey(templateID uint) string {
+	return utils.LinkCheckKey + strconv.FormatUint(uint64(templateID), 10)
+}
+
+func linkCheckLockKey(templateID uint) string {
+	return linkCheckKey(templateID) + ":lock"
+}
+
+func hashLink(link string) string {
+	sum := sha256.Sum256([]byte(link))
+	return hex.EncodeToString(sum[:])
+}
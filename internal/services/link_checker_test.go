@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"go_market_email/internal/utils"
+)
+
+// fakeResponse 假客户端对某个地址和方法的响应
+type fakeResponse struct {
+	status   int
+	location string
+	err      error
+}
+
+// fakeHTTPClient 按 "方法 地址" 返回预设的响应，没有预设时按 "地址" 查找，并记录请求
+type fakeHTTPClient struct {
+	mu        sync.Mutex
+	responses map[string]fakeResponse
+	requests  []string
+	block     chan struct{} // 不为nil时每个请求等待关闭后再返回
+}
+
+func (c *fakeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mu.Lock()
+	c.requests = append(c.requests, req.Method+" "+req.URL.String())
+	response, ok := c.responses[req.Method+" "+req.URL.String()]
+	if !ok {
+		response, ok = c.responses[req.URL.String()]
+	}
+	c.mu.Unlock()
+	if !ok {
+		response = fakeResponse{status: http.StatusNotFound}
+	}
+	if response.err != nil {
+		return nil, response.err
+	}
+	header := http.Header{}
+	if response.location != "" {
+		header.Set("Location", response.location)
+	}
+	return &http.Response{StatusCode: response.status, Header: header, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func (c *fakeHTTPClient) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.requests)
+}
+
+// timeoutError 模拟连接超时
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func newTestLinkChecker(t *testing.T, client HTTPClient, config utils.LinkCheckConfig) (*LinkChecker, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return NewLinkChecker(client, rdb, config, zap.NewNop()), mr
+}
+
+func TestLinkCheckerCheck(t *testing.T) {
+	client := &fakeHTTPClient{responses: map[string]fakeResponse{
+		"https://a.example/start":     {status: http.StatusMovedPermanently, location: "https://b.example/next"},
+		"https://b.example/next":      {status: http.StatusFound, location: "/final"},
+		"https://b.example/final":     {status: http.StatusOK},
+		"HEAD https://c.example/page": {status: http.StatusMethodNotAllowed},
+		"GET https://c.example/page":  {status: http.StatusOK},
+		"https://d.example/missing":   {status: http.StatusNotFound},
+		"https://e.example/loop":      {status: http.StatusFound, location: "https://e.example/loop"},
+		"https://f.example/slow":      {err: timeoutError{}},
+		"https://g.example/refused":   {err: errors.New("connection refused")},
+		"https://h.example/ftp":       {status: http.StatusFound, location: "ftp://h.example/file"},
+	}}
+	checker, _ := newTestLinkChecker(t, client, utils.LinkCheckConfig{MaxRedirects: 3})
+
+	tests := []struct {
+		url       string
+		ok        bool
+		status    int
+		redirects []string
+		finalURL  string
+		timeout   bool
+		hasError  bool
+	}{
+		{url: "https://a.example/start", ok: true, status: 200, redirects: []string{"https://a.example/start", "https://b.example/next"}, finalURL: "https://b.example/final"},
+		{url: "https://c.example/page", ok: true, status: 200, finalURL: "https://c.example/page"},
+		{url: "https://d.example/missing", status: 404, finalURL: "https://d.example/missing"},
+		{url: "https://e.example/loop", redirects: []string{"https://e.example/loop", "https://e.example/loop", "https://e.example/loop", "https://e.example/loop"}, finalURL: "https://e.example/loop", hasError: true},
+		{url: "https://f.example/slow", timeout: true, finalURL: "https://f.example/slow", hasError: true},
+		{url: "https://g.example/refused", finalURL: "https://g.example/refused", hasError: true},
+		{url: "https://h.example/ftp", redirects: []string{"https://h.example/ftp"}, finalURL: "https://h.example/ftp", hasError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			result := checker.Check(context.Background(), tt.url)
+			if result.OK != tt.ok || result.Status != tt.status || result.Timeout != tt.timeout {
+				t.Errorf("OK, Status, Timeout = %v, %d, %v, want %v, %d, %v", result.OK, result.Status, result.Timeout, tt.ok, tt.status, tt.timeout)
+			}
+			if (result.Error != "") != tt.hasError {
+				t.Errorf("Error = %q, hasError %v", result.Error, tt.hasError)
+			}
+			var redirects []string
+			for _, redirect := range result.Redirects {
+				redirects = append(redirects, redirect.URL)
+			}
+			if !equalStrings(redirects, tt.redirects) {
+				t.Errorf("Redirects = %v, want %v", redirects, tt.redirects)
+			}
+			if result.FinalURL != tt.finalURL {
+				t.Errorf("FinalURL = %q, want %q", result.FinalURL, tt.finalURL)
+			}
+			if result.Cached {
+				t.Error("first check should not be cached")
+			}
+		})
+	}
+}
+
+func TestLinkCheckerCache(t *testing.T) {
+	client := &fakeHTTPClient{responses: map[string]fakeResponse{
+		"https://a.example/": {status: http.StatusOK},
+		"https://b.example/": {err: timeoutError{}},
+	}}
+	checker, mr := newTestLinkChecker(t, client, utils.LinkCheckConfig{CacheTTL: 60})
+
+	checker.Check(context.Background(), "https://a.example/")
+	requests := client.count()
+	result := checker.Check(context.Background(), "https://a.example/")
+	if !result.Cached || !result.OK || client.count() != requests {
+		t.Errorf("second check should hit the cache: cached=%v requests=%d->%d", result.Cached, requests, client.count())
+	}
+
+	// 缓存过期后重新请求
+	mr.FastForward(61 * time.Second)
+	if result := checker.Check(context.Background(), "https://a.example/"); result.Cached {
+		t.Error("expired result should not be cached")
+	}
+
+	// 超时的结果不缓存
+	checker.Check(context.Background(), "https://b.example/")
+	requests = client.count()
+	if result := checker.Check(context.Background(), "https://b.example/"); result.Cached || client.count() == requests {
+		t.Error("timeout should not be cached")
+	}
+}
+
+func TestLinkCheckerPrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	checker, _ := newTestLinkChecker(t, nil, utils.LinkCheckConfig{Timeout: 2})
+	result := checker.Check(context.Background(), server.URL)
+	if result.OK || !strings.Contains(result.Error, errPrivateAddress.Error()) {
+		t.Errorf("loopback address should be rejected: ok=%v error=%q", result.OK, result.Error)
+	}
+
+	checker, _ = newTestLinkChecker(t, nil, utils.LinkCheckConfig{Timeout: 2, AllowPrivate: true})
+	if result := checker.Check(context.Background(), server.URL); !result.OK {
+		t.Errorf("allow_private should allow loopback address: %+v", result)
+	}
+}
+
+func TestLinkCheckerStart(t *testing.T) {
+	client := &fakeHTTPClient{
+		responses: map[string]fakeResponse{
+			"https://a.example/": {status: http.StatusOK},
+			"https://b.example/": {status: http.StatusNotFound},
+		},
+		block: make(chan struct{}),
+	}
+	checker, _ := newTestLinkChecker(t, client, utils.LinkCheckConfig{})
+	content := `<a href="https://a.example/">a</a><img src="https://b.example/"><a href="mailto:x@example.com">m</a>`
+
+	report, err := checker.Start(1, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != "running" || report.Total != 2 || !equalStrings(report.Skipped, []string{"mailto:x@example.com"}) {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	// 并发启动同一模板的检查只有一个成功
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := checker.Start(1, content)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if !errors.Is(err, ErrLinkCheckRunning) {
+			t.Errorf("err = %v, want ErrLinkCheckRunning", err)
+		}
+	}
+	if _, err := checker.Start(2, content); err != nil {
+		t.Errorf("other template should not be locked: %v", err)
+	}
+
+	close(client.block)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		current, err := checker.GetReport(1)
+		if err == nil && current.Status == "completed" {
+			if current.Checked != 2 || current.Broken != 1 || current.FinishedAt == nil {
+				t.Errorf("unexpected completed report: %+v", current)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("link check did not complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 锁在检查结束后释放，可以再次检查
+	for {
+		if _, err := checker.Start(1, content); err == nil {
+			break
+		} else if !errors.Is(err, ErrLinkCheckRunning) || time.Now().After(deadline) {
+			t.Fatalf("restart after completion: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLinkCheckerGetReportNotFound(t *testing.T) {
+	checker, _ := newTestLinkChecker(t, &fakeHTTPClient{}, utils.LinkCheckConfig{})
+	if _, err := checker.GetReport(42); !errors.Is(err, ErrLinkCheckNotFound) {
+		t.Errorf("err = %v, want ErrLinkCheckNotFound", err)
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	content := `<table background="https://example.com/bg.png"><tr><td>` +
+		`<a href="https://example.com/a">a</a><a href="//cdn.example.com/x">x</a>` +
+		`<img src="https://example.com/a"><a href="https://example.com/a">again</a>` +
+		`<a href="mailto:x@example.com">m</a><img src="cid:logo"><a href="#top">top</a><a href="/relative">r</a>` +
+		`</td></tr></table>`
+	links, skipped := ExtractLinks(content)
+
+	var urls []string
+	for _, link := range links {
+		urls = append(urls, link.URL)
+	}
+	if !equalStrings(urls, []string{"https://example.com/bg.png", "https://example.com/a", "https://cdn.example.com/x"}) {
+		t.Errorf("links = %v", urls)
+	}
+	if !equalStrings(links[1].Sources, []string{"a", "img"}) {
+		t.Errorf("sources = %v, want [a img]", links[1].Sources)
+	}
+	if !equalStrings(skipped, []string{"mailto:x@example.com", "cid:logo", "#top", "/relative"}) {
+		t.Errorf("skipped = %v", skipped)
+	}
+}
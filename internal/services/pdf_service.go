@@ -0,0 +1,430 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"go_market_email/internal/models"
+	"go_market_email/internal/utils"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// TemplateTypePDF 附件模板，主题为生成的PDF文件名，内容为HTML或纯文本
+const TemplateTypePDF = "pdf"
+
+// PDF排版参数（单位mm，字号单位pt）
+const (
+	pdfMargin     = 15.0
+	pdfFontSize   = 11.0
+	pdfLineHeight = 6.0
+	pdfCellPad    = 1.5
+)
+
+var pdfHeadingSizes = map[atom.Atom]float64{
+	atom.H1: 20, atom.H2: 16, atom.H3: 14, atom.H4: 12, atom.H5: 11, atom.H6: 11,
+}
+
+var pdfSpaces = regexp.MustCompile(`\s+`)
+
+// 文件名中不允许的字符
+var pdfFilenameChars = regexp.MustCompile(`[\\/:*?"<>|\x00-\x1f]+`)
+
+// PDFService 把附件模板渲染为PDF。只支持常见的排版元素：标题、段落、换行、粗体/斜体/下划线、
+// 列表、表格和分隔线，不支持图片和CSS
+type PDFService struct {
+	pageSize string
+	font     []byte
+	boldFont []byte
+	fontErr  error
+}
+
+func NewPDFService(config utils.PDFConfig) *PDFService {
+	s := &PDFService{pageSize: config.PageSize}
+	if s.pageSize == "" {
+		s.pageSize = "A4"
+	}
+	if config.Font != "" {
+		s.font, s.fontErr = os.ReadFile(config.Font)
+		s.boldFont = s.font
+		if s.fontErr == nil && config.BoldFont != "" {
+			s.boldFont, s.fontErr = os.ReadFile(config.BoldFont)
+		}
+	}
+	return s
+}
+
+// Render 把渲染好变量的模板内容转换为PDF，内容中没有HTML标签时按纯文本逐行排版
+func (s *PDFService) Render(content string) ([]byte, error) {
+	if s.fontErr != nil {
+		return nil, fmt.Errorf("PDF字体加载失败: %v", s.fontErr)
+	}
+
+	pdf := fpdf.New("P", "mm", s.pageSize, "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.SetCreationDate(time.Now())
+
+	w := &pdfWriter{pdf: pdf, size: pdfFontSize}
+	if s.font != nil {
+		w.family = "body"
+		w.utf8 = true
+		w.translate = func(text string) string { return text }
+		pdf.AddUTF8FontFromBytes(w.family, "", s.font)
+		pdf.AddUTF8FontFromBytes(w.family, "B", s.boldFont)
+		// 没有单独的斜体字体，斜体按常规字体显示
+		pdf.AddUTF8FontFromBytes(w.family, "I", s.font)
+		pdf.AddUTF8FontFromBytes(w.family, "BI", s.boldFont)
+	} else {
+		// 内置字体只支持西欧字符
+		if _, err := charmap.Windows1252.NewEncoder().String(content); err != nil {
+			return nil, fmt.Errorf("PDF内容包含内置字体不支持的字符，请配置 pdf.font")
+		}
+		w.family = "Helvetica"
+		w.translate = pdf.UnicodeTranslatorFromDescriptor("")
+	}
+	pdf.AddPage()
+	w.applyFont()
+
+	if strings.Contains(content, "<") {
+		doc, err := html.Parse(strings.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		w.render(doc)
+	} else {
+		for _, line := range strings.Split(content, "\n") {
+			w.write(strings.TrimRight(line, "\r"))
+			w.pdf.Ln(pdfLineHeight)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Filename 把渲染好变量的附件模板主题整理为文件名
+func (s *PDFService) Filename(subject string) string {
+	name := strings.TrimSpace(pdfFilenameChars.ReplaceAllString(subject, "_"))
+	if name == "" {
+		name = "document"
+	}
+	if !strings.EqualFold(filepath.Ext(name), ".pdf") {
+		name += ".pdf"
+	}
+	return name
+}
+
+// RenderAttachment 把已替换变量的附件模板主题和内容生成为PDF附件
+func (s *PDFService) RenderAttachment(template *models.EmailTemplate, subject, content string) (*MailAttachment, error) {
+	data, err := s.Render(content)
+	if err != nil {
+		return nil, fmt.Errorf("生成PDF失败（%s）: %v", template.Name, err)
+	}
+	return &MailAttachment{
+		Name:        s.Filename(subject),
+		ContentType: "application/pdf",
+		Content:     data,
+		Size:        int64(len(data)),
+	}, nil
+}
+
+// pdfWriter 遍历HTML节点写入PDF
+type pdfWriter struct {
+	pdf       *fpdf.Fpdf
+	family    string
+	translate func(string) string
+	utf8      bool
+	bold      int
+	italic    int
+	underline int
+	size      float64
+	lists     []int // 每层列表的序号，-1 表示无序列表
+	pre       bool
+}
+
+func (w *pdfWriter) applyFont() {
+	style := ""
+	if w.bold > 0 {
+		style += "B"
+	}
+	if w.italic > 0 {
+		style += "I"
+	}
+	if w.underline > 0 {
+		style += "U"
+	}
+	w.pdf.SetFont(w.family, style, w.size)
+}
+
+func (w *pdfWriter) lineHeight() float64 {
+	return pdfLineHeight * w.size / pdfFontSize
+}
+
+func (w *pdfWriter) write(text string) {
+	if text != "" {
+		w.pdf.Write(w.lineHeight(), w.translate(text))
+	}
+}
+
+// newLine 块级元素前后换行，当前行为空时不重复换行
+func (w *pdfWriter) newLine() {
+	left, _, _, _ := w.pdf.GetMargins()
+	if w.pdf.GetX() > left+0.01 {
+		w.pdf.Ln(w.lineHeight())
+	}
+}
+
+func (w *pdfWriter) render(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		text := n.Data
+		if !w.pre {
+			text = pdfSpaces.ReplaceAllString(text, " ")
+			left, _, _, _ := w.pdf.GetMargins()
+			if w.pdf.GetX() <= left+0.01 {
+				text = strings.TrimLeft(text, " ")
+			}
+			w.write(text)
+			return
+		}
+		for i, line := range strings.Split(text, "\n") {
+			if i > 0 {
+				w.pdf.Ln(w.lineHeight())
+			}
+			w.write(line)
+		}
+		return
+	case html.ElementNode:
+	default:
+		w.children(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Title, atom.Img:
+		return
+	case atom.Br:
+		w.pdf.Ln(w.lineHeight())
+	case atom.Hr:
+		w.newLine()
+		left, _, right, _ := w.pdf.GetMargins()
+		width, _ := w.pdf.GetPageSize()
+		y := w.pdf.GetY() + 2
+		w.pdf.Line(left, y, width-right, y)
+		w.pdf.SetY(y + 2)
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		w.newLine()
+		w.pdf.Ln(2)
+		size := w.size
+		w.size = pdfHeadingSizes[n.DataAtom]
+		w.bold++
+		w.applyFont()
+		w.children(n)
+		w.newLine()
+		w.bold--
+		w.size = size
+		w.applyFont()
+		w.pdf.Ln(1)
+	case atom.B, atom.Strong:
+		w.styled(&w.bold, n)
+	case atom.I, atom.Em:
+		w.styled(&w.italic, n)
+	case atom.U:
+		w.styled(&w.underline, n)
+	case atom.Pre:
+		w.newLine()
+		w.pre = true
+		w.children(n)
+		w.pre = false
+		w.newLine()
+	case atom.Ul, atom.Ol:
+		w.newLine()
+		start := 0
+		if n.DataAtom == atom.Ul {
+			start = -1
+		}
+		w.lists = append(w.lists, start)
+		w.children(n)
+		w.lists = w.lists[:len(w.lists)-1]
+	case atom.Li:
+		w.listItem(n)
+	case atom.Table:
+		w.newLine()
+		w.table(n)
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Blockquote:
+		w.newLine()
+		w.children(n)
+		w.newLine()
+		if n.DataAtom == atom.P {
+			w.pdf.Ln(w.lineHeight() / 2)
+		}
+	default:
+		w.children(n)
+	}
+}
+
+func (w *pdfWriter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.render(child)
+	}
+}
+
+func (w *pdfWriter) styled(counter *int, n *html.Node) {
+	*counter++
+	w.applyFont()
+	w.children(n)
+	*counter--
+	w.applyFont()
+}
+
+func (w *pdfWriter) listItem(n *html.Node) {
+	w.newLine()
+	depth := len(w.lists)
+	marker := "- "
+	if depth > 0 && w.lists[depth-1] >= 0 {
+		w.lists[depth-1]++
+		marker = strconv.Itoa(w.lists[depth-1]) + ". "
+	}
+	left, _, _, _ := w.pdf.GetMargins()
+	indent := 6.0 * float64(depth)
+	w.pdf.SetX(left + indent - 5)
+	w.write(marker)
+	// 列表项换行时与首行对齐
+	w.pdf.SetLeftMargin(left + indent)
+	w.children(n)
+	w.newLine()
+	w.pdf.SetLeftMargin(left)
+	w.pdf.SetX(left)
+}
+
+// table 按等宽列绘制表格，单元格内容只保留文字
+func (w *pdfWriter) table(n *html.Node) {
+	var rows [][]*html.Node
+	var collect func(*html.Node)
+	collect = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if child.DataAtom == atom.Tr {
+				var cells []*html.Node
+				for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+						cells = append(cells, cell)
+					}
+				}
+				rows = append(rows, cells)
+				continue
+			}
+			if child.DataAtom != atom.Table {
+				collect(child)
+			}
+		}
+	}
+	collect(n)
+
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	if columns == 0 {
+		return
+	}
+	left, _, right, _ := w.pdf.GetMargins()
+	pageWidth, pageHeight := w.pdf.GetPageSize()
+	cellWidth := (pageWidth - left - right) / float64(columns)
+
+	for _, row := range rows {
+		texts := make([]string, len(row))
+		lines := 1
+		for i, cell := range row {
+			texts[i] = w.translate(strings.TrimSpace(pdfSpaces.ReplaceAllString(nodeText(cell), " ")))
+			if cell.DataAtom == atom.Th {
+				w.bold++
+			}
+			w.applyFont()
+			if n := w.textLines(texts[i], cellWidth-2*pdfCellPad); n > lines {
+				lines = n
+			}
+			if cell.DataAtom == atom.Th {
+				w.bold--
+			}
+		}
+		height := float64(lines)*w.lineHeight() + 2*pdfCellPad
+		if w.pdf.GetY()+height > pageHeight-pdfMargin {
+			w.pdf.AddPage()
+		}
+		y := w.pdf.GetY()
+		for i := 0; i < columns; i++ {
+			x := left + float64(i)*cellWidth
+			w.pdf.Rect(x, y, cellWidth, height, "D")
+			if i >= len(row) {
+				continue
+			}
+			if row[i].DataAtom == atom.Th {
+				w.bold++
+			}
+			w.applyFont()
+			w.pdf.SetXY(x+pdfCellPad, y+pdfCellPad)
+			w.pdf.MultiCell(cellWidth-2*pdfCellPad, w.lineHeight(), texts[i], "", "L", false)
+			if row[i].DataAtom == atom.Th {
+				w.bold--
+			}
+		}
+		w.applyFont()
+		w.pdf.SetXY(left, y+height)
+	}
+	w.pdf.Ln(w.lineHeight() / 2)
+}
+
+// textLines 文字在给定宽度内换行后的行数。内置字体的文字已转换为单字节编码，
+// 不能使用按Unicode计算宽度的 SplitText，按单词估算
+func (w *pdfWriter) textLines(text string, width float64) int {
+	if w.utf8 {
+		return len(w.pdf.SplitText(text, width))
+	}
+	lines, line := 1, 0.0
+	for _, word := range strings.Fields(text) {
+		wordWidth := w.pdf.GetStringWidth(word + " ")
+		if line > 0 && line+wordWidth > width {
+			lines++
+			line = 0
+		}
+		line += wordWidth
+		for line > width {
+			lines++
+			line -= width
+		}
+	}
+	return lines
+}
+
+// nodeText 节点内的全部文字
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.DataAtom == atom.Br {
+			b.WriteString(" ")
+			continue
+		}
+		b.WriteString(nodeText(child))
+	}
+	return b.String()
+}
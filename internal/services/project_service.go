@@ -0,0 +1,89 @@
+package services
+
+import (
+	"fmt"
+
+	"go_market_email/internal/models"
+	"gorm.io/gorm"
+)
+
+type ProjectService struct {
+	db *gorm.DB
+}
+
+func NewProjectService(db *gorm.DB) *ProjectService {
+	return &ProjectService{db: db}
+}
+
+// InProject 限定查询范围：projectID不为0时为该项目的资源，否则为用户的个人资源
+func InProject(projectID, userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if projectID != 0 {
+			return db.Where("project_id = ?", projectID)
+		}
+		return db.Where("user_id = ? AND project_id = 0", userID)
+	}
+}
+
+// CreateProject 创建项目，创建者成为owner
+func (s *ProjectService) CreateProject(project *models.Project) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(project).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.ProjectMember{
+			ProjectID: project.ID,
+			UserID:    project.UserID,
+			Role:      RoleOwner,
+		}).Error
+	})
+}
+
+// ListProjects 获取用户创建或参与的项目
+func (s *ProjectService) ListProjects(userID uint) ([]models.Project, error) {
+	var projects []models.Project
+	memberOf := s.db.Model(&models.ProjectMember{}).Select("project_id").Where("user_id = ?", userID)
+	err := s.db.Where("user_id = ? OR id IN (?)", userID, memberOf).
+		Order("created_at DESC").Find(&projects).Error
+	return projects, err
+}
+
+// GetProject 获取项目
+func (s *ProjectService) GetProject(id uint) (*models.Project, error) {
+	var project models.Project
+	err := s.db.First(&project, id).Error
+	return &project, err
+}
+
+// UpdateProject 更新项目名称和描述
+func (s *ProjectService) UpdateProject(id uint, updates map[string]interface{}) error {
+	return s.db.Model(&models.Project{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// DeleteProject 删除项目，存在未结束的任务时拒绝删除
+func (s *ProjectService) DeleteProject(id uint) error {
+	var running int64
+	s.db.Model(&models.EmailTask{}).
+		Where("project_id = ? AND status IN ?", id, []string{"pending", "running", "paused"}).
+		Count(&running)
+	if running > 0 {
+		return fmt.Errorf("项目中还有%d个未结束的任务", running)
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("project_id = ?", id).Delete(&models.ProjectMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("current_project_id = ?", id).
+			Update("current_project_id", 0).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Project{}, id).Error
+	})
+}
+
+// SwitchProject 切换用户的当前项目，0表示切换回个人空间
+func (s *ProjectService) SwitchProject(userID, projectID uint) error {
+	return s.db.Model(&models.User{}).Where("id = ?", userID).
+		Update("current_project_id", projectID).Error
+}
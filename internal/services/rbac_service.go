@@ -0,0 +1,282 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"go_market_email/internal/models"
+	"gorm.io/gorm"
+)
+
+// 项目角色，权限依次递增
+const (
+	RoleViewer = "viewer"
+	RoleSender = "sender"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// 权限
+const (
+	PermTemplateRead  = "template.read"
+	PermTemplateWrite = "template.write"
+	PermTaskRead      = "task.read"
+	PermTaskWrite     = "task.write"
+	PermTaskSend      = "task.send"
+	PermDataRead      = "data.read"
+	PermDataWrite     = "data.write"
+	PermStatsRead     = "stats.read"
+	PermProjectRead   = "project.read"
+	PermProjectManage = "project.manage"
+	PermMemberManage  = "member.manage"
+	PermAuditRead     = "audit.read"
+)
+
+var roleRank = map[string]int{
+	RoleViewer: 1,
+	RoleSender: 2,
+	RoleEditor: 3,
+	RoleOwner:  4,
+}
+
+// permissionMinRole 每个权限所需的最低角色
+var permissionMinRole = map[string]string{
+	PermTemplateRead:  RoleViewer,
+	PermTaskRead:      RoleViewer,
+	PermDataRead:      RoleViewer,
+	PermStatsRead:     RoleViewer,
+	PermProjectRead:   RoleViewer,
+	PermTaskSend:      RoleSender,
+	PermTemplateWrite: RoleEditor,
+	PermTaskWrite:     RoleEditor,
+	PermDataWrite:     RoleEditor,
+	PermProjectManage: RoleOwner,
+	PermMemberManage:  RoleOwner,
+	PermAuditRead:     RoleOwner,
+}
+
+var ErrNotProjectMember = errors.New("不是项目成员")
+
+type RBACService struct {
+	db *gorm.DB
+}
+
+func NewRBACService(db *gorm.DB) *RBACService {
+	return &RBACService{db: db}
+}
+
+// ValidRole 检查角色名是否有效
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// Can 判断角色是否拥有权限
+func (s *RBACService) Can(role, permission string) bool {
+	minRole, ok := permissionMinRole[permission]
+	if !ok {
+		return false
+	}
+	return roleRank[role] >= roleRank[minRole]
+}
+
+// GetRole 获取用户在项目中的角色，项目创建者始终是owner
+func (s *RBACService) GetRole(projectID, userID uint) (string, error) {
+	var project models.Project
+	if err := s.db.Select("id", "user_id").First(&project, projectID).Error; err != nil {
+		return "", err
+	}
+	if project.UserID == userID {
+		return RoleOwner, nil
+	}
+
+	var member models.ProjectMember
+	if err := s.db.Where("project_id = ? AND user_id = ?", projectID, userID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrNotProjectMember
+		}
+		return "", err
+	}
+	return member.Role, nil
+}
+
+// ListMembers 获取项目成员
+func (s *RBACService) ListMembers(projectID uint) ([]models.ProjectMember, error) {
+	var members []models.ProjectMember
+	err := s.db.Preload("User").Where("project_id = ?", projectID).
+		Order("created_at ASC").Find(&members).Error
+	return members, err
+}
+
+// AddMember 添加项目成员
+func (s *RBACService) AddMember(projectID, userID uint, role string) (*models.ProjectMember, error) {
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("无效的角色: %s", role)
+	}
+
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("用户不存在")
+	}
+
+	member := &models.ProjectMember{ProjectID: projectID, UserID: userID, Role: role}
+	if err := s.db.Create(member).Error; err != nil {
+		return nil, err
+	}
+	member.User = user
+	return member, nil
+}
+
+// UpdateMemberRole 修改成员角色
+func (s *RBACService) UpdateMemberRole(projectID, userID uint, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("无效的角色: %s", role)
+	}
+	if role != RoleOwner {
+		if err := s.ensureNotCreator(projectID, userID); err != nil {
+			return err
+		}
+	}
+
+	result := s.db.Model(&models.ProjectMember{}).
+		Where("project_id = ? AND user_id = ?", projectID, userID).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RemoveMember 移除项目成员
+func (s *RBACService) RemoveMember(projectID, userID uint) error {
+	if err := s.ensureNotCreator(projectID, userID); err != nil {
+		return err
+	}
+	return s.db.Where("project_id = ? AND user_id = ?", projectID, userID).
+		Delete(&models.ProjectMember{}).Error
+}
+
+// ensureNotCreator 项目创建者不能被降级或移除
+func (s *RBACService) ensureNotCreator(projectID, userID uint) error {
+	var project models.Project
+	if err := s.db.Select("id", "user_id").First(&project, projectID).Error; err != nil {
+		return err
+	}
+	if project.UserID == userID {
+		return fmt.Errorf("不能修改项目创建者的角色")
+	}
+	return nil
+}
+
+// TemplateScope 获取模板所属的项目和用户
+func (s *RBACService) TemplateScope(templateID uint) (uint, uint, error) {
+	var template models.EmailTemplate
+	if err := s.db.Select("id", "project_id", "user_id").First(&template, templateID).Error; err != nil {
+		return 0, 0, err
+	}
+	return template.ProjectID, template.UserID, nil
+}
+
+// TaskScope 获取任务所属的项目和用户
+func (s *RBACService) TaskScope(taskID uint) (uint, uint, error) {
+	var task models.EmailTask
+	if err := s.db.Select("id", "project_id", "user_id").First(&task, taskID).Error; err != nil {
+		return 0, 0, err
+	}
+	return task.ProjectID, task.UserID, nil
+}
+
+// SavedQueryScope 获取保存的查询所属的项目和用户
+func (s *RBACService) SavedQueryScope(queryID uint) (uint, uint, error) {
+	var query models.SavedQuery
+	if err := s.db.Select("id", "project_id", "user_id").First(&query, queryID).Error; err != nil {
+		return 0, 0, err
+	}
+	return query.ProjectID, query.UserID, nil
+}
+
+// ImportMappingScope 获取列映射预设所属的项目和用户
+func (s *RBACService) ImportMappingScope(mappingID uint) (uint, uint, error) {
+	var mapping models.ImportMapping
+	if err := s.db.Select("id", "project_id", "user_id").First(&mapping, mappingID).Error; err != nil {
+		return 0, 0, err
+	}
+	return mapping.ProjectID, mapping.UserID, nil
+}
+
+// DatasetScope 获取数据集所属的项目和用户
+func (s *RBACService) DatasetScope(datasetID uint) (uint, uint, error) {
+	var dataset models.Dataset
+	if err := s.db.Select("id", "project_id", "user_id").First(&dataset, datasetID).Error; err != nil {
+		return 0, 0, err
+	}
+	return dataset.ProjectID, dataset.UserID, nil
+}
+
+// AttachmentScope 获取附件所属的项目和用户
+func (s *RBACService) AttachmentScope(attachmentID uint) (uint, uint, error) {
+	var attachment models.Attachment
+	if err := s.db.Select("id", "project_id", "user_id").First(&attachment, attachmentID).Error; err != nil {
+		return 0, 0, err
+	}
+	return attachment.ProjectID, attachment.UserID, nil
+}
+
+// AssetScope 获取图片资源所属的项目和用户
+func (s *RBACService) AssetScope(assetID uint) (uint, uint, error) {
+	var asset models.Asset
+	if err := s.db.Select("id", "project_id", "user_id").First(&asset, assetID).Error; err != nil {
+		return 0, 0, err
+	}
+	return asset.ProjectID, asset.UserID, nil
+}
+
+// SegmentScope 获取分群所属的项目和用户
+func (s *RBACService) SegmentScope(segmentID uint) (uint, uint, error) {
+	var segment models.Segment
+	if err := s.db.Select("id", "project_id", "user_id").First(&segment, segmentID).Error; err != nil {
+		return 0, 0, err
+	}
+	return segment.ProjectID, segment.UserID, nil
+}
+
+// ContactScope 获取联系人所属的项目和用户
+func (s *RBACService) ContactScope(contactID uint) (uint, uint, error) {
+	var contact models.Contact
+	if err := s.db.Select("id", "project_id", "user_id").First(&contact, contactID).Error; err != nil {
+		return 0, 0, err
+	}
+	return contact.ProjectID, contact.UserID, nil
+}
+
+// ContactListScope 获取联系人列表所属的项目和用户
+func (s *RBACService) ContactListScope(listID uint) (uint, uint, error) {
+	var list models.ContactList
+	if err := s.db.Select("id", "project_id", "user_id").First(&list, listID).Error; err != nil {
+		return 0, 0, err
+	}
+	return list.ProjectID, list.UserID, nil
+}
+
+// WebhookScope 获取Webhook订阅所属的项目和用户
+func (s *RBACService) WebhookScope(subscriptionID uint) (uint, uint, error) {
+	var sub models.WebhookSubscription
+	if err := s.db.Select("id", "project_id", "user_id").First(&sub, subscriptionID).Error; err != nil {
+		return 0, 0, err
+	}
+	return sub.ProjectID, sub.UserID, nil
+}
+
+// WebhookDeliveryScope 获取投递记录所属订阅的项目和用户，默认Webhook的记录没有订阅，不能通过接口访问
+func (s *RBACService) WebhookDeliveryScope(deliveryID uint) (uint, uint, error) {
+	var delivery models.WebhookDelivery
+	if err := s.db.Select("id", "subscription_id").First(&delivery, deliveryID).Error; err != nil {
+		return 0, 0, err
+	}
+	if delivery.SubscriptionID == 0 {
+		return 0, 0, gorm.ErrRecordNotFound
+	}
+	return s.WebhookScope(delivery.SubscriptionID)
+}
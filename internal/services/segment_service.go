@@ -0,0 +1,542 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go_market_email/internal/models"
+	"go_market_email/internal/utils"
+	"gorm.io/gorm"
+)
+
+// 分群类型
+const (
+	SegmentTypeSQL    = "sql"
+	SegmentTypeFilter = "filter"
+)
+
+// defaultSampleSize 预览时默认返回的样本行数
+const defaultSampleSize = 10
+
+// segmentOps 过滤条件支持的操作符
+var segmentOps = map[string]bool{
+	"eq": true, "neq": true, "contains": true, "not_contains": true,
+	"starts_with": true, "ends_with": true, "in": true, "not_in": true,
+	"gt": true, "gte": true, "lt": true, "lte": true,
+	"exists": true, "not_exists": true, "has": true, "not_has": true,
+}
+
+// SegmentRules 联系人过滤规则，match 为 all（默认）或 any
+type SegmentRules struct {
+	Match      string             `json:"match"`
+	Conditions []SegmentCondition `json:"conditions"`
+}
+
+// SegmentCondition 单个过滤条件。
+// field 可以是 email、tags、lists 或 attributes.<属性名>；tags 和 lists（列表ID）使用 has/not_has 操作符
+type SegmentCondition struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// SegmentDefinition 分群定义，每次修改生成一个新版本
+type SegmentDefinition struct {
+	Type       string                 `json:"type" binding:"required,oneof=sql filter"`
+	DataSource string                 `json:"data_source"`
+	Query      string                 `json:"query"`
+	Params     map[string]interface{} `json:"params"`
+	Rules      *SegmentRules          `json:"rules"`
+}
+
+// SegmentPreview 分群预览结果
+type SegmentPreview struct {
+	Version   int                      `json:"version"`
+	Count     int                      `json:"count"`
+	Truncated bool                     `json:"truncated"` // SQL结果超过数据源行数上限，发送时会失败
+	Removed   int                      `json:"removed"`   // 地址无效或重复而不会发送的记录数
+	Columns   []string                 `json:"columns"`
+	Sample    []map[string]interface{} `json:"sample"`
+}
+
+type SegmentService struct {
+	db        *gorm.DB
+	sources   *DataSourceService
+	validator *EmailValidator
+}
+
+func NewSegmentService(db *gorm.DB, sources *DataSourceService) *SegmentService {
+	return &SegmentService{db: db, sources: sources, validator: NewEmailValidator(nil)}
+}
+
+// segmentResult 解析分群得到的收件人
+type segmentResult struct {
+	rows      []map[string]interface{}
+	columns   []string
+	truncated bool
+	removed   int
+}
+
+// CreateSegment 创建分群及其第一个版本
+func (s *SegmentService) CreateSegment(segment *models.Segment, definition SegmentDefinition) error {
+	version, err := s.buildVersion(segment.ProjectID, definition)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		segment.CurrentVersion = 1
+		if err := tx.Create(segment).Error; err != nil {
+			return err
+		}
+		version.SegmentID = segment.ID
+		version.Version = 1
+		version.UserID = segment.UserID
+		return tx.Create(version).Error
+	})
+}
+
+// UpdateSegment 更新名称和描述；definition 不为空时保存为新版本
+func (s *SegmentService) UpdateSegment(segment *models.Segment, updates map[string]interface{}, definition *SegmentDefinition, userID uint) error {
+	var version *models.SegmentVersion
+	if definition != nil {
+		var err error
+		if version, err = s.buildVersion(segment.ProjectID, *definition); err != nil {
+			return err
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if version != nil {
+			// 以数据库中的当前版本为准，避免并发修改产生相同版本号
+			var current models.Segment
+			if err := tx.Select("id", "current_version").First(&current, segment.ID).Error; err != nil {
+				return err
+			}
+			version.SegmentID = segment.ID
+			version.Version = current.CurrentVersion + 1
+			version.UserID = userID
+			if err := tx.Create(version).Error; err != nil {
+				return err
+			}
+			updates["current_version"] = version.Version
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(segment).Updates(updates).Error
+	})
+}
+
+// ListSegments 获取项目中的分群
+func (s *SegmentService) ListSegments(projectID, userID uint) ([]models.Segment, error) {
+	var segments []models.Segment
+	err := s.db.Scopes(InProject(projectID, userID)).Order("updated_at DESC").Find(&segments).Error
+	return segments, err
+}
+
+// GetSegment 获取分群
+func (s *SegmentService) GetSegment(id uint) (*models.Segment, error) {
+	var segment models.Segment
+	err := s.db.First(&segment, id).Error
+	return &segment, err
+}
+
+// ListVersions 获取分群的全部版本，新版本在前
+func (s *SegmentService) ListVersions(segmentID uint) ([]models.SegmentVersion, error) {
+	var versions []models.SegmentVersion
+	err := s.db.Where("segment_id = ?", segmentID).Order("version DESC").Find(&versions).Error
+	return versions, err
+}
+
+// GetVersion 获取指定版本，version 为0时返回当前版本
+func (s *SegmentService) GetVersion(segment *models.Segment, version int) (*models.SegmentVersion, error) {
+	if version == 0 {
+		version = segment.CurrentVersion
+	}
+	var v models.SegmentVersion
+	err := s.db.Where("segment_id = ? AND version = ?", segment.ID, version).First(&v).Error
+	return &v, err
+}
+
+// DeleteSegment 删除分群，被未结束的任务使用时拒绝删除
+func (s *SegmentService) DeleteSegment(id uint) error {
+	var running int64
+	s.db.Model(&models.EmailTask{}).
+		Where("segment_id = ? AND status IN ?", id, []string{"pending", "running", "paused"}).
+		Count(&running)
+	if running > 0 {
+		return fmt.Errorf("分群正被%d个未结束的任务使用", running)
+	}
+	return s.db.Delete(&models.Segment{}, id).Error
+}
+
+// Preview 解析分群并返回数量和样本
+func (s *SegmentService) Preview(ctx context.Context, segment *models.Segment, version *models.SegmentVersion, sampleSize int) (*SegmentPreview, error) {
+	result, err := s.resolveVersion(ctx, segment.ProjectID, segment.UserID, version)
+	if err != nil {
+		return nil, err
+	}
+	return buildPreview(version.Version, result, sampleSize), nil
+}
+
+// PreviewDefinition 预览尚未保存的分群定义
+func (s *SegmentService) PreviewDefinition(ctx context.Context, projectID, userID uint, definition SegmentDefinition, sampleSize int) (*SegmentPreview, error) {
+	version, err := s.buildVersion(projectID, definition)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.resolveVersion(ctx, projectID, userID, version)
+	if err != nil {
+		return nil, err
+	}
+	return buildPreview(0, result, sampleSize), nil
+}
+
+// Resolve 在发送时解析分群的收件人数据，返回数据和实际使用的版本。
+// SQL结果超过数据源的行数上限时返回错误，不向部分收件人发送
+func (s *SegmentService) Resolve(ctx context.Context, segmentID uint, version int) ([]map[string]interface{}, int, error) {
+	segment, err := s.GetSegment(segmentID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("分群不存在: %w", err)
+	}
+	v, err := s.GetVersion(segment, version)
+	if err != nil {
+		return nil, 0, fmt.Errorf("分群版本不存在: %w", err)
+	}
+
+	result, err := s.resolveVersion(ctx, segment.ProjectID, segment.UserID, v)
+	if err != nil {
+		return nil, 0, err
+	}
+	if result.truncated {
+		return nil, 0, fmt.Errorf("分群结果超过数据源 %s 的行数上限，请提高 max_rows 或缩小分群条件", v.DataSource)
+	}
+	return result.rows, v.Version, nil
+}
+
+// resolveVersion 按版本类型执行SQL或过滤联系人，收件人地址与导入的数据一样检查：
+// 去掉无效和重复的地址，保留的地址规范化后写回记录
+func (s *SegmentService) resolveVersion(ctx context.Context, projectID, userID uint, version *models.SegmentVersion) (*segmentResult, error) {
+	result := &segmentResult{}
+	switch version.Type {
+	case SegmentTypeSQL:
+		if s.sources == nil {
+			return nil, fmt.Errorf("未配置数据源")
+		}
+		params := map[string]interface{}{}
+		if version.Params != "" {
+			json.Unmarshal([]byte(version.Params), &params)
+		}
+		query, err := s.sources.Query(ctx, projectID, version.DataSource, version.Query, params, 0)
+		if err != nil {
+			return nil, err
+		}
+		if !containsString(query.Columns, "email") {
+			return nil, fmt.Errorf("查询结果缺少email列")
+		}
+		result.rows, result.columns, result.truncated = query.Rows, query.Columns, query.Truncated
+
+	case SegmentTypeFilter:
+		var rules SegmentRules
+		if err := json.Unmarshal([]byte(version.Rules), &rules); err != nil {
+			return nil, fmt.Errorf("过滤规则格式错误: %w", err)
+		}
+		rows, columns, err := s.filterContacts(ctx, projectID, userID, rules)
+		if err != nil {
+			return nil, err
+		}
+		result.rows, result.columns = rows, columns
+
+	default:
+		return nil, fmt.Errorf("不支持的分群类型: %s", version.Type)
+	}
+
+	pipeline := newAddressPipeline(ctx, s.validator, 0, ValidationOptions{})
+	kept := result.rows[:0]
+	for _, record := range result.rows {
+		if pipeline.check(record) {
+			kept = append(kept, record)
+		}
+	}
+	result.rows = kept
+	result.removed = pipeline.report.Removed
+	return result, nil
+}
+
+// filterContacts 分批扫描项目中已订阅的联系人，返回匹配规则的记录
+func (s *SegmentService) filterContacts(ctx context.Context, projectID, userID uint, rules SegmentRules) ([]map[string]interface{}, []string, error) {
+	rows := []map[string]interface{}{}
+	columnSet := map[string]bool{"email": true, "tags": true}
+
+	var contacts []models.Contact
+	result := s.db.WithContext(ctx).Scopes(InProject(projectID, userID)).
+		Where("status = ?", ContactSubscribed).
+		FindInBatches(&contacts, 1000, func(tx *gorm.DB, batch int) error {
+			lists, err := s.contactLists(ctx, contacts)
+			if err != nil {
+				return err
+			}
+			for _, contact := range contacts {
+				attributes, tags := decodeContact(contact)
+				if !matchRules(rules, contact.Email, attributes, tags, lists[contact.ID]) {
+					continue
+				}
+
+				record := make(map[string]interface{}, len(attributes)+3)
+				for key, value := range attributes {
+					record[key] = value
+					columnSet[key] = true
+				}
+				record["email"] = contact.Email
+				record["tags"] = strings.Join(tags, ",")
+				record["contact_id"] = contact.ID
+				rows = append(rows, record)
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return nil, nil, result.Error
+	}
+
+	columns := make([]string, 0, len(columnSet))
+	for column := range columnSet {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return rows, columns, nil
+}
+
+// contactLists 获取一批联系人所属的列表ID
+func (s *SegmentService) contactLists(ctx context.Context, contacts []models.Contact) (map[uint][]string, error) {
+	ids := make([]uint, len(contacts))
+	for i, contact := range contacts {
+		ids[i] = contact.ID
+	}
+	var members []models.ContactListMember
+	if err := s.db.WithContext(ctx).Where("contact_id IN ?", ids).Find(&members).Error; err != nil {
+		return nil, err
+	}
+
+	lists := make(map[uint][]string)
+	for _, member := range members {
+		lists[member.ContactID] = append(lists[member.ContactID], strconv.FormatUint(uint64(member.ListID), 10))
+	}
+	return lists, nil
+}
+
+// buildVersion 校验分群定义并生成版本记录
+func (s *SegmentService) buildVersion(projectID uint, definition SegmentDefinition) (*models.SegmentVersion, error) {
+	version := &models.SegmentVersion{Type: definition.Type, Params: "{}", Rules: "{}"}
+
+	switch definition.Type {
+	case SegmentTypeSQL:
+		if s.sources == nil {
+			return nil, fmt.Errorf("未配置数据源")
+		}
+		dialect, err := s.sources.Dialect(projectID, definition.DataSource)
+		if err != nil {
+			return nil, err
+		}
+		if err := utils.CheckReadOnlyQuery(definition.Query, dialect); err != nil {
+			return nil, err
+		}
+		names, err := utils.QueryParams(definition.Query, dialect)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if _, ok := definition.Params[name]; !ok {
+				return nil, fmt.Errorf("缺少查询参数: %s", name)
+			}
+		}
+		params := definition.Params
+		if params == nil {
+			params = map[string]interface{}{}
+		}
+		paramsJSON, _ := json.Marshal(params)
+		version.DataSource = definition.DataSource
+		version.Query = definition.Query
+		version.Params = string(paramsJSON)
+
+	case SegmentTypeFilter:
+		if definition.Rules == nil {
+			return nil, fmt.Errorf("过滤规则不能为空")
+		}
+		if err := validateRules(*definition.Rules); err != nil {
+			return nil, err
+		}
+		rulesJSON, _ := json.Marshal(definition.Rules)
+		version.Rules = string(rulesJSON)
+
+	default:
+		return nil, fmt.Errorf("不支持的分群类型: %s", definition.Type)
+	}
+	return version, nil
+}
+
+func validateRules(rules SegmentRules) error {
+	if rules.Match != "" && rules.Match != "all" && rules.Match != "any" {
+		return fmt.Errorf("match 只能是 all 或 any")
+	}
+	for i, condition := range rules.Conditions {
+		if condition.Field != "email" && condition.Field != "tags" && condition.Field != "lists" && !strings.HasPrefix(condition.Field, "attributes.") {
+			return fmt.Errorf("第%d个条件的字段无效: %s", i+1, condition.Field)
+		}
+		if !segmentOps[condition.Op] {
+			return fmt.Errorf("第%d个条件的操作符无效: %s", i+1, condition.Op)
+		}
+		if (condition.Field == "tags" || condition.Field == "lists") && condition.Op != "has" && condition.Op != "not_has" {
+			return fmt.Errorf("%s 只支持 has 和 not_has", condition.Field)
+		}
+		if (condition.Op == "in" || condition.Op == "not_in") && !isList(condition.Value) {
+			return fmt.Errorf("第%d个条件的 %s 需要数组值", i+1, condition.Op)
+		}
+	}
+	return nil
+}
+
+// matchRules 判断联系人是否满足规则，没有条件时匹配全部
+func matchRules(rules SegmentRules, email string, attributes map[string]interface{}, tags, lists []string) bool {
+	if len(rules.Conditions) == 0 {
+		return true
+	}
+	any := rules.Match == "any"
+	for _, condition := range rules.Conditions {
+		matched := matchCondition(condition, email, attributes, tags, lists)
+		if any && matched {
+			return true
+		}
+		if !any && !matched {
+			return false
+		}
+	}
+	return !any
+}
+
+func matchCondition(condition SegmentCondition, email string, attributes map[string]interface{}, tags, lists []string) bool {
+	switch condition.Field {
+	case "tags":
+		has := containsString(tags, fmt.Sprint(condition.Value))
+		return has == (condition.Op == "has")
+	case "lists":
+		has := containsString(lists, fmt.Sprint(condition.Value))
+		return has == (condition.Op == "has")
+	}
+
+	var value interface{}
+	exists := true
+	if condition.Field == "email" {
+		value = email
+	} else {
+		value, exists = attributes[strings.TrimPrefix(condition.Field, "attributes.")]
+	}
+
+	switch condition.Op {
+	case "exists":
+		return exists && fmt.Sprint(value) != ""
+	case "not_exists":
+		return !exists || fmt.Sprint(value) == ""
+	}
+	if !exists {
+		// 属性不存在时只有否定条件成立
+		return condition.Op == "neq" || condition.Op == "not_contains" || condition.Op == "not_in"
+	}
+
+	actual := strings.ToLower(fmt.Sprint(value))
+	expected := strings.ToLower(fmt.Sprint(condition.Value))
+	switch condition.Op {
+	case "eq":
+		return actual == expected
+	case "neq":
+		return actual != expected
+	case "contains":
+		return strings.Contains(actual, expected)
+	case "not_contains":
+		return !strings.Contains(actual, expected)
+	case "starts_with":
+		return strings.HasPrefix(actual, expected)
+	case "ends_with":
+		return strings.HasSuffix(actual, expected)
+	case "in", "not_in":
+		found := false
+		for _, item := range condition.Value.([]interface{}) {
+			if strings.ToLower(fmt.Sprint(item)) == actual {
+				found = true
+				break
+			}
+		}
+		return found == (condition.Op == "in")
+	case "gt", "gte", "lt", "lte":
+		return compareValues(actual, expected, condition.Op)
+	}
+	return false
+}
+
+// compareValues 两边都是数字时按数值比较，否则按字符串比较（适用于日期）
+func compareValues(actual, expected, op string) bool {
+	var cmp int
+	a, errA := strconv.ParseFloat(actual, 64)
+	b, errB := strconv.ParseFloat(expected, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(actual, expected)
+	}
+
+	switch op {
+	case "gt":
+		return cmp > 0
+	case "gte":
+		return cmp >= 0
+	case "lt":
+		return cmp < 0
+	default:
+		return cmp <= 0
+	}
+}
+
+// decodeContact 解析联系人的属性和标签
+func decodeContact(contact models.Contact) (map[string]interface{}, []string) {
+	attributes := map[string]interface{}{}
+	if contact.Attributes != "" {
+		json.Unmarshal([]byte(contact.Attributes), &attributes)
+	}
+	var tags []string
+	if contact.Tags != "" {
+		json.Unmarshal([]byte(contact.Tags), &tags)
+	}
+	return attributes, tags
+}
+
+func buildPreview(version int, result *segmentResult, sampleSize int) *SegmentPreview {
+	if sampleSize <= 0 {
+		sampleSize = defaultSampleSize
+	}
+	sample := result.rows
+	if len(sample) > sampleSize {
+		sample = sample[:sampleSize]
+	}
+	return &SegmentPreview{
+		Version:   version,
+		Count:     len(result.rows),
+		Truncated: result.truncated,
+		Removed:   result.removed,
+		Columns:   result.columns,
+		Sample:    sample,
+	}
+}
+
+func isList(value interface{}) bool {
+	_, ok := value.([]interface{})
+	return ok
+}
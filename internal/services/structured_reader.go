@@ -0,0 +1,453 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// structuredSampleSize 确定JSON和Parquet文件列名时预读的记录数
+const structuredSampleSize = 100
+
+// structuredRecordReader 读取JSON数组、JSON Lines和Parquet文件。
+// 嵌套对象展开为点号分隔的列名（如 address.city），标量数组用逗号连接，
+// 对象数组按下标展开（如 orders.0.id）。列名取自前 structuredSampleSize 条记录
+type structuredRecordReader struct {
+	file    *os.File
+	counter *countingReader
+	next    func() (map[string]interface{}, error)
+	pending []structuredResult
+	columns []string
+}
+
+type structuredResult struct {
+	record map[string]interface{}
+	err    error
+}
+
+func newStructuredRecordReader(file *os.File, counter *countingReader, next func() (map[string]interface{}, error), columns []string) (*structuredRecordReader, error) {
+	reader := &structuredRecordReader{file: file, counter: counter, next: next}
+
+	seen := map[string]bool{}
+	for _, column := range columns {
+		seen[column] = true
+	}
+	for len(reader.pending) < structuredSampleSize {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*ImportRowError); err != nil && !ok {
+			file.Close()
+			return nil, err
+		}
+		reader.pending = append(reader.pending, structuredResult{record: record, err: err})
+
+		keys := make([]string, 0, len(record))
+		for key := range record {
+			if !seen[key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			seen[key] = true
+			columns = append(columns, key)
+		}
+	}
+	if len(reader.pending) == 0 {
+		file.Close()
+		return nil, fmt.Errorf("文件中没有数据")
+	}
+	reader.columns = columns
+	return reader, nil
+}
+
+func (r *structuredRecordReader) Columns() []string { return r.columns }
+
+func (r *structuredRecordReader) Read() (map[string]interface{}, error) {
+	var record map[string]interface{}
+	var err error
+	if len(r.pending) > 0 {
+		record, err = r.pending[0].record, r.pending[0].err
+		r.pending = r.pending[1:]
+	} else {
+		record, err = r.next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 预读确定的列在每条记录中都存在，缺少时补空字符串
+	for _, column := range r.columns {
+		if _, ok := record[column]; !ok {
+			record[column] = ""
+		}
+	}
+	return record, nil
+}
+
+func (r *structuredRecordReader) BytesRead() int64 {
+	if r.counter == nil {
+		return 0
+	}
+	return r.counter.n
+}
+
+func (r *structuredRecordReader) Close() error { return r.file.Close() }
+
+// newJSONRecordReader 读取对象数组，出错行号为数组中的元素序号（从1开始）
+func newJSONRecordReader(path string) (*structuredRecordReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingReader{r: file}
+	decoder := json.NewDecoder(skipBOM(counter))
+	decoder.UseNumber()
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		file.Close()
+		return nil, fmt.Errorf("JSON文件必须是对象数组")
+	}
+
+	index := 0
+	next := func() (map[string]interface{}, error) {
+		if !decoder.More() {
+			return nil, io.EOF
+		}
+		index++
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			// 数组中的语法错误无法跳过
+			return nil, fmt.Errorf("第%d个元素JSON格式错误: %w", index, err)
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, &ImportRowError{Line: index, Message: "不是JSON对象"}
+		}
+		return flattenRecord(object), nil
+	}
+	return newStructuredRecordReader(file, counter, next, nil)
+}
+
+// newJSONLinesRecordReader 每行一个JSON对象，空行被跳过，格式错误的行单独报告
+func newJSONLinesRecordReader(path string) (*structuredRecordReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingReader{r: file}
+	buffered := bufio.NewReader(skipBOM(counter))
+
+	line := 0
+	next := func() (map[string]interface{}, error) {
+		for {
+			data, err := buffered.ReadBytes('\n')
+			if len(data) == 0 && err != nil {
+				return nil, err
+			}
+			line++
+			data = bytes.TrimSpace(data)
+			if len(data) == 0 {
+				continue
+			}
+
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.UseNumber()
+			var value interface{}
+			if err := decoder.Decode(&value); err != nil {
+				return nil, &ImportRowError{Line: line, Message: "JSON格式错误: " + err.Error()}
+			}
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, &ImportRowError{Line: line, Message: "不是JSON对象"}
+			}
+			return flattenRecord(object), nil
+		}
+	}
+	return newStructuredRecordReader(file, counter, next, nil)
+}
+
+func skipBOM(r io.Reader) io.Reader {
+	buffered := bufio.NewReader(r)
+	if bom, err := buffered.Peek(3); err == nil && bytes.Equal(bom, []byte{0xEF, 0xBB, 0xBF}) {
+		buffered.Discard(3)
+	}
+	return buffered
+}
+
+// newParquetRecordReader 按行读取Parquet文件，时间戳、日期和小数按逻辑类型转换
+func newParquetRecordReader(path string) (*structuredRecordReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	parquetFile, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("无法读取Parquet文件: %w", err)
+	}
+
+	schema := parquetFile.Schema()
+	reader := parquet.NewReader(parquetFile)
+	next := func() (map[string]interface{}, error) {
+		row := map[string]interface{}{}
+		if err := reader.Read(&row); err != nil {
+			return nil, err
+		}
+		return flattenRecord(parquetValue(schema, row).(map[string]interface{})), nil
+	}
+
+	// Parquet文件有结构定义，按字段顺序排列顶层列
+	var columns []string
+	for _, field := range schema.Fields() {
+		if field.Leaf() && !field.Repeated() {
+			columns = append(columns, field.Name())
+		}
+	}
+	return newStructuredRecordReader(file, nil, next, columns)
+}
+
+// parquetValue 按结构定义转换读取到的值：展开LIST和MAP，转换时间、日期和小数
+func parquetValue(node parquet.Node, value interface{}) interface{} {
+	if values, ok := value.([]interface{}); ok && node.Repeated() {
+		converted := make([]interface{}, len(values))
+		for i, item := range values {
+			converted[i] = parquetNodeValue(node, item)
+		}
+		return converted
+	}
+	return parquetNodeValue(node, value)
+}
+
+func parquetNodeValue(node parquet.Node, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	logical := node.Type().LogicalType()
+
+	if !node.Leaf() {
+		group, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		// 从文件读取的结构不保留组的LIST注解，只有一个重复字段的组按列表处理
+		fields := node.Fields()
+		if len(fields) == 1 && fields[0].Repeated() {
+			keyValue := fields[0]
+			isMap := logical != nil && logical.Map != nil ||
+				keyValue.Name() == "key_value" && !keyValue.Leaf() && len(keyValue.Fields()) == 2
+			if _, nested := group[keyValue.Name()]; isMap && !nested && len(keyValue.Fields()) == 2 {
+				// MAP 读取时已经组装为键值对
+				converted := make(map[string]interface{}, len(group))
+				for key, item := range group {
+					converted[key] = parquetValue(keyValue.Fields()[1], item)
+				}
+				return converted
+			}
+			return parquetCollection(keyValue, group[keyValue.Name()], isMap)
+		}
+		converted := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			converted[field.Name()] = parquetValue(field, group[field.Name()])
+		}
+		return converted
+	}
+
+	if logical == nil {
+		if data, ok := value.([]byte); ok {
+			return string(data)
+		}
+		return value
+	}
+	switch {
+	case logical.Timestamp != nil:
+		if n, ok := parquetInt(value); ok {
+			return parquetTime(n, logical.Timestamp.Unit).UTC().Format("2006-01-02 15:04:05")
+		}
+	case logical.Date != nil:
+		if n, ok := parquetInt(value); ok {
+			return time.Unix(n*86400, 0).UTC().Format("2006-01-02")
+		}
+	case logical.Time != nil:
+		if n, ok := parquetInt(value); ok {
+			return parquetTime(n, logical.Time.Unit).UTC().Format("15:04:05")
+		}
+	case logical.Decimal != nil:
+		return parquetDecimal(value, int(logical.Decimal.Scale))
+	}
+	if data, ok := value.([]byte); ok {
+		return string(data)
+	}
+	return value
+}
+
+// parquetCollection 展开LIST的 list.element 和MAP的 key_value 结构
+func parquetCollection(repeated parquet.Node, value interface{}, isMap bool) interface{} {
+	items, _ := value.([]interface{})
+	fields := repeated.Fields()
+
+	if isMap {
+		converted := map[string]interface{}{}
+		if len(fields) != 2 {
+			return converted
+		}
+		for _, item := range items {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			key := parquetNodeValue(fields[0], entry[fields[0].Name()])
+			converted[fmt.Sprint(key)] = parquetValue(fields[1], entry[fields[1].Name()])
+		}
+		return converted
+	}
+
+	converted := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		// 三层结构的 list 组只有一个 element 字段，旧的两层结构直接是元素
+		if entry, ok := item.(map[string]interface{}); ok && !repeated.Leaf() && len(fields) == 1 {
+			converted = append(converted, parquetValue(fields[0], entry[fields[0].Name()]))
+		} else {
+			converted = append(converted, parquetNodeValue(repeated, item))
+		}
+	}
+	return converted
+}
+
+func parquetInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func parquetTime(n int64, unit format.TimeUnit) time.Time {
+	switch {
+	case unit.Millis != nil:
+		return time.UnixMilli(n)
+	case unit.Micros != nil:
+		return time.UnixMicro(n)
+	}
+	return time.Unix(0, n)
+}
+
+// parquetDecimal 把未缩放的整数或大端字节转换为小数文本，避免浮点误差
+func parquetDecimal(value interface{}, scale int) interface{} {
+	unscaled := new(big.Int)
+	switch v := value.(type) {
+	case int32:
+		unscaled.SetInt64(int64(v))
+	case int64:
+		unscaled.SetInt64(v)
+	case []byte:
+		unscaled.SetBytes(v)
+		// 二进制补码表示的负数
+		if len(v) > 0 && v[0]&0x80 != 0 {
+			unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(v)*8)))
+		}
+	default:
+		return value
+	}
+	return new(big.Rat).SetFrac(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)).FloatString(scale)
+}
+
+// flattenRecord 展开嵌套对象
+func flattenRecord(object map[string]interface{}) map[string]interface{} {
+	record := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		flattenValue(record, key, value)
+	}
+	return record
+}
+
+func flattenValue(record map[string]interface{}, key string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			record[key] = ""
+		}
+		for child, item := range v {
+			flattenValue(record, key+"."+child, item)
+		}
+	case []interface{}:
+		if scalars, ok := scalarStrings(v); ok {
+			record[key] = strings.Join(scalars, ", ")
+			return
+		}
+		for i, item := range v {
+			flattenValue(record, key+"."+strconv.Itoa(i), item)
+		}
+	default:
+		record[key] = scalarValue(value)
+	}
+}
+
+// scalarStrings 数组元素都是标量时返回它们的文本
+func scalarStrings(values []interface{}) ([]string, bool) {
+	texts := make([]string, 0, len(values))
+	for _, value := range values {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, false
+		}
+		texts = append(texts, FormatValue(scalarValue(value)))
+	}
+	return texts, true
+}
+
+// scalarValue 把 json.Number 转换为整数或浮点数，null 转换为空字符串
+func scalarValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil && !math.IsInf(f, 0) {
+			return f
+		}
+		return v.String()
+	}
+	return value
+}
+
+// FormatValue 把记录中的值转换为模板中显示的文本，整数形式的浮点数不带小数和指数
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case json.Number:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
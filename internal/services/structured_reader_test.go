@@ -0,0 +1,232 @@
+package services
+
+import (
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestFlattenRecord(t *testing.T) {
+	object := map[string]interface{}{
+		"email": "a@example.com",
+		"address": map[string]interface{}{
+			"city": "Beijing",
+			"geo":  map[string]interface{}{"lat": 39.9},
+		},
+		"tags":    []interface{}{"vip", float64(2024), true},
+		"orders":  []interface{}{map[string]interface{}{"id": "o1"}, map[string]interface{}{"id": "o2", "items": []interface{}{"a", "b"}}},
+		"mixed":   []interface{}{"x", map[string]interface{}{"y": "z"}},
+		"empty":   map[string]interface{}{},
+		"none":    nil,
+		"nothing": []interface{}{},
+	}
+	want := map[string]interface{}{
+		"email":           "a@example.com",
+		"address.city":    "Beijing",
+		"address.geo.lat": 39.9,
+		"tags":            "vip, 2024, true",
+		"orders.0.id":     "o1",
+		"orders.1.id":     "o2",
+		"orders.1.items":  "a, b",
+		"mixed.0":         "x",
+		"mixed.1.y":       "z",
+		"empty":           "",
+		"none":            "",
+		"nothing":         "",
+	}
+	if got := flattenRecord(object); !reflect.DeepEqual(got, want) {
+		t.Errorf("flattenRecord = %v, want %v", got, want)
+	}
+}
+
+func TestJSONRecordReader(t *testing.T) {
+	data := "\xEF\xBB\xBF[" +
+		`{"email": "a@example.com", "profile": {"name": "Ann", "age": 30}, "score": 1.5},` +
+		`"not an object",` +
+		`{"email": "b@example.com", "id": 12345678901234567890, "vip": true}` +
+		"]"
+	reader, err := OpenRecordReader(writeImportFile(t, "data.json", []byte(data)), "data.json", ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// 列按记录出现的顺序，同一记录内按名称排序
+	if columns := reader.Columns(); !reflect.DeepEqual(columns, []string{"email", "profile.age", "profile.name", "score", "id", "vip"}) {
+		t.Errorf("Columns = %v", columns)
+	}
+	records, rowErrors := readAllRecords(t, reader)
+	want := []map[string]interface{}{
+		{"email": "a@example.com", "profile.age": int64(30), "profile.name": "Ann", "score": 1.5, "id": "", "vip": ""},
+		{"email": "b@example.com", "profile.age": "", "profile.name": "", "score": "", "id": float64(12345678901234567890), "vip": true},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %v, want %v", records, want)
+	}
+	if len(rowErrors) != 1 || rowErrors[0].Line != 2 {
+		t.Errorf("row errors = %v, want element 2", rowErrors)
+	}
+}
+
+func TestJSONRecordReaderErrors(t *testing.T) {
+	tests := map[string]string{
+		"not an array": `{"email": "a@example.com"}`,
+		"empty array":  `[]`,
+		"syntax error": `[{"email": "a@example.com"}, {"email": }]`,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			reader, err := OpenRecordReader(writeImportFile(t, "data.json", []byte(data)), "data.json", ReaderOptions{})
+			if err != nil {
+				return
+			}
+			defer reader.Close()
+			for {
+				if _, err = reader.Read(); err != nil {
+					break
+				}
+			}
+			if _, ok := err.(*ImportRowError); ok || err == io.EOF {
+				t.Errorf("err = %v, want a fatal error", err)
+			}
+		})
+	}
+}
+
+func TestJSONLinesRecordReader(t *testing.T) {
+	data := `{"email": "a@example.com", "address": {"city": "Beijing"}}` + "\n" +
+		"\n" +
+		`{"email": "b@example.com", "tags": ["x", "y"]}` + "\r\n" +
+		`{"email": ` + "\n" +
+		`[1, 2]` + "\n" +
+		`{"email": "c@example.com"}`
+	reader, err := OpenRecordReader(writeImportFile(t, "data.jsonl", []byte(data)), "data.jsonl", ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if columns := reader.Columns(); !reflect.DeepEqual(columns, []string{"address.city", "email", "tags"}) {
+		t.Errorf("Columns = %v", columns)
+	}
+	records, rowErrors := readAllRecords(t, reader)
+	want := []map[string]interface{}{
+		{"email": "a@example.com", "address.city": "Beijing", "tags": ""},
+		{"email": "b@example.com", "address.city": "", "tags": "x, y"},
+		{"email": "c@example.com", "address.city": "", "tags": ""},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %v, want %v", records, want)
+	}
+	var lines []int
+	for _, rowErr := range rowErrors {
+		lines = append(lines, rowErr.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{4, 5}) {
+		t.Errorf("row error lines = %v, want [4 5]", lines)
+	}
+}
+
+type parquetAddress struct {
+	City string `parquet:"city"`
+	Zip  string `parquet:"zip,optional"`
+}
+
+type parquetContact struct {
+	Email    string         `parquet:"email"`
+	Address  parquetAddress `parquet:"address"`
+	Tags     []string       `parquet:"tags,list"`
+	JoinedAt time.Time      `parquet:"joined_at,timestamp(millisecond)"`
+	Birthday int32          `parquet:"birthday,date"`
+	Amount   int64          `parquet:"amount,decimal(2:18)"`
+}
+
+func TestParquetRecordReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.parquet")
+	rows := []parquetContact{
+		{
+			Email:    "a@example.com",
+			Address:  parquetAddress{City: "Beijing", Zip: "100000"},
+			Tags:     []string{"vip", "new"},
+			JoinedAt: time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC),
+			Birthday: 10957, // 2000-01-01
+			Amount:   -12345,
+		},
+		{Email: "b@example.com", JoinedAt: time.Unix(0, 0)},
+	}
+	if err := parquet.WriteFile(path, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := OpenRecordReader(path, "data.parquet", ReaderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	records, rowErrors := readAllRecords(t, reader)
+	if len(rowErrors) > 0 || len(records) != 2 {
+		t.Fatalf("records = %v, row errors = %v", records, rowErrors)
+	}
+	first := records[0]
+	expected := map[string]interface{}{
+		"email":        "a@example.com",
+		"address.city": "Beijing",
+		"address.zip":  "100000",
+		"tags":         "vip, new",
+		"joined_at":    "2024-05-01 08:30:00",
+		"birthday":     "2000-01-01",
+		"amount":       "-123.45",
+	}
+	for key, value := range expected {
+		if first[key] != value {
+			t.Errorf("%s = %#v, want %#v", key, first[key], value)
+		}
+	}
+	if columns := reader.Columns(); len(columns) == 0 || columns[0] != "email" {
+		t.Errorf("Columns = %v, want email first", columns)
+	}
+}
+
+func TestParquetDecimal(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		scale int
+		want  interface{}
+	}{
+		{value: int32(12345), scale: 2, want: "123.45"},
+		{value: int64(-5), scale: 3, want: "-0.005"},
+		{value: []byte{0x30, 0x39}, scale: 1, want: "1234.5"},
+		{value: []byte{0xFF, 0x85}, scale: 2, want: "-1.23"},
+		{value: "x", scale: 2, want: "x"},
+	}
+	for _, tt := range tests {
+		if got := parquetDecimal(tt.value, tt.scale); got != tt.want {
+			t.Errorf("parquetDecimal(%v, %d) = %v, want %v", tt.value, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, ""},
+		{"text", "text"},
+		{float64(1e21), "1000000000000000000000"},
+		{float64(2.50), "2.5"},
+		{float32(0.25), "0.25"},
+		{int64(7), "7"},
+		{true, "true"},
+	}
+	for _, tt := range tests {
+		if got := FormatValue(tt.value); got != tt.want {
+			t.Errorf("FormatValue(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go_market_email/internal/utils"
+)
+
+// 任务数据按块保存在Redis列表中，每个元素是一块记录的JSON数组，
+// 避免单个Redis值过大，发送时也可以逐块读取
+const (
+	taskDataChunkSize = 1000
+	taskDataTTL       = 24 * time.Hour
+)
+
+// 导入状态
+const (
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// ImportProgress 文件导入进度
+type ImportProgress struct {
+	TaskID     uint    `json:"task_id"`
+	DatasetID  uint    `json:"dataset_id"`
+	Status     string  `json:"status"`
+	Rows       int     `json:"rows"`
+	BytesRead  int64   `json:"bytes_read"`
+	TotalBytes int64   `json:"total_bytes"`
+	Percent    float64 `json:"percent"` // 按字节估算，无法统计字节数时为0
+	Error      string  `json:"error,omitempty"`
+	UpdatedAt  int64   `json:"updated_at"`
+}
+
+func taskDataKey(taskID uint) string {
+	return utils.EmailDataKey + strconv.FormatUint(uint64(taskID), 10)
+}
+
+// chunksKey 分块数据的列表键，base 为任务或数据集的数据键
+func chunksKey(base string) string {
+	return base + ":chunks"
+}
+
+func countKey(base string) string {
+	return base + ":count"
+}
+
+// TaskDataWriter 分块写入任务或数据集的数据。写入过程中数据保存在临时键，
+// Close 时整体替换原有数据，导入失败不会留下半份数据
+type TaskDataWriter struct {
+	rdb     *redis.Client
+	base    string
+	ttl     time.Duration
+	staging string
+	buffer  []map[string]interface{}
+	count   int
+}
+
+// NewTaskDataWriter 创建任务数据写入器
+func (s *DataService) NewTaskDataWriter(taskID uint) *TaskDataWriter {
+	return s.newDataWriter(taskDataKey(taskID), taskDataTTL)
+}
+
+func (s *DataService) newDataWriter(base string, ttl time.Duration) *TaskDataWriter {
+	return &TaskDataWriter{
+		rdb:     s.rdb,
+		base:    base,
+		ttl:     ttl,
+		staging: fmt.Sprintf("%s:staging:%d", chunksKey(base), time.Now().UnixNano()),
+		buffer:  make([]map[string]interface{}, 0, taskDataChunkSize),
+	}
+}
+
+// Write 追加一条记录，缓冲满一块时写入Redis
+func (w *TaskDataWriter) Write(record map[string]interface{}) error {
+	w.buffer = append(w.buffer, record)
+	w.count++
+	if len(w.buffer) >= taskDataChunkSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// Count 已写入的记录数
+func (w *TaskDataWriter) Count() int {
+	return w.count
+}
+
+func (w *TaskDataWriter) flush() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(w.buffer)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	pipe := w.rdb.TxPipeline()
+	pipe.RPush(ctx, w.staging, data)
+	pipe.Expire(ctx, w.staging, w.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	w.buffer = w.buffer[:0]
+	return nil
+}
+
+// Close 写入剩余记录并替换任务数据
+func (w *TaskDataWriter) Close() error {
+	if err := w.flush(); err != nil {
+		w.Abort()
+		return err
+	}
+
+	ctx := context.Background()
+	pipe := w.rdb.TxPipeline()
+	pipe.Del(ctx, w.base)
+	if w.count == 0 {
+		pipe.Del(ctx, chunksKey(w.base))
+	} else {
+		pipe.Rename(ctx, w.staging, chunksKey(w.base))
+		pipe.Expire(ctx, chunksKey(w.base), w.ttl)
+	}
+	pipe.Set(ctx, countKey(w.base), w.count, w.ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Abort 放弃写入，任务原有数据保持不变
+func (w *TaskDataWriter) Abort() {
+	w.rdb.Del(context.Background(), w.staging)
+}
+
+// deleteData 删除任务或数据集的全部数据
+func (s *DataService) deleteData(base string) error {
+	return s.rdb.Del(context.Background(), base, chunksKey(base), countKey(base)).Err()
+}
+
+// EachTaskDataChunk 逐块读取任务数据，兼容旧版本保存的整块数据
+func (s *DataService) EachTaskDataChunk(taskID uint, fn func([]map[string]interface{}) error) error {
+	return s.eachDataChunk(taskDataKey(taskID), fn)
+}
+
+func (s *DataService) eachDataChunk(base string, fn func([]map[string]interface{}) error) error {
+	ctx := context.Background()
+	chunks, err := s.rdb.LLen(ctx, chunksKey(base)).Result()
+	if err != nil {
+		return err
+	}
+
+	if chunks == 0 {
+		dataJSON, err := s.rdb.Get(ctx, base).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var data []map[string]interface{}
+		if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
+			return err
+		}
+		return fn(data)
+	}
+
+	for i := int64(0); i < chunks; i++ {
+		chunkJSON, err := s.rdb.LIndex(ctx, chunksKey(base), i).Result()
+		if err != nil {
+			return err
+		}
+		var chunk []map[string]interface{}
+		if err := json.Unmarshal([]byte(chunkJSON), &chunk); err != nil {
+			return err
+		}
+		if err := fn(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TaskDataCount 获取任务数据的记录数
+func (s *DataService) TaskDataCount(taskID uint) (int, error) {
+	return s.dataCount(taskDataKey(taskID))
+}
+
+func (s *DataService) dataCount(base string) (int, error) {
+	ctx := context.Background()
+	count, err := s.rdb.Get(ctx, countKey(base)).Int()
+	if err == nil {
+		return count, nil
+	}
+	if err != redis.Nil {
+		return 0, err
+	}
+
+	// 旧版本保存的整块数据没有计数
+	total := 0
+	err = s.eachDataChunk(base, func(chunk []map[string]interface{}) error {
+		total += len(chunk)
+		return nil
+	})
+	return total, err
+}
+
+func importProgressKey(taskID uint) string {
+	return utils.ImportProgressKey + strconv.FormatUint(uint64(taskID), 10)
+}
+
+func datasetProgressKey(datasetID uint) string {
+	return utils.ImportProgressKey + "dataset:" + strconv.FormatUint(uint64(datasetID), 10)
+}
+
+// saveImportProgress 保存导入进度，导入时指定了任务的同时按任务保存
+func (s *DataService) saveImportProgress(progress *ImportProgress) {
+	if progress.TotalBytes > 0 {
+		progress.Percent = float64(progress.BytesRead) * 100 / float64(progress.TotalBytes)
+	}
+	if progress.Status == ImportCompleted {
+		progress.Percent = 100
+	}
+	progress.UpdatedAt = time.Now().Unix()
+
+	data, _ := json.Marshal(progress)
+	ctx := context.Background()
+	if progress.DatasetID != 0 {
+		s.rdb.Set(ctx, datasetProgressKey(progress.DatasetID), data, taskDataTTL)
+	}
+	if progress.TaskID != 0 {
+		s.rdb.Set(ctx, importProgressKey(progress.TaskID), data, taskDataTTL)
+	}
+}
+
+// GetImportProgress 获取任务最近一次导入的进度
+func (s *DataService) GetImportProgress(taskID uint) (*ImportProgress, error) {
+	return s.loadImportProgress(importProgressKey(taskID))
+}
+
+// GetDatasetImportProgress 获取数据集的导入进度
+func (s *DataService) GetDatasetImportProgress(datasetID uint) (*ImportProgress, error) {
+	return s.loadImportProgress(datasetProgressKey(datasetID))
+}
+
+func (s *DataService) loadImportProgress(key string) (*ImportProgress, error) {
+	data, err := s.rdb.Get(context.Background(), key).Result()
+	if err != nil {
+		return nil, err
+	}
+	var progress ImportProgress
+	err = json.Unmarshal([]byte(data), &progress)
+	return &progress, err
+}
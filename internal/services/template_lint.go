@@ -0,0 +1,278 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go_market_email/internal/models"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// 检查结果的严重程度，分别扣10、5、1分
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+	LintInfo    = "info"
+)
+
+// lintRulePenaltyCap 同一规则最多扣的分数，避免一类问题把分数扣光
+const lintRulePenaltyCap = 30
+
+// lintMinTextPerImage 每张图片至少对应的文字数，低于时图片比例过高，容易被判为垃圾邮件
+const lintMinTextPerImage = 200
+
+var lintPenalties = map[string]int{LintError: 10, LintWarning: 5, LintInfo: 1}
+
+// 常见的垃圾邮件触发词，按不区分大小写的子串匹配主题和正文文字
+var spamPhrases = []string{
+	"100% free", "act now", "buy now", "cash bonus", "click here", "click below", "double your",
+	"earn money", "make money", "extra income", "free gift", "free money", "guaranteed",
+	"limited time", "no credit check", "risk-free", "risk free", "winner", "you have been selected",
+	"congratulations", "urgent", "once in a lifetime", "100% satisfied", "lowest price",
+	"免费领取", "立即购买", "点击这里", "限时抢购", "中奖", "恭喜您", "现金奖励", "轻松赚钱",
+	"日赚", "稳赚", "零风险", "保证收益", "最低价", "不容错过", "先到先得",
+}
+
+// 退订链接的关键字，出现在链接地址、链接文字或模板变量名中即可
+var unsubscribeKeywords = []string{"unsubscribe", "opt-out", "optout", "退订", "取消订阅"}
+
+// LintFinding 一条检查结果
+type LintFinding struct {
+	Rule     string `json:"rule"` // unknown_variable, broken_link, missing_alt, missing_unsubscribe, image_ratio, html_size, html_structure, spam_phrase
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// LintResult 模板检查结果，Score 为100减去各项扣分，最低为0
+type LintResult struct {
+	Score    int           `json:"score"`
+	Findings []LintFinding `json:"findings"`
+	Size     int           `json:"size"` // 处理后的HTML字节数
+}
+
+// LintTemplate 检查模板中的常见问题并打分。columns 为数据中可用的列，为 nil 时不检查未知变量。
+// 链接只检查格式，不发起请求
+func (s *TemplateService) LintTemplate(template *models.EmailTemplate, columns []string) *LintResult {
+	linter := &templateLinter{findings: []LintFinding{}}
+
+	if columns != nil {
+		known := map[string]bool{}
+		for _, column := range columns {
+			known[column] = true
+		}
+		for _, variable := range s.ExtractVariables(template.Subject + " " + template.Content) {
+			if !known[variable] {
+				linter.add("unknown_variable", LintError, fmt.Sprintf("变量 {{%s}} 在数据中不存在，发送时不会被替换", variable))
+			}
+		}
+	}
+
+	_, report := ProcessHTML(template.Content)
+	linter.size = report.Size
+	if report.Clipped {
+		linter.add("html_size", LintError, fmt.Sprintf("HTML大小 %d 字节超过 %d 字节，Gmail 会截断邮件", report.Size, GmailClipSize))
+	}
+	for _, element := range report.RemovedElements {
+		linter.add("html_structure", LintError, fmt.Sprintf("<%s> 元素会在发送前被删除", element))
+	}
+	for _, attribute := range report.RemovedAttributes {
+		linter.add("html_structure", LintError, fmt.Sprintf("%s 属性会在发送前被删除", attribute))
+	}
+	for _, tag := range report.UnclosedTags {
+		linter.add("html_structure", LintWarning, fmt.Sprintf("<%s> 缺少结束标签", tag))
+	}
+	for _, tag := range report.StrayEndTags {
+		linter.add("html_structure", LintWarning, fmt.Sprintf("</%s> 没有对应的开始标签", tag))
+	}
+
+	doc, err := html.Parse(strings.NewReader(template.Content))
+	if err == nil {
+		linter.walk(doc)
+	}
+
+	if template.Type != TemplateTypePDF {
+		// 纯文本内容没有链接标签，提到退订方式即可
+		plain := !strings.Contains(template.Content, "<") && containsKeyword([]string{template.Content}, unsubscribeKeywords)
+		if !linter.unsubscribe && !plain && !containsKeyword(s.ExtractVariables(template.Content), unsubscribeKeywords) {
+			linter.add("missing_unsubscribe", LintError, "缺少退订链接")
+		}
+		textLength := utf8.RuneCountInString(strings.Join(strings.FieldsFunc(linter.text.String(), unicode.IsSpace), ""))
+		switch {
+		case linter.images > 0 && textLength == 0:
+			linter.add("image_ratio", LintError, "邮件只有图片没有文字")
+		case linter.images > 0 && textLength < linter.images*lintMinTextPerImage:
+			linter.add("image_ratio", LintWarning, fmt.Sprintf("%d 张图片只有 %d 个文字，建议每张图片至少 %d 个文字", linter.images, textLength, lintMinTextPerImage))
+		}
+	}
+
+	linter.checkSpam(template.Subject, linter.text.String())
+	return linter.result()
+}
+
+type templateLinter struct {
+	findings    []LintFinding
+	penalties   map[string]int
+	size        int
+	images      int
+	unsubscribe bool
+	text        strings.Builder
+}
+
+func (l *templateLinter) add(rule, severity, message string) {
+	l.findings = append(l.findings, LintFinding{Rule: rule, Severity: severity, Message: message})
+	if l.penalties == nil {
+		l.penalties = map[string]int{}
+	}
+	l.penalties[rule] += lintPenalties[severity]
+}
+
+func (l *templateLinter) result() *LintResult {
+	score := 100
+	for _, penalty := range l.penalties {
+		if penalty > lintRulePenaltyCap {
+			penalty = lintRulePenaltyCap
+		}
+		score -= penalty
+	}
+	if score < 0 {
+		score = 0
+	}
+	return &LintResult{Score: score, Findings: l.findings, Size: l.size}
+}
+
+// walk 收集正文文字，检查链接、图片的 alt 和退订链接
+func (l *templateLinter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		l.text.WriteString(n.Data)
+		l.text.WriteString(" ")
+	case html.ElementNode:
+		switch n.DataAtom {
+		case atom.Script, atom.Style, atom.Head, atom.Title:
+			return
+		case atom.A, atom.Area:
+			href, ok := attribute(n, "href")
+			if !ok {
+				break
+			}
+			l.checkLink(n.Data, href, false)
+			if containsKeyword([]string{href, nodeText(n)}, unsubscribeKeywords) {
+				l.unsubscribe = true
+			}
+		case atom.Img:
+			src, _ := attribute(n, "src")
+			l.checkLink("img", src, true)
+			// 1x1 的跟踪像素不计入图片
+			width, _ := attribute(n, "width")
+			height, _ := attribute(n, "height")
+			if width != "1" || height != "1" {
+				l.images++
+				// alt="" 表示装饰性图片，不报告
+				if _, ok := attribute(n, "alt"); !ok {
+					l.add("missing_alt", LintWarning, fmt.Sprintf("图片 %s 缺少 alt 文字", src))
+				}
+			}
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		l.walk(child)
+	}
+}
+
+// checkLink 检查链接格式，包含模板变量的地址在发送时才确定，不做检查
+func (l *templateLinter) checkLink(tag, link string, image bool) {
+	link = strings.TrimSpace(link)
+	if strings.Contains(link, "{{") {
+		return
+	}
+	if link == "" || (!image && link == "#") {
+		l.add("broken_link", LintWarning, fmt.Sprintf("<%s> 的链接为空", tag))
+		return
+	}
+	if isScriptURL(link) {
+		l.add("broken_link", LintError, fmt.Sprintf("<%s> 使用脚本链接: %s", tag, link))
+		return
+	}
+	parsed, err := url.Parse(link)
+	if err != nil || strings.ContainsAny(link, " \t\r\n") {
+		l.add("broken_link", LintError, fmt.Sprintf("<%s> 的链接格式错误: %s", tag, link))
+		return
+	}
+
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		if parsed.Host == "" {
+			l.add("broken_link", LintError, fmt.Sprintf("<%s> 的链接缺少域名: %s", tag, link))
+		}
+	case "mailto", "tel":
+		if image {
+			l.add("broken_link", LintError, fmt.Sprintf("图片地址无效: %s", link))
+		}
+	case "cid", "data", "asset":
+		if !image {
+			l.add("broken_link", LintError, fmt.Sprintf("<%s> 的链接无法在邮件中打开: %s", tag, link))
+		}
+	case "":
+		// 图片的相对路径在发送时按图片资源改写，链接的相对路径在邮件中无法打开
+		if !image && !strings.HasPrefix(link, "#") {
+			l.add("broken_link", LintError, fmt.Sprintf("<%s> 使用相对地址，在邮件中无法打开: %s", tag, link))
+		}
+	default:
+		l.add("broken_link", LintWarning, fmt.Sprintf("<%s> 使用不常见的协议: %s", tag, link))
+	}
+}
+
+// checkSpam 检查垃圾邮件触发词、全大写主题和过多的感叹号
+func (l *templateLinter) checkSpam(subject, text string) {
+	content := strings.ToLower(subject + "\n" + text)
+	for _, phrase := range spamPhrases {
+		if strings.Contains(content, phrase) {
+			l.add("spam_phrase", LintWarning, fmt.Sprintf("包含常见的垃圾邮件用语: %s", phrase))
+		}
+	}
+
+	letters, upper := 0, 0
+	for _, r := range subject {
+		if unicode.IsLetter(r) && r < unicode.MaxASCII {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters >= 8 && upper == letters {
+		l.add("spam_phrase", LintWarning, "主题全部为大写字母")
+	}
+	if strings.Count(subject, "!")+strings.Count(subject, "！") >= 3 {
+		l.add("spam_phrase", LintWarning, "主题中感叹号过多")
+	}
+	if strings.Contains(content, "$$$") || strings.Contains(content, "￥￥￥") {
+		l.add("spam_phrase", LintWarning, "包含连续的货币符号")
+	}
+}
+
+func attribute(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// containsKeyword 判断任一值是否包含任一关键字，不区分大小写
+func containsKeyword(values []string, keywords []string) bool {
+	for _, value := range values {
+		value = strings.ToLower(value)
+		for _, keyword := range keywords {
+			if strings.Contains(value, keyword) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -3,12 +3,19 @@ package services
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"regexp"
 	"strings"
 	"go_market_email/internal/models"
 	"gorm.io/gorm"
 )
 
+// TemplateTypeEmail 邮件模板
+const TemplateTypeEmail = "email"
+
+// ErrInvalidTemplate 更新后的模板未通过校验
+var ErrInvalidTemplate = errors.New("模板无效")
+
 type TemplateService struct {
 	db *gorm.DB
 }
@@ -62,22 +69,16 @@ func (s *TemplateService) CreateTemplate(template *models.EmailTemplate) error {
 	return s.db.Create(template).Error
 }
 
-// UpdateTemplate 更新模板（创建新版本）
+// UpdateTemplate 更新模板（创建新版本），新版本与创建时一样校验，未通过时返回 ErrInvalidTemplate
 func (s *TemplateService) UpdateTemplate(id uint, updates *models.EmailTemplate) error {
 	var existing models.EmailTemplate
 	if err := s.db.First(&existing, id).Error; err != nil {
 		return err
 	}
 	
-	// 创建新版本
-	newTemplate := models.EmailTemplate{
-		Name:      existing.Name,
-		Subject:   updates.Subject,
-		Content:   updates.Content,
-		UserID:    existing.UserID,
-		ProjectID: existing.ProjectID,
-		Version:   existing.Version + 1,
-		Status:    "active",
+	newTemplate := nextTemplateVersion(existing, updates)
+	if err := s.ValidateTemplate(&newTemplate); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
 	}
 	
 	// 提取变量
@@ -91,6 +92,35 @@ func (s *TemplateService) UpdateTemplate(id uint, updates *models.EmailTemplate)
 	return s.db.Create(&newTemplate).Error
 }
 
+// nextTemplateVersion 按更新内容生成模板的下一个版本，名称和类型沿用原模板
+func nextTemplateVersion(existing models.EmailTemplate, updates *models.EmailTemplate) models.EmailTemplate {
+	newTemplate := models.EmailTemplate{
+		Name:      existing.Name,
+		Type:      existing.Type,
+		Subject:   updates.Subject,
+		Content:   updates.Content,
+		UserID:    existing.UserID,
+		ProjectID: existing.ProjectID,
+		Version:   existing.Version + 1,
+		Status:    "active",
+	}
+	
+	// 未指定附件时沿用上一版本的附件
+	newTemplate.AttachmentIDs = existing.AttachmentIDs
+	if updates.AttachmentIDs != nil {
+		newTemplate.AttachmentIDs = updates.AttachmentIDs
+	}
+	newTemplate.ImageMode = existing.ImageMode
+	if updates.ImageMode != "" {
+		newTemplate.ImageMode = updates.ImageMode
+	}
+	newTemplate.PDFTemplateIDs = existing.PDFTemplateIDs
+	if updates.PDFTemplateIDs != nil {
+		newTemplate.PDFTemplateIDs = updates.PDFTemplateIDs
+	}
+	return newTemplate
+}
+
 // GetTemplate 获取模板
 func (s *TemplateService) GetTemplate(id uint) (*models.EmailTemplate, error) {
 	var template models.EmailTemplate
@@ -98,13 +128,16 @@ func (s *TemplateService) GetTemplate(id uint) (*models.EmailTemplate, error) {
 	return &template, err
 }
 
-// ListTemplates 获取模板列表
-func (s *TemplateService) ListTemplates(userID, projectID uint, page, pageSize int) ([]models.EmailTemplate, int64, error) {
+// ListTemplates 获取模板列表，projectID为0时只列出用户的个人模板，templateType 不为空时只列出该类型
+func (s *TemplateService) ListTemplates(userID, projectID uint, templateType string, page, pageSize int) ([]models.EmailTemplate, int64, error) {
 	var templates []models.EmailTemplate
 	var total int64
 	
-	query := s.db.Model(&models.EmailTemplate{}).
-		Where("user_id = ? AND project_id = ? AND status = ?", userID, projectID, "active")
+	query := s.db.Model(&models.EmailTemplate{}).Scopes(InProject(projectID, userID)).
+		Where("status = ?", "active")
+	if templateType != "" {
+		query = query.Where("type = ?", templateType)
+	}
 	
 	query.Count(&total)
 	
@@ -115,6 +148,43 @@ func (s *TemplateService) ListTemplates(userID, projectID uint, page, pageSize i
 	return templates, total, err
 }
 
+// CheckPDFTemplates 检查附件模板都存在、类型为pdf且属于同一项目（个人空间为同一用户）
+func (s *TemplateService) CheckPDFTemplates(ids models.IDList, projectID, userID uint) error {
+	for _, id := range ids {
+		template, err := s.GetTemplate(id)
+		if err != nil {
+			return fmt.Errorf("附件模板不存在: %d", id)
+		}
+		if template.Type != TemplateTypePDF {
+			return fmt.Errorf("模板 %d 不是附件模板", id)
+		}
+		if template.ProjectID != projectID || (projectID == 0 && template.UserID != userID) {
+			return fmt.Errorf("附件模板不属于当前项目: %d", id)
+		}
+	}
+	return nil
+}
+
+// GetPDFTemplates 按顺序加载附件模板，重复的ID只保留一个
+func (s *TemplateService) GetPDFTemplates(lists ...models.IDList) ([]models.EmailTemplate, error) {
+	var templates []models.EmailTemplate
+	seen := map[uint]bool{}
+	for _, ids := range lists {
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			template, err := s.GetTemplate(id)
+			if err != nil || template.Type != TemplateTypePDF {
+				return nil, fmt.Errorf("附件模板不存在: %d", id)
+			}
+			templates = append(templates, *template)
+		}
+	}
+	return templates, nil
+}
+
 // GetTemplateVersions 获取模板版本历史
 func (s *TemplateService) GetTemplateVersions(name string, userID, projectID uint) ([]models.EmailTemplate, error) {
 	var templates []models.EmailTemplate
@@ -136,9 +206,7 @@ func (s *TemplateService) ReplaceVariables(template string, data map[string]inte
 	
 	for key, value := range data {
 		placeholder := "{{" + key + "}}"
-		if valueStr, ok := value.(string); ok {
-			result = strings.ReplaceAll(result, placeholder, valueStr)
-		}
+		result = strings.ReplaceAll(result, placeholder, FormatValue(value))
 	}
 	
 	return result
@@ -155,6 +223,15 @@ func (s *TemplateService) ValidateTemplate(template *models.EmailTemplate) error
 	if template.Content == "" {
 		return errors.New("邮件内容不能为空")
 	}
+	if template.Type != "" && template.Type != TemplateTypeEmail && template.Type != TemplateTypePDF {
+		return errors.New("模板类型只能是 email 或 pdf")
+	}
+	if template.Type == TemplateTypePDF && len(template.PDFTemplateIDs) > 0 {
+		return errors.New("附件模板不能再引用附件模板")
+	}
+	if template.ImageMode != "" && template.ImageMode != ImageModeEmbed && template.ImageMode != ImageModeHosted {
+		return errors.New("图片引用方式只能是 embed 或 hosted")
+	}
 	
 	// 检查模板大小
 	contentSize := len([]byte(template.Content))
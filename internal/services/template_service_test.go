@@ -0,0 +1,66 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"go_market_email/internal/models"
+)
+
+func TestNextTemplateVersion(t *testing.T) {
+	existing := models.EmailTemplate{
+		ID:            3,
+		Name:          "welcome",
+		Type:          TemplateTypeEmail,
+		Subject:       "Hi",
+		Content:       "<p>Hi</p>",
+		UserID:        1,
+		ProjectID:     2,
+		Version:       4,
+		ImageMode:     ImageModeHosted,
+		AttachmentIDs: models.IDList{7},
+	}
+
+	next := nextTemplateVersion(existing, &models.EmailTemplate{Name: "renamed", Type: TemplateTypePDF, Subject: "Hello", Content: "<p>Hello</p>"})
+	if next.ID != 0 || next.Name != "welcome" || next.Type != TemplateTypeEmail || next.Version != 5 || next.Status != "active" {
+		t.Errorf("unexpected version: %+v", next)
+	}
+	if next.Subject != "Hello" || next.ImageMode != ImageModeHosted || !reflect.DeepEqual(next.AttachmentIDs, models.IDList{7}) {
+		t.Errorf("omitted fields should be inherited: %+v", next)
+	}
+
+	next = nextTemplateVersion(existing, &models.EmailTemplate{Subject: "Hi", Content: "x", ImageMode: ImageModeEmbed, AttachmentIDs: models.IDList{}})
+	if next.ImageMode != ImageModeEmbed || len(next.AttachmentIDs) != 0 {
+		t.Errorf("given fields should replace the previous version: %+v", next)
+	}
+}
+
+func TestUpdatedTemplateValidation(t *testing.T) {
+	s := NewTemplateService(nil)
+	email := models.EmailTemplate{Name: "welcome", Type: TemplateTypeEmail, Subject: "Hi", Content: "<p>Hi</p>", Version: 1}
+	pdf := models.EmailTemplate{Name: "invoice", Type: TemplateTypePDF, Subject: "invoice-{{name}}.pdf", Content: "<p>{{amount}}</p>", Version: 1}
+
+	tests := []struct {
+		name     string
+		existing models.EmailTemplate
+		updates  models.EmailTemplate
+		ok       bool
+	}{
+		{name: "valid", existing: email, updates: models.EmailTemplate{Subject: "Hello", Content: "<p>Hello</p>", ImageMode: ImageModeEmbed}, ok: true},
+		{name: "invalid image mode", existing: email, updates: models.EmailTemplate{Subject: "Hello", Content: "<p>Hello</p>", ImageMode: "foo"}},
+		{name: "empty subject", existing: email, updates: models.EmailTemplate{Content: "<p>Hello</p>"}},
+		{name: "empty content", existing: email, updates: models.EmailTemplate{Subject: "Hello"}},
+		{name: "email references pdf templates", existing: email, updates: models.EmailTemplate{Subject: "Hello", Content: "x", PDFTemplateIDs: models.IDList{9}}, ok: true},
+		{name: "pdf update", existing: pdf, updates: models.EmailTemplate{Subject: "bill.pdf", Content: "<p>{{amount}}</p>"}, ok: true},
+		{name: "pdf references pdf templates", existing: pdf, updates: models.EmailTemplate{Subject: "bill.pdf", Content: "x", PDFTemplateIDs: models.IDList{9}}},
+		{name: "pdf type cannot be changed", existing: pdf, updates: models.EmailTemplate{Type: TemplateTypeEmail, Subject: "bill.pdf", Content: "x", PDFTemplateIDs: models.IDList{9}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := nextTemplateVersion(tt.existing, &tt.updates)
+			if err := s.ValidateTemplate(&next); (err == nil) != tt.ok {
+				t.Errorf("ValidateTemplate = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,598 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"go_market_email/internal/models"
+	"go_market_email/internal/utils"
+	"gorm.io/gorm"
+)
+
+// Webhook事件类型
+const (
+	EventTaskStarted   = "task.started"
+	EventTaskCompleted = "task.completed"
+	EventTaskFailed    = "task.failed"
+	EventTaskPaused    = "task.paused"
+	EventTaskResumed   = "task.resumed"
+	EventEmailSent     = "email.sent"
+	EventEmailFailed   = "email.failed"
+)
+
+// WebhookEvents 支持订阅的全部事件类型
+var WebhookEvents = []string{
+	EventTaskStarted,
+	EventTaskCompleted,
+	EventTaskFailed,
+	EventTaskPaused,
+	EventTaskResumed,
+	EventEmailSent,
+	EventEmailFailed,
+}
+
+// ErrDeliveryPending 投递记录已在队列中或正在投递
+var ErrDeliveryPending = errors.New("投递记录正在等待投递")
+
+// webhookSubscriptionCacheTTL 订阅列表的本地缓存时间。本进程修改订阅时立即失效，
+// 其他进程修改的订阅最多延迟这么久生效
+const webhookSubscriptionCacheTTL = 30 * time.Second
+
+// webhookRecoverInterval 检查投递中记录的间隔，投递进程退出后遗留的记录在超时后重新入队
+const webhookRecoverInterval = 30 * time.Second
+
+// subscriptionScope 订阅所属的项目，个人空间按用户区分
+type subscriptionScope struct {
+	projectID uint
+	userID    uint
+}
+
+type cachedSubscriptions struct {
+	subs      []models.WebhookSubscription
+	expiresAt time.Time
+}
+
+type WebhookService struct {
+	db     *gorm.DB
+	rdb    *redis.Client
+	config utils.WebhookConfig
+	logger *zap.Logger
+	client *http.Client // 投递订阅，连接时检查目标地址
+	// defaultClient 投递配置文件中的默认Webhook，地址由管理员配置，允许内网
+	defaultClient *http.Client
+
+	subsMu sync.Mutex
+	subs   map[subscriptionScope]cachedSubscriptions
+}
+
+func NewWebhookService(db *gorm.DB, rdb *redis.Client, config utils.WebhookConfig, logger *zap.Logger) *WebhookService {
+	timeout := time.Duration(config.Timeout) * time.Second
+	// 不跟随重定向，避免外网地址重定向到内网
+	client := newLinkHTTPClient(timeout, config.AllowPrivate)
+	client.Timeout = timeout
+	defaultClient := newLinkHTTPClient(timeout, true)
+	defaultClient.Timeout = timeout
+
+	return &WebhookService{
+		db:            db,
+		rdb:           rdb,
+		config:        config,
+		logger:        logger,
+		client:        client,
+		defaultClient: defaultClient,
+		subs:          make(map[subscriptionScope]cachedSubscriptions),
+	}
+}
+
+// CreateSubscription 创建Webhook订阅
+func (s *WebhookService) CreateSubscription(sub *models.WebhookSubscription, events []string) error {
+	if err := s.validateURL(sub.URL); err != nil {
+		return err
+	}
+	if err := s.validateEvents(events); err != nil {
+		return err
+	}
+	eventsJSON, _ := json.Marshal(events)
+	sub.Events = string(eventsJSON)
+	if err := s.db.Create(sub).Error; err != nil {
+		return err
+	}
+	s.invalidateSubscriptions()
+	return nil
+}
+
+// UpdateSubscription 更新Webhook订阅，权限已由中间件按订阅所属项目校验
+func (s *WebhookService) UpdateSubscription(id uint, updates map[string]interface{}, events []string) error {
+	if rawURL, ok := updates["url"].(string); ok {
+		if err := s.validateURL(rawURL); err != nil {
+			return err
+		}
+	}
+	if events != nil {
+		if err := s.validateEvents(events); err != nil {
+			return err
+		}
+		eventsJSON, _ := json.Marshal(events)
+		updates["events"] = string(eventsJSON)
+	}
+	result := s.db.Model(&models.WebhookSubscription{}).
+		Where("id = ?", id).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	s.invalidateSubscriptions()
+	return nil
+}
+
+// ListSubscriptions 获取项目的Webhook订阅，个人空间为用户自己的订阅
+func (s *WebhookService) ListSubscriptions(projectID, userID uint) ([]models.WebhookSubscription, error) {
+	var subs []models.WebhookSubscription
+	err := s.db.Scopes(InProject(projectID, userID)).Order("created_at DESC").Find(&subs).Error
+	return subs, err
+}
+
+// DeleteSubscription 删除Webhook订阅
+func (s *WebhookService) DeleteSubscription(id uint) error {
+	if err := s.db.Delete(&models.WebhookSubscription{}, id).Error; err != nil {
+		return err
+	}
+	s.invalidateSubscriptions()
+	return nil
+}
+
+// ListDeliveries 获取投递记录
+func (s *WebhookService) ListDeliveries(subscriptionID uint, status string, page, pageSize int) ([]models.WebhookDelivery, int64, error) {
+	query := s.db.Model(&models.WebhookDelivery{}).Where("subscription_id = ?", subscriptionID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var deliveries []models.WebhookDelivery
+	offset := (page - 1) * pageSize
+	err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&deliveries).Error
+	return deliveries, total, err
+}
+
+// GetSubscription 获取单个Webhook订阅
+func (s *WebhookService) GetSubscription(id uint) (*models.WebhookSubscription, error) {
+	var sub models.WebhookSubscription
+	err := s.db.First(&sub, id).Error
+	return &sub, err
+}
+
+// Replay 重新投递一条记录，权限已由中间件按订阅所属项目校验。
+// 等待重试的记录从重试集合中移除后再入队；已结束的记录按状态比较后重置，
+// 已在队列中或正在投递的记录返回 ErrDeliveryPending，避免重复投递
+func (s *WebhookService) Replay(deliveryID uint) error {
+	var delivery models.WebhookDelivery
+	if err := s.db.First(&delivery, deliveryID).Error; err != nil {
+		return err
+	}
+
+	reset := map[string]interface{}{
+		"status":        "pending",
+		"attempts":      0,
+		"next_retry_at": nil,
+	}
+	member := strconv.FormatUint(uint64(delivery.ID), 10)
+	removed, err := s.rdb.ZRem(context.Background(), utils.WebhookRetryKey, member).Result()
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		s.db.Model(&delivery).Updates(reset)
+		return s.enqueue(delivery.ID)
+	}
+
+	result := s.db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status IN ?", delivery.ID, []string{"success", "failed"}).
+		Updates(reset)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDeliveryPending
+	}
+	return s.enqueue(delivery.ID)
+}
+
+// Publish 发布事件，为事件所属项目（个人任务为任务创建者）中每个匹配的订阅创建投递记录并加入异步队列
+func (s *WebhookService) Publish(event string, projectID, userID uint, data map[string]interface{}) {
+	payload := map[string]interface{}{
+		"event":     event,
+		"data":      data,
+		"timestamp": time.Now().Unix(),
+	}
+	payloadJSON, _ := json.Marshal(payload)
+
+	var targets []models.WebhookDelivery
+
+	// 配置文件中的默认Webhook接收全部事件
+	if s.config.URL != "" {
+		targets = append(targets, models.WebhookDelivery{URL: s.config.URL})
+	}
+
+	subs, err := s.activeSubscriptions(projectID, userID)
+	if err != nil {
+		s.logger.Error("查询Webhook订阅失败", zap.Error(err))
+	}
+	for _, sub := range subs {
+		if subscribesTo(sub.Events, event) {
+			targets = append(targets, models.WebhookDelivery{SubscriptionID: sub.ID, URL: sub.URL})
+		}
+	}
+
+	for _, delivery := range targets {
+		delivery.Event = event
+		delivery.Payload = string(payloadJSON)
+		delivery.Status = "pending"
+		if err := s.db.Create(&delivery).Error; err != nil {
+			s.logger.Error("创建Webhook投递记录失败", zap.String("event", event), zap.Error(err))
+			continue
+		}
+		if err := s.enqueue(delivery.ID); err != nil {
+			s.logger.Error("Webhook加入队列失败", zap.Uint("deliveryID", delivery.ID), zap.Error(err))
+		}
+	}
+}
+
+// activeSubscriptions 获取项目中启用的订阅，发送过程中每个收件人都会发布事件，因此缓存查询结果
+func (s *WebhookService) activeSubscriptions(projectID, userID uint) ([]models.WebhookSubscription, error) {
+	scope := subscriptionScope{projectID: projectID}
+	if projectID == 0 {
+		scope.userID = userID
+	}
+
+	s.subsMu.Lock()
+	cached, ok := s.subs[scope]
+	s.subsMu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.subs, nil
+	}
+
+	var subs []models.WebhookSubscription
+	if err := s.db.Scopes(InProject(projectID, userID)).Where("status = ?", "active").Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	s.subsMu.Lock()
+	s.subs[scope] = cachedSubscriptions{subs: subs, expiresAt: time.Now().Add(webhookSubscriptionCacheTTL)}
+	s.subsMu.Unlock()
+	return subs, nil
+}
+
+func (s *WebhookService) invalidateSubscriptions() {
+	s.subsMu.Lock()
+	s.subs = make(map[subscriptionScope]cachedSubscriptions)
+	s.subsMu.Unlock()
+}
+
+// StartDispatcher 启动投递工作协程，直到ctx取消
+func (s *WebhookService) StartDispatcher(ctx context.Context) {
+	workers := s.config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go s.dispatchLoop(ctx)
+	}
+	go s.retryLoop(ctx)
+}
+
+// dispatchLoop 从队列中取出投递记录并发送。取出的记录同时移入投递中列表，
+// 投递结束（成功、失败或安排重试）后才移除，进程中途退出时由recoverClaimed重新入队
+func (s *WebhookService) dispatchLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		member, err := s.rdb.BRPopLPush(ctx, utils.WebhookQueueKey, utils.WebhookClaimedKey, 5*time.Second).Result()
+		if err != nil {
+			if err != redis.Nil && ctx.Err() == nil {
+				s.logger.Error("读取Webhook队列失败", zap.Error(err))
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		deliveryID, err := strconv.ParseUint(member, 10, 32)
+		if err != nil {
+			s.logger.Error("解析投递ID失败", zap.String("deliveryID", member), zap.Error(err))
+		} else {
+			s.deliver(uint(deliveryID))
+		}
+		s.rdb.LRem(context.Background(), utils.WebhookClaimedKey, 1, member)
+	}
+}
+
+// recoverClaimed 将投递中列表里停留超过投递超时的记录重新入队。
+// 正常投递在请求超时内结束并移出列表，超时仍在列表中说明取出它的进程已退出。
+// seen 记录本进程首次看到每条记录的时间，进程启动后遗留的记录同样在超时后恢复
+func (s *WebhookService) recoverClaimed(ctx context.Context, seen map[string]time.Time) {
+	members, err := s.rdb.LRange(ctx, utils.WebhookClaimedKey, 0, -1).Result()
+	if err != nil {
+		return
+	}
+
+	staleAfter := time.Duration(s.config.Timeout)*time.Second + time.Minute
+	now := time.Now()
+	current := make(map[string]bool, len(members))
+	for _, member := range members {
+		current[member] = true
+		firstSeen, ok := seen[member]
+		if !ok {
+			seen[member] = now
+			continue
+		}
+		if now.Sub(firstSeen) < staleAfter {
+			continue
+		}
+		delete(seen, member)
+
+		// 只有成功移除的进程负责处理，避免多进程重复入队
+		if removed, _ := s.rdb.LRem(ctx, utils.WebhookClaimedKey, 1, member).Result(); removed == 0 {
+			continue
+		}
+		// 已结束或已安排重试的记录不再入队
+		var delivery models.WebhookDelivery
+		if err := s.db.First(&delivery, member).Error; err != nil || delivery.Status != "pending" {
+			continue
+		}
+		if err := s.rdb.ZScore(ctx, utils.WebhookRetryKey, member).Err(); err == nil {
+			continue
+		}
+		s.logger.Warn("Webhook投递中断，重新入队", zap.Uint("deliveryID", delivery.ID))
+		s.rdb.LPush(ctx, utils.WebhookQueueKey, member)
+	}
+	for member := range seen {
+		if !current[member] {
+			delete(seen, member)
+		}
+	}
+}
+
+// retryLoop 将到期的重试记录和中断的投递移回投递队列
+func (s *WebhookService) retryLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	recoverTicker := time.NewTicker(webhookRecoverInterval)
+	defer recoverTicker.Stop()
+
+	seen := make(map[string]time.Time)
+	s.recoverClaimed(ctx, seen)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-recoverTicker.C:
+			s.recoverClaimed(ctx, seen)
+		case <-ticker.C:
+			now := strconv.FormatInt(time.Now().Unix(), 10)
+			ids, err := s.rdb.ZRangeByScore(ctx, utils.WebhookRetryKey, &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+			if err != nil {
+				continue
+			}
+			for _, id := range ids {
+				// 只有成功移除的协程才负责重新入队，避免多进程重复投递
+				if removed, _ := s.rdb.ZRem(ctx, utils.WebhookRetryKey, id).Result(); removed > 0 {
+					s.rdb.LPush(ctx, utils.WebhookQueueKey, id)
+				}
+			}
+		}
+	}
+}
+
+// deliver 执行一次投递，失败时按指数退避安排重试
+func (s *WebhookService) deliver(deliveryID uint) {
+	var delivery models.WebhookDelivery
+	if err := s.db.First(&delivery, deliveryID).Error; err != nil {
+		s.logger.Error("投递记录不存在", zap.Uint("deliveryID", deliveryID), zap.Error(err))
+		return
+	}
+	if delivery.Status == "success" {
+		return
+	}
+
+	secret := s.config.Secret
+	if delivery.SubscriptionID != 0 {
+		var sub models.WebhookSubscription
+		if err := s.db.First(&sub, delivery.SubscriptionID).Error; err != nil {
+			s.finishDelivery(&delivery, "failed", 0, "", "订阅已删除")
+			return
+		}
+		secret = sub.Secret
+	}
+
+	statusCode, body, err := s.post(delivery, secret)
+	delivery.Attempts++
+
+	if err == nil && statusCode >= 200 && statusCode < 300 {
+		s.finishDelivery(&delivery, "success", statusCode, body, "")
+		return
+	}
+
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	} else {
+		errMsg = fmt.Sprintf("非2xx响应: %d", statusCode)
+	}
+
+	if delivery.Attempts > s.config.MaxRetries {
+		s.logger.Warn("Webhook投递最终失败",
+			zap.Uint("deliveryID", delivery.ID),
+			zap.String("event", delivery.Event),
+			zap.Int("attempts", delivery.Attempts),
+			zap.String("error", errMsg))
+		s.finishDelivery(&delivery, "failed", statusCode, body, errMsg)
+		return
+	}
+
+	// 指数退避：base * 2^(attempts-1)
+	delay := time.Duration(s.config.RetryBaseDelay) * time.Second << (delivery.Attempts - 1)
+	nextRetry := time.Now().Add(delay)
+	s.db.Model(&delivery).Updates(map[string]interface{}{
+		"attempts":        delivery.Attempts,
+		"response_status": statusCode,
+		"response_body":   body,
+		"error":           errMsg,
+		"next_retry_at":   &nextRetry,
+	})
+	s.rdb.ZAdd(context.Background(), utils.WebhookRetryKey, &redis.Z{
+		Score:  float64(nextRetry.Unix()),
+		Member: delivery.ID,
+	})
+}
+
+// post 发送签名后的请求，返回状态码和截断后的响应体
+func (s *WebhookService) post(delivery models.WebhookDelivery, secret string) (int, string, error) {
+	req, err := http.NewRequest("POST", delivery.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(int(delivery.ID)))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhookPayload(secret, timestamp, []byte(delivery.Payload)))
+	}
+
+	client := s.client
+	if delivery.SubscriptionID == 0 {
+		client = s.defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, string(body), nil
+}
+
+func (s *WebhookService) finishDelivery(delivery *models.WebhookDelivery, status string, statusCode int, body, errMsg string) {
+	updates := map[string]interface{}{
+		"status":          status,
+		"attempts":        delivery.Attempts,
+		"response_status": statusCode,
+		"response_body":   body,
+		"error":           errMsg,
+		"next_retry_at":   nil,
+	}
+	if status == "success" {
+		now := time.Now()
+		updates["delivered_at"] = &now
+	}
+	s.db.Model(delivery).Updates(updates)
+}
+
+func (s *WebhookService) enqueue(deliveryID uint) error {
+	return s.rdb.LPush(context.Background(), utils.WebhookQueueKey, deliveryID).Err()
+}
+
+// validateURL 订阅地址只能是 http(s)，未开启 allow_private 时主机不能解析到本机、内网或链路本地地址。
+// 投递时连接层会再次检查实际连接的地址，防止校验后DNS解析结果改变
+func (s *WebhookService) validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("Webhook地址必须是http或https地址")
+	}
+	if s.config.AllowPrivate {
+		return nil
+	}
+
+	host := u.Hostname()
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return fmt.Errorf("无法解析Webhook地址: %s", host)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return fmt.Errorf("Webhook地址不能指向内网或本机地址: %s", host)
+		}
+	}
+	return nil
+}
+
+func (s *WebhookService) validateEvents(events []string) error {
+	if len(events) == 0 {
+		return fmt.Errorf("至少需要订阅一个事件")
+	}
+	for _, event := range events {
+		if event == "*" {
+			continue
+		}
+		valid := false
+		for _, known := range WebhookEvents {
+			if event == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("未知的事件类型: %s", event)
+		}
+	}
+	return nil
+}
+
+// SignWebhookPayload 计算签名：HMAC-SHA256(secret, timestamp + "." + payload)
+func SignWebhookPayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// subscribesTo 判断订阅的事件列表是否包含指定事件
+func subscribesTo(eventsJSON, event string) bool {
+	var events []string
+	if err := json.Unmarshal([]byte(eventsJSON), &events); err != nil {
+		return false
+	}
+	for _, e := range events {
+		if e == "*" || e == event {
+			return true
+		}
+	}
+	return false
+}
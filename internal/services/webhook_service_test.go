@@ -0,0 +1,80 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go_market_email/internal/models"
+	"go_market_email/internal/utils"
+)
+
+func TestWebhookValidateURL(t *testing.T) {
+	tests := []struct {
+		url          string
+		allowPrivate bool
+		ok           bool
+	}{
+		{url: "https://93.184.216.34/hook", ok: true},
+		{url: "http://93.184.216.34:8080/hook", ok: true},
+		{url: "ftp://93.184.216.34/hook"},
+		{url: "file:///etc/passwd"},
+		{url: "https:///hook"},
+		{url: "http://127.0.0.1/hook"},
+		{url: "http://localhost:8080/hook"},
+		{url: "http://169.254.169.254/latest/meta-data/"},
+		{url: "http://10.0.0.5/hook"},
+		{url: "http://192.168.1.1/hook"},
+		{url: "http://[::1]/hook"},
+		{url: "http://[fe80::1]/hook"},
+		{url: "http://0.0.0.0/hook"},
+		{url: "http://10.0.0.5/hook", allowPrivate: true, ok: true},
+		{url: "gopher://10.0.0.5/hook", allowPrivate: true},
+	}
+	for _, tt := range tests {
+		s := &WebhookService{config: utils.WebhookConfig{AllowPrivate: tt.allowPrivate}}
+		if err := s.validateURL(tt.url); (err == nil) != tt.ok {
+			t.Errorf("validateURL(%q, allowPrivate=%v) = %v, want ok %v", tt.url, tt.allowPrivate, err, tt.ok)
+		}
+	}
+}
+
+func TestWebhookPostPrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	s := NewWebhookService(nil, nil, utils.WebhookConfig{Timeout: 2}, zap.NewNop())
+
+	// 订阅在连接时拒绝内网地址，即使创建时的校验被DNS变化绕过
+	_, _, err := s.post(models.WebhookDelivery{ID: 1, SubscriptionID: 1, URL: server.URL, Payload: "{}"}, "")
+	if err == nil || !strings.Contains(err.Error(), errPrivateAddress.Error()) {
+		t.Errorf("subscription delivery to loopback: err = %v, want %v", err, errPrivateAddress)
+	}
+
+	// 配置文件中的默认Webhook由管理员配置，允许内网
+	status, _, err := s.post(models.WebhookDelivery{ID: 2, URL: server.URL, Payload: "{}"}, "")
+	if err != nil || status != http.StatusOK {
+		t.Errorf("default webhook delivery: status = %d, err = %v", status, err)
+	}
+}
+
+func TestWebhookPostNoRedirect(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer redirect.Close()
+
+	s := NewWebhookService(nil, nil, utils.WebhookConfig{Timeout: 2, AllowPrivate: true}, zap.NewNop())
+	status, _, err := s.post(models.WebhookDelivery{ID: 1, SubscriptionID: 1, URL: redirect.URL, Payload: "{}"}, "")
+	if err != nil || status != http.StatusTemporaryRedirect {
+		t.Errorf("redirect should not be followed: status = %d, err = %v", status, err)
+	}
+}
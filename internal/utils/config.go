@@ -7,21 +7,30 @@ import (
 )
 
 type Config struct {
-	Server    ServerConfig    `mapstructure:"server"`
-	Database  DatabaseConfig  `mapstructure:"database"`
-	Redis     RedisConfig     `mapstructure:"redis"`
-	SMTP      SMTPConfig      `mapstructure:"smtp"`
-	AI        AIConfig        `mapstructure:"ai"`
-	Email     EmailConfig     `mapstructure:"email"`
-	Scheduler SchedulerConfig `mapstructure:"scheduler"`
-	Log       LogConfig       `mapstructure:"log"`
-	Auth      AuthConfig      `mapstructure:"auth"`
-	Webhook   WebhookConfig   `mapstructure:"webhook"`
+	Server     ServerConfig     `mapstructure:"server"`
+	Database   DatabaseConfig   `mapstructure:"database"`
+	Redis      RedisConfig      `mapstructure:"redis"`
+	SMTP       SMTPConfig       `mapstructure:"smtp"`
+	AI         AIConfig         `mapstructure:"ai"`
+	Email      EmailConfig      `mapstructure:"email"`
+	Scheduler  SchedulerConfig  `mapstructure:"scheduler"`
+	Log        LogConfig        `mapstructure:"log"`
+	Auth       AuthConfig       `mapstructure:"auth"`
+	Webhook    WebhookConfig    `mapstructure:"webhook"`
+	Secrets    SecretsConfig    `mapstructure:"secrets"`
+	Upload     UploadConfig     `mapstructure:"upload"`
+	Attachment AttachmentConfig `mapstructure:"attachment"`
+	Asset      AssetConfig      `mapstructure:"asset"`
+	PDF        PDFConfig        `mapstructure:"pdf"`
+	LinkCheck  LinkCheckConfig  `mapstructure:"link_check"`
+
+	DataSources map[string]DataSourceConfig `mapstructure:"datasources"`
 }
 
 type ServerConfig struct {
-	Port string `mapstructure:"port"`
-	Mode string `mapstructure:"mode"`
+	Port           string   `mapstructure:"port"`
+	Mode           string   `mapstructure:"mode"`
+	AllowedOrigins []string `mapstructure:"allowed_origins"`
 }
 
 type DatabaseConfig struct {
@@ -73,6 +82,7 @@ type EmailConfig struct {
 	SendInterval      int `mapstructure:"send_interval"`
 	RetryTimes        int `mapstructure:"retry_times"`
 	TemplateSizeLimit int `mapstructure:"template_size_limit"`
+	LintMinScore      int `mapstructure:"lint_min_score"` // 模板检查得分低于该值时拒绝启动任务，0表示不检查
 }
 
 type SchedulerConfig struct {
@@ -81,18 +91,81 @@ type SchedulerConfig struct {
 }
 
 type LogConfig struct {
-	Level         string `mapstructure:"level"`
-	RetentionDays int    `mapstructure:"retention_days"`
-	FilePath      string `mapstructure:"file_path"`
+	Level         string          `mapstructure:"level"`
+	RetentionDays int             `mapstructure:"retention_days"`
+	FilePath      string          `mapstructure:"file_path"`
+	Redact        RedactionConfig `mapstructure:"redact"`
+}
+
+// RedactionConfig 日志脱敏策略
+type RedactionConfig struct {
+	Emails string   `mapstructure:"emails"` // partial（默认，保留首字母和域名）, full, none
+	Keys   []string `mapstructure:"keys"`   // 额外需要整体遮盖的字段名
 }
 
 type AuthConfig struct {
-	Token string `mapstructure:"token"`
+	JWTSecret     string `mapstructure:"jwt_secret"`
+	SessionTTL    int    `mapstructure:"session_ttl"`     // 会话有效期（分钟）
+	MaxSessionAge int    `mapstructure:"max_session_age"` // 从登录起会话可续期的最长时间（分钟）
+}
+
+// DataSourceConfig 外部数据源连接，只用于只读查询
+type DataSourceConfig struct {
+	Driver       string `mapstructure:"driver"` // mysql, postgres, sqlite
+	DSN          string `mapstructure:"dsn"`
+	MaxRows      int    `mapstructure:"max_rows"`
+	Timeout      int    `mapstructure:"timeout"` // 单条查询超时（秒）
+	MaxOpenConns int    `mapstructure:"max_open_conns"`
+	Projects     []uint `mapstructure:"projects"`     // 允许使用的项目ID，0表示个人空间
+	AllProjects  bool   `mapstructure:"all_projects"` // 所有项目和个人空间都可以使用
 }
 
 type WebhookConfig struct {
-	URL     string `mapstructure:"url"`
-	Timeout int    `mapstructure:"timeout"`
+	URL            string `mapstructure:"url"`
+	Timeout        int    `mapstructure:"timeout"`
+	Secret         string `mapstructure:"secret"`
+	MaxRetries     int    `mapstructure:"max_retries"`
+	RetryBaseDelay int    `mapstructure:"retry_base_delay"`
+	Workers        int    `mapstructure:"workers"`
+	AllowPrivate   bool   `mapstructure:"allow_private"` // 允许订阅指向内网和本机地址
+}
+
+// UploadConfig 上传文件和数据集
+type UploadConfig struct {
+	Dir        string `mapstructure:"dir"`         // 上传文件的临时保存目录，为空时使用系统临时目录
+	DatasetTTL int    `mapstructure:"dataset_ttl"` // 数据集保留时间（小时），默认72
+}
+
+// AttachmentConfig 附件库和按数据列选择的附件
+type AttachmentConfig struct {
+	Dir          string   `mapstructure:"dir"`            // 附件库文件的保存目录
+	MaxSize      int64    `mapstructure:"max_size"`       // 单个附件的大小上限（字节），默认10MB
+	MaxTotalSize int64    `mapstructure:"max_total_size"` // 每封邮件附件的总大小上限（字节），默认20MB
+	AllowedTypes []string `mapstructure:"allowed_types"`  // 允许的内容类型，以 / 或 . 结尾时按前缀匹配，为空时使用默认列表
+	RowDir       string   `mapstructure:"row_dir"`        // 按数据列选择的附件所在目录，每个项目使用其中的 project-<ID> 子目录
+}
+
+// AssetConfig 模板图片资源
+type AssetConfig struct {
+	Dir     string `mapstructure:"dir"`      // 图片文件的保存目录
+	MaxSize int64  `mapstructure:"max_size"` // 单张图片的大小上限（字节），默认2MB
+	BaseURL string `mapstructure:"base_url"` // 对外访问地址，如 https://mail.example.com，托管图片和相对路径改写时使用
+}
+
+// PDFConfig 逐个收件人生成的PDF附件
+type PDFConfig struct {
+	Font     string `mapstructure:"font"`      // TrueType字体文件，内容包含中文等非拉丁字符时必须配置
+	BoldFont string `mapstructure:"bold_font"` // 粗体字体文件，为空时粗体使用 font
+	PageSize string `mapstructure:"page_size"` // A4（默认）, Letter 等
+}
+
+// LinkCheckConfig 模板链接检查
+type LinkCheckConfig struct {
+	Timeout      int  `mapstructure:"timeout"`       // 单个链接包括重定向的超时时间（秒），默认10
+	MaxRedirects int  `mapstructure:"max_redirects"` // 默认10
+	Concurrency  int  `mapstructure:"concurrency"`   // 同时检查的链接数，默认8
+	CacheTTL     int  `mapstructure:"cache_ttl"`     // 检查结果的缓存时间（秒），默认3600
+	AllowPrivate bool `mapstructure:"allow_private"` // 允许访问内网和本机地址，默认拒绝
 }
 
 func LoadConfig(configPath string) (*Config, error) {
@@ -116,8 +189,8 @@ func LoadConfig(configPath string) (*Config, error) {
 	}
 	
 	// 环境变量覆盖配置
-	if token := os.Getenv("GME_AUTH_TOKEN"); token != "" {
-		config.Auth.Token = token
+	if jwtSecret := os.Getenv("GME_AUTH_JWT_SECRET"); jwtSecret != "" {
+		config.Auth.JWTSecret = jwtSecret
 	}
 	if dbPassword := os.Getenv("GME_DATABASE_PASSWORD"); dbPassword != "" {
 		config.Database.Password = dbPassword
@@ -137,6 +210,14 @@ func LoadConfig(configPath string) (*Config, error) {
 	if apiKey := os.Getenv("GME_AI_OPENAI_API_KEY"); apiKey != "" {
 		config.AI.OpenAI.APIKey = apiKey
 	}
+	if webhookSecret := os.Getenv("GME_WEBHOOK_SECRET"); webhookSecret != "" {
+		config.Webhook.Secret = webhookSecret
+	}
+	
+	// 解析 env:/file:/enc: 形式的敏感配置
+	if err := resolveSecrets(&config); err != nil {
+		return nil, err
+	}
 	
 	return &config, nil
 }
\ No newline at end of file
@@ -47,9 +47,24 @@ func InitDatabase(config DatabaseConfig) (*gorm.DB, error) {
 func autoMigrate(db *gorm.DB) error {
 	return db.AutoMigrate(
 		&models.User{},
+		&models.APIKey{},
 		&models.Project{},
+		&models.ProjectMember{},
 		&models.EmailTemplate{},
 		&models.EmailTask{},
 		&models.EmailLog{},
+		&models.WebhookSubscription{},
+		&models.WebhookDelivery{},
+		&models.AuditLog{},
+		&models.SavedQuery{},
+		&models.ImportMapping{},
+		&models.Dataset{},
+		&models.Attachment{},
+		&models.Asset{},
+		&models.Contact{},
+		&models.ContactList{},
+		&models.ContactListMember{},
+		&models.Segment{},
+		&models.SegmentVersion{},
 	)
 }
\ No newline at end of file
@@ -46,11 +46,11 @@ func InitLogger(config LogConfig) (*zap.Logger, error) {
 	// 控制台输出
 	consoleEncoder := zapcore.NewConsoleEncoder(encoderConfig)
 	
-	// 创建核心
-	core := zapcore.NewTee(
+	// 创建核心，所有输出先经过脱敏
+	core := NewRedactCore(zapcore.NewTee(
 		zapcore.NewCore(fileEncoder, zapcore.AddSync(logFile), level),
 		zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), level),
-	)
+	), NewRedactor(config.Redact))
 	
 	logger := zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
 	
@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 邮箱脱敏策略
+const (
+	RedactEmailPartial = "partial"
+	RedactEmailFull    = "full"
+	RedactEmailNone    = "none"
+)
+
+const redactedValue = "******"
+
+// defaultRedactKeys 字段名等于这些词或以 _词 结尾时整体遮盖（驼峰命名按下划线处理），
+// 如 password、smtp_password、accessToken；has_password 之类的布尔字段不遮盖
+var defaultRedactKeys = []string{"password", "passwd", "password_hash", "secret", "token", "api_key", "apikey", "authorization", "cookie", "master_key", "private_key", "dsn"}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// Redactor 按策略遮盖日志字段中的令牌、密码和邮箱地址
+type Redactor struct {
+	keys   []string
+	emails string
+}
+
+func NewRedactor(config RedactionConfig) *Redactor {
+	keys := append([]string{}, defaultRedactKeys...)
+	for _, key := range config.Keys {
+		keys = append(keys, snakeKey(key))
+	}
+
+	emails := config.Emails
+	if emails == "" {
+		emails = RedactEmailPartial
+	}
+	return &Redactor{keys: keys, emails: emails}
+}
+
+// MaskEmails 按策略遮盖文本中的邮箱地址
+func (r *Redactor) MaskEmails(text string) string {
+	if r.emails == RedactEmailNone {
+		return text
+	}
+	return emailPattern.ReplaceAllStringFunc(text, func(address string) string {
+		if r.emails == RedactEmailFull {
+			return redactedValue
+		}
+		at := strings.LastIndex(address, "@")
+		return address[:1] + "***" + address[at:]
+	})
+}
+
+func (r *Redactor) sensitiveKey(key string) bool {
+	key = snakeKey(key)
+	for _, sensitive := range r.keys {
+		if key == sensitive || strings.HasSuffix(key, "_"+sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// snakeKey 把字段名转为小写下划线形式：apiKey、api-key、api.key 都转为 api_key，JWTSecret 转为 jwt_secret
+func snakeKey(key string) string {
+	var b strings.Builder
+	runes := []rune(key)
+	for i, r := range runes {
+		switch {
+		case r == '-' || r == '.' || r == ' ':
+			b.WriteByte('_')
+		case unicode.IsUpper(r):
+			// 小写或数字后的大写字母、连续大写字母中后面跟小写的一个（JWTSecret）开始新的单词
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Fields 返回脱敏后的字段副本
+func (r *Redactor) Fields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		redacted[i] = r.field(field)
+	}
+	return redacted
+}
+
+func (r *Redactor) field(field zapcore.Field) zapcore.Field {
+	if field.Type != zapcore.BoolType && r.sensitiveKey(field.Key) {
+		return zap.String(field.Key, redactedValue)
+	}
+
+	switch field.Type {
+	case zapcore.StringType:
+		field.String = r.MaskEmails(field.String)
+	case zapcore.ByteStringType:
+		if b, ok := field.Interface.([]byte); ok {
+			return zap.String(field.Key, r.MaskEmails(string(b)))
+		}
+	case zapcore.ObjectMarshalerType:
+		if object, ok := field.Interface.(zapcore.ObjectMarshaler); ok {
+			enc := zapcore.NewMapObjectEncoder()
+			if err := object.MarshalLogObject(enc); err == nil {
+				return zap.Reflect(field.Key, r.value(enc.Fields))
+			}
+		}
+	case zapcore.ArrayMarshalerType:
+		if array, ok := field.Interface.(zapcore.ArrayMarshaler); ok {
+			enc := zapcore.NewMapObjectEncoder()
+			if err := enc.AddArray(field.Key, array); err == nil {
+				return zap.Reflect(field.Key, r.value(enc.Fields[field.Key]))
+			}
+		}
+	case zapcore.ReflectType:
+		// map 和结构体按JSON编码后逐层脱敏，与日志编码器的输出一致
+		if data, err := json.Marshal(field.Interface); err == nil {
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.UseNumber()
+			var value interface{}
+			if decoder.Decode(&value) == nil {
+				return zap.Reflect(field.Key, r.value(value))
+			}
+		}
+	case zapcore.ErrorType:
+		if err, ok := field.Interface.(error); ok {
+			return zap.String(field.Key, r.MaskEmails(err.Error()))
+		}
+	case zapcore.StringerType:
+		if s, ok := field.Interface.(interface{ String() string }); ok {
+			return zap.String(field.Key, r.MaskEmails(s.String()))
+		}
+	}
+	return field
+}
+
+// value 递归脱敏嵌套的对象和数组
+func (r *Redactor) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if _, isBool := item.(bool); !isBool && r.sensitiveKey(key) {
+				redacted[key] = redactedValue
+				continue
+			}
+			redacted[key] = r.value(item)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = r.value(item)
+		}
+		return redacted
+	case string:
+		return r.MaskEmails(v)
+	}
+	return value
+}
+
+// redactCore 在写入前对日志字段和消息脱敏
+type redactCore struct {
+	zapcore.Core
+	redactor *Redactor
+}
+
+// NewRedactCore 包装日志核心，所有输出都经过脱敏
+func NewRedactCore(core zapcore.Core, redactor *Redactor) zapcore.Core {
+	return &redactCore{Core: core, redactor: redactor}
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(c.redactor.Fields(fields)), redactor: c.redactor}
+}
+
+func (c *redactCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = c.redactor.MaskEmails(entry.Message)
+	return c.Core.Write(entry, c.redactor.Fields(fields))
+}
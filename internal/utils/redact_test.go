@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (c credentials) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("username", c.Username)
+	enc.AddString("api_key", c.Password)
+	return nil
+}
+
+func TestRedactorFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(NewRedactCore(core, NewRedactor(RedactionConfig{Keys: []string{"X-Custom"}})))
+
+	logger.Info("sent to alice@example.com",
+		zap.String("password", "p1"),
+		zap.String("smtp_password", "p2"),
+		zap.String("accessToken", "t1"),
+		zap.String("X-Api-Key", "k1"),
+		zap.String("x_custom", "c1"),
+		zap.Bool("has_password", true),
+		zap.String("token_expires_at", "2024-01-01"),
+		zap.String("password_policy_url", "https://example.com"),
+		zap.Strings("recipients", []string{"bob@example.com", "carol@example.com"}),
+		zap.ByteString("body", []byte("reply to dave@example.com")),
+		zap.Any("config", map[string]interface{}{
+			"smtp": map[string]interface{}{"host": "smtp.example.com", "password": "p3", "has_password": true},
+			"list": []interface{}{map[string]interface{}{"token": "t2", "email": "erin@example.com"}},
+		}),
+		zap.Any("user", credentials{Username: "frank", Password: "p4"}),
+		zap.Reflect("login", struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}{"grace", "p5"}),
+		zap.Object("object", credentials{Username: "heidi", Password: "p6"}),
+	)
+
+	entry := logs.All()[0]
+	if entry.Message != "sent to a***@example.com" {
+		t.Errorf("message = %q", entry.Message)
+	}
+	data, _ := json.Marshal(entry.ContextMap())
+	var got map[string]interface{}
+	json.Unmarshal(data, &got)
+
+	want := map[string]interface{}{
+		"password":            redactedValue,
+		"smtp_password":       redactedValue,
+		"accessToken":         redactedValue,
+		"X-Api-Key":           redactedValue,
+		"x_custom":            redactedValue,
+		"has_password":        true,
+		"token_expires_at":    "2024-01-01",
+		"password_policy_url": "https://example.com",
+		"recipients":          []interface{}{"b***@example.com", "c***@example.com"},
+		"body":                "reply to d***@example.com",
+		"config": map[string]interface{}{
+			"smtp": map[string]interface{}{"host": "smtp.example.com", "password": redactedValue, "has_password": true},
+			"list": []interface{}{map[string]interface{}{"token": redactedValue, "email": "e***@example.com"}},
+		},
+		"user":   map[string]interface{}{"username": "frank", "api_key": redactedValue},
+		"login":  map[string]interface{}{"username": "grace", "password": redactedValue},
+		"object": map[string]interface{}{"username": "heidi", "api_key": redactedValue},
+	}
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if string(wantJSON) != string(gotJSON) {
+		t.Errorf("fields =\n%s\nwant\n%s", gotJSON, wantJSON)
+	}
+}
+
+func TestSnakeKey(t *testing.T) {
+	tests := map[string]string{
+		"apiKey":        "api_key",
+		"X-Api-Key":     "x_api_key",
+		"smtp.password": "smtp_password",
+		"JWTSecret":     "jwt_secret",
+		"userID":        "user_id",
+		"has_password":  "has_password",
+	}
+	for key, want := range tests {
+		if got := snakeKey(key); got != want {
+			t.Errorf("snakeKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
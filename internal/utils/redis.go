@@ -32,4 +32,13 @@ const (
 	TaskPauseKey      = "task:pause:"
 	StatsKey          = "stats"
 	WebSocketKey      = "websocket:stats"
+	WebhookQueueKey   = "webhook:queue"
+	WebhookRetryKey   = "webhook:retry"
+	WebhookClaimedKey = "webhook:claimed" // 已取出正在投递的记录
+	TaskEventChannel  = "task:events"
+	ImportProgressKey = "import:progress:"
+	ValidationKey     = "import:validation:"
+	DatasetDataKey    = "dataset:data:"
+	LinkCheckKey      = "link_check:template:"
+	LinkCacheKey      = "link_check:url:"
 )
\ No newline at end of file
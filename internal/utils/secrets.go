@@ -0,0 +1,256 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// 配置中敏感值的引用前缀：
+//
+//	env:NAME    从环境变量读取
+//	file:PATH   从文件读取（相对路径基于 secrets.dir），适用于Docker/K8s secrets
+//	enc:BASE64  使用主密钥加密的密文，通过 email-cli secret encrypt 生成
+//
+// 不带前缀的值按明文使用
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+	secretEncPrefix  = "enc:"
+)
+
+// 主密钥派生参数（scrypt），每个密文使用独立的随机盐
+const (
+	secretSaltSize = 16
+	secretScryptN  = 1 << 15
+	secretScryptR  = 8
+	secretScryptP  = 1
+	secretKeySize  = 32
+)
+
+// SecretsConfig 密钥配置
+type SecretsConfig struct {
+	Dir           string `mapstructure:"dir"`             // file: 引用的相对路径根目录
+	MasterKeyFile string `mapstructure:"master_key_file"` // 主密钥文件，也可通过 GME_MASTER_KEY 设置
+}
+
+// SecretProvider 按名称读取密钥
+type SecretProvider interface {
+	Get(name string) (string, error)
+}
+
+// EnvSecretProvider 从环境变量读取密钥
+type EnvSecretProvider struct{}
+
+func (EnvSecretProvider) Get(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("环境变量 %s 未设置", name)
+	}
+	return value, nil
+}
+
+// FileSecretProvider 从文件读取密钥，去掉末尾换行
+type FileSecretProvider struct {
+	Dir string
+}
+
+func (p FileSecretProvider) Get(name string) (string, error) {
+	path := name
+	if !filepath.IsAbs(path) && p.Dir != "" {
+		path = filepath.Join(p.Dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取密钥文件失败: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// EncryptedSecretProvider 使用主密钥解密 AES-256-GCM 密文，密文格式为 盐 + nonce + 密文
+type EncryptedSecretProvider struct {
+	masterKey []byte
+
+	mu   sync.Mutex
+	keys map[string][]byte // 按盐缓存派生的密钥，scrypt 每次派生需要几十毫秒
+}
+
+// NewEncryptedSecretProvider 主密钥可以是任意长度的口令，加密时经 scrypt 加随机盐派生为256位密钥
+func NewEncryptedSecretProvider(masterKey string) *EncryptedSecretProvider {
+	return &EncryptedSecretProvider{masterKey: []byte(masterKey), keys: make(map[string][]byte)}
+}
+
+func (p *EncryptedSecretProvider) Get(name string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(name)
+	if err != nil {
+		return "", fmt.Errorf("密文格式错误: %w", err)
+	}
+
+	if len(data) < secretSaltSize {
+		return "", errors.New("密文长度不足")
+	}
+	salt, data := data[:secretSaltSize], data[secretSaltSize:]
+	gcm, err := p.gcm(salt)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("密文长度不足")
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("解密失败，请检查主密钥")
+	}
+	return string(plain), nil
+}
+
+// Encrypt 加密明文，返回可直接写入配置文件的 enc: 值
+func (p *EncryptedSecretProvider) Encrypt(plain string) (string, error) {
+	salt := make([]byte, secretSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	gcm, err := p.gcm(salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(append(salt, nonce...), nonce, []byte(plain), nil)
+	return secretEncPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (p *EncryptedSecretProvider) gcm(salt []byte) (cipher.AEAD, error) {
+	key, err := p.deriveKey(salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (p *EncryptedSecretProvider) deriveKey(salt []byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key(p.masterKey, salt, secretScryptN, secretScryptR, secretScryptP, secretKeySize)
+	if err != nil {
+		return nil, err
+	}
+	p.keys[string(salt)] = key
+	return key, nil
+}
+
+// SecretResolver 根据前缀把配置值解析为明文
+type SecretResolver struct {
+	env       SecretProvider
+	file      SecretProvider
+	encrypted SecretProvider
+}
+
+// NewSecretResolver 创建解析器，未配置主密钥时遇到 enc: 值会报错
+func NewSecretResolver(config SecretsConfig) (*SecretResolver, error) {
+	resolver := &SecretResolver{
+		env:  EnvSecretProvider{},
+		file: FileSecretProvider{Dir: config.Dir},
+	}
+
+	masterKey, err := LoadMasterKey(config)
+	if err != nil {
+		return nil, err
+	}
+	if masterKey != "" {
+		resolver.encrypted = NewEncryptedSecretProvider(masterKey)
+	}
+	return resolver, nil
+}
+
+// LoadMasterKey 依次从 GME_MASTER_KEY 环境变量和 secrets.master_key_file 读取主密钥
+func LoadMasterKey(config SecretsConfig) (string, error) {
+	if key := os.Getenv("GME_MASTER_KEY"); key != "" {
+		return key, nil
+	}
+	if config.MasterKeyFile == "" {
+		return "", nil
+	}
+	return FileSecretProvider{}.Get(config.MasterKeyFile)
+}
+
+// Resolve 解析单个配置值
+func (r *SecretResolver) Resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretEnvPrefix):
+		return r.env.Get(strings.TrimPrefix(value, secretEnvPrefix))
+	case strings.HasPrefix(value, secretFilePrefix):
+		return r.file.Get(strings.TrimPrefix(value, secretFilePrefix))
+	case strings.HasPrefix(value, secretEncPrefix):
+		if r.encrypted == nil {
+			return "", errors.New("存在加密配置但未设置主密钥（GME_MASTER_KEY 或 secrets.master_key_file）")
+		}
+		return r.encrypted.Get(strings.TrimPrefix(value, secretEncPrefix))
+	default:
+		return value, nil
+	}
+}
+
+// resolveSecrets 解析配置中的全部敏感字段
+func resolveSecrets(config *Config) error {
+	resolver, err := NewSecretResolver(config.Secrets)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]*string{
+		"database.password": &config.Database.Password,
+		"redis.password":    &config.Redis.Password,
+		"smtp.username":     &config.SMTP.Username,
+		"smtp.password":     &config.SMTP.Password,
+		"ai.openai.api_key": &config.AI.OpenAI.APIKey,
+		"auth.jwt_secret":   &config.Auth.JWTSecret,
+		"webhook.secret":    &config.Webhook.Secret,
+	}
+	for name, field := range fields {
+		value, err := resolver.Resolve(*field)
+		if err != nil {
+			return fmt.Errorf("解析配置 %s 失败: %w", name, err)
+		}
+		*field = value
+	}
+
+	for name, source := range config.DataSources {
+		dsn, err := resolver.Resolve(source.DSN)
+		if err != nil {
+			return fmt.Errorf("解析配置 datasources.%s.dsn 失败: %w", name, err)
+		}
+		source.DSN = dsn
+		config.DataSources[name] = source
+	}
+
+	for header, value := range config.AI.CustomAPI.Headers {
+		resolved, err := resolver.Resolve(value)
+		if err != nil {
+			return fmt.Errorf("解析配置 ai.custom_api.headers.%s 失败: %w", header, err)
+		}
+		config.AI.CustomAPI.Headers[header] = resolved
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncryptedSecretProvider(t *testing.T) {
+	provider := NewEncryptedSecretProvider("master-key")
+	first, err := provider.Encrypt("smtp-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := provider.Encrypt("smtp-password")
+	if !strings.HasPrefix(first, secretEncPrefix) || first == second {
+		t.Errorf("each value should use a random salt and nonce: %q %q", first, second)
+	}
+
+	// 新的实例重新派生密钥
+	plain, err := NewEncryptedSecretProvider("master-key").Get(strings.TrimPrefix(first, secretEncPrefix))
+	if err != nil || plain != "smtp-password" {
+		t.Errorf("Get = %q, %v", plain, err)
+	}
+	if _, err := NewEncryptedSecretProvider("wrong-key").Get(strings.TrimPrefix(first, secretEncPrefix)); err == nil {
+		t.Error("wrong master key should fail")
+	}
+	if _, err := provider.Get("c2hvcnQ="); err == nil {
+		t.Error("short ciphertext should fail")
+	}
+}
@@ -0,0 +1,420 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SQL方言，决定注释、字符串转义和占位符的写法
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+type sqlTokenKind int
+
+const (
+	sqlWord   sqlTokenKind = iota // 关键字或未加引号的标识符
+	sqlQuoted                     // 字符串或带引号的标识符
+	sqlNumber
+	sqlParam // :name 命名参数
+	sqlPunct
+)
+
+type sqlToken struct {
+	kind  sqlTokenKind
+	text  string
+	start int
+	end   int
+}
+
+// sqlForbiddenWords 只读查询中不允许出现的关键字
+var sqlForbiddenWords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "UPSERT": true,
+	"DROP": true, "ALTER": true, "CREATE": true, "TRUNCATE": true, "RENAME": true,
+	"GRANT": true, "REVOKE": true, "INTO": true, "CALL": true, "EXEC": true, "EXECUTE": true,
+	"LOCK": true, "UNLOCK": true, "COPY": true, "ATTACH": true, "DETACH": true, "PRAGMA": true,
+	"VACUUM": true, "HANDLER": true, "OUTFILE": true, "DUMPFILE": true,
+}
+
+// sqlAllowedFunctions 查询中允许调用的函数。数据库内置函数很多都有副作用
+// （加锁、读文件、终止连接、修改会话配置等），因此只放行常用的纯计算函数
+var sqlAllowedFunctions = wordSet(
+	// 聚合和窗口函数
+	"COUNT", "SUM", "AVG", "MIN", "MAX", "GROUP_CONCAT", "STRING_AGG", "ARRAY_AGG", "BOOL_AND", "BOOL_OR",
+	"STDDEV", "STDDEV_POP", "STDDEV_SAMP", "VARIANCE", "VAR_POP", "VAR_SAMP", "BIT_AND", "BIT_OR",
+	"JSON_AGG", "JSONB_AGG", "JSON_ARRAYAGG", "JSON_OBJECTAGG", "JSON_GROUP_ARRAY", "JSON_GROUP_OBJECT",
+	"ROW_NUMBER", "RANK", "DENSE_RANK", "PERCENT_RANK", "CUME_DIST", "NTILE", "LAG", "LEAD",
+	"FIRST_VALUE", "LAST_VALUE", "NTH_VALUE",
+	// 条件和类型转换
+	"COALESCE", "NULLIF", "IFNULL", "IF", "IIF", "GREATEST", "LEAST", "ISNULL", "NVL",
+	"CAST", "CONVERT", "TO_CHAR", "TO_DATE", "TO_NUMBER", "TO_TIMESTAMP", "TYPEOF",
+	// 字符串
+	"CONCAT", "CONCAT_WS", "LOWER", "UPPER", "LCASE", "UCASE", "TRIM", "LTRIM", "RTRIM", "BTRIM",
+	"LENGTH", "CHAR_LENGTH", "CHARACTER_LENGTH", "OCTET_LENGTH", "SUBSTRING", "SUBSTR", "SUBSTRING_INDEX",
+	"LEFT", "RIGHT", "REPLACE", "REVERSE", "LPAD", "RPAD", "POSITION", "LOCATE", "INSTR", "STRPOS",
+	"SPLIT_PART", "INITCAP", "ASCII", "CHR", "FIELD", "FIND_IN_SET", "MD5", "SHA1", "SHA2", "HEX",
+	"REGEXP_REPLACE", "REGEXP_SUBSTR", "REGEXP_LIKE", "REGEXP_INSTR",
+	// 数值
+	"ABS", "CEIL", "CEILING", "FLOOR", "ROUND", "TRUNC", "MOD", "POWER", "POW", "SQRT", "EXP",
+	"LN", "LOG", "LOG10", "LOG2", "SIGN", "DIV",
+	// 日期时间
+	"NOW", "CURRENT_DATE", "CURRENT_TIME", "CURRENT_TIMESTAMP", "CURDATE", "CURTIME", "UTC_DATE", "UTC_TIMESTAMP",
+	"DATE", "TIME", "DATETIME", "TIMESTAMP", "YEAR", "MONTH", "DAY", "HOUR", "MINUTE", "SECOND", "QUARTER", "WEEK",
+	"DAYOFWEEK", "DAYOFMONTH", "DAYOFYEAR", "WEEKDAY", "MONTHNAME", "DAYNAME", "LAST_DAY", "TO_DAYS",
+	"DATE_FORMAT", "DATE_ADD", "DATE_SUB", "ADDDATE", "SUBDATE", "DATEDIFF", "TIMESTAMPDIFF", "TIMESTAMPADD",
+	"STR_TO_DATE", "FROM_UNIXTIME", "UNIX_TIMESTAMP", "CONVERT_TZ", "EXTRACT", "DATE_TRUNC", "DATE_PART",
+	"AGE", "MAKE_DATE", "MAKE_TIMESTAMP", "STRFTIME", "JULIANDAY",
+	// JSON 和数组
+	"JSON_EXTRACT", "JSON_UNQUOTE", "JSON_VALUE", "JSON_CONTAINS", "JSON_LENGTH", "JSON_TYPE", "JSON_OBJECT",
+	"JSON_ARRAY", "JSON_BUILD_OBJECT", "JSONB_BUILD_OBJECT", "JSON_EXTRACT_PATH_TEXT", "JSONB_EXTRACT_PATH_TEXT",
+	"TO_JSON", "TO_JSONB", "JSON_EACH", "ARRAY_LENGTH", "ARRAY_TO_STRING", "STRING_TO_ARRAY", "CARDINALITY", "UNNEST",
+)
+
+// sqlParenKeywords 后面可以直接跟括号的关键字，如子查询、IN 列表和窗口定义，不是函数调用
+var sqlParenKeywords = wordSet(
+	"SELECT", "WITH", "RECURSIVE", "AS", "FROM", "JOIN", "LATERAL", "ON", "USING", "WHERE", "HAVING",
+	"AND", "OR", "NOT", "IN", "EXISTS", "ANY", "ALL", "SOME", "BETWEEN", "LIKE", "ILIKE", "IS",
+	"CASE", "WHEN", "THEN", "ELSE", "BY", "UNION", "INTERSECT", "EXCEPT", "DISTINCT", "VALUES",
+	"OVER", "FILTER", "GROUP", "ROW", "ARRAY", "LIMIT", "OFFSET", "MATERIALIZED",
+)
+
+func wordSet(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[word] = true
+	}
+	return set
+}
+
+// CheckReadOnlyQuery 对SQL做词法分析，只允许单条 SELECT（可带 WITH 公共表达式）语句。
+// 关键字按完整单词匹配，字符串、注释和带引号的标识符中的内容不受影响，
+// 因此 updated_at 之类的列名不会被误判。函数调用只允许 sqlAllowedFunctions 中的函数
+func CheckReadOnlyQuery(query, dialect string) error {
+	tokens, err := tokenizeSQL(query, dialect)
+	if err != nil {
+		return err
+	}
+
+	// 去掉末尾的分号，其余分号说明有多条语句
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 {
+		return errors.New("查询不能为空")
+	}
+
+	first := ""
+	for _, token := range tokens {
+		if token.kind == sqlPunct && token.text == "(" {
+			continue
+		}
+		first = strings.ToUpper(token.text)
+		break
+	}
+	if first != "SELECT" && first != "WITH" {
+		return errors.New("只允许执行SELECT查询")
+	}
+
+	ctes := cteNames(tokens)
+	for i, token := range tokens {
+		if token.kind == sqlPunct && token.text == ";" {
+			return errors.New("只允许执行单条查询语句")
+		}
+		// PostgreSQL 可以用带引号的名称调用函数，如 "pg_sleep"(1)
+		if quotedIdentifier(token) && i+1 < len(tokens) && tokens[i+1].text == "(" && !isAllowedCall(tokens, i, ctes) {
+			return fmt.Errorf("查询中不允许调用函数 %s", token.text)
+		}
+		if token.kind != sqlWord {
+			continue
+		}
+
+		word := strings.ToUpper(token.text)
+		if sqlForbiddenWords[word] {
+			return fmt.Errorf("查询中不允许使用 %s", word)
+		}
+		if i+1 < len(tokens) && tokens[i+1].text == "(" && !isAllowedCall(tokens, i, ctes) {
+			return fmt.Errorf("查询中不允许调用函数 %s", word)
+		}
+		// SELECT ... FOR UPDATE/SHARE 会加锁
+		if word == "FOR" && i+1 < len(tokens) && tokens[i+1].kind == sqlWord {
+			next := strings.ToUpper(tokens[i+1].text)
+			if next == "SHARE" || next == "NO" || next == "KEY" {
+				return errors.New("查询中不允许加锁")
+			}
+		}
+	}
+	return nil
+}
+
+// isAllowedCall 判断后面紧跟括号的单词是否可以出现在只读查询中：允许的函数、关键字、
+// 类型参数（如 CAST(x AS DECIMAL(10,2))、x::numeric(10,2)）以及公共表达式的列名列表
+func isAllowedCall(tokens []sqlToken, i int, ctes map[int]bool) bool {
+	word := strings.ToUpper(tokens[i].text)
+	if quotedIdentifier(tokens[i]) {
+		word = strings.ToUpper(tokens[i].text[1 : len(tokens[i].text)-1])
+	} else if sqlParenKeywords[word] {
+		return true
+	}
+	if ctes[i] {
+		return true
+	}
+	if i > 0 {
+		prev := tokens[i-1]
+		// 带模式名的调用（如 pg_catalog.set_config）按函数名判断
+		if prev.text == "." {
+			return sqlAllowedFunctions[word]
+		}
+		if prev.kind == sqlWord && strings.EqualFold(prev.text, "AS") {
+			return true
+		}
+		if prev.text == ":" && i > 1 && tokens[i-2].text == ":" {
+			return true
+		}
+	}
+	return sqlAllowedFunctions[word]
+}
+
+// quotedIdentifier 判断是否为带引号的标识符（"name" 或 `name`），MySQL 的双引号为字符串
+func quotedIdentifier(token sqlToken) bool {
+	if token.kind != sqlQuoted || len(token.text) < 2 {
+		return false
+	}
+	return token.text[0] == '`' || token.text[0] == '"'
+}
+
+// cteNames 返回 WITH 子句中公共表达式名称的位置，名称后可以跟列名列表 name(col, ...)。
+// 同一层括号内从 WITH 开始到 SELECT 之前为公共表达式定义，逗号分隔各个定义
+func cteNames(tokens []sqlToken) map[int]bool {
+	names := make(map[int]bool)
+	inWith := map[int]bool{}
+	depth := 0
+	for i, token := range tokens {
+		switch {
+		case token.text == "(":
+			depth++
+		case token.text == ")":
+			inWith[depth] = false
+			depth--
+		case token.kind == sqlWord:
+			word := strings.ToUpper(token.text)
+			switch {
+			case word == "WITH":
+				inWith[depth] = true
+			case word == "SELECT" || word == "VALUES":
+				inWith[depth] = false
+			case inWith[depth] && word != "RECURSIVE" && i > 0:
+				prev := strings.ToUpper(tokens[i-1].text)
+				if prev == "WITH" || prev == "RECURSIVE" || prev == "," {
+					names[i] = true
+				}
+			}
+		}
+	}
+	return names
+}
+
+// QueryParams 返回查询中的命名参数，按首次出现的顺序去重
+func QueryParams(query, dialect string) ([]string, error) {
+	tokens, err := tokenizeSQL(query, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		if token.kind == sqlParam && !seen[token.text] {
+			seen[token.text] = true
+			names = append(names, token.text)
+		}
+	}
+	return names, nil
+}
+
+// BindNamedParams 把 :name 形式的命名参数替换为驱动的占位符（MySQL/SQLite为 ?，PostgreSQL为 $n），
+// 返回改写后的SQL和按顺序排列的参数值
+func BindNamedParams(query, dialect string, params map[string]interface{}) (string, []interface{}, error) {
+	tokens, err := tokenizeSQL(query, dialect)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var builder strings.Builder
+	var args []interface{}
+	last := 0
+	for _, token := range tokens {
+		if token.kind != sqlParam {
+			continue
+		}
+		value, ok := params[token.text]
+		if !ok {
+			return "", nil, fmt.Errorf("缺少查询参数: %s", token.text)
+		}
+
+		builder.WriteString(query[last:token.start])
+		args = append(args, value)
+		if dialect == DialectPostgres {
+			builder.WriteString("$" + strconv.Itoa(len(args)))
+		} else {
+			builder.WriteString("?")
+		}
+		last = token.end
+	}
+	builder.WriteString(query[last:])
+	return builder.String(), args, nil
+}
+
+// tokenizeSQL 按方言切分SQL，跳过注释和空白
+func tokenizeSQL(query, dialect string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	runes := []rune(query)
+	// 记录每个字符的字节偏移，便于按原文替换
+	offsets := make([]int, len(runes)+1)
+	pos := 0
+	for i, r := range runes {
+		offsets[i] = pos
+		pos += len(string(r))
+	}
+	offsets[len(runes)] = pos
+
+	emit := func(kind sqlTokenKind, text string, start, end int) {
+		tokens = append(tokens, sqlToken{kind: kind, text: text, start: offsets[start], end: offsets[end]})
+	}
+
+	n := len(runes)
+	for i := 0; i < n; {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		// 行注释，MySQL 的 -- 后必须有空白，否则 1--1 是两次取负
+		case r == '-' && i+1 < n && runes[i+1] == '-' && (dialect != DialectMySQL || i+2 >= n || unicode.IsSpace(runes[i+2]) || unicode.IsControl(runes[i+2])),
+			r == '#' && dialect == DialectMySQL:
+			for i < n && runes[i] != '\n' {
+				i++
+			}
+
+		// 块注释，PostgreSQL允许嵌套；MySQL的 /*! */ 会被执行，直接拒绝
+		case r == '/' && i+1 < n && runes[i+1] == '*':
+			if dialect == DialectMySQL && i+2 < n && (runes[i+2] == '!' || runes[i+2] == '+') {
+				return nil, errors.New("不允许使用MySQL可执行注释")
+			}
+			depth := 0
+			for i < n {
+				if runes[i] == '/' && i+1 < n && runes[i+1] == '*' {
+					if depth == 0 || dialect == DialectPostgres {
+						depth++
+					}
+					i += 2
+					continue
+				}
+				if runes[i] == '*' && i+1 < n && runes[i+1] == '/' {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+					continue
+				}
+				i++
+			}
+			if depth != 0 {
+				return nil, errors.New("注释未闭合")
+			}
+
+		// 字符串和带引号的标识符
+		case r == '\'' || r == '"' || r == '`':
+			backslash := dialect == DialectMySQL && r != '`'
+			// PostgreSQL 的 E'...' 字符串支持反斜杠转义
+			if dialect == DialectPostgres && r == '\'' && len(tokens) > 0 {
+				prev := tokens[len(tokens)-1]
+				if prev.kind == sqlWord && strings.EqualFold(prev.text, "E") && prev.end == offsets[i] {
+					backslash = true
+				}
+			}
+			end, err := scanQuoted(runes, i, r, backslash)
+			if err != nil {
+				return nil, err
+			}
+			emit(sqlQuoted, string(runes[i:end]), i, end)
+			i = end
+
+		// PostgreSQL美元符号字符串 $tag$...$tag$
+		case r == '$' && dialect == DialectPostgres && i+1 < n && (runes[i+1] == '$' || unicode.IsLetter(runes[i+1]) || runes[i+1] == '_'):
+			j := i + 1
+			for j < n && runes[j] != '$' && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			if j >= n || runes[j] != '$' {
+				return nil, errors.New("无效的美元符号字符串")
+			}
+			tag := runes[i : j+1]
+			end := -1
+			for k := j + 1; k+len(tag) <= n; k++ {
+				if string(runes[k:k+len(tag)]) == string(tag) {
+					end = k + len(tag)
+					break
+				}
+			}
+			if end < 0 {
+				return nil, errors.New("字符串未闭合")
+			}
+			emit(sqlQuoted, string(runes[i:end]), i, end)
+			i = end
+
+		// 命名参数，:: 为PostgreSQL类型转换
+		case r == ':' && i+1 < n && (unicode.IsLetter(runes[i+1]) || runes[i+1] == '_') && (i == 0 || runes[i-1] != ':'):
+			j := i + 1
+			for j < n && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			emit(sqlParam, string(runes[i+1:j]), i, j)
+			i = j
+
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < n && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '$') {
+				j++
+			}
+			emit(sqlWord, string(runes[i:j]), i, j)
+			i = j
+
+		case unicode.IsDigit(r):
+			j := i + 1
+			for j < n && (unicode.IsDigit(runes[j]) || unicode.IsLetter(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			emit(sqlNumber, string(runes[i:j]), i, j)
+			i = j
+
+		default:
+			emit(sqlPunct, string(r), i, i+1)
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// scanQuoted 返回引号内容结束后的位置，连续两个引号表示转义
+func scanQuoted(runes []rune, start int, quote rune, backslash bool) (int, error) {
+	for i := start + 1; i < len(runes); i++ {
+		if backslash && runes[i] == '\\' {
+			i++
+			continue
+		}
+		if runes[i] == quote {
+			if i+1 < len(runes) && runes[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	}
+	return 0, errors.New("字符串或标识符未闭合")
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckReadOnlyQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		dialect string
+		ok      bool
+	}{
+		// 基本语句
+		{name: "select", query: "SELECT id, email FROM users WHERE vip = 1", dialect: DialectMySQL, ok: true},
+		{name: "trailing semicolon", query: "SELECT 1;", dialect: DialectMySQL, ok: true},
+		{name: "parenthesized select", query: "(SELECT 1) UNION (SELECT 2)", dialect: DialectPostgres, ok: true},
+		{name: "empty", query: " ; ", dialect: DialectMySQL},
+		{name: "delete", query: "DELETE FROM users", dialect: DialectMySQL},
+		{name: "show", query: "SHOW TABLES", dialect: DialectMySQL},
+
+		// 多条语句，字符串和注释中的分号
+		{name: "stacked statements", query: "SELECT 1; DROP TABLE users", dialect: DialectMySQL},
+		{name: "stacked select", query: "SELECT 1; SELECT 2", dialect: DialectPostgres},
+		{name: "semicolon in string", query: "SELECT 'a;b' AS s", dialect: DialectMySQL, ok: true},
+		{name: "semicolon in double quoted string", query: `SELECT "a;DROP TABLE x" AS s`, dialect: DialectMySQL, ok: true},
+		{name: "escaped quote in string", query: `SELECT 'it''s; DELETE' AS s`, dialect: DialectPostgres, ok: true},
+		{name: "mysql backslash escape", query: `SELECT 'a\'; DELETE FROM users; --' AS s`, dialect: DialectMySQL, ok: true},
+		{name: "postgres backslash is literal", query: `SELECT 'a\'; DELETE FROM users; --'`, dialect: DialectPostgres},
+		{name: "postgres E string", query: `SELECT E'a\'; DELETE' AS s`, dialect: DialectPostgres, ok: true},
+		{name: "semicolon in line comment", query: "SELECT 1 -- ; DROP TABLE users", dialect: DialectPostgres, ok: true},
+		{name: "semicolon in block comment", query: "SELECT 1 /* ; DROP TABLE users */", dialect: DialectSQLite, ok: true},
+		{name: "unterminated string", query: "SELECT 'abc", dialect: DialectMySQL},
+		{name: "unterminated comment", query: "SELECT 1 /* abc", dialect: DialectMySQL},
+
+		// MySQL 注释
+		{name: "mysql executable comment", query: "SELECT 1 /*! , SLEEP(10) */", dialect: DialectMySQL},
+		{name: "mysql versioned executable comment", query: "SELECT 1 /*!50000 UNION SELECT load_file('/etc/passwd') */", dialect: DialectMySQL},
+		{name: "mysql optimizer hint", query: "SELECT /*+ MAX_EXECUTION_TIME(1) */ 1", dialect: DialectMySQL},
+		{name: "mysql hash comment", query: "SELECT 1 # ; DROP TABLE users", dialect: DialectMySQL, ok: true},
+		{name: "hash is not a postgres comment", query: "SELECT 1 # ; DROP TABLE users", dialect: DialectPostgres},
+		{name: "mysql double dash without space", query: "SELECT 2--1, SLEEP(10)", dialect: DialectMySQL},
+		{name: "mysql double dash with space", query: "SELECT 2 -- , SLEEP(10)", dialect: DialectMySQL, ok: true},
+		{name: "mysql comments do not nest", query: "SELECT 1 /* /* */ ; DROP TABLE users */", dialect: DialectMySQL},
+
+		// PostgreSQL 美元符号字符串和嵌套注释
+		{name: "dollar quoted", query: "SELECT $$; DROP TABLE users$$ AS s", dialect: DialectPostgres, ok: true},
+		{name: "tagged dollar quoted", query: "SELECT $body$ it's; $$ DELETE $body$ AS s", dialect: DialectPostgres, ok: true},
+		{name: "unterminated dollar quoted", query: "SELECT $tag$ abc $other$", dialect: DialectPostgres},
+		{name: "nested comment", query: "SELECT 1 /* outer /* inner */ ; DROP TABLE users */", dialect: DialectPostgres, ok: true},
+		{name: "nested comment unclosed", query: "SELECT 1 /* outer /* inner */", dialect: DialectPostgres},
+		{name: "positional parameter", query: "SELECT $1", dialect: DialectPostgres, ok: true},
+
+		// 写入文件和加锁
+		{name: "into outfile", query: "SELECT * FROM users INTO OUTFILE '/tmp/users.csv'", dialect: DialectMySQL},
+		{name: "into dumpfile", query: "SELECT email FROM users LIMIT 1 INTO DUMPFILE '/tmp/x'", dialect: DialectMySQL},
+		{name: "into variable", query: "SELECT email INTO @x FROM users LIMIT 1", dialect: DialectMySQL},
+		{name: "select into table", query: "SELECT * INTO backup FROM users", dialect: DialectPostgres},
+		{name: "for update", query: "SELECT * FROM users FOR UPDATE", dialect: DialectMySQL},
+		{name: "for share", query: "SELECT * FROM users FOR SHARE", dialect: DialectPostgres},
+		{name: "for no key update", query: "SELECT * FROM users FOR NO KEY UPDATE", dialect: DialectPostgres},
+		{name: "for key share", query: "SELECT * FROM users FOR KEY SHARE", dialect: DialectPostgres},
+		{name: "lock in share mode", query: "SELECT * FROM users LOCK IN SHARE MODE", dialect: DialectMySQL},
+
+		// 函数调用
+		{name: "allowed functions", query: "SELECT COUNT(*), LOWER(email), COALESCE(name, '') FROM users GROUP BY email", dialect: DialectMySQL, ok: true},
+		{name: "window function", query: "SELECT ROW_NUMBER() OVER (PARTITION BY city ORDER BY id) FROM users", dialect: DialectPostgres, ok: true},
+		{name: "pg_sleep", query: "SELECT pg_sleep(10)", dialect: DialectPostgres},
+		{name: "sleep", query: "SELECT email FROM users WHERE SLEEP(5) = 0", dialect: DialectMySQL},
+		{name: "load_file", query: "SELECT load_file('/etc/passwd')", dialect: DialectMySQL},
+		{name: "benchmark", query: "SELECT BENCHMARK(1000000, MD5('a'))", dialect: DialectMySQL},
+		{name: "set_config", query: "SELECT set_config('role', 'admin', false)", dialect: DialectPostgres},
+		{name: "schema qualified", query: "SELECT pg_catalog.pg_read_file('/etc/passwd')", dialect: DialectPostgres},
+		{name: "schema qualified allowed", query: "SELECT pg_catalog.lower('A')", dialect: DialectPostgres, ok: true},
+		{name: "quoted function name", query: `SELECT "pg_sleep"(10)`, dialect: DialectPostgres},
+		{name: "quoted schema and function", query: `SELECT "pg_catalog"."pg_read_file"('/etc/passwd')`, dialect: DialectPostgres},
+		{name: "backtick function name", query: "SELECT `sleep`(5)", dialect: DialectMySQL},
+		{name: "quoted allowed function", query: `SELECT "lower"(email) FROM users`, dialect: DialectPostgres, ok: true},
+		{name: "table function", query: "SELECT * FROM pg_ls_dir('.')", dialect: DialectPostgres},
+		{name: "function after whitespace", query: "SELECT pg_sleep (10)", dialect: DialectPostgres},
+		{name: "cast with type params", query: "SELECT CAST(amount AS DECIMAL(10,2)) FROM orders", dialect: DialectMySQL, ok: true},
+		{name: "postgres cast with type params", query: "SELECT amount::numeric(10,2) FROM orders", dialect: DialectPostgres, ok: true},
+		{name: "subquery and in list", query: "SELECT * FROM users WHERE id IN (1, 2) AND EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id)", dialect: DialectMySQL, ok: true},
+		{name: "join using", query: "SELECT * FROM users JOIN orders USING (user_id)", dialect: DialectSQLite, ok: true},
+
+		// 公共表达式
+		{name: "cte", query: "WITH vip AS (SELECT * FROM users WHERE vip = 1) SELECT email FROM vip", dialect: DialectPostgres, ok: true},
+		{name: "cte with columns", query: "WITH RECURSIVE t(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM t WHERE n < 5), u(m) AS (SELECT 2) SELECT n FROM t, u", dialect: DialectPostgres, ok: true},
+		{name: "cte delete", query: "WITH x AS (DELETE FROM users RETURNING *) SELECT * FROM x", dialect: DialectPostgres},
+		{name: "cte update", query: "WITH x AS (UPDATE users SET vip = 0 RETURNING id) SELECT * FROM x", dialect: DialectPostgres},
+		{name: "cte name is not a function", query: "WITH x AS (SELECT 1) SELECT * FROM users WHERE id IN (SELECT * FROM x) AND pg_sleep(1) IS NULL", dialect: DialectPostgres},
+
+		// 包含关键字的标识符
+		{name: "keyword prefixed columns", query: "SELECT updated_at, created_by, deleted, insert_count, call_id, grant_type FROM users", dialect: DialectMySQL, ok: true},
+		{name: "quoted keyword column", query: `SELECT "delete", "update" FROM flags`, dialect: DialectPostgres, ok: true},
+		{name: "backtick keyword column", query: "SELECT `delete`, `into` FROM flags", dialect: DialectMySQL, ok: true},
+		{name: "keyword in string", query: "SELECT * FROM logs WHERE action = 'DELETE' OR action = 'DROP TABLE'", dialect: DialectSQLite, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckReadOnlyQuery(tt.query, tt.dialect)
+			if (err == nil) != tt.ok {
+				t.Errorf("CheckReadOnlyQuery(%q, %s) = %v, want ok %v", tt.query, tt.dialect, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestBindNamedParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		dialect string
+		params  map[string]interface{}
+		want    string
+		args    []interface{}
+		wantErr bool
+	}{
+		{
+			name:    "mysql",
+			query:   "SELECT * FROM users WHERE city = :city AND vip = :vip",
+			dialect: DialectMySQL,
+			params:  map[string]interface{}{"city": "Beijing", "vip": 1},
+			want:    "SELECT * FROM users WHERE city = ? AND vip = ?",
+			args:    []interface{}{"Beijing", 1},
+		},
+		{
+			name:    "postgres repeated",
+			query:   "SELECT * FROM users WHERE city = :city OR home = :city",
+			dialect: DialectPostgres,
+			params:  map[string]interface{}{"city": "Beijing"},
+			want:    "SELECT * FROM users WHERE city = $1 OR home = $2",
+			args:    []interface{}{"Beijing", "Beijing"},
+		},
+		{
+			name:    "postgres cast",
+			query:   "SELECT created_at::date FROM users WHERE id = :id::int",
+			dialect: DialectPostgres,
+			params:  map[string]interface{}{"id": "7"},
+			want:    "SELECT created_at::date FROM users WHERE id = $1::int",
+			args:    []interface{}{"7"},
+		},
+		{
+			name:    "assignment",
+			query:   "SELECT @n := :start",
+			dialect: DialectMySQL,
+			params:  map[string]interface{}{"start": 0},
+			want:    "SELECT @n := ?",
+			args:    []interface{}{0},
+		},
+		{
+			name:    "strings and comments",
+			query:   "SELECT ':skip', \"x:skip\" FROM t -- :skip\nWHERE a = :a /* :skip */",
+			dialect: DialectSQLite,
+			params:  map[string]interface{}{"a": 1},
+			want:    "SELECT ':skip', \"x:skip\" FROM t -- :skip\nWHERE a = ? /* :skip */",
+			args:    []interface{}{1},
+		},
+		{
+			name:    "utf8 before param",
+			query:   "SELECT '城市' AS label FROM users WHERE city = :city",
+			dialect: DialectMySQL,
+			params:  map[string]interface{}{"city": "北京"},
+			want:    "SELECT '城市' AS label FROM users WHERE city = ?",
+			args:    []interface{}{"北京"},
+		},
+		{
+			name:    "missing param",
+			query:   "SELECT * FROM users WHERE city = :city",
+			dialect: DialectMySQL,
+			params:  map[string]interface{}{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args, err := BindNamedParams(tt.query, tt.dialect, tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("query = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestQueryParams(t *testing.T) {
+	names, err := QueryParams("SELECT * FROM t WHERE a = :a AND b = :b AND c = :a AND d::text = ':e'", DialectPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("QueryParams = %v, want [a b]", names)
+	}
+}
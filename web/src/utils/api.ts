@@ -13,6 +13,11 @@ api.interceptors.request.use(
     if (token) {
       config.headers.Authorization = `Bearer ${token}`
     }
+    // 当前项目，未选择时访问个人资源
+    const projectId = localStorage.getItem('projectId')
+    if (projectId) {
+      config.headers['X-Project-ID'] = projectId
+    }
     return config
   },
   (error) => {